@@ -23,6 +23,16 @@ const (
 	MultiHost  RoutingType = "multihost"
 )
 
+type WorkspacePathScheme string
+
+const (
+	// WorkspaceIDPathScheme exposes the workspace endpoints on paths like /<workspace-id>/<component>/<port>
+	WorkspaceIDPathScheme WorkspacePathScheme = "workspace-id"
+	// WorkspaceNamePathScheme exposes the workspace endpoints on paths like
+	// /<namespace>/<workspace-name>/<component>/<endpoint>
+	WorkspaceNamePathScheme WorkspacePathScheme = "workspace-name"
+)
+
 // CheManagerSpec holds the configuration of the Che controller.
 // +k8s:openapi-gen=true
 type CheManagerSpec struct {
@@ -45,6 +55,199 @@ type CheManagerSpec struct {
 	// it is taken from the `RELATED_IMAGE_gateway_configurer` environment variable of the che
 	// operator deployment/pod. If not defined there it defaults to a hardcoded value.
 	GatewayConfigurerImage string `json:"gatewayConfigurerImage,omitempty"`
+
+	// Gateway contains the additional configuration of the Che gateway. This is only used in the singlehost mode.
+	Gateway GatewaySpec `json:"gateway,omitempty"`
+
+	// WorkspacePathScheme defines the paths on which the workspace endpoints are exposed in the singlehost mode.
+	// The "workspace-id" scheme uses the paths like /<workspace-id>/<component>/<port> that che-theia depends on.
+	// The "workspace-name" scheme uses the paths like /<namespace>/<workspace-name>/<component>/<endpoint>, which
+	// are easier to remember, and redirects the paths of the "workspace-id" scheme to them. Defaults to "workspace-id".
+	// +kubebuilder:validation:Enum=workspace-id;workspace-name
+	WorkspacePathScheme WorkspacePathScheme `json:"workspacePathScheme,omitempty"`
+
+	// CustomHosts limits the additional hosts on which the workspaces can expose their endpoints in the singlehost
+	// mode using the "che.routing.controller.devfile.io/custom-hosts" annotation.
+	CustomHosts CustomHostsSpec `json:"customHosts,omitempty"`
+}
+
+// CustomHostsSpec holds the limits of the custom hosts of the workspaces.
+// +k8s:openapi-gen=true
+type CustomHostsSpec struct {
+	// AllowedDomains are the domains, like "apps.example.com", in which the workspaces can have custom hosts.
+	// A custom host is allowed if it is one of the domains or a subdomain of one of them. The host of the Che
+	// manager itself is never allowed. The workspaces cannot have any custom hosts if empty.
+	AllowedDomains []string `json:"allowedDomains,omitempty"`
+
+	// SharedTLSSecrets are the names of the TLS secrets in the namespace of the Che manager that the workspaces can
+	// use for their custom hosts in the "che.routing.controller.devfile.io/custom-hosts-tls-secret" annotation.
+	// Any other TLS secret named in the annotation is read from the namespace of the workspace.
+	SharedTLSSecrets []string `json:"sharedTLSSecrets,omitempty"`
+}
+
+// GatewaySpec holds the configuration of the Che gateway deployment.
+// +k8s:openapi-gen=true
+type GatewaySpec struct {
+	// Replicas is the number of the gateway pods to run. The pods are spread across the nodes of the cluster
+	// (if possible) and are protected by a pod disruption budget, so that draining a node doesn't make
+	// the workspaces unavailable, if there is more than 1 replica. With a single replica, the pod disruption
+	// budget cannot keep the gateway running without blocking the node drains, so the workspaces are unavailable
+	// while the gateway pod is moved to another node. Defaults to 1.
+	// +kubebuilder:validation:Minimum=1
+	Replicas *int32 `json:"replicas,omitempty"`
+
+	// Observability configures the logging, access logs and tracing of the gateway. Changing it restarts the gateway
+	// pods.
+	Observability GatewayObservability `json:"observability,omitempty"`
+
+	// Cors is the CORS policy the gateway applies to the workspace endpoints, so that they can be called from
+	// the web pages on other hosts. The individual endpoints can override it using the "cors" attribute with
+	// the same structure. The cross-origin requests are not allowed if not defined.
+	Cors *CorsPolicy `json:"cors,omitempty"`
+
+	// IPAllowList limits the access to the workspace endpoints to the clients with the IP addresses in the provided
+	// ranges, e.g. "10.0.0.0/8" or "192.168.1.7". The IP address of the client is taken from the X-Forwarded-For
+	// header set by the ingress or route in front of the gateway. It requires ForwardedHeadersTrustedIPs to be set,
+	// so that the header cannot be forged by calling the gateway directly. The access is not limited if empty.
+	// The workspaces can narrow it using the "che.routing.controller.devfile.io/ip-allow-list" annotation with
+	// the comma-separated ranges and the individual endpoints using the "ipAllowList" attribute. Only the addresses
+	// in both the ranges of the manager and the narrowed ranges are allowed.
+	IPAllowList []string `json:"ipAllowList,omitempty"`
+
+	// RateLimit limits the rate of the requests each client can make to each of the workspace endpoints. The requests
+	// are not limited if not defined. The workspaces can lower it using
+	// the "che.routing.controller.devfile.io/rate-limit" annotation and the individual endpoints using
+	// the "rateLimit" attribute, both with the same structure. A higher rate limit than the one of the manager
+	// has no effect.
+	RateLimit *RateLimit `json:"rateLimit,omitempty"`
+
+	// ForwardedHeadersTrustedIPs are the IP addresses or ranges of the proxies in front of the gateway, like
+	// the ingress controller, from which the gateway accepts the X-Forwarded-* headers. The headers from any source
+	// are accepted if empty. Note that the IP allow lists and the rate limits rely on the X-Forwarded-For header, so
+	// the proxies in front of the gateway need to be trusted if they are used. Changing it restarts the gateway pods.
+	ForwardedHeadersTrustedIPs []string `json:"forwardedHeadersTrustedIPs,omitempty"`
+
+	// IsolateWorkspaces, if true, creates a network policy for each workspace that only allows the gateway pods
+	// to reach the public endpoints of the workspace, so that the gateway cannot be bypassed by calling
+	// the workspace services directly. The endpoints with the internal exposure stay reachable from anywhere,
+	// unless they share the port with a public endpoint. The ports of the endpoints with no exposure and all
+	// the other ports of the workspace pods are only reachable from within the pods.
+	// A network policy allowing the traffic to the gateway is also created in the namespace of the manager, so
+	// that the gateway stays reachable in the namespaces that deny the traffic by default. The namespace of
+	// the manager is recognized by the NamespaceLabels.
+	IsolateWorkspaces bool `json:"isolateWorkspaces,omitempty"`
+
+	// NamespaceLabels are the labels of the namespace of the manager by which the network policies isolating
+	// the workspaces recognize the traffic from the gateway. Defaults to the "kubernetes.io/metadata.name" label
+	// with the name of the namespace, which Kubernetes puts on all the namespaces since 1.21. On the older clusters,
+	// the namespace of the manager needs to be labeled and the labels listed here.
+	NamespaceLabels map[string]string `json:"namespaceLabels,omitempty"`
+
+	// MetricsNamespaceLabels are the labels of the namespaces from which the metrics of the gateway can be read when
+	// the workspaces are isolated, e.g. the namespaces of Prometheus and of the operator, which reads them to track
+	// the activity of the workspaces. Otherwise, the metrics are only reachable from the namespace of the manager.
+	MetricsNamespaceLabels map[string]string `json:"metricsNamespaceLabels,omitempty"`
+
+	// ActivityTracking, if true, makes the operator periodically read the request counts of the workspace services
+	// from the metrics of the gateway pods and record the time of the last request to the endpoints of each workspace
+	// in the "che.routing.controller.devfile.io/last-activity" annotation of its DevWorkspace. The idling of
+	// the workspaces can then be based on the traffic seen by the gateway instead of the agents in the workspaces.
+	ActivityTracking bool `json:"activityTracking,omitempty"`
+
+	// ErrorPages, if true, adds the error pages sidecar to the gateway pods, which shows the status of the workspace
+	// instead of the bare error when an endpoint of the workspace cannot be reached. The sidecar reads the workspaces
+	// in all the namespaces, so a cluster role allowing that is bound to the service account of the gateway.
+	ErrorPages bool `json:"errorPages,omitempty"`
+
+	// CookiePathRewriting, if true, adds the cookie rewriting sidecar to the gateway pods. The endpoints with
+	// the "cookiePathRewrite" attribute are then forwarded through the sidecar, which rewrites the paths of the cookies
+	// they set to their public path prefix. The workspaces with such endpoints cannot be routed without it.
+	CookiePathRewriting bool `json:"cookiePathRewriting,omitempty"`
+}
+
+// RateLimit specifies the rate of the requests allowed from a single client.
+// +k8s:openapi-gen=true
+type RateLimit struct {
+	// Average is the number of requests per second allowed on average.
+	// +kubebuilder:validation:Minimum=1
+	Average int64 `json:"average"`
+
+	// Burst is the maximum number of requests allowed to go through at once. Defaults to 1.
+	// +kubebuilder:validation:Minimum=0
+	Burst int64 `json:"burst,omitempty"`
+}
+
+// CorsPolicy specifies which cross-origin requests are allowed.
+// +k8s:openapi-gen=true
+type CorsPolicy struct {
+	// AllowedOrigins are the origins, like "https://che.example.com", from which the requests are allowed.
+	// "*" allows the requests from any origin. No cross-origin requests are allowed if empty.
+	AllowedOrigins []string `json:"allowedOrigins,omitempty"`
+
+	// AllowedMethods are the HTTP methods of the allowed requests. Defaults to GET, HEAD, POST, PUT, PATCH and DELETE.
+	AllowedMethods []string `json:"allowedMethods,omitempty"`
+
+	// AllowedHeaders are the headers the requests can contain besides the ones always allowed by the browsers.
+	AllowedHeaders []string `json:"allowedHeaders,omitempty"`
+
+	// AllowCredentials allows the requests to contain the cookies and the authorization headers. It cannot be used
+	// together with allowing any origin.
+	AllowCredentials bool `json:"allowCredentials,omitempty"`
+
+	// MaxAge is the number of seconds for which the browsers can cache the results of the preflight requests.
+	// +kubebuilder:validation:Minimum=0
+	MaxAge int64 `json:"maxAge,omitempty"`
+}
+
+// GatewayObservability holds the configuration of the logging and tracing of the gateway.
+// +k8s:openapi-gen=true
+type GatewayObservability struct {
+	// LogLevel is the level of the log messages of the gateway. Defaults to INFO.
+	// +kubebuilder:validation:Enum=DEBUG;INFO;WARN;ERROR;FATAL;PANIC
+	LogLevel string `json:"logLevel,omitempty"`
+
+	// AccessLog enables the access log of the gateway, logging every request to the standard output of the gateway
+	// container. The access log is disabled if not defined.
+	AccessLog *GatewayAccessLog `json:"accessLog,omitempty"`
+
+	// Tracing enables sending the traces of the requests going through the gateway. The tracing is disabled if not defined.
+	Tracing *GatewayTracing `json:"tracing,omitempty"`
+}
+
+// GatewayAccessLog holds the configuration of the access log of the gateway.
+// +k8s:openapi-gen=true
+type GatewayAccessLog struct {
+	// Format is the format of the access log lines. Defaults to "common".
+	// +kubebuilder:validation:Enum=common;json
+	Format string `json:"format,omitempty"`
+
+	// StatusCodes limits the access log to the requests with the response status codes in the provided
+	// ranges, e.g. "200", "300-302" or "500-599". All requests are logged if not specified.
+	StatusCodes []string `json:"statusCodes,omitempty"`
+
+	// RetryAttempts, if true, makes the access log contain the requests that needed retries, regardless of the
+	// other filters.
+	RetryAttempts bool `json:"retryAttempts,omitempty"`
+
+	// MinDuration limits the access log to the requests that took longer than the provided duration, e.g. "10ms".
+	// This is useful for finding the slow requests.
+	MinDuration string `json:"minDuration,omitempty"`
+}
+
+// GatewayTracing holds the configuration of the tracing of the requests going through the gateway.
+// +k8s:openapi-gen=true
+type GatewayTracing struct {
+	// ServiceName is the name of the service the traces are reported under. Defaults to the name of the Che manager.
+	ServiceName string `json:"serviceName,omitempty"`
+
+	// JaegerCollectorEndpoint is the URL of the HTTP endpoint of the Jaeger collector to send the traces to,
+	// e.g. "http://jaeger-collector.observability:14268/api/traces". OpenTelemetry collectors can receive the traces
+	// using their Jaeger receiver. If not specified, the traces are sent to the Jaeger agent on JaegerAgentHostPort.
+	JaegerCollectorEndpoint string `json:"jaegerCollectorEndpoint,omitempty"`
+
+	// JaegerAgentHostPort is the host and port of the Jaeger agent to send the traces to over UDP, if the
+	// JaegerCollectorEndpoint is not specified. Defaults to "127.0.0.1:6831".
+	JaegerAgentHostPort string `json:"jaegerAgentHostPort,omitempty"`
 }
 
 type GatewayPhase string
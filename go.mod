@@ -7,6 +7,8 @@ require (
 	github.com/devfile/devworkspace-operator v0.1.1-0.20210306005457-3f3d84540faa
 	github.com/google/go-cmp v0.5.0
 	github.com/openshift/api v0.0.0-20200205133042-34f0ec8dab87
+	github.com/prometheus/client_golang v1.0.0
+	github.com/prometheus/common v0.4.1
 	k8s.io/api v0.18.8
 	k8s.io/apimachinery v0.18.8
 	k8s.io/client-go v0.18.8
@@ -14,24 +14,35 @@ package main
 
 import (
 	"flag"
+	"fmt"
+	"io"
 	"os"
+	"strings"
+	"time"
 
-	controllerv1alpha1 "github.com/devfile/devworkspace-operator/apis/controller/v1alpha1"
 	"github.com/devfile/devworkspace-operator/controllers/controller/workspacerouting"
-	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
 	extensions "k8s.io/api/extensions/v1beta1"
-	rbac "k8s.io/api/rbac/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
 
-	"github.com/che-incubator/devworkspace-che-operator/apis/che-controller/v1alpha1"
+	"github.com/che-incubator/devworkspace-che-operator/pkg/activity"
+	"github.com/che-incubator/devworkspace-che-operator/pkg/cookierewrite"
+	"github.com/che-incubator/devworkspace-che-operator/pkg/errorpages"
+	"github.com/che-incubator/devworkspace-che-operator/pkg/explain"
 	"github.com/che-incubator/devworkspace-che-operator/pkg/infrastructure"
 	"github.com/che-incubator/devworkspace-che-operator/pkg/manager"
+	"github.com/che-incubator/devworkspace-che-operator/pkg/metrics"
+	"github.com/che-incubator/devworkspace-che-operator/pkg/render"
+	operatorscheme "github.com/che-incubator/devworkspace-che-operator/pkg/scheme"
 	"github.com/che-incubator/devworkspace-che-operator/pkg/solver"
+	"github.com/che-incubator/devworkspace-che-operator/pkg/sync"
 	routev1 "github.com/openshift/api/route/v1"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	ctrlmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
 )
 
 var (
@@ -39,24 +50,62 @@ var (
 	setupLog = ctrl.Log.WithName("setup")
 )
 
+const (
+	// fieldManager is the name of the field manager used with the server-side apply
+	fieldManager = "devworkspace-che-operator"
+)
+
 func init() {
-	utilruntime.Must(v1alpha1.AddToScheme(scheme))
-	utilruntime.Must(controllerv1alpha1.AddToScheme(scheme))
-	utilruntime.Must(extensions.AddToScheme(scheme))
-	utilruntime.Must(corev1.AddToScheme(scheme))
-	utilruntime.Must(appsv1.AddToScheme(scheme))
-	utilruntime.Must(rbac.AddToScheme(scheme))
-	utilruntime.Must(routev1.AddToScheme(scheme))
+	utilruntime.Must(operatorscheme.AddToScheme(scheme))
 }
 
 func main() {
+	// the subcommands don't run the controllers, so they need to run before we bail out on the undetected
+	// infrastructure below
+	if len(os.Args) > 1 {
+		subcommands := map[string]func([]string, io.Writer) error{
+			render.CommandName:        render.Run,
+			explain.CommandName:       explain.Run,
+			errorpages.CommandName:    errorpages.Run,
+			cookierewrite.CommandName: cookierewrite.Run,
+		}
+		if run, ok := subcommands[os.Args[1]]; ok {
+			if err := run(os.Args[2:], os.Stdout); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+			return
+		}
+	}
 
 	var metricsAddr string
 	var enableLeaderElection bool
+	var serverSideApply bool
+	var sweepInterval time.Duration
+	var activityTrackingInterval time.Duration
+	var activityTrackingResolution time.Duration
+	var dryRun bool
+	var dryRunReport string
 	flag.StringVar(&metricsAddr, "metrics-addr", ":8080", "The address the metric endpoint binds to.")
 	flag.BoolVar(&enableLeaderElection, "enable-leader-election", false,
 		"Enable leader election for controller manager. "+
 			"Enabling this will ensure there is only one active controller manager.")
+	flag.BoolVar(&serverSideApply, "server-side-apply", false,
+		"Use the server-side apply to sync the objects to the cluster. "+
+			"This makes the operator manage only the fields it sets, leaving the rest to the cluster and other controllers.")
+	flag.DurationVar(&sweepInterval, "sweep-interval", 10*time.Minute,
+		"How often to look for and delete the gateway configuration of the workspace routings that no longer exist.")
+	flag.DurationVar(&activityTrackingInterval, "activity-tracking-interval", time.Minute,
+		"How often to read the request counts from the gateways of the Che managers with the activity tracking enabled "+
+			"and record the last activity on the workspaces.")
+	flag.DurationVar(&activityTrackingResolution, "activity-tracking-resolution", 5*time.Minute,
+		"How old the recorded last activity of a workspace needs to be to be updated. Every update makes the workspace "+
+			"reconcile, so the activity is not recorded on every request.")
+	flag.BoolVar(&dryRun, "dry-run", false,
+		"Only log the changes the operator would do in the cluster without actually doing them. "+
+			"The orphaned objects are not swept and the activity of the workspaces is not tracked in this mode.")
+	flag.StringVar(&dryRunReport, "dry-run-report", "",
+		"The namespace/name of a config map to write the changes planned in the dry-run mode to. The long changes are truncated in the report and those that don't fit into it are only logged.")
 	flag.Parse()
 
 	ctrl.SetLogger(zap.New(zap.UseDevMode(true)))
@@ -79,17 +128,42 @@ func main() {
 		os.Exit(1)
 	}
 
+	var syncOpts []sync.Option
+	if serverSideApply {
+		syncOpts = append(syncOpts, sync.WithServerSideApply(fieldManager))
+	}
+	var report *sync.DryRunReport
+	if dryRun {
+		if dryRunReport != "" {
+			parts := strings.SplitN(dryRunReport, "/", 2)
+			if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+				setupLog.Error(nil, "the dry-run report needs to be specified as namespace/name", "dry-run-report", dryRunReport)
+				os.Exit(1)
+			}
+			report = sync.NewDryRunReport(mgr.GetClient(), scheme, client.ObjectKey{Namespace: parts[0], Name: parts[1]})
+		}
+		syncOpts = append(syncOpts, sync.WithDryRun(report))
+		setupLog.Info("running in the dry-run mode, no changes will be done in the cluster")
+	}
+
 	cheReconciler := &manager.CheReconciler{}
-	if err = cheReconciler.SetupWithManager(mgr); err != nil {
+	if err = cheReconciler.SetupWithManager(mgr, syncOpts...); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "Che")
 		os.Exit(1)
 	}
 
+	// the workspace routing controller writes to the cluster on its own, so in the dry-run mode it gets a client that
+	// only plans the writes and drops the status updates.
+	routingClient := mgr.GetClient()
+	if dryRun {
+		routingClient = sync.NewDryRunClient(mgr.GetClient(), scheme, report)
+	}
+
 	routingReconciler := &workspacerouting.WorkspaceRoutingReconciler{
-		Client:       mgr.GetClient(),
+		Client:       routingClient,
 		Log:          ctrl.Log.WithName("controllers").WithName("WorkspaceRouting"),
 		Scheme:       mgr.GetScheme(),
-		SolverGetter: solver.Getter(scheme),
+		SolverGetter: solver.Getter(scheme, mgr.GetAPIReader(), mgr.GetEventRecorderFor("che-routing"), syncOpts...),
 	}
 
 	if err = routingReconciler.SetupWithManager(mgr); err != nil {
@@ -97,6 +171,35 @@ func main() {
 		os.Exit(1)
 	}
 
+	// the sweeper and the activity tracker only exist to change the cluster, so there is nothing to run in the dry-run mode
+	if !dryRun {
+		// the gateway config maps, the claims and the access to the custom hosts of the workspaces live in a different
+		// namespace than their workspace routings and therefore are not garbage collected when the routings are deleted.
+		// The same goes for the cluster roles of the gateways, which are not namespaced at all.
+		sweptLists := []runtime.Object{&corev1.ConfigMapList{}, &rbacv1.ClusterRoleList{}, &rbacv1.ClusterRoleBindingList{}}
+		if infrastructure.Current.Type == infrastructure.OpenShift {
+			sweptLists = append(sweptLists, &routev1.RouteList{})
+		} else {
+			sweptLists = append(sweptLists, &extensions.IngressList{}, &corev1.SecretList{})
+		}
+		// the swept objects are listed directly so that we don't cache all the secrets, ingresses, etc. in the cluster
+		if err = mgr.Add(sync.NewSweeper(mgr.GetClient(), mgr.GetAPIReader(), scheme, sweepInterval, sweptLists...)); err != nil {
+			setupLog.Error(err, "unable to set up the sweeper of the orphaned objects")
+			os.Exit(1)
+		}
+
+		// the gateway pods and the workspaces are read directly so that we don't cache all of them in the cluster
+		if err = mgr.Add(activity.NewTracker(mgr.GetClient(), mgr.GetAPIReader(), activityTrackingInterval, activityTrackingResolution)); err != nil {
+			setupLog.Error(err, "unable to set up the activity tracker of the workspaces")
+			os.Exit(1)
+		}
+	}
+
+	if err = ctrlmetrics.Registry.Register(metrics.NewCollector(mgr.GetClient())); err != nil {
+		setupLog.Error(err, "unable to register the metrics collector")
+		os.Exit(1)
+	}
+
 	setupLog.Info("starting manager")
 	if err := mgr.Start(ctrl.SetupSignalHandler()); err != nil {
 		setupLog.Error(err, "problem running manager")
@@ -0,0 +1,393 @@
+//
+// Copyright (c) 2019-2021 Red Hat, Inc.
+// This program and the accompanying materials are made
+// available under the terms of the Eclipse Public License 2.0
+// which is available at https://www.eclipse.org/legal/epl-2.0/
+//
+// SPDX-License-Identifier: EPL-2.0
+//
+// Contributors:
+//   Red Hat, Inc. - initial API and implementation
+//
+
+// Package activity tracks the activity of the workspaces at the gateway. The gateway sees every request to
+// the workspace endpoints, so the time of the last request can be used to idle the workspaces without relying
+// on the agents running in them.
+package activity
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/che-incubator/devworkspace-che-operator/apis/che-controller/v1alpha1"
+	"github.com/che-incubator/devworkspace-che-operator/pkg/defaults"
+	"github.com/che-incubator/devworkspace-che-operator/pkg/gateway"
+	"github.com/che-incubator/devworkspace-che-operator/pkg/traefik"
+	dw "github.com/devfile/api/v2/pkg/apis/workspaces/v1alpha2"
+	dwo "github.com/devfile/devworkspace-operator/apis/controller/v1alpha1"
+	"github.com/devfile/devworkspace-operator/pkg/config"
+	"github.com/prometheus/common/expfmt"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/selection"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	// LastActivityAnnotation is the annotation of the DevWorkspace with the time (in the RFC 3339 format) of the last
+	// request to its endpoints seen by the gateway.
+	LastActivityAnnotation = "che.routing.controller.devfile.io/last-activity"
+
+	// requestsMetricName is the counter of the requests to the services in the Prometheus metrics of the gateway
+	requestsMetricName = "traefik_service_requests_total"
+
+	// the services of the dynamic configuration are suffixed with the name of the provider in the metrics
+	fileProviderSuffix = "@file"
+)
+
+var (
+	// unreachableCodes are the status codes with which the gateway responds when the endpoint cannot be reached,
+	// e.g. because the workspace is stopped. Such requests are not the activity of the workspace.
+	unreachableCodes = map[string]bool{"502": true, "503": true, "504": true}
+)
+
+var (
+	log = ctrl.Log.WithName("activity")
+
+	scrapeClient = &http.Client{Timeout: 10 * time.Second}
+)
+
+// scraper reads the request counts of the services from the metrics of a gateway pod. The counts are keyed by
+// the names of the services as they appear in the dynamic configuration of the gateway.
+type scraper func(ctx context.Context, pod *corev1.Pod) (map[string]float64, error)
+
+// Tracker periodically scrapes the metrics of the gateway pods of the Che managers with the activity tracking
+// enabled and records the time of the last request on the DevWorkspaces. A workspace is active if the request
+// counts of any of its endpoint services changed since the previous scrape of the same gateway pod. Every change
+// of a DevWorkspace makes the workspace reconcile, so the time is only recorded if the recorded one is older than
+// the resolution.
+type Tracker struct {
+	client     client.Client
+	reader     client.Reader
+	interval   time.Duration
+	resolution time.Duration
+	scrape     scraper
+	now        func() time.Time
+
+	// counts are the request counts of the services seen in the previous scrape, keyed by the UID of the gateway pod
+	counts map[types.UID]map[string]float64
+}
+
+var _ manager.Runnable = (*Tracker)(nil)
+var _ manager.LeaderElectionRunnable = (*Tracker)(nil)
+
+// NewTracker creates a new tracker that scrapes the gateway pods every interval and records the activity of
+// a workspace at most once per resolution. The pods and the DevWorkspaces are read using the reader, which should
+// not be a caching client so that the operator doesn't need to cache all of them in the cluster. The rest of
+// the objects are read and the DevWorkspaces are annotated using the client.
+func NewTracker(client client.Client, reader client.Reader, interval time.Duration, resolution time.Duration) *Tracker {
+	return &Tracker{
+		client:     client,
+		reader:     reader,
+		interval:   interval,
+		resolution: resolution,
+		scrape:     scrapeGatewayPod,
+		now:        time.Now,
+		counts:     map[types.UID]map[string]float64{},
+	}
+}
+
+// Start tracks the activity until the stop channel is closed.
+func (t *Tracker) Start(stop <-chan struct{}) error {
+	wait.Until(func() {
+		if err := t.Track(context.TODO()); err != nil {
+			log.Error(err, "Failed to track the activity of the workspaces")
+		}
+	}, t.interval, stop)
+	return nil
+}
+
+// NeedLeaderElection makes sure that only the leader tracks the activity. The request counts are compared with
+// the previous scrapes, which only the tracker that did them knows about.
+func (t *Tracker) NeedLeaderElection() bool {
+	return true
+}
+
+// Track scrapes the gateway pods of all the Che managers with the activity tracking enabled and annotates
+// the DevWorkspaces that were active since the previous scrape.
+func (t *Tracker) Track(ctx context.Context) error {
+	managers := &v1alpha1.CheManagerList{}
+	if err := t.client.List(ctx, managers); err != nil {
+		return err
+	}
+
+	seenPods := map[types.UID]bool{}
+
+	for i := range managers.Items {
+		m := &managers.Items[i]
+		if !m.Spec.Gateway.ActivityTracking {
+			continue
+		}
+
+		if err := t.trackManager(ctx, m, seenPods); err != nil {
+			log.Error(err, "Failed to track the activity of the workspaces of the Che manager", "name", m.Name, "namespace", m.Namespace)
+		}
+	}
+
+	// forget the pods that are gone or whose managers no longer track the activity
+	for uid := range t.counts {
+		if !seenPods[uid] {
+			delete(t.counts, uid)
+		}
+	}
+
+	return nil
+}
+
+func (t *Tracker) trackManager(ctx context.Context, manager *v1alpha1.CheManager, seenPods map[types.UID]bool) error {
+	workspaces, err := t.workspaceConfigs(ctx, manager)
+	if err != nil {
+		return err
+	}
+
+	pods := &corev1.PodList{}
+	if err = t.reader.List(ctx, pods, client.InNamespace(manager.Namespace), client.MatchingLabels(defaults.GetLabelsForComponent(manager, "deployment"))); err != nil {
+		return err
+	}
+
+	active := map[string]bool{}
+
+	for i := range pods.Items {
+		pod := &pods.Items[i]
+		if pod.Status.Phase != corev1.PodRunning || pod.Status.PodIP == "" {
+			continue
+		}
+
+		counts, err := t.scrape(ctx, pod)
+		if err != nil {
+			// keep the previous counts so that the requests in the meantime are not missed
+			log.Error(err, "Failed to scrape the metrics of the gateway pod", "name", pod.Name, "namespace", pod.Namespace)
+			seenPods[pod.UID] = true
+			continue
+		}
+
+		previous, known := t.counts[pod.UID]
+		t.counts[pod.UID] = counts
+		seenPods[pod.UID] = true
+
+		// the first scrape of a pod is only the baseline for the next ones
+		if !known {
+			continue
+		}
+
+		for service, count := range counts {
+			// the counters restart with the gateway container, so any change means new requests
+			if prev, ok := previous[service]; ok && prev == count {
+				continue
+			}
+			if cm, ok := workspaces.byService[service]; ok {
+				active[cm.Labels[config.WorkspaceIDLabel]] = true
+			}
+		}
+	}
+
+	now := t.now().UTC()
+	for workspaceID := range active {
+		if err := t.recordActivity(ctx, workspaces.byID[workspaceID], now); err != nil {
+			log.Error(err, "Failed to record the activity of the workspace", "workspace-id", workspaceID)
+		}
+	}
+
+	return nil
+}
+
+// workspaceGatewayConfigs are the gateway config maps of the workspaces of a manager
+type workspaceGatewayConfigs struct {
+	byID      map[string]*corev1.ConfigMap
+	byService map[string]*corev1.ConfigMap
+}
+
+// workspaceConfigs reads the gateway config maps of the workspaces of the manager and indexes them by the workspace
+// IDs and by the names of the endpoint services they configure.
+func (t *Tracker) workspaceConfigs(ctx context.Context, manager *v1alpha1.CheManager) (workspaceGatewayConfigs, error) {
+	ret := workspaceGatewayConfigs{
+		byID:      map[string]*corev1.ConfigMap{},
+		byService: map[string]*corev1.ConfigMap{},
+	}
+
+	workspaceIDExists, err := labels.NewRequirement(config.WorkspaceIDLabel, selection.Exists, nil)
+	if err != nil {
+		return ret, err
+	}
+
+	configs := &corev1.ConfigMapList{}
+	selector := labels.SelectorFromSet(defaults.GetLabelsForComponent(manager, "gateway-config")).Add(*workspaceIDExists)
+	if err := t.client.List(ctx, configs, &client.ListOptions{Namespace: manager.Namespace, LabelSelector: selector}); err != nil {
+		return ret, err
+	}
+
+	for i := range configs.Items {
+		cm := &configs.Items[i]
+		ret.byID[cm.Labels[config.WorkspaceIDLabel]] = cm
+
+		for file, content := range cm.Data {
+			cfg := traefik.Config{}
+			if err := yaml.Unmarshal([]byte(content), &cfg); err != nil {
+				return ret, fmt.Errorf("failed to parse %s in the config map %s/%s: %s", file, cm.Namespace, cm.Name, err)
+			}
+			for _, service := range endpointServices(cfg) {
+				ret.byService[service] = cm
+			}
+		}
+	}
+
+	return ret, nil
+}
+
+// endpointServices returns the names of the services that forward the requests to the workspace endpoints. The routers
+// that only redirect to the endpoints and the error pages of the workspace are not the activity of the workspace.
+func endpointServices(cfg traefik.Config) []string {
+	errorPagesURL := fmt.Sprintf("http://127.0.0.1:%d", gateway.GatewayErrorPagesPort)
+
+	var ret []string
+	for name, router := range cfg.HTTP.Routers {
+		// the redirects to the endpoints use a redirect middleware of the same name as the router
+		if m, ok := cfg.HTTP.Middlewares[name]; ok && m.RedirectRegex != nil && containsString(router.Middlewares, name) {
+			continue
+		}
+
+		service, ok := cfg.HTTP.Services[router.Service]
+		if !ok {
+			continue
+		}
+		servers := service.LoadBalancer.Servers
+		if len(servers) > 0 && servers[0].URL == errorPagesURL {
+			continue
+		}
+
+		ret = append(ret, router.Service)
+	}
+
+	return ret
+}
+
+func containsString(list []string, s string) bool {
+	for _, i := range list {
+		if i == s {
+			return true
+		}
+	}
+	return false
+}
+
+// recordActivity annotates the DevWorkspace of the workspace with the time of its last activity, unless the recorded
+// activity is more recent than the resolution of the tracker. The DevWorkspace is the controller of the workspace
+// routing recorded on the gateway config map of the workspace.
+func (t *Tracker) recordActivity(ctx context.Context, cm *corev1.ConfigMap, now time.Time) error {
+	routing := &dwo.WorkspaceRouting{}
+	key := client.ObjectKey{
+		Name:      cm.Annotations[defaults.ConfigAnnotationWorkspaceRoutingName],
+		Namespace: cm.Annotations[defaults.ConfigAnnotationWorkspaceRoutingNamespace],
+	}
+	if err := t.client.Get(ctx, key, routing); err != nil {
+		if errors.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+
+	owner := metav1.GetControllerOf(routing)
+	if owner == nil || owner.Kind != "DevWorkspace" {
+		return nil
+	}
+
+	workspace := &dw.DevWorkspace{}
+	if err := t.reader.Get(ctx, client.ObjectKey{Name: owner.Name, Namespace: routing.Namespace}, workspace); err != nil {
+		if errors.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+
+	if last, err := time.Parse(time.RFC3339, workspace.Annotations[LastActivityAnnotation]); err == nil && now.Sub(last) < t.resolution {
+		return nil
+	}
+
+	patch := fmt.Sprintf(`{"metadata":{"annotations":{"%s":"%s"}}}`, LastActivityAnnotation, now.Format(time.RFC3339))
+	if err := t.client.Patch(ctx, workspace, client.RawPatch(types.MergePatchType, []byte(patch))); err != nil {
+		if errors.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+
+	return nil
+}
+
+// scrapeGatewayPod reads the request counts of the services from the metrics endpoint of the gateway pod.
+func scrapeGatewayPod(ctx context.Context, pod *corev1.Pod) (map[string]float64, error) {
+	url := fmt.Sprintf("http://%s:%d/metrics", pod.Status.PodIP, gateway.GatewayMetricsPort)
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := scrapeClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("the metrics endpoint %s responded with %s", url, resp.Status)
+	}
+
+	return parseRequestCounts(resp.Body)
+}
+
+// parseRequestCounts sums the request counts of the services in the Prometheus metrics of the gateway. The counts
+// are reported per the entrypoint, method, protocol and status code of the requests. Only the status codes matter
+// here: the requests that could not reach the endpoint are left out.
+func parseRequestCounts(metrics io.Reader) (map[string]float64, error) {
+	families, err := (&expfmt.TextParser{}).TextToMetricFamilies(metrics)
+	if err != nil {
+		return nil, err
+	}
+
+	counts := map[string]float64{}
+
+	family, ok := families[requestsMetricName]
+	if !ok {
+		return counts, nil
+	}
+
+	for _, m := range family.GetMetric() {
+		service := ""
+		unreachable := false
+		for _, l := range m.GetLabel() {
+			switch l.GetName() {
+			case "service":
+				service = l.GetValue()
+			case "code":
+				unreachable = unreachableCodes[l.GetValue()]
+			}
+		}
+
+		if strings.HasSuffix(service, fileProviderSuffix) && !unreachable {
+			counts[strings.TrimSuffix(service, fileProviderSuffix)] += m.GetCounter().GetValue()
+		}
+	}
+
+	return counts, nil
+}
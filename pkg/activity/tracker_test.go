@@ -0,0 +1,276 @@
+//
+// Copyright (c) 2019-2021 Red Hat, Inc.
+// This program and the accompanying materials are made
+// available under the terms of the Eclipse Public License 2.0
+// which is available at https://www.eclipse.org/legal/epl-2.0/
+//
+// SPDX-License-Identifier: EPL-2.0
+//
+// Contributors:
+//   Red Hat, Inc. - initial API and implementation
+//
+
+package activity
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/che-incubator/devworkspace-che-operator/apis/che-controller/v1alpha1"
+	"github.com/che-incubator/devworkspace-che-operator/pkg/defaults"
+	dw "github.com/devfile/api/v2/pkg/apis/workspaces/v1alpha2"
+	dwo "github.com/devfile/devworkspace-operator/apis/controller/v1alpha1"
+	"github.com/devfile/devworkspace-operator/pkg/config"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func createTestScheme() *runtime.Scheme {
+	scheme := runtime.NewScheme()
+	utilruntime.Must(v1alpha1.AddToScheme(scheme))
+	utilruntime.Must(corev1.AddToScheme(scheme))
+	utilruntime.Must(dw.AddToScheme(scheme))
+	utilruntime.Must(dwo.AddToScheme(scheme))
+
+	return scheme
+}
+
+func getTestObjects(activityTracking bool) []runtime.Object {
+	manager := &v1alpha1.CheManager{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "che",
+			Namespace: "ns",
+		},
+		Spec: v1alpha1.CheManagerSpec{
+			Host: "over.the.rainbow",
+			Gateway: v1alpha1.GatewaySpec{
+				ActivityTracking: activityTracking,
+			},
+		},
+	}
+
+	configLabels := defaults.GetLabelsForComponent(manager, "gateway-config")
+	configLabels[config.WorkspaceIDLabel] = "wsid"
+
+	isController := true
+
+	return []runtime.Object{
+		manager,
+		&corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "wsid",
+				Namespace: "ns",
+				Labels:    configLabels,
+				Annotations: map[string]string{
+					defaults.ConfigAnnotationWorkspaceRoutingName:      "routing",
+					defaults.ConfigAnnotationWorkspaceRoutingNamespace: "ws",
+				},
+			},
+			Data: map[string]string{
+				"wsid.yml": `http:
+  routers:
+    wsid-m1-9999:
+      rule: PathPrefix(` + "`/wsid/m1/9999`" + `)
+      service: wsid-m1-9999
+    wsid-m1-9999-redirect:
+      rule: PathPrefix(` + "`/ws/workspace/m1/9999`" + `)
+      service: wsid-m1-9999-redirect
+      middlewares:
+      - wsid-m1-9999-redirect
+    wsid-error-pages:
+      rule: PathPrefix(` + "`/wsid`" + `)
+      service: wsid-error-pages
+  services:
+    wsid-m1-9999:
+      loadBalancer:
+        servers:
+        - url: http://wsid-service.ws.svc:9999
+    wsid-m1-9999-redirect:
+      loadBalancer:
+        servers:
+        - url: http://wsid-service.ws.svc:9999
+    wsid-error-pages:
+      loadBalancer:
+        servers:
+        - url: http://127.0.0.1:8091
+  middlewares:
+    wsid-m1-9999-redirect:
+      redirectRegex:
+        regex: ^([^:]+://[^/]+)/ws/workspace/m1/9999([/?].*)?$
+        replacement: ${1}/wsid/m1/9999${2}
+`,
+			},
+		},
+		&dwo.WorkspaceRouting{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "routing",
+				Namespace: "ws",
+				OwnerReferences: []metav1.OwnerReference{
+					{
+						APIVersion: dw.SchemeGroupVersion.String(),
+						Kind:       "DevWorkspace",
+						Name:       "workspace",
+						UID:        "workspace-uid",
+						Controller: &isController,
+					},
+				},
+			},
+			Spec: dwo.WorkspaceRoutingSpec{
+				WorkspaceId: "wsid",
+			},
+		},
+		&dw.DevWorkspace{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "workspace",
+				Namespace: "ws",
+				UID:       "workspace-uid",
+			},
+		},
+		&corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "gateway",
+				Namespace: "ns",
+				UID:       "pod-uid",
+				Labels:    defaults.GetLabelsForComponent(manager, "deployment"),
+			},
+			Status: corev1.PodStatus{
+				Phase: corev1.PodRunning,
+				PodIP: "10.0.0.1",
+			},
+		},
+	}
+}
+
+func getLastActivity(t *testing.T, cl client.Client) string {
+	workspace := &dw.DevWorkspace{}
+	if err := cl.Get(context.TODO(), client.ObjectKey{Name: "workspace", Namespace: "ws"}, workspace); err != nil {
+		t.Fatal(err)
+	}
+	return workspace.Annotations[LastActivityAnnotation]
+}
+
+func TestRecordsActivityOfWorkspace(t *testing.T) {
+	cl := fake.NewFakeClientWithScheme(createTestScheme(), getTestObjects(true)...)
+
+	counts := map[string]float64{"wsid-m1-9999": 5, "wsid-m1-9999-redirect": 1, "wsid-error-pages": 1, "other-service": 3}
+	now := time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	tracker := NewTracker(cl, cl, time.Minute, 5*time.Minute)
+	tracker.scrape = func(ctx context.Context, pod *corev1.Pod) (map[string]float64, error) {
+		ret := map[string]float64{}
+		for k, v := range counts {
+			ret[k] = v
+		}
+		return ret, nil
+	}
+	tracker.now = func() time.Time {
+		return now
+	}
+
+	track := func() {
+		if err := tracker.Track(context.TODO()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	track()
+	if last := getLastActivity(t, cl); last != "" {
+		t.Errorf("The first scrape of the gateway should only have been the baseline but the last activity is %s", last)
+	}
+
+	now = now.Add(time.Minute)
+	counts["wsid-m1-9999"] = 7
+	track()
+	if last := getLastActivity(t, cl); last != "2021-03-01T12:01:00Z" {
+		t.Errorf("The requests to the workspace should have been recorded as the activity but the last activity is '%s'", last)
+	}
+
+	now = now.Add(time.Minute)
+	counts["other-service"] = 10
+	track()
+	if last := getLastActivity(t, cl); last != "2021-03-01T12:01:00Z" {
+		t.Errorf("The requests to other services should not have been recorded as the activity of the workspace but the last activity is '%s'", last)
+	}
+
+	// e.g. a stopped workspace shows the error pages and the old URLs of its endpoints still redirect
+	now = now.Add(10 * time.Minute)
+	counts["wsid-m1-9999-redirect"] = 5
+	counts["wsid-error-pages"] = 5
+	track()
+	if last := getLastActivity(t, cl); last != "2021-03-01T12:01:00Z" {
+		t.Errorf("The redirects and error pages should not have been recorded as the activity of the workspace but the last activity is '%s'", last)
+	}
+
+	// each change of the DevWorkspace makes it reconcile, so the activity is recorded at most once per resolution
+	if err := cl.Update(context.TODO(), withLastActivity(t, cl, "2021-03-01T12:10:00Z")); err != nil {
+		t.Fatal(err)
+	}
+	now = now.Add(time.Minute)
+	counts["wsid-m1-9999"] = 8
+	track()
+	if last := getLastActivity(t, cl); last != "2021-03-01T12:10:00Z" {
+		t.Errorf("The activity more recent than the resolution should have been kept but the last activity is '%s'", last)
+	}
+
+	now = now.Add(5 * time.Minute)
+	counts["wsid-m1-9999"] = 9
+	track()
+	if last := getLastActivity(t, cl); last != "2021-03-01T12:18:00Z" {
+		t.Errorf("The activity older than the resolution should have been updated but the last activity is '%s'", last)
+	}
+}
+
+func withLastActivity(t *testing.T, cl client.Client, lastActivity string) *dw.DevWorkspace {
+	workspace := &dw.DevWorkspace{}
+	if err := cl.Get(context.TODO(), client.ObjectKey{Name: "workspace", Namespace: "ws"}, workspace); err != nil {
+		t.Fatal(err)
+	}
+	workspace.Annotations[LastActivityAnnotation] = lastActivity
+	return workspace
+}
+
+func TestIgnoresManagersWithoutActivityTracking(t *testing.T) {
+	cl := fake.NewFakeClientWithScheme(createTestScheme(), getTestObjects(false)...)
+
+	tracker := NewTracker(cl, cl, time.Minute, 5*time.Minute)
+	tracker.scrape = func(ctx context.Context, pod *corev1.Pod) (map[string]float64, error) {
+		t.Fatalf("The gateway of the manager without the activity tracking should not have been scraped")
+		return nil, nil
+	}
+
+	if err := tracker.Track(context.TODO()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestParsesRequestCounts(t *testing.T) {
+	metrics := `# HELP traefik_service_requests_total How many HTTP requests processed on a service, partitioned by status code, protocol, and method.
+# TYPE traefik_service_requests_total counter
+traefik_service_requests_total{code="200",method="GET",protocol="http",service="wsid-m1-9999@file"} 10
+traefik_service_requests_total{code="404",method="GET",protocol="http",service="wsid-m1-9999@file"} 2
+traefik_service_requests_total{code="502",method="GET",protocol="http",service="wsid-m1-9999@file"} 3
+traefik_service_requests_total{code="503",method="GET",protocol="http",service="wsid-m1-8888@file"} 4
+traefik_service_requests_total{code="200",method="GET",protocol="websocket",service="wsid-m1-8888@file"} 1
+traefik_service_requests_total{code="200",method="GET",protocol="http",service="prometheus@internal"} 50
+# HELP traefik_entrypoint_requests_total How many HTTP requests processed on an entrypoint, partitioned by status code, protocol, and method.
+# TYPE traefik_entrypoint_requests_total counter
+traefik_entrypoint_requests_total{code="200",entrypoint="http",method="GET",protocol="http"} 11
+`
+
+	counts, err := parseRequestCounts(strings.NewReader(metrics))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]float64{"wsid-m1-9999": 12, "wsid-m1-8888": 1}
+	if !reflect.DeepEqual(counts, expected) {
+		t.Errorf("Expected the request counts %v but got %v", expected, counts)
+	}
+}
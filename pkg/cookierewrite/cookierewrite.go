@@ -0,0 +1,178 @@
+//
+// Copyright (c) 2019-2021 Red Hat, Inc.
+// This program and the accompanying materials are made
+// available under the terms of the Eclipse Public License 2.0
+// which is available at https://www.eclipse.org/legal/epl-2.0/
+//
+// SPDX-License-Identifier: EPL-2.0
+//
+// Contributors:
+//   Red Hat, Inc. - initial API and implementation
+//
+
+// Package cookierewrite implements the operator subcommand that rewrites the paths of the cookies set by
+// the workspace endpoints. Traefik cannot modify the cookies of the responses, so the gateway forwards the requests
+// to the endpoints that need it through this proxy running in a sidecar of the gateway.
+package cookierewrite
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+
+	ctrl "sigs.k8s.io/controller-runtime"
+)
+
+const (
+	// CommandName is the name of the operator subcommand that rewrites the cookie paths
+	CommandName = "cookie-rewrite"
+
+	// BackendHeader is the header in which the gateway tells the proxy the URL of the endpoint to forward
+	// the request to
+	BackendHeader = "X-Che-Cookie-Rewrite-Backend"
+	// PublicPrefixHeader is the header in which the gateway tells the proxy the public path prefix of the endpoint
+	PublicPrefixHeader = "X-Che-Cookie-Rewrite-Public-Prefix"
+	// BackendPrefixHeader is the header in which the gateway tells the proxy the path prefix the gateway adds to
+	// the requests to the endpoint. It is optional.
+	BackendPrefixHeader = "X-Che-Cookie-Rewrite-Backend-Prefix"
+)
+
+var (
+	log = ctrl.Log.WithName("cookie-rewrite")
+)
+
+// Run serves the proxy on the address specified by the command line arguments until the server fails.
+func Run(args []string, out io.Writer) error {
+	flags := flag.NewFlagSet(CommandName, flag.ContinueOnError)
+	address := flags.String("address", "127.0.0.1:8092", "The address to serve the proxy on.")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(out, "Rewriting the cookie paths on %s\n", *address)
+
+	return http.ListenAndServe(*address, NewHandler())
+}
+
+// NewHandler returns the handler forwarding the requests to the endpoint in the BackendHeader and rewriting the paths
+// of the cookies in its responses from the BackendPrefixHeader to the PublicPrefixHeader.
+func NewHandler() http.Handler {
+	return &handler{}
+}
+
+type handler struct {
+	// transport is used to forward the requests. The default transport is used if nil.
+	transport http.RoundTripper
+}
+
+func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	backend, err := url.Parse(r.Header.Get(BackendHeader))
+	if err != nil || !isServiceURL(backend) {
+		log.Info("Refusing to forward a request without a valid backend URL", "backend", r.Header.Get(BackendHeader))
+		http.Error(w, "no valid backend to forward the request to", http.StatusBadGateway)
+		return
+	}
+
+	publicPrefix := r.Header.Get(PublicPrefixHeader)
+	backendPrefix := r.Header.Get(BackendPrefixHeader)
+	if !strings.HasPrefix(publicPrefix, "/") || (backendPrefix != "" && !strings.HasPrefix(backendPrefix, "/")) {
+		log.Info("Refusing to forward a request without valid path prefixes", "publicPrefix", publicPrefix, "backendPrefix", backendPrefix)
+		http.Error(w, "no valid path prefixes to rewrite the cookies with", http.StatusBadGateway)
+		return
+	}
+
+	proxy := &httputil.ReverseProxy{
+		Transport: h.transport,
+		Director: func(req *http.Request) {
+			req.URL.Scheme = backend.Scheme
+			req.URL.Host = backend.Host
+			req.Header.Del(BackendHeader)
+			req.Header.Del(PublicPrefixHeader)
+			req.Header.Del(BackendPrefixHeader)
+		},
+		ModifyResponse: func(resp *http.Response) error {
+			RewriteCookiePaths(resp.Header, backendPrefix, publicPrefix)
+			return nil
+		},
+	}
+
+	// the gateway already set the forwarded headers, so we don't want the proxy to add the address of the gateway
+	// to them. The proxy only does that if it knows the address of the client.
+	req := r.WithContext(r.Context())
+	req.RemoteAddr = ""
+
+	proxy.ServeHTTP(w, req)
+}
+
+// isServiceURL checks that the URL is the plain in-cluster URL of a service, as generated by the solver for
+// the workspace endpoints, i.e. http://<service>.<namespace>.svc:<port>. The proxy only ever forwards to such URLs
+// so that it cannot be used to reach arbitrary hosts.
+func isServiceURL(u *url.URL) bool {
+	if u.Scheme != "http" || u.User != nil || u.Opaque != "" || u.RawQuery != "" || u.Fragment != "" || (u.Path != "" && u.Path != "/") {
+		return false
+	}
+
+	if u.Port() == "" {
+		return false
+	}
+
+	labels := strings.Split(u.Hostname(), ".")
+	if len(labels) != 3 || labels[2] != "svc" {
+		return false
+	}
+
+	return labels[0] != "" && labels[1] != ""
+}
+
+// RewriteCookiePaths rewrites the paths of the cookies set in the headers, so that the cookies under the backend
+// prefix are set under the public prefix instead. The cookies without a path and the cookies already set under
+// the public prefix are left intact.
+func RewriteCookiePaths(header http.Header, backendPrefix string, publicPrefix string) {
+	cookies := header["Set-Cookie"]
+	for i := range cookies {
+		cookies[i] = rewriteCookiePath(cookies[i], backendPrefix, publicPrefix)
+	}
+}
+
+func rewriteCookiePath(cookie string, backendPrefix string, publicPrefix string) string {
+	publicPrefix = strings.TrimSuffix(publicPrefix, "/")
+	backendPrefix = strings.TrimSuffix(backendPrefix, "/")
+
+	// the first part is the name and value of the cookie, the rest are its attributes
+	parts := strings.Split(cookie, ";")
+	for i := 1; i < len(parts); i++ {
+		attr := strings.TrimLeft(parts[i], " \t")
+		eq := strings.Index(attr, "=")
+		if eq < 0 || !strings.EqualFold(strings.TrimSpace(attr[:eq]), "path") {
+			continue
+		}
+
+		path := strings.TrimSpace(attr[eq+1:])
+		if !strings.HasPrefix(path, "/") || hasPathPrefix(path, publicPrefix) || !hasPathPrefix(path, backendPrefix) {
+			continue
+		}
+
+		path = publicPrefix + strings.TrimPrefix(path, backendPrefix)
+		if path == publicPrefix+"/" {
+			// the cookie path matches all the paths under it, so the trailing slash would only exclude
+			// the request to the prefix itself
+			path = publicPrefix
+		}
+		if path == "" {
+			path = "/"
+		}
+
+		parts[i] = parts[i][:len(parts[i])-len(attr)] + "Path=" + path
+	}
+
+	return strings.Join(parts, ";")
+}
+
+// hasPathPrefix returns true if the path equals the prefix or is under it. The empty prefix matches all the paths.
+func hasPathPrefix(path string, prefix string) bool {
+	return prefix == "" || path == prefix || strings.HasPrefix(path, prefix+"/")
+}
@@ -1,6 +1,7 @@
 package defaults
 
 import (
+	"fmt"
 	"os"
 	"runtime"
 
@@ -9,10 +10,12 @@ import (
 )
 
 const (
-	gatewayImageEnvVarName           = "RELATED_IMAGE_gateway"
-	gatewayConfigurerImageEnvVarName = "RELATED_IMAGE_gateway_configurer"
+	gatewayImageEnvVarName               = "RELATED_IMAGE_gateway"
+	gatewayConfigurerImageEnvVarName     = "RELATED_IMAGE_gateway_configurer"
+	gatewayErrorPagesImageEnvVarName     = "RELATED_IMAGE_gateway_error_pages"
+	gatewayCookieRewriterImageEnvVarName = "RELATED_IMAGE_gateway_cookie_rewriter"
 
-	defaultGatewayImage           = "docker.io/traefik:v2.2.8"
+	defaultGatewayImage           = "docker.io/traefik:v2.4.8"
 	defaultGatewayConfigurerImage = "quay.io/che-incubator/configbump:0.1.4"
 
 	configAnnotationPrefix                    = "che.routing.controller.devfile.io/"
@@ -20,6 +23,8 @@ const (
 	ConfigAnnotationCheManagerNamespace       = configAnnotationPrefix + "che-namespace"
 	ConfigAnnotationWorkspaceRoutingName      = configAnnotationPrefix + "workspace-routing-name"
 	ConfigAnnotationWorkspaceRoutingNamespace = configAnnotationPrefix + "workspace-routing-namespace"
+	ConfigAnnotationGatewayConfigHash         = configAnnotationPrefix + "gateway-config-hash"
+	ConfigAnnotationWorkspaceName             = configAnnotationPrefix + "workspace-name"
 )
 
 var (
@@ -50,6 +55,37 @@ func GetGatewayConfigurerImage() string {
 	return read(gatewayConfigurerImageEnvVarName, defaultGatewayConfigurerImage)
 }
 
+// GetGatewayErrorPagesImage returns the image of the error pages sidecar of the gateway. The error pages are served by
+// the operator binary itself, so there is no hardcoded default that would drift from the version of the operator.
+// The deployment of the operator sets the image to its own.
+func GetGatewayErrorPagesImage() (string, error) {
+	ret := os.Getenv(gatewayErrorPagesImageEnvVarName)
+	if len(ret) == 0 {
+		ret = os.Getenv(archDependent(gatewayErrorPagesImageEnvVarName))
+	}
+
+	if len(ret) == 0 {
+		return "", fmt.Errorf("the image of the error pages is not configured, the %s environment variable of the operator needs to be set to the image of the operator", gatewayErrorPagesImageEnvVarName)
+	}
+
+	return ret, nil
+}
+
+// GetGatewayCookieRewriterImage returns the image of the cookie rewriting sidecar of the gateway. Like the error
+// pages, the sidecar runs the operator binary, so the deployment of the operator sets the image to its own.
+func GetGatewayCookieRewriterImage() (string, error) {
+	ret := os.Getenv(gatewayCookieRewriterImageEnvVarName)
+	if len(ret) == 0 {
+		ret = os.Getenv(archDependent(gatewayCookieRewriterImageEnvVarName))
+	}
+
+	if len(ret) == 0 {
+		return "", fmt.Errorf("the image of the cookie rewriter is not configured, the %s environment variable of the operator needs to be set to the image of the operator", gatewayCookieRewriterImageEnvVarName)
+	}
+
+	return ret, nil
+}
+
 func read(varName string, fallback string) string {
 	ret := os.Getenv(varName)
 
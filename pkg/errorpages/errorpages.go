@@ -0,0 +1,196 @@
+//
+// Copyright (c) 2019-2021 Red Hat, Inc.
+// This program and the accompanying materials are made
+// available under the terms of the Eclipse Public License 2.0
+// which is available at https://www.eclipse.org/legal/epl-2.0/
+//
+// SPDX-License-Identifier: EPL-2.0
+//
+// Contributors:
+//   Red Hat, Inc. - initial API and implementation
+//
+
+// Package errorpages implements the operator subcommand that serves the error pages of the gateway. The gateway
+// shows them instead of its own error responses when a workspace endpoint cannot be reached, e.g. because
+// the workspace is starting or stopped.
+package errorpages
+
+import (
+	"flag"
+	"fmt"
+	"html/template"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	dw "github.com/devfile/api/v2/pkg/apis/workspaces/v1alpha2"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// CommandName is the name of the operator subcommand that serves the error pages
+	CommandName = "error-pages"
+
+	// refreshSeconds is how often the error pages reload the original URL, so that the user gets to the endpoint
+	// as soon as the workspace is ready
+	refreshSeconds = 5
+)
+
+var (
+	log = ctrl.Log.WithName("error-pages")
+
+	pageTemplate = template.Must(template.New("page").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+{{- if .Refresh }}
+<meta http-equiv="refresh" content="{{ .RefreshSeconds }}">
+{{- end }}
+<title>{{ .Title }}</title>
+</head>
+<body>
+<h1>{{ .Title }}</h1>
+<p>{{ .Description }}</p>
+{{- if .Message }}
+<p>{{ .Message }}</p>
+{{- end }}
+{{- if .Refresh }}
+<p>This page reloads automatically every {{ .RefreshSeconds }} seconds.</p>
+{{- end }}
+<p><small>Workspace {{ .Namespace }}/{{ .Name }}, HTTP status {{ .Status }}</small></p>
+</body>
+</html>
+`))
+)
+
+// page holds the data rendered on the error page
+type page struct {
+	Namespace      string
+	Name           string
+	Status         int
+	Title          string
+	Description    string
+	Message        string
+	Refresh        bool
+	RefreshSeconds int
+}
+
+// Run serves the error pages on the address specified by the command line arguments until the server fails.
+func Run(args []string, out io.Writer) error {
+	flags := flag.NewFlagSet(CommandName, flag.ContinueOnError)
+	address := flags.String("address", "127.0.0.1:8091", "The address to serve the error pages on.")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	cfg, err := ctrl.GetConfig()
+	if err != nil {
+		return err
+	}
+
+	scheme := runtime.NewScheme()
+	utilruntime.Must(dw.AddToScheme(scheme))
+
+	cl, err := client.New(cfg, client.Options{Scheme: scheme})
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(out, "Serving the error pages on %s\n", *address)
+
+	return http.ListenAndServe(*address, NewHandler(cl))
+}
+
+// NewHandler returns the handler of the error page requests. The path of the requests is
+// /<workspace namespace>/<workspace name>/<status code>. The page describes the status of the DevWorkspace and
+// responds with the status code from the path.
+func NewHandler(cl client.Reader) http.Handler {
+	return &handler{client: cl}
+}
+
+type handler struct {
+	client client.Reader
+}
+
+func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if len(parts) != 3 {
+		http.NotFound(w, r)
+		return
+	}
+
+	status, err := strconv.Atoi(parts[2])
+	if err != nil || http.StatusText(status) == "" {
+		http.NotFound(w, r)
+		return
+	}
+
+	p := page{
+		Namespace:      parts[0],
+		Name:           parts[1],
+		Status:         status,
+		RefreshSeconds: refreshSeconds,
+	}
+
+	workspace := &dw.DevWorkspace{}
+	if err := h.client.Get(r.Context(), client.ObjectKey{Name: p.Name, Namespace: p.Namespace}, workspace); err != nil {
+		if errors.IsNotFound(err) {
+			p.Title = "Workspace not found"
+			p.Description = "The workspace doesn't exist (anymore)."
+		} else {
+			log.Error(err, "Failed to get the workspace", "namespace", p.Namespace, "name", p.Name)
+			p.Title = "Workspace not available"
+			p.Description = "The status of the workspace could not be determined."
+			p.Refresh = true
+		}
+	} else {
+		describe(&p, workspace.Status)
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	if err := pageTemplate.Execute(w, p); err != nil {
+		log.Error(err, "Failed to render the error page", "namespace", p.Namespace, "name", p.Name)
+	}
+}
+
+// describe fills in the description of the workspace status on the page. The page keeps reloading, so that
+// the user gets to the endpoint once the workspace runs, unless there's no point in waiting.
+func describe(p *page, status dw.DevWorkspaceStatus) {
+	p.Message = status.Message
+	p.Refresh = true
+
+	switch status.Phase {
+	case dw.WorkspaceStatusStarting:
+		p.Title = "Workspace is starting"
+		p.Description = "The workspace is starting. The page will be available once the workspace is running."
+	case dw.WorkspaceStatusRunning:
+		if p.Status == http.StatusNotFound {
+			p.Title = "Page not found"
+			p.Description = "The workspace is running but it has no endpoint on this path."
+			p.Refresh = false
+		} else {
+			p.Title = "Endpoint not ready"
+			p.Description = "The workspace is running but the endpoint is not responding yet."
+		}
+	case dw.WorkspaceStatusStopping:
+		p.Title = "Workspace is stopping"
+		p.Description = "The workspace is stopping. It needs to be started again to access its endpoints."
+	case dw.WorkspaceStatusStopped:
+		p.Title = "Workspace is stopped"
+		p.Description = "The workspace is stopped. It needs to be started to access its endpoints."
+	case dw.WorkspaceStatusFailed, dw.WorkspaceStatusError:
+		p.Title = "Workspace failed"
+		p.Description = "The workspace failed to start."
+		p.Refresh = false
+	default:
+		p.Title = "Workspace not available"
+		p.Description = "The workspace is being prepared."
+	}
+}
@@ -0,0 +1,89 @@
+package errorpages
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	dw "github.com/devfile/api/v2/pkg/apis/workspaces/v1alpha2"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func createTestScheme() *runtime.Scheme {
+	scheme := runtime.NewScheme()
+	utilruntime.Must(dw.AddToScheme(scheme))
+	return scheme
+}
+
+func workspace(name string, phase dw.WorkspacePhase, message string) *dw.DevWorkspace {
+	return &dw.DevWorkspace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: "ws",
+		},
+		Status: dw.DevWorkspaceStatus{
+			Phase:   phase,
+			Message: message,
+		},
+	}
+}
+
+func TestDescribesWorkspaceStatus(t *testing.T) {
+	cl := fake.NewFakeClientWithScheme(createTestScheme(),
+		workspace("starting", dw.WorkspaceStatusStarting, "Waiting for the workspace deployment"),
+		workspace("running", dw.WorkspaceStatusRunning, ""),
+		workspace("stopped", dw.WorkspaceStatusStopped, ""),
+		workspace("failed", dw.WorkspaceStatusFailed, "Image pull failed"))
+
+	handler := NewHandler(cl)
+
+	for _, tc := range []struct {
+		path     string
+		status   int
+		contains []string
+		refresh  bool
+	}{
+		{"/ws/starting/502", http.StatusBadGateway, []string{"Workspace is starting", "Waiting for the workspace deployment"}, true},
+		{"/ws/running/503", http.StatusServiceUnavailable, []string{"Endpoint not ready"}, true},
+		{"/ws/running/404", http.StatusNotFound, []string{"no endpoint on this path"}, false},
+		{"/ws/stopped/502", http.StatusBadGateway, []string{"Workspace is stopped"}, true},
+		{"/ws/failed/502", http.StatusBadGateway, []string{"Workspace failed", "Image pull failed"}, false},
+		{"/ws/nonexistent/404", http.StatusNotFound, []string{"Workspace not found"}, false},
+	} {
+		t.Run(tc.path, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tc.path, nil))
+
+			if rec.Code != tc.status {
+				t.Errorf("Expected the status %d but got %d", tc.status, rec.Code)
+			}
+
+			body := rec.Body.String()
+			for _, c := range tc.contains {
+				if !strings.Contains(body, c) {
+					t.Errorf("Expected the page to contain '%s' but it was:\n%s", c, body)
+				}
+			}
+
+			if refresh := strings.Contains(body, `http-equiv="refresh"`); refresh != tc.refresh {
+				t.Errorf("Expected the page to reload automatically to be %t but it was %t", tc.refresh, refresh)
+			}
+		})
+	}
+}
+
+func TestRejectsMalformedPaths(t *testing.T) {
+	handler := NewHandler(fake.NewFakeClientWithScheme(createTestScheme()))
+
+	for _, path := range []string{"/ws/starting", "/ws/starting/abc", "/ws/starting/999", "/a/b/c/502"} {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		if rec.Code != http.StatusNotFound || strings.Contains(rec.Body.String(), "Workspace") {
+			t.Errorf("Expected the plain not found response for %s but got %d: %s", path, rec.Code, rec.Body.String())
+		}
+	}
+}
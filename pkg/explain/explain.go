@@ -0,0 +1,189 @@
+//
+// Copyright (c) 2019-2021 Red Hat, Inc.
+// This program and the accompanying materials are made
+// available under the terms of the Eclipse Public License 2.0
+// which is available at https://www.eclipse.org/legal/epl-2.0/
+//
+// SPDX-License-Identifier: EPL-2.0
+//
+// Contributors:
+//   Red Hat, Inc. - initial API and implementation
+//
+
+// Package explain implements the operator subcommand that explains how the gateway routes a public URL to
+// a workspace backend.
+package explain
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+
+	"github.com/che-incubator/devworkspace-che-operator/pkg/traefik"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	// CommandName is the name of the operator subcommand that explains the routing
+	CommandName = "explain"
+
+	gatewayConfigComponent = "gateway-config"
+)
+
+type files []string
+
+func (f *files) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *files) Set(value string) error {
+	*f = append(*f, value)
+	return nil
+}
+
+// Run explains the routing of the URL as specified by the command line arguments and writes the explanation to out.
+func Run(args []string, out io.Writer) error {
+	var configFiles files
+	flags := flag.NewFlagSet(CommandName, flag.ContinueOnError)
+	url := flags.String("url", "", "The public URL to explain the routing of.")
+	namespace := flags.String("namespace", "", "The namespace of the che manager to read the gateway config maps from.")
+	managerName := flags.String("manager", "", "The name of the che manager to read the gateway config maps of. "+
+		"All the gateway config maps in the namespace are read if not specified.")
+	flags.Var(&configFiles, "file", "The YAML file with a gateway config map or a dynamic Traefik configuration to use "+
+		"instead of reading the config maps from the cluster. Can be repeated.")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if *url == "" || (*namespace == "" && len(configFiles) == 0) {
+		flags.Usage()
+		return fmt.Errorf("the URL and either the namespace or the configuration files need to be specified")
+	}
+
+	var configMaps []corev1.ConfigMap
+	var err error
+	if len(configFiles) > 0 {
+		configMaps, err = readFiles(configFiles)
+	} else {
+		configMaps, err = readClusterConfigMaps(*namespace, *managerName)
+	}
+	if err != nil {
+		return err
+	}
+
+	gateway, err := traefik.GatewayFromConfigMaps(configMaps...)
+	if err != nil {
+		return err
+	}
+
+	ex, err := gateway.ExplainURL(*url)
+	if err != nil {
+		return err
+	}
+
+	return write(out, ex)
+}
+
+func write(out io.Writer, ex *traefik.Explanation) error {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "Router:      %s\n", ex.RouterName)
+	fmt.Fprintf(&sb, "Rule:        %s\n", ex.Router.Rule)
+	fmt.Fprintf(&sb, "Priority:    %d\n", ex.Router.EffectivePriority())
+	if len(ex.Middlewares) == 0 {
+		fmt.Fprintf(&sb, "Middlewares: none\n")
+	} else {
+		fmt.Fprintf(&sb, "Middlewares:\n")
+		for _, m := range ex.Middlewares {
+			fmt.Fprintf(&sb, "  - %s: %s\n", m.Name, m.Effect)
+		}
+	}
+	if ex.Redirect != "" {
+		fmt.Fprintf(&sb, "Redirect:    %s\n", ex.Redirect)
+	} else {
+		fmt.Fprintf(&sb, "Service:     %s\n", ex.ServiceName)
+		fmt.Fprintf(&sb, "Backend:     %s\n", ex.Backend)
+		if t := ex.Transport.ForwardingTimeouts; t != nil {
+			fmt.Fprintf(&sb, "Timeouts:    %s (dial %s, response header %s, idle connection %s)\n", ex.TransportName,
+				orDefault(t.DialTimeout), orDefault(t.ResponseHeaderTimeout), orDefault(t.IdleConnTimeout))
+		}
+	}
+
+	_, err := io.WriteString(out, sb.String())
+	return err
+}
+
+func orDefault(duration string) string {
+	if duration == "" {
+		return "default"
+	}
+	return duration
+}
+
+// readFiles reads the config maps from the files. A file that doesn't contain a config map is considered to be
+// the dynamic Traefik configuration itself.
+func readFiles(configFiles []string) ([]corev1.ConfigMap, error) {
+	var ret []corev1.ConfigMap
+	for _, f := range configFiles {
+		data, err := ioutil.ReadFile(f)
+		if err != nil {
+			return nil, err
+		}
+
+		meta := &metav1.TypeMeta{}
+		if err = yaml.Unmarshal(data, meta); err != nil {
+			return nil, err
+		}
+
+		if meta.Kind == "ConfigMap" {
+			cm := corev1.ConfigMap{}
+			if err = yaml.Unmarshal(data, &cm); err != nil {
+				return nil, err
+			}
+			ret = append(ret, cm)
+		} else {
+			ret = append(ret, corev1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{Name: f},
+				Data:       map[string]string{f: string(data)},
+			})
+		}
+	}
+
+	return ret, nil
+}
+
+// readClusterConfigMaps reads the config maps that the gateway reads its dynamic configuration from.
+func readClusterConfigMaps(namespace string, managerName string) ([]corev1.ConfigMap, error) {
+	cfg, err := ctrl.GetConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	scheme := runtime.NewScheme()
+	utilruntime.Must(corev1.AddToScheme(scheme))
+
+	cl, err := client.New(cfg, client.Options{Scheme: scheme})
+	if err != nil {
+		return nil, err
+	}
+
+	labels := client.MatchingLabels{"app.kubernetes.io/component": gatewayConfigComponent}
+	if managerName != "" {
+		labels["app.kubernetes.io/part-of"] = managerName
+	}
+
+	list := &corev1.ConfigMapList{}
+	if err = cl.List(context.TODO(), list, client.InNamespace(namespace), labels); err != nil {
+		return nil, err
+	}
+
+	return list.Items, nil
+}
@@ -0,0 +1,74 @@
+package explain
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const workspaceConfigMap = `apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: wsid
+  namespace: che
+data:
+  wsid.yml: |
+    http:
+      routers:
+        wsid-theia-ide-3100:
+          rule: "PathPrefix(` + "`/wsid/theia-ide/3100`" + `)"
+          service: wsid-theia-ide-3100
+          middlewares: [wsid-theia-ide-3100]
+      services:
+        wsid-theia-ide-3100:
+          loadBalancer:
+            servers:
+            - url: "http://wsid-service.workspace.svc:3100"
+            serversTransport: wsid-theia-ide-3100-transport
+      middlewares:
+        wsid-theia-ide-3100:
+          stripPrefix:
+            prefixes: ["/wsid/theia-ide/3100"]
+      serversTransports:
+        wsid-theia-ide-3100-transport:
+          forwardingTimeouts:
+            responseHeaderTimeout: 1m30s
+`
+
+func TestExplainsURLUsingFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "explain")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "wsid.yaml")
+	if err = ioutil.WriteFile(file, []byte(workspaceConfigMap), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	out := &bytes.Buffer{}
+	err = Run([]string{"--url", "https://che.example.com/wsid/theia-ide/3100/index.html", "--file", file}, out)
+	if err != nil {
+		t.Fatalf("Failed to explain the URL: %s", err)
+	}
+
+	if !strings.Contains(out.String(), "Router:      wsid-theia-ide-3100\n") {
+		t.Errorf("Unexpected router in the explanation:\n%s", out.String())
+	}
+	if !strings.Contains(out.String(), "Backend:     http://wsid-service.workspace.svc:3100/index.html\n") {
+		t.Errorf("Unexpected backend in the explanation:\n%s", out.String())
+	}
+	if !strings.Contains(out.String(), "Timeouts:    wsid-theia-ide-3100-transport (dial default, response header 1m30s, idle connection default)\n") {
+		t.Errorf("Unexpected timeouts in the explanation:\n%s", out.String())
+	}
+}
+
+func TestRequiresURL(t *testing.T) {
+	if err := Run([]string{"--namespace", "che"}, &bytes.Buffer{}); err == nil {
+		t.Errorf("Expected an error about the missing URL")
+	}
+}
@@ -14,37 +14,51 @@ package gateway
 
 import (
 	"context"
+	"crypto/sha256"
+	"fmt"
 
 	"github.com/che-incubator/devworkspace-che-operator/apis/che-controller/v1alpha1"
+	"github.com/che-incubator/devworkspace-che-operator/pkg/cookierewrite"
 	"github.com/che-incubator/devworkspace-che-operator/pkg/defaults"
+	"github.com/che-incubator/devworkspace-che-operator/pkg/errorpages"
 	"github.com/che-incubator/devworkspace-che-operator/pkg/infrastructure"
 	"github.com/che-incubator/devworkspace-che-operator/pkg/sync"
+	"github.com/che-incubator/devworkspace-che-operator/pkg/traefik"
+	dw "github.com/devfile/api/v2/pkg/apis/workspaces/v1alpha2"
 	"github.com/google/go-cmp/cmp"
 	"github.com/google/go-cmp/cmp/cmpopts"
+	routev1 "github.com/openshift/api/route/v1"
 	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/api/extensions/v1beta1"
+	networkingv1 "k8s.io/api/networking/v1"
+	policy "k8s.io/api/policy/v1beta1"
 	rbac "k8s.io/api/rbac/v1"
 	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/tools/record"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/yaml"
 )
 
 var (
-	serviceAccountDiffOpts = cmpopts.IgnoreFields(corev1.ServiceAccount{}, "TypeMeta", "ObjectMeta", "Secrets", "ImagePullSecrets")
-	roleDiffOpts           = cmpopts.IgnoreFields(rbac.Role{}, "TypeMeta", "ObjectMeta")
-	roleBindingDiffOpts    = cmpopts.IgnoreFields(rbac.RoleBinding{}, "TypeMeta", "ObjectMeta")
-	serviceDiffOpts        = cmp.Options{
+	serviceAccountDiffOpts     = cmpopts.IgnoreFields(corev1.ServiceAccount{}, "TypeMeta", "ObjectMeta", "Secrets", "ImagePullSecrets")
+	roleDiffOpts               = cmpopts.IgnoreFields(rbac.Role{}, "TypeMeta", "ObjectMeta")
+	roleBindingDiffOpts        = cmpopts.IgnoreFields(rbac.RoleBinding{}, "TypeMeta", "ObjectMeta")
+	clusterRoleDiffOpts        = cmpopts.IgnoreFields(rbac.ClusterRole{}, "TypeMeta", "ObjectMeta")
+	clusterRoleBindingDiffOpts = cmpopts.IgnoreFields(rbac.ClusterRoleBinding{}, "TypeMeta", "ObjectMeta")
+	serviceDiffOpts            = cmp.Options{
 		cmpopts.IgnoreFields(corev1.Service{}, "TypeMeta", "ObjectMeta", "Status"),
 		cmpopts.IgnoreFields(corev1.ServiceSpec{}, "ClusterIP"),
 	}
 	configMapDiffOpts  = cmpopts.IgnoreFields(corev1.ConfigMap{}, "TypeMeta", "ObjectMeta")
 	deploymentDiffOpts = cmp.Options{
 		cmpopts.IgnoreFields(appsv1.Deployment{}, "TypeMeta", "ObjectMeta", "Status"),
-		cmpopts.IgnoreFields(appsv1.DeploymentSpec{}, "Replicas", "RevisionHistoryLimit", "ProgressDeadlineSeconds"),
-		cmpopts.IgnoreFields(appsv1.DeploymentStrategy{}, "RollingUpdate"),
+		cmpopts.IgnoreFields(appsv1.DeploymentSpec{}, "RevisionHistoryLimit", "ProgressDeadlineSeconds"),
 		cmpopts.IgnoreFields(corev1.Container{}, "TerminationMessagePath", "TerminationMessagePolicy"),
 		cmpopts.IgnoreFields(corev1.PodSpec{}, "DNSPolicy", "SchedulerName", "SecurityContext", "DeprecatedServiceAccount"),
 		cmpopts.IgnoreFields(corev1.ConfigMapVolumeSource{}, "DefaultMode"),
@@ -53,26 +67,55 @@ var (
 			return x.Cmp(y) == 0
 		}),
 	}
+	podDisruptionBudgetDiffOpts = cmpopts.IgnoreFields(policy.PodDisruptionBudget{}, "TypeMeta", "ObjectMeta", "Status")
 
-	GatewayPort       = 8080
-	GatewaySecurePort = 8443
+	GatewayPort        = 8080
+	GatewaySecurePort  = 8443
+	GatewayMetricsPort = 8090
+
+	// GatewayErrorPagesPort is the port on which the error pages sidecar of the gateway listens. It is only
+	// reachable from within the gateway pod.
+	GatewayErrorPagesPort = 8091
+
+	// GatewayCookieRewritePort is the port on which the cookie rewriting sidecar of the gateway listens. It is only
+	// reachable from within the gateway pod.
+	GatewayCookieRewritePort = 8092
+
+	// GatewayMetricsPortName is the name of the port of the gateway service on which the Prometheus metrics are exposed
+	GatewayMetricsPortName = "gateway-metrics"
+
+	gatewayStaticConfigFileName = "traefik.yml"
+
+	defaultGatewayReplicas int32 = 1
 )
 
 type CheGateway struct {
-	client client.Client
-	scheme *runtime.Scheme
+	client   client.Client
+	reader   client.Reader
+	scheme   *runtime.Scheme
+	recorder record.EventRecorder
+	syncOpts []sync.Option
 }
 
-func New(client client.Client, scheme *runtime.Scheme) CheGateway {
+// New creates a new gateway handler. The reader is used to read the existing objects of the gateway and to find
+// the objects to prune. It should not cache (e.g. the API reader of the manager), because the gateway reads kinds
+// that are not watched, like the network policies or the cluster roles, and the cached client would start caching
+// all of them in the cluster. If nil, the client is used. The recorder is used to record the events about
+// the gateway on the Che managers and can be nil if no events should be recorded. The sync options configure how
+// the objects of the gateway are synced to the cluster.
+func New(client client.Client, reader client.Reader, scheme *runtime.Scheme, recorder record.EventRecorder, syncOpts ...sync.Option) CheGateway {
 	return CheGateway{
-		client: client,
-		scheme: scheme,
+		client:   client,
+		reader:   reader,
+		scheme:   scheme,
+		recorder: recorder,
+		syncOpts: syncOpts,
 	}
 }
 
 func (g *CheGateway) Sync(ctx context.Context, manager *v1alpha1.CheManager) (bool, string, error) {
 
-	syncer := sync.New(g.client, g.scheme)
+	syncer := g.syncer()
 
 	var ret, partial bool
 	var err error
@@ -95,24 +138,51 @@ func (g *CheGateway) Sync(ctx context.Context, manager *v1alpha1.CheManager) (bo
 	}
 	ret = ret || partial
 
-	traefikConfig := getGatewayTraefikConfigSpec(manager)
+	if partial, err = g.reconcileClusterRole(syncer, ctx, manager); err != nil {
+		return false, "", err
+	}
+	ret = ret || partial
+
+	traefikConfig, err := getGatewayTraefikConfigSpec(manager)
+	if err != nil {
+		return false, "", err
+	}
 	if partial, _, err = syncer.Sync(ctx, manager, &traefikConfig, configMapDiffOpts); err != nil {
 		return false, "", err
 	}
 	ret = ret || partial
 
-	depl := getGatewayDeploymentSpec(manager)
+	depl, err := getGatewayDeploymentSpec(manager, getConfigHash(&traefikConfig))
+	if err != nil {
+		return false, "", err
+	}
 	if partial, _, err = syncer.Sync(ctx, manager, &depl, deploymentDiffOpts); err != nil {
 		return false, "", err
 	}
 	ret = ret || partial
 
+	pdb := getGatewayPodDisruptionBudgetSpec(manager)
+	if partial, _, err = syncer.Sync(ctx, manager, &pdb, podDisruptionBudgetDiffOpts); err != nil {
+		return false, "", err
+	}
+	ret = ret || partial
+
 	service := getGatewayServiceSpec(manager)
 	if partial, _, err = syncer.Sync(ctx, manager, &service, serviceDiffOpts); err != nil {
 		return false, "", err
 	}
 	ret = ret || partial
 
+	if partial, err = g.reconcileServiceMonitor(syncer, ctx, manager); err != nil {
+		return false, "", err
+	}
+	ret = ret || partial
+
+	if partial, err = g.reconcileNetworkPolicy(syncer, ctx, manager); err != nil {
+		return false, "", err
+	}
+	ret = ret || partial
+
 	var host string
 
 	if infrastructure.Current.Type == infrastructure.OpenShift {
@@ -127,6 +197,12 @@ func (g *CheGateway) Sync(ctx context.Context, manager *v1alpha1.CheManager) (bo
 		ret = ret || partial
 	}
 
+	// get rid of the objects we created previously but no longer need, e.g. because they're not created in
+	// the current version or mode anymore.
+	if err = syncer.Prune(ctx, manager, getGatewayObjectLists()...); err != nil {
+		return false, "", err
+	}
+
 	return ret, host, nil
 }
 
@@ -135,7 +211,7 @@ func GetGatewayServiceName(manager *v1alpha1.CheManager) string {
 }
 
 func (g *CheGateway) Delete(ctx context.Context, manager *v1alpha1.CheManager) error {
-	syncer := sync.New(g.client, g.scheme)
+	syncer := g.syncer()
 
 	deployment := appsv1.Deployment{
 		ObjectMeta: metav1.ObjectMeta{
@@ -147,6 +223,16 @@ func (g *CheGateway) Delete(ctx context.Context, manager *v1alpha1.CheManager) e
 		return err
 	}
 
+	pdb := policy.PodDisruptionBudget{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      manager.Name,
+			Namespace: manager.Namespace,
+		},
+	}
+	if err := syncer.Delete(ctx, &pdb); err != nil {
+		return err
+	}
+
 	serverConfig := corev1.ConfigMap{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      manager.Name,
@@ -177,6 +263,10 @@ func (g *CheGateway) Delete(ctx context.Context, manager *v1alpha1.CheManager) e
 		return err
 	}
 
+	if err := g.deleteClusterRole(syncer, ctx, manager); err != nil {
+		return err
+	}
+
 	sa := corev1.ServiceAccount{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      manager.Name,
@@ -197,7 +287,58 @@ func (g *CheGateway) Delete(ctx context.Context, manager *v1alpha1.CheManager) e
 		return err
 	}
 
-	return nil
+	if err := g.deleteServiceMonitor(syncer, ctx, manager); err != nil {
+		return err
+	}
+
+	if infrastructure.Current.Type == infrastructure.OpenShift {
+		if err := syncer.Delete(ctx, getRouteSpec(manager)); err != nil {
+			return err
+		}
+	} else {
+		if err := syncer.Delete(ctx, getIngressSpec(manager)); err != nil {
+			return err
+		}
+	}
+
+	// nothing was synced, so this deletes any other objects that we might have created for the manager
+	return syncer.Prune(ctx, manager, getGatewayObjectLists()...)
+}
+
+// getGatewayObjectLists returns the lists of all the kinds of objects that can be created for the gateway. These
+// are used to find the objects to prune.
+func getGatewayObjectLists() []runtime.Object {
+	lists := []runtime.Object{
+		&corev1.ServiceAccountList{},
+		&rbac.RoleList{},
+		&rbac.RoleBindingList{},
+		&corev1.ConfigMapList{},
+		&appsv1.DeploymentList{},
+		&policy.PodDisruptionBudgetList{},
+		&corev1.ServiceList{},
+		&v1beta1.IngressList{},
+		&networkingv1.NetworkPolicyList{},
+	}
+
+	if infrastructure.Current.Type == infrastructure.OpenShift {
+		lists = append(lists, &routev1.RouteList{})
+	}
+
+	if infrastructure.HasAPIGroup(MonitoringAPIGroup) {
+		serviceMonitors := &unstructured.UnstructuredList{}
+		serviceMonitors.SetGroupVersionKind(ServiceMonitorGVK.GroupVersion().WithKind(ServiceMonitorGVK.Kind + "List"))
+		lists = append(lists, serviceMonitors)
+	}
+
+	return lists
+}
+
+func (g *CheGateway) syncer() sync.Syncer {
+	opts := []sync.Option{sync.WithEventRecorder(g.recorder)}
+	if g.reader != nil {
+		opts = append(opts, sync.WithReader(g.reader))
+	}
+	return sync.New(g.client, g.scheme, append(opts, g.syncOpts...)...)
 }
 
 // below functions declare the desired states of the various objects required for the gateway
@@ -262,7 +403,115 @@ func getGatewayRoleBindingSpec(manager *v1alpha1.CheManager) rbac.RoleBinding {
 	}
 }
 
-func getGatewayTraefikConfigSpec(manager *v1alpha1.CheManager) corev1.ConfigMap {
+// reconcileClusterRole makes sure the error pages sidecar can read the status of the workspaces in all
+// the namespaces. The cluster role and its binding are only created if the error pages are enabled, so that
+// the gateway isn't granted the access to all the workspaces in the cluster if it doesn't need it.
+func (g *CheGateway) reconcileClusterRole(syncer sync.Syncer, ctx context.Context, manager *v1alpha1.CheManager) (bool, error) {
+	if !manager.Spec.Gateway.ErrorPages {
+		return false, g.deleteClusterRole(syncer, ctx, manager)
+	}
+
+	clusterRole := getGatewayClusterRoleSpec(manager)
+	partial, _, err := syncer.Sync(ctx, manager, &clusterRole, clusterRoleDiffOpts)
+	if err != nil {
+		return false, err
+	}
+
+	clusterRoleBinding := getGatewayClusterRoleBindingSpec(manager)
+	partialBinding, _, err := syncer.Sync(ctx, manager, &clusterRoleBinding, clusterRoleBindingDiffOpts)
+	if err != nil {
+		return false, err
+	}
+
+	return partial || partialBinding, nil
+}
+
+// deleteClusterRole deletes the cluster role of the gateway and its binding. These are not namespaced, so they are
+// not found by the pruning of the objects in the namespace of the manager and need to be deleted explicitly.
+func (g *CheGateway) deleteClusterRole(syncer sync.Syncer, ctx context.Context, manager *v1alpha1.CheManager) error {
+	clusterRoleBinding := rbac.ClusterRoleBinding{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: getGatewayClusterRoleName(manager),
+		},
+	}
+	if err := syncer.Delete(ctx, &clusterRoleBinding); err != nil {
+		return err
+	}
+
+	clusterRole := rbac.ClusterRole{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: getGatewayClusterRoleName(manager),
+		},
+	}
+	return syncer.Delete(ctx, &clusterRole)
+}
+
+// getGatewayClusterRoleName returns the name of the cluster role of the gateway. The cluster roles are not namespaced,
+// so the name contains the hash of the namespace and the name of the manager to not clash with the gateways of
+// the other managers. Neither can contain a slash, so the hashed value is unique for each manager.
+func getGatewayClusterRoleName(manager *v1alpha1.CheManager) string {
+	hash := sha256.Sum256([]byte(manager.Namespace + "/" + manager.Name))
+	return fmt.Sprintf("%s-gateway-%x", manager.Name, hash[:16])
+}
+
+func getGatewayClusterRoleSpec(manager *v1alpha1.CheManager) rbac.ClusterRole {
+	return rbac.ClusterRole{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: rbac.SchemeGroupVersion.String(),
+			Kind:       "ClusterRole",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   getGatewayClusterRoleName(manager),
+			Labels: defaults.GetLabelsForComponent(manager, "security"),
+		},
+		Rules: []rbac.PolicyRule{
+			{
+				Verbs:     []string{"get"},
+				APIGroups: []string{dw.SchemeGroupVersion.Group},
+				Resources: []string{"devworkspaces"},
+			},
+		},
+	}
+}
+
+func getGatewayClusterRoleBindingSpec(manager *v1alpha1.CheManager) rbac.ClusterRoleBinding {
+	return rbac.ClusterRoleBinding{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: rbac.SchemeGroupVersion.String(),
+			Kind:       "ClusterRoleBinding",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   getGatewayClusterRoleName(manager),
+			Labels: defaults.GetLabelsForComponent(manager, "security"),
+		},
+		RoleRef: rbac.RoleRef{
+			APIGroup: "rbac.authorization.k8s.io",
+			Kind:     "ClusterRole",
+			Name:     getGatewayClusterRoleName(manager),
+		},
+		Subjects: []rbac.Subject{
+			{
+				Kind:      "ServiceAccount",
+				Name:      manager.Name,
+				Namespace: manager.Namespace,
+			},
+		},
+	}
+}
+
+func getGatewayTraefikConfigSpec(manager *v1alpha1.CheManager) (corev1.ConfigMap, error) {
+	// an invalid IP range would make the gateway fail to start
+	for _, ip := range manager.Spec.Gateway.ForwardedHeadersTrustedIPs {
+		if !traefik.IsValidIPRange(ip) {
+			return corev1.ConfigMap{}, fmt.Errorf("the trusted IP '%s' of the forwarded headers is not a valid IP address or range", ip)
+		}
+	}
+
+	contents, err := yaml.Marshal(getGatewayTraefikConfig(manager))
+	if err != nil {
+		return corev1.ConfigMap{}, err
+	}
+
 	return corev1.ConfigMap{
 		TypeMeta: metav1.TypeMeta{
 			APIVersion: corev1.SchemeGroupVersion.String(),
@@ -274,36 +523,133 @@ func getGatewayTraefikConfigSpec(manager *v1alpha1.CheManager) corev1.ConfigMap
 			Labels:    defaults.GetLabelsForComponent(manager, "gateway-config"),
 		},
 		Data: map[string]string{
-			"traefik.yml": `
-entrypoints:
-  http:
-    address: ":8080"
-    forwardedHeaders:
-      insecure: true
-  https:
-    address: ":8443"
-    forwardedHeaders:
-      insecure: true
-global:
-  checkNewVersion: false
-  sendAnonymousUsage: false
-providers:
-  file:
-    directory: "/dynamic-config"
-    watch: true
-log:
-  level: "INFO"`,
+			gatewayStaticConfigFileName: string(contents),
 		},
+	}, nil
+}
+
+func getGatewayTraefikConfig(manager *v1alpha1.CheManager) traefikStaticConfig {
+	observability := manager.Spec.Gateway.Observability
+
+	logLevel := observability.LogLevel
+	if logLevel == "" {
+		logLevel = "INFO"
+	}
+
+	// the forwarded headers are accepted from anyone unless the trusted proxies are configured
+	forwardedHeaders := &traefikStaticConfigEntryPointForwardedHeaders{Insecure: true}
+	if trustedIPs := manager.Spec.Gateway.ForwardedHeadersTrustedIPs; len(trustedIPs) > 0 {
+		forwardedHeaders = &traefikStaticConfigEntryPointForwardedHeaders{TrustedIPs: trustedIPs}
 	}
+
+	// The metrics are exposed on a dedicated entrypoint so that they're not reachable through the ingress/route.
+	// Traefik v2.4 has no router metrics, but the routers and services of the workspaces share the same names that
+	// contain the workspace ID, so the service labels are enough to tell the traffic of the individual workspaces
+	// apart (see the README for the names).
+	config := traefikStaticConfig{
+		EntryPoints: map[string]traefikStaticConfigEntryPoint{
+			"http": {
+				Address:          fmt.Sprintf(":%d", GatewayPort),
+				ForwardedHeaders: forwardedHeaders,
+			},
+			"https": {
+				Address:          fmt.Sprintf(":%d", GatewaySecurePort),
+				ForwardedHeaders: forwardedHeaders,
+			},
+			"metrics": {
+				Address: fmt.Sprintf(":%d", GatewayMetricsPort),
+			},
+		},
+		Global: traefikStaticConfigGlobal{
+			CheckNewVersion:    false,
+			SendAnonymousUsage: false,
+		},
+		Providers: traefikStaticConfigProviders{
+			File: traefikStaticConfigFileProvider{
+				Directory: "/dynamic-config",
+				Watch:     true,
+			},
+		},
+		Log: traefikStaticConfigLog{
+			Level: logLevel,
+		},
+		// the health checks of the gateway pods are served on the same internal-only entrypoint as the metrics
+		Ping: traefikStaticConfigPing{
+			EntryPoint: "metrics",
+		},
+		Metrics: traefikStaticConfigMetrics{
+			Prometheus: traefikStaticConfigPrometheus{
+				EntryPoint:           "metrics",
+				AddEntryPointsLabels: true,
+				AddServicesLabels:    true,
+			},
+		},
+	}
+
+	if accessLog := observability.AccessLog; accessLog != nil {
+		config.AccessLog = &traefikStaticConfigAccessLog{
+			Format: accessLog.Format,
+		}
+
+		if len(accessLog.StatusCodes) > 0 || accessLog.RetryAttempts || accessLog.MinDuration != "" {
+			config.AccessLog.Filters = &traefikStaticConfigAccessLogFilters{
+				StatusCodes:   accessLog.StatusCodes,
+				RetryAttempts: accessLog.RetryAttempts,
+				MinDuration:   accessLog.MinDuration,
+			}
+		}
+	}
+
+	if tracing := observability.Tracing; tracing != nil {
+		serviceName := tracing.ServiceName
+		if serviceName == "" {
+			serviceName = manager.Name
+		}
+
+		config.Tracing = &traefikStaticConfigTracing{
+			ServiceName: serviceName,
+			Jaeger: traefikStaticConfigJaegerTracing{
+				LocalAgentHostPort: tracing.JaegerAgentHostPort,
+			},
+		}
+
+		if tracing.JaegerCollectorEndpoint != "" {
+			config.Tracing.Jaeger.Collector = &traefikStaticConfigJaegerCollector{
+				Endpoint: tracing.JaegerCollectorEndpoint,
+			}
+		}
+	}
+
+	return config
+}
+
+// getConfigHash computes the hash of the static configuration of the gateway. The hash is put on the pods of
+// the gateway so that they are restarted when the configuration changes, because traefik doesn't reload
+// the static configuration.
+func getConfigHash(config *corev1.ConfigMap) string {
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(config.Data[gatewayStaticConfigFileName])))
 }
 
-func getGatewayDeploymentSpec(manager *v1alpha1.CheManager) appsv1.Deployment {
+func getGatewayDeploymentSpec(manager *v1alpha1.CheManager, configHash string) (appsv1.Deployment, error) {
 	gatewayImage := defaults.GetGatewayImage()
 	sidecarImage := defaults.GetGatewayConfigurerImage()
 
 	terminationGracePeriodSeconds := int64(10)
+	replicas := getGatewayReplicas(manager)
 
-	return appsv1.Deployment{
+	// never take a running gateway pod down before its replacement is ready to serve the workspaces
+	maxUnavailable := intstr.FromInt(0)
+	maxSurge := intstr.FromInt(1)
+
+	healthCheck := corev1.Handler{
+		HTTPGet: &corev1.HTTPGetAction{
+			Path:   "/ping",
+			Port:   intstr.FromInt(GatewayMetricsPort),
+			Scheme: corev1.URISchemeHTTP,
+		},
+	}
+
+	deployment := appsv1.Deployment{
 		TypeMeta: metav1.TypeMeta{
 			APIVersion: appsv1.SchemeGroupVersion.String(),
 			Kind:       "Deployment",
@@ -314,25 +660,65 @@ func getGatewayDeploymentSpec(manager *v1alpha1.CheManager) appsv1.Deployment {
 			Labels:    defaults.GetLabelsForComponent(manager, "deployment"),
 		},
 		Spec: appsv1.DeploymentSpec{
+			Replicas: &replicas,
 			Selector: &metav1.LabelSelector{
 				MatchLabels: defaults.GetLabelsForComponent(manager, "deployment"),
 			},
 			Strategy: appsv1.DeploymentStrategy{
 				Type: appsv1.RollingUpdateDeploymentStrategyType,
+				RollingUpdate: &appsv1.RollingUpdateDeployment{
+					MaxUnavailable: &maxUnavailable,
+					MaxSurge:       &maxSurge,
+				},
 			},
 			Template: corev1.PodTemplateSpec{
 				ObjectMeta: metav1.ObjectMeta{
 					Labels: defaults.GetLabelsForComponent(manager, "deployment"),
+					Annotations: map[string]string{
+						defaults.ConfigAnnotationGatewayConfigHash: configHash,
+					},
 				},
 				Spec: corev1.PodSpec{
 					TerminationGracePeriodSeconds: &terminationGracePeriodSeconds,
 					ServiceAccountName:            manager.Name,
 					RestartPolicy:                 corev1.RestartPolicyAlways,
+					Affinity: &corev1.Affinity{
+						// try to spread the gateway pods across the nodes so that a single node going down doesn't
+						// take down all the replicas
+						PodAntiAffinity: &corev1.PodAntiAffinity{
+							PreferredDuringSchedulingIgnoredDuringExecution: []corev1.WeightedPodAffinityTerm{
+								{
+									Weight: 100,
+									PodAffinityTerm: corev1.PodAffinityTerm{
+										LabelSelector: &metav1.LabelSelector{
+											MatchLabels: defaults.GetLabelsForComponent(manager, "deployment"),
+										},
+										TopologyKey: "kubernetes.io/hostname",
+									},
+								},
+							},
+						},
+					},
 					Containers: []corev1.Container{
 						{
 							Name:            "gateway",
 							Image:           gatewayImage,
 							ImagePullPolicy: corev1.PullAlways,
+							ReadinessProbe: &corev1.Probe{
+								Handler:          healthCheck,
+								PeriodSeconds:    5,
+								TimeoutSeconds:   1,
+								SuccessThreshold: 1,
+								FailureThreshold: 3,
+							},
+							LivenessProbe: &corev1.Probe{
+								Handler:             healthCheck,
+								InitialDelaySeconds: 10,
+								PeriodSeconds:       10,
+								TimeoutSeconds:      1,
+								SuccessThreshold:    1,
+								FailureThreshold:    3,
+							},
 							VolumeMounts: []corev1.VolumeMount{
 								{
 									Name:      "static-config",
@@ -397,6 +783,82 @@ func getGatewayDeploymentSpec(manager *v1alpha1.CheManager) appsv1.Deployment {
 			},
 		},
 	}
+
+	if manager.Spec.Gateway.ErrorPages {
+		errorPagesImage, err := defaults.GetGatewayErrorPagesImage()
+		if err != nil {
+			return appsv1.Deployment{}, err
+		}
+
+		// serves the pages the gateway shows when a workspace endpoint cannot be reached
+		deployment.Spec.Template.Spec.Containers = append(deployment.Spec.Template.Spec.Containers, corev1.Container{
+			Name:            "error-pages",
+			Image:           errorPagesImage,
+			ImagePullPolicy: corev1.PullAlways,
+			Command: []string{
+				"/usr/local/bin/devworkspace-che-operator",
+				errorpages.CommandName,
+				"--address",
+				fmt.Sprintf("127.0.0.1:%d", GatewayErrorPagesPort),
+			},
+		})
+	}
+
+	if manager.Spec.Gateway.CookiePathRewriting {
+		cookieRewriterImage, err := defaults.GetGatewayCookieRewriterImage()
+		if err != nil {
+			return appsv1.Deployment{}, err
+		}
+
+		// rewrites the paths of the cookies of the endpoints, which Traefik cannot do itself
+		deployment.Spec.Template.Spec.Containers = append(deployment.Spec.Template.Spec.Containers, corev1.Container{
+			Name:            "cookie-rewrite",
+			Image:           cookieRewriterImage,
+			ImagePullPolicy: corev1.PullAlways,
+			Command: []string{
+				"/usr/local/bin/devworkspace-che-operator",
+				cookierewrite.CommandName,
+				"--address",
+				fmt.Sprintf("127.0.0.1:%d", GatewayCookieRewritePort),
+			},
+		})
+	}
+
+	return deployment, nil
+}
+
+func getGatewayPodDisruptionBudgetSpec(manager *v1alpha1.CheManager) policy.PodDisruptionBudget {
+	// we only ever allow 1 gateway pod to be down due to a voluntary disruption. With more replicas, this keeps
+	// the gateway up while the nodes are drained. The single default replica is still allowed to be evicted, because
+	// the budget would otherwise block the node drains forever.
+	maxUnavailable := intstr.FromInt(1)
+
+	return policy.PodDisruptionBudget{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: policy.SchemeGroupVersion.String(),
+			Kind:       "PodDisruptionBudget",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      manager.Name,
+			Namespace: manager.Namespace,
+			Labels:    defaults.GetLabelsForComponent(manager, "deployment"),
+		},
+		Spec: policy.PodDisruptionBudgetSpec{
+			MaxUnavailable: &maxUnavailable,
+			Selector: &metav1.LabelSelector{
+				MatchLabels: defaults.GetLabelsForComponent(manager, "deployment"),
+			},
+		},
+	}
+}
+
+// getGatewayReplicas returns the number of the gateway pods. There is 1 by default, more need to be configured so that
+// a voluntary disruption of a single pod doesn't take all the workspaces down.
+func getGatewayReplicas(manager *v1alpha1.CheManager) int32 {
+	if manager.Spec.Gateway.Replicas == nil {
+		return defaultGatewayReplicas
+	}
+	return *manager.Spec.Gateway.Replicas
 }
 
 func getGatewayServiceSpec(manager *v1alpha1.CheManager) corev1.Service {
@@ -427,6 +889,12 @@ func getGatewayServiceSpec(manager *v1alpha1.CheManager) corev1.Service {
 					Protocol:   corev1.ProtocolTCP,
 					TargetPort: intstr.FromInt(GatewaySecurePort),
 				},
+				{
+					Name:       GatewayMetricsPortName,
+					Port:       int32(GatewayMetricsPort),
+					Protocol:   corev1.ProtocolTCP,
+					TargetPort: intstr.FromInt(GatewayMetricsPort),
+				},
 			},
 		},
 	}
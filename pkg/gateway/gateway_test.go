@@ -2,19 +2,32 @@ package gateway
 
 import (
 	"context"
+	"os"
+	"reflect"
+	"strings"
 	"testing"
 
 	"github.com/che-incubator/devworkspace-che-operator/apis/che-controller/v1alpha1"
+	"github.com/che-incubator/devworkspace-che-operator/pkg/defaults"
+	"github.com/che-incubator/devworkspace-che-operator/pkg/infrastructure"
+	"github.com/che-incubator/devworkspace-che-operator/pkg/sync"
 	routev1 "github.com/openshift/api/route/v1"
 	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
 	extensions "k8s.io/api/extensions/v1beta1"
+	networkingv1 "k8s.io/api/networking/v1"
+	policy "k8s.io/api/policy/v1beta1"
 	rbac "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/yaml"
 )
 
 func createTestScheme() *runtime.Scheme {
@@ -25,6 +38,8 @@ func createTestScheme() *runtime.Scheme {
 	utilruntime.Must(corev1.AddToScheme(scheme))
 	utilruntime.Must(appsv1.AddToScheme(scheme))
 	utilruntime.Must(rbac.AddToScheme(scheme))
+	utilruntime.Must(policy.AddToScheme(scheme))
+	utilruntime.Must(networkingv1.AddToScheme(scheme))
 	utilruntime.Must(routev1.AddToScheme(scheme))
 
 	return scheme
@@ -70,6 +85,12 @@ func TestDelete(t *testing.T) {
 				Namespace: ns,
 			},
 		},
+		&policy.PodDisruptionBudget{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      managerName,
+				Namespace: ns,
+			},
+		},
 		&corev1.ConfigMap{
 			ObjectMeta: metav1.ObjectMeta{
 				Name:      managerName,
@@ -121,3 +142,797 @@ func TestDelete(t *testing.T) {
 
 	TestGatewayObjectsDontExist(t, ctx, cl, managerName, ns)
 }
+
+func TestDefaultReplicas(t *testing.T) {
+	scheme := createTestScheme()
+
+	cl := fake.NewFakeClientWithScheme(scheme)
+	ctx := context.TODO()
+
+	gateway := CheGateway{client: cl, scheme: scheme}
+
+	_, _, err := gateway.Sync(ctx, &v1alpha1.CheManager{
+		ObjectMeta: v1.ObjectMeta{
+			Name:      "che",
+			Namespace: "default",
+		},
+		Spec: v1alpha1.CheManagerSpec{
+			Host: "over.the.rainbow",
+		},
+	})
+	if err != nil {
+		t.Fatalf("Error while syncing: %s", err)
+	}
+
+	depl := &appsv1.Deployment{}
+	if err = cl.Get(ctx, client.ObjectKey{Name: "che", Namespace: "default"}, depl); err != nil {
+		t.Fatalf("Failed to get the gateway deployment: %s", err)
+	}
+
+	if depl.Spec.Replicas == nil || *depl.Spec.Replicas != 1 {
+		t.Errorf("The gateway deployment should have 1 replica by default.")
+	}
+}
+
+func TestReplicas(t *testing.T) {
+	scheme := createTestScheme()
+
+	cl := fake.NewFakeClientWithScheme(scheme)
+	ctx := context.TODO()
+
+	gateway := CheGateway{client: cl, scheme: scheme}
+
+	managerName := "che"
+	ns := "default"
+	replicas := int32(3)
+
+	_, _, err := gateway.Sync(ctx, &v1alpha1.CheManager{
+		ObjectMeta: v1.ObjectMeta{
+			Name:      managerName,
+			Namespace: ns,
+		},
+		Spec: v1alpha1.CheManagerSpec{
+			Host: "over.the.rainbow",
+			Gateway: v1alpha1.GatewaySpec{
+				Replicas: &replicas,
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Error while syncing: %s", err)
+	}
+
+	depl := &appsv1.Deployment{}
+	if err = cl.Get(ctx, client.ObjectKey{Name: managerName, Namespace: ns}, depl); err != nil {
+		t.Fatalf("Failed to get the gateway deployment: %s", err)
+	}
+
+	if depl.Spec.Replicas == nil || *depl.Spec.Replicas != 3 {
+		t.Errorf("The gateway deployment should have 3 replicas.")
+	}
+
+	if depl.Spec.Template.Spec.Affinity == nil || depl.Spec.Template.Spec.Affinity.PodAntiAffinity == nil {
+		t.Errorf("The gateway pods should have an anti-affinity set.")
+	}
+
+	// now change the number of replicas and check that the deployment is updated
+	replicas = 1
+	_, _, err = gateway.Sync(ctx, &v1alpha1.CheManager{
+		ObjectMeta: v1.ObjectMeta{
+			Name:      managerName,
+			Namespace: ns,
+		},
+		Spec: v1alpha1.CheManagerSpec{
+			Host: "over.the.rainbow",
+			Gateway: v1alpha1.GatewaySpec{
+				Replicas: &replicas,
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Error while syncing: %s", err)
+	}
+
+	if err = cl.Get(ctx, client.ObjectKey{Name: managerName, Namespace: ns}, depl); err != nil {
+		t.Fatalf("Failed to get the gateway deployment: %s", err)
+	}
+
+	if depl.Spec.Replicas == nil || *depl.Spec.Replicas != 1 {
+		t.Errorf("The gateway deployment should have been scaled down to 1 replica.")
+	}
+
+	pdb := &policy.PodDisruptionBudget{}
+	if err = cl.Get(ctx, client.ObjectKey{Name: managerName, Namespace: ns}, pdb); err != nil {
+		t.Fatalf("Failed to get the gateway pod disruption budget: %s", err)
+	}
+
+	if pdb.Spec.MaxUnavailable == nil || pdb.Spec.MaxUnavailable.IntValue() != 1 {
+		t.Errorf("The pod disruption budget should allow for at most 1 unavailable gateway pod.")
+	}
+}
+
+func TestServiceMonitor(t *testing.T) {
+	scheme := createTestScheme()
+
+	cl := fake.NewFakeClientWithScheme(scheme)
+	ctx := context.TODO()
+
+	gateway := CheGateway{client: cl, scheme: scheme}
+
+	managerName := "che"
+	ns := "default"
+
+	manager := &v1alpha1.CheManager{
+		ObjectMeta: v1.ObjectMeta{
+			Name:      managerName,
+			Namespace: ns,
+		},
+		Spec: v1alpha1.CheManagerSpec{
+			Host: "over.the.rainbow",
+		},
+	}
+
+	getServiceMonitor := func() error {
+		sm := &unstructured.Unstructured{}
+		sm.SetGroupVersionKind(ServiceMonitorGVK)
+		return cl.Get(ctx, client.ObjectKey{Name: managerName, Namespace: ns}, sm)
+	}
+
+	// without the prometheus operator in the cluster, there should be no service monitor
+	infrastructure.InitializeForTesting(infrastructure.Kind{Type: infrastructure.Kubernetes})
+
+	if _, _, err := gateway.Sync(ctx, manager); err != nil {
+		t.Fatalf("Error while syncing: %s", err)
+	}
+
+	if err := getServiceMonitor(); !errors.IsNotFound(err) {
+		t.Errorf("Expected to not find the service monitor but the error we got was unexpected: %s", err)
+	}
+
+	infrastructure.InitializeForTesting(infrastructure.Kind{Type: infrastructure.Kubernetes}, MonitoringAPIGroup)
+	defer infrastructure.InitializeForTesting(infrastructure.Kind{Type: infrastructure.Undetected})
+
+	if _, _, err := gateway.Sync(ctx, manager); err != nil {
+		t.Fatalf("Error while syncing: %s", err)
+	}
+
+	if err := getServiceMonitor(); err != nil {
+		t.Errorf("Failed to get the service monitor: %s", err)
+	}
+
+	service := &corev1.Service{}
+	if err := cl.Get(ctx, client.ObjectKey{Name: managerName, Namespace: ns}, service); err != nil {
+		t.Fatalf("Failed to get the gateway service: %s", err)
+	}
+
+	found := false
+	for _, p := range service.Spec.Ports {
+		if p.Name == GatewayMetricsPortName && p.Port == int32(GatewayMetricsPort) {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("The gateway service should expose the metrics port.")
+	}
+
+	// syncing again should not produce any changes
+	changed, _, err := gateway.Sync(ctx, manager)
+	if err != nil {
+		t.Fatalf("Error while syncing: %s", err)
+	}
+	if changed {
+		t.Errorf("Syncing the unchanged gateway should not produce any changes.")
+	}
+
+	if err := gateway.Delete(ctx, manager); err != nil {
+		t.Fatalf("Error while deleting: %s", err)
+	}
+
+	if err := getServiceMonitor(); !errors.IsNotFound(err) {
+		t.Errorf("Expected to not find the service monitor but the error we got was unexpected: %s", err)
+	}
+}
+
+func TestObservabilityConfig(t *testing.T) {
+	scheme := createTestScheme()
+
+	cl := fake.NewFakeClientWithScheme(scheme)
+	ctx := context.TODO()
+
+	gateway := CheGateway{client: cl, scheme: scheme}
+
+	managerName := "che"
+	ns := "default"
+
+	manager := &v1alpha1.CheManager{
+		ObjectMeta: v1.ObjectMeta{
+			Name:      managerName,
+			Namespace: ns,
+		},
+		Spec: v1alpha1.CheManagerSpec{
+			Host: "over.the.rainbow",
+		},
+	}
+
+	getConfigAndHash := func() (traefikStaticConfig, string) {
+		if _, _, err := gateway.Sync(ctx, manager); err != nil {
+			t.Fatalf("Error while syncing: %s", err)
+		}
+
+		cm := &corev1.ConfigMap{}
+		if err := cl.Get(ctx, client.ObjectKey{Name: managerName, Namespace: ns}, cm); err != nil {
+			t.Fatalf("Failed to get the gateway configmap: %s", err)
+		}
+
+		config := traefikStaticConfig{}
+		if err := yaml.Unmarshal([]byte(cm.Data["traefik.yml"]), &config); err != nil {
+			t.Fatalf("Failed to parse the traefik configuration: %s", err)
+		}
+
+		depl := &appsv1.Deployment{}
+		if err := cl.Get(ctx, client.ObjectKey{Name: managerName, Namespace: ns}, depl); err != nil {
+			t.Fatalf("Failed to get the gateway deployment: %s", err)
+		}
+
+		return config, depl.Spec.Template.Annotations[defaults.ConfigAnnotationGatewayConfigHash]
+	}
+
+	config, origHash := getConfigAndHash()
+
+	if config.Log.Level != "INFO" {
+		t.Errorf("The default log level should be INFO but was %s", config.Log.Level)
+	}
+	if config.AccessLog != nil {
+		t.Error("The access log should be disabled by default")
+	}
+	if config.Tracing != nil {
+		t.Error("The tracing should be disabled by default")
+	}
+	if origHash == "" {
+		t.Error("The gateway pods should be annotated with the hash of the configuration")
+	}
+
+	manager.Spec.Gateway.Observability = v1alpha1.GatewayObservability{
+		LogLevel: "DEBUG",
+		AccessLog: &v1alpha1.GatewayAccessLog{
+			Format:      "json",
+			StatusCodes: []string{"500-599"},
+			MinDuration: "10ms",
+		},
+		Tracing: &v1alpha1.GatewayTracing{
+			JaegerCollectorEndpoint: "http://jaeger:14268/api/traces",
+		},
+	}
+
+	config, hash := getConfigAndHash()
+
+	if config.Log.Level != "DEBUG" {
+		t.Errorf("Unexpected log level: %s", config.Log.Level)
+	}
+	if config.AccessLog == nil || config.AccessLog.Format != "json" {
+		t.Errorf("The access log should be configured with the json format")
+	} else if config.AccessLog.Filters == nil || config.AccessLog.Filters.MinDuration != "10ms" ||
+		len(config.AccessLog.Filters.StatusCodes) != 1 || config.AccessLog.Filters.StatusCodes[0] != "500-599" {
+		t.Errorf("Unexpected access log filters: %v", config.AccessLog.Filters)
+	}
+	if config.Tracing == nil || config.Tracing.ServiceName != managerName || config.Tracing.Jaeger.Collector == nil ||
+		config.Tracing.Jaeger.Collector.Endpoint != "http://jaeger:14268/api/traces" {
+		t.Errorf("Unexpected tracing configuration: %v", config.Tracing)
+	}
+	if hash == origHash {
+		t.Error("The hash of the configuration on the gateway pods should have changed so that the pods are restarted")
+	}
+}
+
+func TestForwardedHeadersTrustedIPs(t *testing.T) {
+	manager := &v1alpha1.CheManager{
+		ObjectMeta: v1.ObjectMeta{
+			Name:      "che",
+			Namespace: "default",
+		},
+	}
+
+	config := getGatewayTraefikConfig(manager)
+	if fh := config.EntryPoints["http"].ForwardedHeaders; fh == nil || !fh.Insecure {
+		t.Errorf("The forwarded headers should be accepted from anyone by default: %v", fh)
+	}
+
+	manager.Spec.Gateway.ForwardedHeadersTrustedIPs = []string{"10.0.0.0/8", "192.168.1.7"}
+	config = getGatewayTraefikConfig(manager)
+	for _, ep := range []string{"http", "https"} {
+		fh := config.EntryPoints[ep].ForwardedHeaders
+		if fh == nil || fh.Insecure || len(fh.TrustedIPs) != 2 {
+			t.Errorf("The forwarded headers of the %s entrypoint should only be accepted from the trusted IPs: %v", ep, fh)
+		}
+	}
+
+	manager.Spec.Gateway.ForwardedHeadersTrustedIPs = []string{"10.0.0.0/33"}
+	if _, err := getGatewayTraefikConfigSpec(manager); err == nil {
+		t.Error("The invalid trusted IP range should have been rejected")
+	}
+}
+
+func TestStaticConfigChangeRollsGateway(t *testing.T) {
+	scheme := createTestScheme()
+
+	cl := fake.NewFakeClientWithScheme(scheme)
+	ctx := context.TODO()
+
+	gateway := CheGateway{client: cl, scheme: scheme}
+
+	managerName := "che"
+	ns := "default"
+
+	manager := &v1alpha1.CheManager{
+		ObjectMeta: v1.ObjectMeta{
+			Name:      managerName,
+			Namespace: ns,
+		},
+		Spec: v1alpha1.CheManagerSpec{
+			Host: "over.the.rainbow",
+		},
+	}
+
+	if _, _, err := gateway.Sync(ctx, manager); err != nil {
+		t.Fatalf("Error while syncing: %s", err)
+	}
+
+	depl := &appsv1.Deployment{}
+	if err := cl.Get(ctx, client.ObjectKey{Name: managerName, Namespace: ns}, depl); err != nil {
+		t.Fatalf("Failed to get the gateway deployment: %s", err)
+	}
+
+	rollingUpdate := depl.Spec.Strategy.RollingUpdate
+	if rollingUpdate == nil || rollingUpdate.MaxUnavailable == nil || rollingUpdate.MaxUnavailable.IntValue() != 0 {
+		t.Errorf("The gateway deployment should not allow any unavailable pods during the rollout")
+	}
+
+	if depl.Spec.Template.Spec.Containers[0].ReadinessProbe == nil {
+		t.Errorf("The gateway container should have a readiness probe so that the rollout waits for the new pods")
+	}
+
+	origHash := depl.Spec.Template.Annotations[defaults.ConfigAnnotationGatewayConfigHash]
+	origResourceVersion := depl.ResourceVersion
+
+	// nothing changed, so syncing again must not touch the deployment
+	changed, _, err := gateway.Sync(ctx, manager)
+	if err != nil {
+		t.Fatalf("Error while syncing: %s", err)
+	}
+	if changed {
+		t.Errorf("Syncing an unchanged gateway should not produce any changes")
+	}
+
+	if err := cl.Get(ctx, client.ObjectKey{Name: managerName, Namespace: ns}, depl); err != nil {
+		t.Fatalf("Failed to get the gateway deployment: %s", err)
+	}
+	if depl.ResourceVersion != origResourceVersion {
+		t.Errorf("The gateway deployment should not have been updated")
+	}
+
+	// change the static configuration and check that the pod template changes
+	manager.Spec.Gateway.Observability.LogLevel = "WARN"
+
+	if _, _, err := gateway.Sync(ctx, manager); err != nil {
+		t.Fatalf("Error while syncing: %s", err)
+	}
+
+	if err := cl.Get(ctx, client.ObjectKey{Name: managerName, Namespace: ns}, depl); err != nil {
+		t.Fatalf("Failed to get the gateway deployment: %s", err)
+	}
+
+	if depl.Spec.Template.Annotations[defaults.ConfigAnnotationGatewayConfigHash] == origHash {
+		t.Errorf("The config hash on the gateway pods should have changed")
+	}
+}
+
+func TestPrunesObjectsNoLongerNeeded(t *testing.T) {
+	managerName := "che"
+	ns := "default"
+
+	scheme := createTestScheme()
+	ctx := context.TODO()
+
+	manager := &v1alpha1.CheManager{
+		ObjectMeta: v1.ObjectMeta{
+			Name:      managerName,
+			Namespace: ns,
+			UID:       "manager-uid",
+		},
+		Spec: v1alpha1.CheManagerSpec{
+			Host: "over.the.rainbow",
+		},
+	}
+
+	cl := fake.NewFakeClientWithScheme(scheme, manager)
+
+	gateway := CheGateway{client: cl, scheme: scheme}
+
+	infrastructure.InitializeForTesting(infrastructure.Kind{Type: infrastructure.Kubernetes})
+	defer infrastructure.InitializeForTesting(infrastructure.Kind{Type: infrastructure.Undetected})
+
+	if _, _, err := gateway.Sync(ctx, manager); err != nil {
+		t.Fatalf("Error while syncing: %s", err)
+	}
+
+	ingress := &extensions.Ingress{}
+	if err := cl.Get(ctx, client.ObjectKey{Name: managerName, Namespace: ns}, ingress); err != nil {
+		t.Fatalf("The ingress should have been created: %s", err)
+	}
+	if ingress.Labels[sync.OwnerUIDLabel] != "manager-uid" {
+		t.Errorf("The ingress should have been labeled with the UID of the manager")
+	}
+
+	// when we find ourselves on OpenShift, we create a route and no longer need the ingress
+	infrastructure.InitializeForTesting(infrastructure.Kind{Type: infrastructure.OpenShift})
+
+	if _, _, err := gateway.Sync(ctx, manager); err != nil {
+		t.Fatalf("Error while syncing: %s", err)
+	}
+
+	if err := cl.Get(ctx, client.ObjectKey{Name: managerName, Namespace: ns}, &routev1.Route{}); err != nil {
+		t.Errorf("The route should have been created: %s", err)
+	}
+	if err := cl.Get(ctx, client.ObjectKey{Name: managerName, Namespace: ns}, &extensions.Ingress{}); !errors.IsNotFound(err) {
+		t.Errorf("The ingress should have been pruned")
+	}
+
+	TestGatewayObjectsExist(t, ctx, cl, managerName, ns)
+
+	if err := gateway.Delete(ctx, manager); err != nil {
+		t.Fatalf("Error while deleting: %s", err)
+	}
+
+	if err := cl.Get(ctx, client.ObjectKey{Name: managerName, Namespace: ns}, &routev1.Route{}); !errors.IsNotFound(err) {
+		t.Errorf("The route should have been deleted")
+	}
+}
+
+// listRecordingClient records the kinds of the lists read through it
+type listRecordingClient struct {
+	client.Client
+	lists []string
+}
+
+func (c *listRecordingClient) List(ctx context.Context, list runtime.Object, opts ...client.ListOption) error {
+	c.lists = append(c.lists, reflect.TypeOf(list).Elem().Name())
+	return c.Client.List(ctx, list, opts...)
+}
+
+func TestPrunesThroughReader(t *testing.T) {
+	scheme := createTestScheme()
+	ctx := context.TODO()
+
+	manager := &v1alpha1.CheManager{
+		ObjectMeta: v1.ObjectMeta{
+			Name:      "che",
+			Namespace: "default",
+			UID:       "manager-uid",
+		},
+		Spec: v1alpha1.CheManagerSpec{
+			Host: "over.the.rainbow",
+		},
+	}
+
+	cl := fake.NewFakeClientWithScheme(scheme, manager)
+	cachedClient := &listRecordingClient{Client: cl}
+
+	gateway := New(cachedClient, cl, scheme, nil)
+
+	if _, _, err := gateway.Sync(ctx, manager); err != nil {
+		t.Fatalf("Error while syncing: %s", err)
+	}
+	if err := gateway.Delete(ctx, manager); err != nil {
+		t.Fatalf("Error while deleting: %s", err)
+	}
+
+	// the cached client would cache all the objects of the listed kinds in the cluster
+	if len(cachedClient.lists) > 0 {
+		t.Errorf("The objects to prune should have been listed using the reader but the client listed %v", cachedClient.lists)
+	}
+}
+
+func TestNetworkPolicyOfIsolatedWorkspaces(t *testing.T) {
+	managerName := "che"
+	ns := "default"
+
+	scheme := createTestScheme()
+	ctx := context.TODO()
+
+	manager := &v1alpha1.CheManager{
+		ObjectMeta: v1.ObjectMeta{
+			Name:      managerName,
+			Namespace: ns,
+			UID:       "manager-uid",
+		},
+		Spec: v1alpha1.CheManagerSpec{
+			Host: "over.the.rainbow",
+			Gateway: v1alpha1.GatewaySpec{
+				IsolateWorkspaces: true,
+			},
+		},
+	}
+
+	cl := fake.NewFakeClientWithScheme(scheme, manager)
+
+	gateway := CheGateway{client: cl, scheme: scheme}
+
+	infrastructure.InitializeForTesting(infrastructure.Kind{Type: infrastructure.Kubernetes})
+	defer infrastructure.InitializeForTesting(infrastructure.Kind{Type: infrastructure.Undetected})
+
+	if _, _, err := gateway.Sync(ctx, manager); err != nil {
+		t.Fatalf("Error while syncing: %s", err)
+	}
+
+	networkPolicy := &networkingv1.NetworkPolicy{}
+	if err := cl.Get(ctx, client.ObjectKey{Name: managerName, Namespace: ns}, networkPolicy); err != nil {
+		t.Fatalf("The network policy should have been created: %s", err)
+	}
+
+	depl := &appsv1.Deployment{}
+	if err := cl.Get(ctx, client.ObjectKey{Name: managerName, Namespace: ns}, depl); err != nil {
+		t.Fatalf("Failed to get the gateway deployment: %s", err)
+	}
+	for k, v := range networkPolicy.Spec.PodSelector.MatchLabels {
+		if depl.Spec.Template.Labels[k] != v {
+			t.Errorf("The network policy should select the gateway pods but it selects %v", networkPolicy.Spec.PodSelector.MatchLabels)
+		}
+	}
+	if len(networkPolicy.Spec.Ingress) != 2 {
+		t.Fatalf("The network policy should have a rule for the web ports and a rule for the metrics: %v", networkPolicy.Spec.Ingress)
+	}
+	web := networkPolicy.Spec.Ingress[0]
+	if len(web.From) != 0 || len(web.Ports) != 2 || web.Ports[0].Port.IntValue() != GatewayPort || web.Ports[1].Port.IntValue() != GatewaySecurePort {
+		t.Errorf("The network policy should open the web ports of the gateway to anyone: %v", web)
+	}
+	metrics := networkPolicy.Spec.Ingress[1]
+	if len(metrics.Ports) != 1 || metrics.Ports[0].Port.IntValue() != GatewayMetricsPort {
+		t.Errorf("The second rule of the network policy should be for the metrics port: %v", metrics)
+	}
+	if len(metrics.From) != 1 || metrics.From[0].NamespaceSelector.MatchLabels[NamespaceNameLabel] != ns {
+		t.Errorf("The metrics should only be reachable from the namespace of the manager: %v", metrics.From)
+	}
+
+	// the monitoring can read the metrics from the namespaces it runs in
+	manager.Spec.Gateway.MetricsNamespaceLabels = map[string]string{"monitoring": "true"}
+	metrics = getGatewayNetworkPolicySpec(manager).Spec.Ingress[1]
+	if len(metrics.From) != 2 || !reflect.DeepEqual(metrics.From[1].NamespaceSelector.MatchLabels, map[string]string{"monitoring": "true"}) {
+		t.Errorf("The metrics should be reachable from the monitoring namespaces: %v", metrics.From)
+	}
+	manager.Spec.Gateway.MetricsNamespaceLabels = nil
+
+	peer := GetGatewayNetworkPeer(manager)
+	if peer.NamespaceSelector.MatchLabels[NamespaceNameLabel] != ns {
+		t.Errorf("The gateway peer should select the namespace of the manager: %v", peer.NamespaceSelector)
+	}
+	for k, v := range peer.PodSelector.MatchLabels {
+		if depl.Spec.Template.Labels[k] != v {
+			t.Errorf("The gateway peer should select the gateway pods but it selects %v", peer.PodSelector.MatchLabels)
+		}
+	}
+
+	// the clusters older than 1.21 don't label the namespaces with their names, so the labels can be configured
+	manager.Spec.Gateway.NamespaceLabels = map[string]string{"che-gateway": "true"}
+	peer = GetGatewayNetworkPeer(manager)
+	if !reflect.DeepEqual(peer.NamespaceSelector.MatchLabels, map[string]string{"che-gateway": "true"}) {
+		t.Errorf("The gateway peer should select the namespace by the configured labels: %v", peer.NamespaceSelector)
+	}
+
+	manager.Spec.Gateway.NamespaceLabels = map[string]string{}
+	peer = GetGatewayNetworkPeer(manager)
+	if peer.NamespaceSelector.MatchLabels[NamespaceNameLabel] != ns {
+		t.Errorf("The gateway peer should never select all the namespaces: %v", peer.NamespaceSelector)
+	}
+
+	manager.Spec.Gateway.IsolateWorkspaces = false
+	if _, _, err := gateway.Sync(ctx, manager); err != nil {
+		t.Fatalf("Error while syncing: %s", err)
+	}
+
+	if err := cl.Get(ctx, client.ObjectKey{Name: managerName, Namespace: ns}, &networkingv1.NetworkPolicy{}); !errors.IsNotFound(err) {
+		t.Errorf("The network policy should have been pruned")
+	}
+}
+
+func TestWarnsAboutNamespaceNotSelectedByNetworkPolicies(t *testing.T) {
+	scheme := createTestScheme()
+	ctx := context.TODO()
+
+	manager := &v1alpha1.CheManager{
+		ObjectMeta: v1.ObjectMeta{Name: "che", Namespace: "default"},
+		Spec: v1alpha1.CheManagerSpec{
+			Host:    "over.the.rainbow",
+			Gateway: v1alpha1.GatewaySpec{IsolateWorkspaces: true},
+		},
+	}
+
+	for _, tc := range []struct {
+		name            string
+		namespaceLabels map[string]string
+		warned          bool
+	}{
+		// the clusters older than 1.21 don't label the namespaces with their names
+		{name: "unlabeled", namespaceLabels: nil, warned: true},
+		{name: "labeled with name", namespaceLabels: map[string]string{NamespaceNameLabel: "default"}, warned: false},
+	} {
+		namespace := &corev1.Namespace{ObjectMeta: v1.ObjectMeta{Name: "default", Labels: tc.namespaceLabels}}
+		cl := fake.NewFakeClientWithScheme(scheme, manager.DeepCopy(), namespace)
+		recorder := record.NewFakeRecorder(100)
+
+		gateway := New(cl, cl, scheme, recorder)
+		if _, _, err := gateway.Sync(ctx, manager.DeepCopy()); err != nil {
+			t.Fatalf("Error while syncing: %s", err)
+		}
+
+		warned := false
+		for len(recorder.Events) > 0 {
+			if strings.Contains(<-recorder.Events, EventReasonNamespaceNotSelected) {
+				warned = true
+			}
+		}
+		if warned != tc.warned {
+			t.Errorf("%s: expected the namespace to be reported as not selected: %t but it was: %t", tc.name, tc.warned, warned)
+		}
+	}
+}
+
+func TestErrorPages(t *testing.T) {
+	managerName := "che"
+	ns := "default"
+
+	scheme := createTestScheme()
+	ctx := context.TODO()
+
+	manager := &v1alpha1.CheManager{
+		ObjectMeta: v1.ObjectMeta{
+			Name:      managerName,
+			Namespace: ns,
+			UID:       "manager-uid",
+		},
+		Spec: v1alpha1.CheManagerSpec{
+			Host: "over.the.rainbow",
+			Gateway: v1alpha1.GatewaySpec{
+				ErrorPages: true,
+			},
+		},
+	}
+
+	cl := fake.NewFakeClientWithScheme(scheme, manager)
+
+	gateway := CheGateway{client: cl, scheme: scheme}
+
+	// the error pages are served by the operator itself, so there's no default image that could be used
+	if _, _, err := gateway.Sync(ctx, manager); err == nil || !strings.Contains(err.Error(), "RELATED_IMAGE_gateway_error_pages") {
+		t.Fatalf("The sync should have failed because of the unknown image of the error pages but the error was: %v", err)
+	}
+
+	os.Setenv("RELATED_IMAGE_gateway_error_pages", "quay.io/che-incubator/devworkspace-che-operator:test")
+	defer os.Unsetenv("RELATED_IMAGE_gateway_error_pages")
+
+	if _, _, err := gateway.Sync(ctx, manager); err != nil {
+		t.Fatalf("Error while syncing: %s", err)
+	}
+
+	clusterRoleName := getGatewayClusterRoleName(manager)
+
+	if err := cl.Get(ctx, client.ObjectKey{Name: clusterRoleName}, &rbac.ClusterRole{}); err != nil {
+		t.Errorf("The cluster role should have been created: %s", err)
+	}
+
+	clusterRoleBinding := &rbac.ClusterRoleBinding{}
+	if err := cl.Get(ctx, client.ObjectKey{Name: clusterRoleName}, clusterRoleBinding); err != nil {
+		t.Fatalf("The cluster role binding should have been created: %s", err)
+	}
+	if len(clusterRoleBinding.Subjects) != 1 || clusterRoleBinding.Subjects[0].Name != managerName || clusterRoleBinding.Subjects[0].Namespace != ns {
+		t.Errorf("The cluster role should have been bound to the service account of the gateway: %v", clusterRoleBinding.Subjects)
+	}
+
+	errorPagesContainer := func() *corev1.Container {
+		depl := &appsv1.Deployment{}
+		if err := cl.Get(ctx, client.ObjectKey{Name: managerName, Namespace: ns}, depl); err != nil {
+			t.Fatalf("Failed to get the gateway deployment: %s", err)
+		}
+		for i, c := range depl.Spec.Template.Spec.Containers {
+			if c.Name == "error-pages" {
+				return &depl.Spec.Template.Spec.Containers[i]
+			}
+		}
+		return nil
+	}
+
+	if c := errorPagesContainer(); c == nil {
+		t.Errorf("The error pages sidecar should have been added to the gateway")
+	} else if c.Image != "quay.io/che-incubator/devworkspace-che-operator:test" {
+		t.Errorf("The error pages sidecar should have used the configured image but uses %s", c.Image)
+	}
+
+	manager.Spec.Gateway.ErrorPages = false
+	if _, _, err := gateway.Sync(ctx, manager); err != nil {
+		t.Fatalf("Error while syncing: %s", err)
+	}
+
+	if errorPagesContainer() != nil {
+		t.Errorf("The error pages sidecar should have been removed from the gateway")
+	}
+	if err := cl.Get(ctx, client.ObjectKey{Name: clusterRoleName}, &rbac.ClusterRole{}); !errors.IsNotFound(err) {
+		t.Errorf("The cluster role should have been deleted")
+	}
+	if err := cl.Get(ctx, client.ObjectKey{Name: clusterRoleName}, &rbac.ClusterRoleBinding{}); !errors.IsNotFound(err) {
+		t.Errorf("The cluster role binding should have been deleted")
+	}
+}
+
+func TestCookieRewriteSidecar(t *testing.T) {
+	managerName := "che"
+	ns := "default"
+
+	scheme := createTestScheme()
+	ctx := context.TODO()
+
+	manager := &v1alpha1.CheManager{
+		ObjectMeta: v1.ObjectMeta{
+			Name:      managerName,
+			Namespace: ns,
+			UID:       "manager-uid",
+		},
+		Spec: v1alpha1.CheManagerSpec{
+			Host: "over.the.rainbow",
+			Gateway: v1alpha1.GatewaySpec{
+				CookiePathRewriting: true,
+			},
+		},
+	}
+
+	cl := fake.NewFakeClientWithScheme(scheme, manager)
+
+	gateway := CheGateway{client: cl, scheme: scheme}
+
+	if _, _, err := gateway.Sync(ctx, manager); err == nil || !strings.Contains(err.Error(), "RELATED_IMAGE_gateway_cookie_rewriter") {
+		t.Fatalf("The sync should have failed because of the unknown image of the cookie rewriter but the error was: %v", err)
+	}
+
+	os.Setenv("RELATED_IMAGE_gateway_cookie_rewriter", "quay.io/che-incubator/devworkspace-che-operator:test")
+	defer os.Unsetenv("RELATED_IMAGE_gateway_cookie_rewriter")
+
+	if _, _, err := gateway.Sync(ctx, manager); err != nil {
+		t.Fatalf("Error while syncing: %s", err)
+	}
+
+	cookieRewriteContainer := func() *corev1.Container {
+		depl := &appsv1.Deployment{}
+		if err := cl.Get(ctx, client.ObjectKey{Name: managerName, Namespace: ns}, depl); err != nil {
+			t.Fatalf("Failed to get the gateway deployment: %s", err)
+		}
+		for i, c := range depl.Spec.Template.Spec.Containers {
+			if c.Name == "cookie-rewrite" {
+				return &depl.Spec.Template.Spec.Containers[i]
+			}
+		}
+		return nil
+	}
+
+	if c := cookieRewriteContainer(); c == nil {
+		t.Errorf("The cookie rewriting sidecar should have been added to the gateway")
+	} else if !reflect.DeepEqual(c.Command, []string{"/usr/local/bin/devworkspace-che-operator", "cookie-rewrite", "--address", "127.0.0.1:8092"}) {
+		t.Errorf("Unexpected command of the cookie rewriting sidecar: %v", c.Command)
+	}
+
+	manager.Spec.Gateway.CookiePathRewriting = false
+	if _, _, err := gateway.Sync(ctx, manager); err != nil {
+		t.Fatalf("Error while syncing: %s", err)
+	}
+
+	if cookieRewriteContainer() != nil {
+		t.Errorf("The cookie rewriting sidecar should have been removed from the gateway")
+	}
+}
+
+func TestClusterRoleNamesDontClash(t *testing.T) {
+	first := &v1alpha1.CheManager{ObjectMeta: v1.ObjectMeta{Name: "c", Namespace: "a-b"}}
+	second := &v1alpha1.CheManager{ObjectMeta: v1.ObjectMeta{Name: "b-c", Namespace: "a"}}
+
+	if getGatewayClusterRoleName(first) == getGatewayClusterRoleName(second) {
+		t.Errorf("The managers %s/%s and %s/%s should have different cluster roles but both have %s",
+			first.Namespace, first.Name, second.Namespace, second.Name, getGatewayClusterRoleName(first))
+	}
+}
@@ -0,0 +1,170 @@
+//
+// Copyright (c) 2019-2021 Red Hat, Inc.
+// This program and the accompanying materials are made
+// available under the terms of the Eclipse Public License 2.0
+// which is available at https://www.eclipse.org/legal/epl-2.0/
+//
+// SPDX-License-Identifier: EPL-2.0
+//
+// Contributors:
+//   Red Hat, Inc. - initial API and implementation
+//
+
+package gateway
+
+import (
+	"context"
+
+	"github.com/che-incubator/devworkspace-che-operator/apis/che-controller/v1alpha1"
+	"github.com/che-incubator/devworkspace-che-operator/pkg/defaults"
+	"github.com/che-incubator/devworkspace-che-operator/pkg/sync"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// NamespaceNameLabel is the label Kubernetes 1.21+ puts on every namespace with the name of the namespace. It is
+	// used to select the namespace of the gateway in the network policies, unless the manager specifies the labels
+	// of its namespace.
+	NamespaceNameLabel = "kubernetes.io/metadata.name"
+
+	// EventReasonNamespaceNotSelected is the reason of the event recorded on the Che manager when its namespace
+	// doesn't have the labels by which the network policies recognize it.
+	EventReasonNamespaceNotSelected = "NamespaceNotSelected"
+)
+
+var (
+	networkPolicyDiffOpts = cmpopts.IgnoreFields(networkingv1.NetworkPolicy{}, "TypeMeta", "ObjectMeta")
+)
+
+// GetGatewayNetworkPeer returns the network policy peer matching the gateway pods of the manager. The network
+// policies can use it to let the traffic from the gateway through.
+func GetGatewayNetworkPeer(manager *v1alpha1.CheManager) networkingv1.NetworkPolicyPeer {
+	return networkingv1.NetworkPolicyPeer{
+		NamespaceSelector: getManagerNamespaceSelector(manager),
+		PodSelector: &metav1.LabelSelector{
+			MatchLabels: defaults.GetLabelsForComponent(manager, "deployment"),
+		},
+	}
+}
+
+func getManagerNamespaceSelector(manager *v1alpha1.CheManager) *metav1.LabelSelector {
+	// an empty selector would match all the namespaces
+	namespaceLabels := manager.Spec.Gateway.NamespaceLabels
+	if len(namespaceLabels) == 0 {
+		namespaceLabels = map[string]string{NamespaceNameLabel: manager.Namespace}
+	}
+
+	return &metav1.LabelSelector{
+		MatchLabels: namespaceLabels,
+	}
+}
+
+// reconcileNetworkPolicy makes sure the gateway is reachable if the workspaces are isolated. The network policy is
+// not needed otherwise and is pruned together with the other objects the gateway no longer needs.
+func (g *CheGateway) reconcileNetworkPolicy(syncer sync.Syncer, ctx context.Context, manager *v1alpha1.CheManager) (bool, error) {
+	if !manager.Spec.Gateway.IsolateWorkspaces {
+		return false, nil
+	}
+
+	if err := g.checkNamespaceSelected(ctx, manager); err != nil {
+		return false, err
+	}
+
+	networkPolicy := getGatewayNetworkPolicySpec(manager)
+	changed, _, err := syncer.Sync(ctx, manager, &networkPolicy, networkPolicyDiffOpts)
+	return changed, err
+}
+
+// checkNamespaceSelected warns when the namespace of the manager doesn't have the labels the network policies
+// select it by, e.g. on the clusters older than 1.21 that don't label the namespaces with their names. The network
+// policies would then block the traffic from the gateway to the workspaces and to its own metrics.
+func (g *CheGateway) checkNamespaceSelected(ctx context.Context, manager *v1alpha1.CheManager) error {
+	reader := g.reader
+	if reader == nil {
+		reader = g.client
+	}
+
+	namespace := &corev1.Namespace{}
+	if err := reader.Get(ctx, client.ObjectKey{Name: manager.Namespace}, namespace); err != nil {
+		if errors.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+
+	selector := getManagerNamespaceSelector(manager)
+	for k, v := range selector.MatchLabels {
+		if namespace.Labels[k] != v {
+			if g.recorder != nil {
+				g.recorder.Eventf(manager, corev1.EventTypeWarning, EventReasonNamespaceNotSelected,
+					"The namespace %s doesn't have the label %s=%s, so the network policies isolating the workspaces block the gateway. Label the namespace and list the labels in spec.gateway.namespaceLabels.",
+					manager.Namespace, k, v)
+			}
+			return nil
+		}
+	}
+
+	return nil
+}
+
+func getGatewayNetworkPolicySpec(manager *v1alpha1.CheManager) networkingv1.NetworkPolicy {
+	tcp := corev1.ProtocolTCP
+	var webPorts []networkingv1.NetworkPolicyPort
+	for _, p := range []int{GatewayPort, GatewaySecurePort} {
+		port := intstr.FromInt(p)
+		webPorts = append(webPorts, networkingv1.NetworkPolicyPort{Protocol: &tcp, Port: &port})
+	}
+	metricsPort := intstr.FromInt(GatewayMetricsPort)
+
+	// the metrics tell the traffic of the individual workspaces apart, so they're only reachable from the namespace
+	// of the manager and the namespaces of the monitoring, if configured. The kubelet can reach the pods regardless of
+	// the network policies, so the probes keep working.
+	metricsPeers := []networkingv1.NetworkPolicyPeer{
+		{
+			NamespaceSelector: getManagerNamespaceSelector(manager),
+		},
+	}
+	// an empty selector would match all the namespaces
+	if len(manager.Spec.Gateway.MetricsNamespaceLabels) > 0 {
+		metricsPeers = append(metricsPeers, networkingv1.NetworkPolicyPeer{
+			NamespaceSelector: &metav1.LabelSelector{
+				MatchLabels: manager.Spec.Gateway.MetricsNamespaceLabels,
+			},
+		})
+	}
+
+	return networkingv1.NetworkPolicy{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: networkingv1.SchemeGroupVersion.String(),
+			Kind:       "NetworkPolicy",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      manager.Name,
+			Namespace: manager.Namespace,
+			Labels:    defaults.GetLabelsForComponent(manager, "deployment"),
+		},
+		Spec: networkingv1.NetworkPolicySpec{
+			PodSelector: metav1.LabelSelector{
+				MatchLabels: defaults.GetLabelsForComponent(manager, "deployment"),
+			},
+			PolicyTypes: []networkingv1.PolicyType{networkingv1.PolicyTypeIngress},
+			// the ingress controllers and routers live in different namespaces on different clusters, so the web
+			// ports of the gateway are open to any source
+			Ingress: []networkingv1.NetworkPolicyIngressRule{
+				{
+					Ports: webPorts,
+				},
+				{
+					Ports: []networkingv1.NetworkPolicyPort{{Protocol: &tcp, Port: &metricsPort}},
+					From:  metricsPeers,
+				},
+			},
+		},
+	}
+}
@@ -10,6 +10,7 @@ import (
 	"github.com/google/go-cmp/cmp"
 	"github.com/google/go-cmp/cmp/cmpopts"
 	routev1 "github.com/openshift/api/route/v1"
+	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
@@ -19,6 +20,10 @@ import (
 
 const (
 	generatedHostAnnotation = "openshift.io/host.generated"
+
+	// EventReasonRouteHostRegenerated is the reason of the event recorded on the Che manager when the host of
+	// the gateway route changes.
+	EventReasonRouteHostRegenerated = "RouteHostRegenerated"
 )
 
 var (
@@ -97,6 +102,12 @@ func (g *CheGateway) reconcileRoute(syncer sync.Syncer, ctx context.Context, mgr
 			return changed, "", err
 		}
 		routeHost = inCluster.(*routev1.Route).Spec.Host
+
+		// the workspace URLs are based on the host, so let the users know that they're going to change
+		if g.recorder != nil && existing.Spec.Host != "" && existing.Spec.Host != routeHost {
+			g.recorder.Eventf(mgr, corev1.EventTypeNormal, EventReasonRouteHostRegenerated,
+				"The host of the gateway route changed from %s to %s", existing.Spec.Host, routeHost)
+		}
 	}
 
 	return changed, routeHost, err
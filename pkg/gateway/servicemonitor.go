@@ -0,0 +1,96 @@
+//
+// Copyright (c) 2019-2021 Red Hat, Inc.
+// This program and the accompanying materials are made
+// available under the terms of the Eclipse Public License 2.0
+// which is available at https://www.eclipse.org/legal/epl-2.0/
+//
+// SPDX-License-Identifier: EPL-2.0
+//
+// Contributors:
+//   Red Hat, Inc. - initial API and implementation
+//
+
+package gateway
+
+import (
+	"context"
+
+	"github.com/che-incubator/devworkspace-che-operator/apis/che-controller/v1alpha1"
+	"github.com/che-incubator/devworkspace-che-operator/pkg/defaults"
+	"github.com/che-incubator/devworkspace-che-operator/pkg/infrastructure"
+	"github.com/che-incubator/devworkspace-che-operator/pkg/sync"
+	"github.com/google/go-cmp/cmp"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+const (
+	// MonitoringAPIGroup is the API group of the Prometheus operator. The service monitor for the gateway is only
+	// created if this API group is available in the cluster.
+	MonitoringAPIGroup = "monitoring.coreos.com"
+)
+
+var (
+	// ServiceMonitorGVK is the group-version-kind of the Prometheus operator service monitors. We don't depend on
+	// the Prometheus operator API package and work with the service monitors as unstructured objects instead.
+	ServiceMonitorGVK = schema.GroupVersionKind{Group: MonitoringAPIGroup, Version: "v1", Kind: "ServiceMonitor"}
+
+	serviceMonitorDiffOpts = cmp.Comparer(func(x, y *unstructured.Unstructured) bool {
+		return equality.Semantic.DeepEqual(x.Object["spec"], y.Object["spec"])
+	})
+)
+
+func (g *CheGateway) reconcileServiceMonitor(syncer sync.Syncer, ctx context.Context, manager *v1alpha1.CheManager) (bool, error) {
+	if !infrastructure.HasAPIGroup(MonitoringAPIGroup) {
+		return false, nil
+	}
+
+	serviceMonitor := getServiceMonitorSpec(manager)
+	changed, _, err := syncer.Sync(ctx, manager, serviceMonitor, serviceMonitorDiffOpts)
+	return changed, err
+}
+
+func (g *CheGateway) deleteServiceMonitor(syncer sync.Syncer, ctx context.Context, manager *v1alpha1.CheManager) error {
+	if !infrastructure.HasAPIGroup(MonitoringAPIGroup) {
+		return nil
+	}
+
+	serviceMonitor := &unstructured.Unstructured{}
+	serviceMonitor.SetGroupVersionKind(ServiceMonitorGVK)
+	serviceMonitor.SetName(manager.Name)
+	serviceMonitor.SetNamespace(manager.Namespace)
+
+	return syncer.Delete(ctx, serviceMonitor)
+}
+
+func getServiceMonitorSpec(manager *v1alpha1.CheManager) *unstructured.Unstructured {
+	// the types of the values need to match what we get back from the cluster (i.e. what JSON unmarshalling produces)
+	// so that we can compare the spec of the blueprint with the spec of the object in the cluster.
+	matchLabels := map[string]interface{}{}
+	for k, v := range defaults.GetLabelsForComponent(manager, "deployment") {
+		matchLabels[k] = v
+	}
+
+	serviceMonitor := &unstructured.Unstructured{
+		Object: map[string]interface{}{
+			"spec": map[string]interface{}{
+				"selector": map[string]interface{}{
+					"matchLabels": matchLabels,
+				},
+				"endpoints": []interface{}{
+					map[string]interface{}{
+						"port": GatewayMetricsPortName,
+						"path": "/metrics",
+					},
+				},
+			},
+		},
+	}
+	serviceMonitor.SetGroupVersionKind(ServiceMonitorGVK)
+	serviceMonitor.SetName(manager.Name)
+	serviceMonitor.SetNamespace(manager.Namespace)
+	serviceMonitor.SetLabels(defaults.GetLabelsForComponent(manager, "monitoring"))
+
+	return serviceMonitor
+}
@@ -4,10 +4,13 @@ import (
 	"context"
 	"testing"
 
+	"github.com/che-incubator/devworkspace-che-operator/apis/che-controller/v1alpha1"
 	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
+	policy "k8s.io/api/policy/v1beta1"
 	rbac "k8s.io/api/rbac/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 )
 
@@ -47,6 +50,13 @@ func TestGatewayObjectsExist(t *testing.T, ctx context.Context, cl client.Client
 		t.Error("There should be a deployment for the gateway")
 	}
 
+	pdb := policy.PodDisruptionBudget{}
+	if err := cl.Get(ctx, client.ObjectKey{Name: managerName, Namespace: ns}, &pdb); err != nil {
+		t.Errorf("Failed to get a pod disruption budget called '%s': %s", managerName, err)
+	} else if pdb.Name != managerName {
+		t.Error("There should be a pod disruption budget for the gateway")
+	}
+
 	service := corev1.Service{}
 	if err := cl.Get(ctx, client.ObjectKey{Name: managerName, Namespace: ns}, &service); err != nil {
 		t.Errorf("Failed to get a service called '%s': %s", managerName, err)
@@ -62,6 +72,12 @@ func TestGatewayObjectsDontExist(t *testing.T, ctx context.Context, cl client.Cl
 		t.Errorf("Expected to not find the gateway deployment but the error we got was unexpected: %s", err)
 	}
 
+	pdb := &policy.PodDisruptionBudget{}
+	err = cl.Get(ctx, client.ObjectKey{Name: managerName, Namespace: ns}, pdb)
+	if !errors.IsNotFound(err) {
+		t.Errorf("Expected to not find the gateway pod disruption budget but the error we got was unexpected: %s", err)
+	}
+
 	cm := &corev1.ConfigMap{}
 	err = cl.Get(ctx, client.ObjectKey{Name: managerName, Namespace: ns}, cm)
 	if !errors.IsNotFound(err) {
@@ -80,6 +96,20 @@ func TestGatewayObjectsDontExist(t *testing.T, ctx context.Context, cl client.Cl
 		t.Errorf("Expected to not find the gateway role but the error we got was unexpected: %s", err)
 	}
 
+	clusterRoleName := getGatewayClusterRoleName(&v1alpha1.CheManager{ObjectMeta: metav1.ObjectMeta{Name: managerName, Namespace: ns}})
+
+	clusterRoleBinding := &rbac.ClusterRoleBinding{}
+	err = cl.Get(ctx, client.ObjectKey{Name: clusterRoleName}, clusterRoleBinding)
+	if !errors.IsNotFound(err) {
+		t.Errorf("Expected to not find the gateway cluster role binding but the error we got was unexpected: %s", err)
+	}
+
+	clusterRole := &rbac.ClusterRole{}
+	err = cl.Get(ctx, client.ObjectKey{Name: clusterRoleName}, clusterRole)
+	if !errors.IsNotFound(err) {
+		t.Errorf("Expected to not find the gateway cluster role but the error we got was unexpected: %s", err)
+	}
+
 	sa := &corev1.ServiceAccount{}
 	err = cl.Get(ctx, client.ObjectKey{Name: managerName, Namespace: ns}, sa)
 	if !errors.IsNotFound(err) {
@@ -0,0 +1,81 @@
+package gateway
+
+// A representation of the static Traefik config as we need it. This is in no way complete but can be used for
+// the purposes we need it for.
+type traefikStaticConfig struct {
+	EntryPoints map[string]traefikStaticConfigEntryPoint `json:"entrypoints"`
+	Global      traefikStaticConfigGlobal                `json:"global"`
+	Providers   traefikStaticConfigProviders             `json:"providers"`
+	Log         traefikStaticConfigLog                   `json:"log"`
+	AccessLog   *traefikStaticConfigAccessLog            `json:"accessLog,omitempty"`
+	Metrics     traefikStaticConfigMetrics               `json:"metrics"`
+	Ping        traefikStaticConfigPing                  `json:"ping"`
+	Tracing     *traefikStaticConfigTracing              `json:"tracing,omitempty"`
+}
+
+type traefikStaticConfigEntryPoint struct {
+	Address          string                                         `json:"address"`
+	ForwardedHeaders *traefikStaticConfigEntryPointForwardedHeaders `json:"forwardedHeaders,omitempty"`
+}
+
+type traefikStaticConfigEntryPointForwardedHeaders struct {
+	Insecure   bool     `json:"insecure"`
+	TrustedIPs []string `json:"trustedIPs,omitempty"`
+}
+
+type traefikStaticConfigGlobal struct {
+	CheckNewVersion    bool `json:"checkNewVersion"`
+	SendAnonymousUsage bool `json:"sendAnonymousUsage"`
+}
+
+type traefikStaticConfigProviders struct {
+	File traefikStaticConfigFileProvider `json:"file"`
+}
+
+type traefikStaticConfigFileProvider struct {
+	Directory string `json:"directory"`
+	Watch     bool   `json:"watch"`
+}
+
+type traefikStaticConfigLog struct {
+	Level string `json:"level"`
+}
+
+type traefikStaticConfigAccessLog struct {
+	Format  string                               `json:"format,omitempty"`
+	Filters *traefikStaticConfigAccessLogFilters `json:"filters,omitempty"`
+}
+
+type traefikStaticConfigAccessLogFilters struct {
+	StatusCodes   []string `json:"statusCodes,omitempty"`
+	RetryAttempts bool     `json:"retryAttempts,omitempty"`
+	MinDuration   string   `json:"minDuration,omitempty"`
+}
+
+type traefikStaticConfigMetrics struct {
+	Prometheus traefikStaticConfigPrometheus `json:"prometheus"`
+}
+
+type traefikStaticConfigPrometheus struct {
+	EntryPoint           string `json:"entryPoint"`
+	AddEntryPointsLabels bool   `json:"addEntryPointsLabels"`
+	AddServicesLabels    bool   `json:"addServicesLabels"`
+}
+
+type traefikStaticConfigPing struct {
+	EntryPoint string `json:"entryPoint"`
+}
+
+type traefikStaticConfigTracing struct {
+	ServiceName string                           `json:"serviceName"`
+	Jaeger      traefikStaticConfigJaegerTracing `json:"jaeger"`
+}
+
+type traefikStaticConfigJaegerTracing struct {
+	LocalAgentHostPort string                              `json:"localAgentHostPort,omitempty"`
+	Collector          *traefikStaticConfigJaegerCollector `json:"collector,omitempty"`
+}
+
+type traefikStaticConfigJaegerCollector struct {
+	Endpoint string `json:"endpoint"`
+}
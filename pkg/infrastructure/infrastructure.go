@@ -41,10 +41,13 @@ const (
 var (
 	// Current is the infrastructure that we're currently running on. Can have an Undetected type if the detection fails.
 	Current Kind
+
+	// apiGroups are the API groups that were available in the cluster at the time of the detection.
+	apiGroups []metav1.APIGroup
 )
 
 func init() {
-	Current = detect()
+	Current, apiGroups = detect()
 }
 
 // IsLatest returns true if the infrastructure is at its latest detected generation
@@ -55,26 +58,48 @@ func (k Kind) IsLatest() bool {
 	return true
 }
 
-func detect() Kind {
+// HasAPIGroup returns true if the API group with the provided name was available in the cluster at the time
+// the infrastructure was detected.
+func HasAPIGroup(name string) bool {
+	return findAPIGroup(apiGroups, name) != nil
+}
+
+// InitializeForTesting sets the current infrastructure and the API groups available in it. This is only meant
+// to be used in the tests.
+func InitializeForTesting(kind Kind, groupNames ...string) {
+	Override(kind, groupNames...)
+}
+
+// Override sets the current infrastructure and the API groups available in it regardless of what was detected.
+// This is useful when we're not talking to any real cluster, e.g. when rendering the configuration offline.
+func Override(kind Kind, groupNames ...string) {
+	Current = kind
+	apiGroups = make([]metav1.APIGroup, len(groupNames))
+	for i, n := range groupNames {
+		apiGroups[i] = metav1.APIGroup{Name: n}
+	}
+}
+
+func detect() (Kind, []metav1.APIGroup) {
 	kubeCfg, err := config.GetConfig()
 	if err != nil {
-		return Kind{Type: Undetected, Generation: Unknown}
+		return Kind{Type: Undetected, Generation: Unknown}, nil
 	}
 	discoveryClient, err := discovery.NewDiscoveryClientForConfig(kubeCfg)
 	if err != nil {
-		return Kind{Type: Undetected, Generation: Unknown}
+		return Kind{Type: Undetected, Generation: Unknown}, nil
 	}
 	apiList, err := discoveryClient.ServerGroups()
 	if err != nil {
-		return Kind{Type: Undetected, Generation: Unknown}
+		return Kind{Type: Undetected, Generation: Unknown}, nil
 	}
 	if findAPIGroup(apiList.Groups, "route.openshift.io") == nil {
-		return Kind{Type: Kubernetes, Generation: Unknown}
+		return Kind{Type: Kubernetes, Generation: Unknown}, apiList.Groups
 	} else {
 		if findAPIGroup(apiList.Groups, "config.openshift.io") == nil {
-			return Kind{Type: OpenShift, Generation: V3}
+			return Kind{Type: OpenShift, Generation: V3}, apiList.Groups
 		} else {
-			return Kind{Type: OpenShift, Generation: V4}
+			return Kind{Type: OpenShift, Generation: V4}, apiList.Groups
 		}
 	}
 }
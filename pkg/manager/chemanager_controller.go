@@ -26,9 +26,12 @@ import (
 	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/api/extensions/v1beta1"
+	policy "k8s.io/api/policy/v1beta1"
 	rbac "k8s.io/api/rbac/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 )
@@ -42,13 +45,17 @@ var (
 const (
 	// FinalizerName is the name of the finalizer put on the Che Manager resources by the controller. Public for testing purposes.
 	FinalizerName = "chemanager.che.eclipse.org"
+
+	// EventReasonFinalizationBlocked is the reason of the event recorded on the Che manager when it cannot be finalized.
+	EventReasonFinalizationBlocked = "FinalizationBlocked"
 )
 
 type CheReconciler struct {
-	client  client.Client
-	scheme  *runtime.Scheme
-	gateway gateway.CheGateway
-	syncer  datasync.Syncer
+	client   client.Client
+	scheme   *runtime.Scheme
+	gateway  gateway.CheGateway
+	syncer   datasync.Syncer
+	recorder record.EventRecorder
 }
 
 // GetCurrentManagers returns a map of all che managers (keyed by their namespaced name)
@@ -79,20 +86,25 @@ func GetCurrentManagers() map[client.ObjectKey]v1alpha1.CheManager {
 
 // New returns a new instance of the Che manager reconciler. This is mainly useful for
 // testing because it doesn't set up any watches in the cluster, etc. For that use SetupWithManager.
+// The returned reconciler doesn't record any events.
 func New(cl client.Client, scheme *runtime.Scheme) CheReconciler {
 	return CheReconciler{
 		client:  cl,
 		scheme:  scheme,
-		gateway: gateway.New(cl, scheme),
+		gateway: gateway.New(cl, nil, scheme, nil),
 		syncer:  datasync.New(cl, scheme),
 	}
 }
 
-func (r *CheReconciler) SetupWithManager(mgr ctrl.Manager) error {
+// SetupWithManager sets up the reconciler with the controller manager. The sync options configure how the objects
+// are synced to the cluster. The objects are read using the API reader of the manager, so that the kinds the
+// reconciler doesn't own, like the network policies or the cluster roles, are not cached in the whole cluster.
+func (r *CheReconciler) SetupWithManager(mgr ctrl.Manager, syncOpts ...datasync.Option) error {
 	r.client = mgr.GetClient()
 	r.scheme = mgr.GetScheme()
-	r.gateway = gateway.New(mgr.GetClient(), mgr.GetScheme())
-	r.syncer = datasync.New(r.client, r.scheme)
+	r.recorder = mgr.GetEventRecorderFor("che-manager")
+	r.gateway = gateway.New(mgr.GetClient(), mgr.GetAPIReader(), mgr.GetScheme(), r.recorder, syncOpts...)
+	r.syncer = datasync.New(r.client, r.scheme, append([]datasync.Option{datasync.WithEventRecorder(r.recorder), datasync.WithReader(mgr.GetAPIReader())}, syncOpts...)...)
 
 	bld := ctrl.NewControllerManagedBy(mgr).
 		For(&v1alpha1.CheManager{}).
@@ -100,6 +112,7 @@ func (r *CheReconciler) SetupWithManager(mgr ctrl.Manager) error {
 		Owns(&v1beta1.Ingress{}).
 		Owns(&corev1.ConfigMap{}).
 		Owns(&appsv1.Deployment{}).
+		Owns(&policy.PodDisruptionBudget{}).
 		Owns(&corev1.Pod{}).
 		Owns(&corev1.ServiceAccount{}).
 		Owns(&rbac.Role{}).
@@ -107,6 +120,11 @@ func (r *CheReconciler) SetupWithManager(mgr ctrl.Manager) error {
 	if infrastructure.Current.Type == infrastructure.OpenShift {
 		bld.Owns(&routev1.Route{})
 	}
+	if infrastructure.HasAPIGroup(gateway.MonitoringAPIGroup) {
+		serviceMonitor := &unstructured.Unstructured{}
+		serviceMonitor.SetGroupVersionKind(gateway.ServiceMonitorGVK)
+		bld.Owns(serviceMonitor)
+	}
 	return bld.Complete(r)
 }
 
@@ -171,6 +189,9 @@ func (r *CheReconciler) updateStatus(ctx context.Context, manager *v1alpha1.CheM
 	currentPhase := manager.Status.GatewayPhase
 	currentHost := manager.Status.GatewayHost
 
+	// we patch rather than update the status so that we don't conflict with the concurrent changes to the manager
+	orig := manager.DeepCopy()
+
 	if manager.Spec.Routing == v1alpha1.MultiHost {
 		manager.Status.GatewayPhase = v1alpha1.GatewayPhaseInactive
 	} else if changed {
@@ -185,8 +206,13 @@ func (r *CheReconciler) updateStatus(ctx context.Context, manager *v1alpha1.CheM
 	manager.Status.Phase = v1alpha1.ManagerPhaseActive
 	manager.Status.Message = ""
 
+	if r.syncer.IsDryRun() {
+		// we're not changing anything in the cluster, so there's no point in waiting for the gateway to establish
+		return ctrl.Result{}, nil
+	}
+
 	if currentPhase != manager.Status.GatewayPhase || currentHost != manager.Status.GatewayHost {
-		return ctrl.Result{Requeue: true}, r.client.Status().Update(ctx, manager)
+		return ctrl.Result{Requeue: true}, r.client.Status().Patch(ctx, manager, client.MergeFrom(orig))
 	}
 
 	return ctrl.Result{Requeue: currentPhase == v1alpha1.GatewayPhaseInitializing}, nil
@@ -199,6 +225,11 @@ func (r *CheReconciler) finalize(ctx context.Context, mgr *v1alpha1.CheManager)
 		err = r.multihostFinalize(ctx, mgr)
 	}
 
+	if r.syncer.IsDryRun() {
+		// the finalizer stays on the manager until the operator runs for real
+		return err
+	}
+
 	if err == nil {
 		finalizers := []string{}
 		for i := range mgr.Finalizers {
@@ -211,9 +242,13 @@ func (r *CheReconciler) finalize(ctx context.Context, mgr *v1alpha1.CheManager)
 
 		err = r.client.Update(ctx, mgr)
 	} else {
+		if r.recorder != nil {
+			r.recorder.Eventf(mgr, corev1.EventTypeWarning, EventReasonFinalizationBlocked, "Finalization has failed: %s", err.Error())
+		}
+		orig := mgr.DeepCopy()
 		mgr.Status.Phase = v1alpha1.ManagerPhasePendingDeletion
 		mgr.Status.Message = fmt.Sprintf("Finalization has failed: %s", err.Error())
-		err = r.client.Status().Update(ctx, mgr)
+		err = r.client.Status().Patch(ctx, mgr, client.MergeFrom(orig))
 	}
 
 	return err
@@ -234,6 +269,9 @@ func (r *CheReconciler) reconcileGateway(ctx context.Context, mgr *v1alpha1.CheM
 }
 
 func (r *CheReconciler) ensureFinalizer(ctx context.Context, manager *v1alpha1.CheManager) (updated bool, err error) {
+	if r.syncer.IsDryRun() {
+		return false, nil
+	}
 
 	needsUpdate := true
 	if manager.Finalizers != nil {
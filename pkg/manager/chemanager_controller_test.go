@@ -3,6 +3,7 @@ package manager
 import (
 	"context"
 	"reflect"
+	"strings"
 	"testing"
 	"time"
 
@@ -14,11 +15,13 @@ import (
 	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
 	extensions "k8s.io/api/extensions/v1beta1"
+	policy "k8s.io/api/policy/v1beta1"
 	rbac "k8s.io/api/rbac/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/client-go/tools/record"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/client/fake"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
@@ -31,6 +34,7 @@ func createTestScheme() *runtime.Scheme {
 	utilruntime.Must(corev1.AddToScheme(scheme))
 	utilruntime.Must(appsv1.AddToScheme(scheme))
 	utilruntime.Must(rbac.AddToScheme(scheme))
+	utilruntime.Must(policy.AddToScheme(scheme))
 	utilruntime.Must(routev1.AddToScheme(scheme))
 
 	return scheme
@@ -51,7 +55,7 @@ func TestCreatesObjectsInSingleHost(t *testing.T) {
 		},
 	})
 
-	reconciler := CheReconciler{client: cl, scheme: scheme, gateway: gateway.New(cl, scheme), syncer: sync.New(cl, scheme)}
+	reconciler := CheReconciler{client: cl, scheme: scheme, gateway: gateway.New(cl, nil, scheme, nil), syncer: sync.New(cl, scheme)}
 
 	// first reconcile sets the finalizer, second reconcile actually finishes the process
 	_, err := reconciler.Reconcile(reconcile.Request{NamespacedName: types.NamespacedName{Name: managerName, Namespace: ns}})
@@ -126,7 +130,7 @@ func TestUpdatesObjectsInSingleHost(t *testing.T) {
 
 	ctx := context.TODO()
 
-	reconciler := CheReconciler{client: cl, scheme: scheme, gateway: gateway.New(cl, scheme), syncer: sync.New(cl, scheme)}
+	reconciler := CheReconciler{client: cl, scheme: scheme, gateway: gateway.New(cl, nil, scheme, nil), syncer: sync.New(cl, scheme)}
 
 	_, err := reconciler.Reconcile(reconcile.Request{NamespacedName: types.NamespacedName{Name: managerName, Namespace: ns}})
 	if err != nil {
@@ -166,7 +170,7 @@ func TestDoesntCreateObjectsInMultiHost(t *testing.T) {
 		},
 	})
 
-	reconciler := CheReconciler{client: cl, scheme: scheme, gateway: gateway.New(cl, scheme), syncer: sync.New(cl, scheme)}
+	reconciler := CheReconciler{client: cl, scheme: scheme, gateway: gateway.New(cl, nil, scheme, nil), syncer: sync.New(cl, scheme)}
 
 	_, err := reconciler.Reconcile(reconcile.Request{NamespacedName: types.NamespacedName{Name: managerName, Namespace: ns}})
 	if err != nil {
@@ -233,7 +237,7 @@ func TestDeletesObjectsInMultiHost(t *testing.T) {
 
 	ctx := context.TODO()
 
-	reconciler := CheReconciler{client: cl, scheme: scheme, gateway: gateway.New(cl, scheme), syncer: sync.New(cl, scheme)}
+	reconciler := CheReconciler{client: cl, scheme: scheme, gateway: gateway.New(cl, nil, scheme, nil), syncer: sync.New(cl, scheme)}
 
 	_, err := reconciler.Reconcile(reconcile.Request{NamespacedName: types.NamespacedName{Name: managerName, Namespace: ns}})
 	if err != nil {
@@ -256,7 +260,7 @@ func TestNoManagerSharedWhenReconcilingNonExistent(t *testing.T) {
 
 	ctx := context.TODO()
 
-	reconciler := CheReconciler{client: cl, scheme: scheme, gateway: gateway.New(cl, scheme), syncer: sync.New(cl, scheme)}
+	reconciler := CheReconciler{client: cl, scheme: scheme, gateway: gateway.New(cl, nil, scheme, nil), syncer: sync.New(cl, scheme)}
 
 	_, err := reconciler.Reconcile(reconcile.Request{NamespacedName: types.NamespacedName{Name: managerName, Namespace: ns}})
 	if err != nil {
@@ -314,7 +318,7 @@ func TestAddsManagerToSharedMapOnCreate(t *testing.T) {
 		},
 	})
 
-	reconciler := CheReconciler{client: cl, scheme: scheme, gateway: gateway.New(cl, scheme), syncer: sync.New(cl, scheme)}
+	reconciler := CheReconciler{client: cl, scheme: scheme, gateway: gateway.New(cl, nil, scheme, nil), syncer: sync.New(cl, scheme)}
 
 	_, err := reconciler.Reconcile(reconcile.Request{NamespacedName: types.NamespacedName{Name: managerName, Namespace: ns}})
 	if err != nil {
@@ -358,7 +362,7 @@ func TestUpdatesManagerInSharedMapOnUpdate(t *testing.T) {
 		},
 	})
 
-	reconciler := CheReconciler{client: cl, scheme: scheme, gateway: gateway.New(cl, scheme), syncer: sync.New(cl, scheme)}
+	reconciler := CheReconciler{client: cl, scheme: scheme, gateway: gateway.New(cl, nil, scheme, nil), syncer: sync.New(cl, scheme)}
 
 	_, err := reconciler.Reconcile(reconcile.Request{NamespacedName: types.NamespacedName{Name: managerName, Namespace: ns}})
 	if err != nil {
@@ -450,7 +454,7 @@ func TestRemovesManagerFromSharedMapOnDelete(t *testing.T) {
 		},
 	})
 
-	reconciler := CheReconciler{client: cl, scheme: scheme, gateway: gateway.New(cl, scheme), syncer: sync.New(cl, scheme)}
+	reconciler := CheReconciler{client: cl, scheme: scheme, gateway: gateway.New(cl, nil, scheme, nil), syncer: sync.New(cl, scheme)}
 
 	_, err := reconciler.Reconcile(reconcile.Request{NamespacedName: types.NamespacedName{Name: managerName, Namespace: ns}})
 	if err != nil {
@@ -515,7 +519,8 @@ func TestManagerFinalization(t *testing.T) {
 			},
 		})
 
-	reconciler := CheReconciler{client: cl, scheme: scheme, gateway: gateway.New(cl, scheme), syncer: sync.New(cl, scheme)}
+	recorder := record.NewFakeRecorder(100)
+	reconciler := CheReconciler{client: cl, scheme: scheme, gateway: gateway.New(cl, nil, scheme, nil), syncer: sync.New(cl, scheme), recorder: recorder}
 
 	_, err := reconciler.Reconcile(reconcile.Request{NamespacedName: types.NamespacedName{Name: managerName, Namespace: ns}})
 	if err != nil {
@@ -565,6 +570,13 @@ func TestManagerFinalization(t *testing.T) {
 		t.Fatalf("Expected an non-empty message about the failed finalization in the manager status")
 	}
 
+	if len(recorder.Events) != 1 {
+		t.Fatalf("Expected a single event about the failed finalization but there were %d", len(recorder.Events))
+	}
+	if e := <-recorder.Events; !strings.HasPrefix(e, corev1.EventTypeWarning+" "+EventReasonFinalizationBlocked) {
+		t.Fatalf("Unexpected event recorded: %s", e)
+	}
+
 	// now remove the config map and check that the finalization proceeds
 	err = cl.Delete(ctx, &corev1.ConfigMap{
 		ObjectMeta: metav1.ObjectMeta{
@@ -591,3 +603,93 @@ func TestManagerFinalization(t *testing.T) {
 		t.Fatalf("The finalizers should be cleared after the finalization success but there were still some: %d", len(manager.Finalizers))
 	}
 }
+
+func TestStatusUpdateDoesntConflictWithConcurrentChanges(t *testing.T) {
+	managerName := "che"
+	ns := "default"
+	scheme := createTestScheme()
+	ctx := context.TODO()
+	cl := fake.NewFakeClientWithScheme(scheme, &v1alpha1.CheManager{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      managerName,
+			Namespace: ns,
+		},
+		Spec: v1alpha1.CheManagerSpec{
+			Host: "over.the.rainbow",
+		},
+	})
+
+	reconciler := CheReconciler{client: cl, scheme: scheme, gateway: gateway.New(cl, nil, scheme, nil), syncer: sync.New(cl, scheme)}
+
+	stale := &v1alpha1.CheManager{}
+	if err := cl.Get(ctx, client.ObjectKey{Name: managerName, Namespace: ns}, stale); err != nil {
+		t.Fatalf("Failed to get the manager: %s", err)
+	}
+
+	// someone else modifies the manager in the meantime
+	concurrent := stale.DeepCopy()
+	concurrent.Labels = map[string]string{"modified": "true"}
+	if err := cl.Update(ctx, concurrent); err != nil {
+		t.Fatalf("Failed to update the manager: %s", err)
+	}
+
+	if _, err := reconciler.updateStatus(ctx, stale, true, "over.the.rainbow"); err != nil {
+		t.Fatalf("The status update should not have conflicted with the concurrent change: %s", err)
+	}
+
+	current := &v1alpha1.CheManager{}
+	if err := cl.Get(ctx, client.ObjectKey{Name: managerName, Namespace: ns}, current); err != nil {
+		t.Fatalf("Failed to get the manager: %s", err)
+	}
+
+	if current.Status.GatewayPhase != v1alpha1.GatewayPhaseInitializing {
+		t.Errorf("Unexpected gateway phase: %s", current.Status.GatewayPhase)
+	}
+
+	if current.Labels["modified"] != "true" {
+		t.Errorf("The concurrent change of the manager should have been preserved")
+	}
+}
+
+func TestDryRunDoesntChangeAnything(t *testing.T) {
+	managerName := "che"
+	ns := "default"
+	scheme := createTestScheme()
+	ctx := context.TODO()
+	cl := fake.NewFakeClientWithScheme(scheme, &v1alpha1.CheManager{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      managerName,
+			Namespace: ns,
+		},
+		Spec: v1alpha1.CheManagerSpec{
+			Host: "over.the.rainbow",
+		},
+	})
+
+	reconciler := CheReconciler{client: cl, scheme: scheme, gateway: gateway.New(cl, nil, scheme, nil, sync.WithDryRun(nil)), syncer: sync.New(cl, scheme, sync.WithDryRun(nil))}
+	defer delete(currentManagers, client.ObjectKey{Name: managerName, Namespace: ns})
+
+	res, err := reconciler.Reconcile(reconcile.Request{NamespacedName: types.NamespacedName{Name: managerName, Namespace: ns}})
+	if err != nil {
+		t.Fatalf("Failed to reconcile che manager with error: %s", err)
+	}
+	if res.Requeue {
+		t.Error("The reconciliation should not be requeued in the dry-run mode")
+	}
+
+	manager := &v1alpha1.CheManager{}
+	if err = cl.Get(ctx, client.ObjectKey{Name: managerName, Namespace: ns}, manager); err != nil {
+		t.Fatalf("Failed to get the manager: %s", err)
+	}
+
+	if len(manager.Finalizers) != 0 {
+		t.Error("The finalizer should not have been set in the dry-run mode")
+	}
+	if manager.Status.Phase != "" {
+		t.Error("The status should not have been updated in the dry-run mode")
+	}
+
+	if err = cl.Get(ctx, client.ObjectKey{Name: managerName, Namespace: ns}, &appsv1.Deployment{}); err == nil {
+		t.Error("The gateway should not have been created in the dry-run mode")
+	}
+}
@@ -0,0 +1,145 @@
+//
+// Copyright (c) 2019-2021 Red Hat, Inc.
+// This program and the accompanying materials are made
+// available under the terms of the Eclipse Public License 2.0
+// which is available at https://www.eclipse.org/legal/epl-2.0/
+//
+// SPDX-License-Identifier: EPL-2.0
+//
+// Contributors:
+//   Red Hat, Inc. - initial API and implementation
+//
+
+package metrics
+
+import (
+	"context"
+
+	"github.com/che-incubator/devworkspace-che-operator/apis/che-controller/v1alpha1"
+	"github.com/che-incubator/devworkspace-che-operator/pkg/defaults"
+	dwo "github.com/devfile/devworkspace-operator/apis/controller/v1alpha1"
+	"github.com/devfile/devworkspace-operator/pkg/config"
+	"github.com/prometheus/client_golang/prometheus"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/selection"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+var (
+	managersDesc = prometheus.NewDesc(prometheus.BuildFQName(namespace, "", "managers"),
+		"The number of Che managers by their phase.",
+		[]string{"phase"}, nil)
+
+	workspacesDesc = prometheus.NewDesc(prometheus.BuildFQName(namespace, "", "manager_workspaces"),
+		"The number of workspaces attached to the gateway of a Che manager.",
+		[]string{"manager", "namespace"}, nil)
+
+	endpointsDesc = prometheus.NewDesc(prometheus.BuildFQName(namespace, "", "exposed_endpoints"),
+		"The number of workspace endpoints handled by the Che routing, by their protocol and exposure.",
+		[]string{"protocol", "exposure"}, nil)
+)
+
+// Collector computes the state metrics of the Che managers and workspace routings at the time they're scraped.
+// It reads the objects using the provided client so it is important to use a caching client (like the one
+// provided by the controller manager) so that the scrapes don't cause excessive load on the cluster.
+type Collector struct {
+	client client.Client
+}
+
+var _ prometheus.Collector = (*Collector)(nil)
+
+// NewCollector creates a new collector reading the state of the cluster using the provided client.
+func NewCollector(client client.Client) *Collector {
+	return &Collector{client: client}
+}
+
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- managersDesc
+	ch <- workspacesDesc
+	ch <- endpointsDesc
+}
+
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	ctx := context.Background()
+
+	managers := &v1alpha1.CheManagerList{}
+	if err := c.client.List(ctx, managers); err != nil {
+		ch <- prometheus.NewInvalidMetric(managersDesc, err)
+		ch <- prometheus.NewInvalidMetric(workspacesDesc, err)
+	} else {
+		c.collectManagers(ctx, ch, managers.Items)
+	}
+
+	routings := &dwo.WorkspaceRoutingList{}
+	if err := c.client.List(ctx, routings); err != nil {
+		ch <- prometheus.NewInvalidMetric(endpointsDesc, err)
+	} else {
+		collectEndpoints(ch, routings.Items)
+	}
+}
+
+func (c *Collector) collectManagers(ctx context.Context, ch chan<- prometheus.Metric, managers []v1alpha1.CheManager) {
+	phases := map[string]int{}
+
+	workspaceIDExists, err := labels.NewRequirement(config.WorkspaceIDLabel, selection.Exists, nil)
+	if err != nil {
+		ch <- prometheus.NewInvalidMetric(workspacesDesc, err)
+		return
+	}
+
+	for _, m := range managers {
+		phase := string(m.Status.Phase)
+		if phase == "" {
+			phase = "Unknown"
+		}
+		phases[phase]++
+
+		// the workspaces are represented by the gateway configmaps in the namespace of the manager
+		configs := &corev1.ConfigMapList{}
+		selector := labels.SelectorFromSet(defaults.GetLabelsForComponent(&m, "gateway-config")).Add(*workspaceIDExists)
+		if err := c.client.List(ctx, configs, &client.ListOptions{Namespace: m.Namespace, LabelSelector: selector}); err != nil {
+			ch <- prometheus.NewInvalidMetric(workspacesDesc, err)
+			continue
+		}
+
+		ch <- prometheus.MustNewConstMetric(workspacesDesc, prometheus.GaugeValue, float64(len(configs.Items)), m.Name, m.Namespace)
+	}
+
+	for phase, count := range phases {
+		ch <- prometheus.MustNewConstMetric(managersDesc, prometheus.GaugeValue, float64(count), phase)
+	}
+}
+
+func collectEndpoints(ch chan<- prometheus.Metric, routings []dwo.WorkspaceRouting) {
+	type key struct {
+		protocol string
+		exposure string
+	}
+
+	counts := map[key]int{}
+
+	for _, r := range routings {
+		if r.Spec.RoutingClass != "che" {
+			continue
+		}
+
+		for _, endpoints := range r.Spec.Endpoints {
+			for _, e := range endpoints {
+				protocol := string(e.Protocol)
+				if protocol == "" {
+					protocol = "http"
+				}
+				exposure := string(e.Exposure)
+				if exposure == "" {
+					exposure = "public"
+				}
+				counts[key{protocol: protocol, exposure: exposure}]++
+			}
+		}
+	}
+
+	for k, count := range counts {
+		ch <- prometheus.MustNewConstMetric(endpointsDesc, prometheus.GaugeValue, float64(count), k.protocol, k.exposure)
+	}
+}
@@ -0,0 +1,132 @@
+package metrics
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/che-incubator/devworkspace-che-operator/apis/che-controller/v1alpha1"
+	"github.com/che-incubator/devworkspace-che-operator/pkg/defaults"
+	dw "github.com/devfile/api/v2/pkg/apis/workspaces/v1alpha2"
+	dwo "github.com/devfile/devworkspace-operator/apis/controller/v1alpha1"
+	"github.com/devfile/devworkspace-operator/controllers/controller/workspacerouting/solvers"
+	"github.com/devfile/devworkspace-operator/pkg/config"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func createTestScheme() *runtime.Scheme {
+	scheme := runtime.NewScheme()
+
+	utilruntime.Must(v1alpha1.AddToScheme(scheme))
+	utilruntime.Must(dwo.AddToScheme(scheme))
+	utilruntime.Must(corev1.AddToScheme(scheme))
+
+	return scheme
+}
+
+func TestCollect(t *testing.T) {
+	manager := &v1alpha1.CheManager{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "che",
+			Namespace: "ns",
+		},
+		Status: v1alpha1.CheManagerStatus{
+			Phase: v1alpha1.ManagerPhaseActive,
+		},
+	}
+
+	workspaceConfig := func(name string, wsId string) *corev1.ConfigMap {
+		labels := defaults.GetLabelsForComponent(manager, "gateway-config")
+		if wsId != "" {
+			labels[config.WorkspaceIDLabel] = wsId
+		}
+		return &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: manager.Namespace,
+				Labels:    labels,
+			},
+		}
+	}
+
+	routing := func(name string, class dwo.WorkspaceRoutingClass, endpoints ...dw.Endpoint) *dwo.WorkspaceRouting {
+		return &dwo.WorkspaceRouting{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: "ws",
+			},
+			Spec: dwo.WorkspaceRoutingSpec{
+				RoutingClass: class,
+				Endpoints: map[string]dwo.EndpointList{
+					"m": endpoints,
+				},
+			},
+		}
+	}
+
+	cl := fake.NewFakeClientWithScheme(createTestScheme(),
+		manager,
+		&v1alpha1.CheManager{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "pending",
+				Namespace: "ns2",
+			},
+		},
+		workspaceConfig("ws1", "wsid1"),
+		workspaceConfig("ws2", "wsid2"),
+		// this is the main gateway configuration, not a workspace
+		workspaceConfig("che", ""),
+		routing("r1", "che",
+			dw.Endpoint{Name: "e1", TargetPort: 8080},
+			dw.Endpoint{Name: "e2", TargetPort: 8081, Exposure: dw.InternalEndpointExposure, Protocol: "tcp"}),
+		routing("r2", "che", dw.Endpoint{Name: "e1", TargetPort: 8080, Protocol: "https"}),
+		routing("r3", "basic", dw.Endpoint{Name: "e1", TargetPort: 8080}),
+	)
+
+	expected := `
+# HELP devworkspace_che_exposed_endpoints The number of workspace endpoints handled by the Che routing, by their protocol and exposure.
+# TYPE devworkspace_che_exposed_endpoints gauge
+devworkspace_che_exposed_endpoints{exposure="internal",protocol="tcp"} 1
+devworkspace_che_exposed_endpoints{exposure="public",protocol="http"} 1
+devworkspace_che_exposed_endpoints{exposure="public",protocol="https"} 1
+# HELP devworkspace_che_manager_workspaces The number of workspaces attached to the gateway of a Che manager.
+# TYPE devworkspace_che_manager_workspaces gauge
+devworkspace_che_manager_workspaces{manager="che",namespace="ns"} 2
+devworkspace_che_manager_workspaces{manager="pending",namespace="ns2"} 0
+# HELP devworkspace_che_managers The number of Che managers by their phase.
+# TYPE devworkspace_che_managers gauge
+devworkspace_che_managers{phase="Active"} 1
+devworkspace_che_managers{phase="Unknown"} 1
+`
+
+	if err := testutil.CollectAndCompare(NewCollector(cl), strings.NewReader(expected)); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestRecordSolverError(t *testing.T) {
+	notReady := testutil.ToFloat64(solverErrors.WithLabelValues(SolverErrorRoutingNotReady))
+	invalid := testutil.ToFloat64(solverErrors.WithLabelValues(SolverErrorRoutingInvalid))
+	other := testutil.ToFloat64(solverErrors.WithLabelValues(SolverErrorOther))
+
+	RecordSolverError(nil)
+	RecordSolverError(&solvers.RoutingNotReady{})
+	RecordSolverError(&solvers.RoutingInvalid{Reason: "because"})
+	RecordSolverError(errors.New("kaboom"))
+	RecordSolverError(errors.New("kaboom again"))
+
+	if d := testutil.ToFloat64(solverErrors.WithLabelValues(SolverErrorRoutingNotReady)) - notReady; d != 1 {
+		t.Errorf("Expected 1 new not ready error but got %v", d)
+	}
+	if d := testutil.ToFloat64(solverErrors.WithLabelValues(SolverErrorRoutingInvalid)) - invalid; d != 1 {
+		t.Errorf("Expected 1 new invalid routing error but got %v", d)
+	}
+	if d := testutil.ToFloat64(solverErrors.WithLabelValues(SolverErrorOther)) - other; d != 2 {
+		t.Errorf("Expected 2 new other errors but got %v", d)
+	}
+}
@@ -0,0 +1,78 @@
+//
+// Copyright (c) 2019-2021 Red Hat, Inc.
+// This program and the accompanying materials are made
+// available under the terms of the Eclipse Public License 2.0
+// which is available at https://www.eclipse.org/legal/epl-2.0/
+//
+// SPDX-License-Identifier: EPL-2.0
+//
+// Contributors:
+//   Red Hat, Inc. - initial API and implementation
+//
+
+// Package metrics contains the Prometheus metrics specific to the Che manager and the workspace routing.
+// The metrics are registered with the controller-runtime metrics registry and are therefore exposed on the same
+// endpoint as the default controller-runtime metrics.
+package metrics
+
+import (
+	"errors"
+
+	"github.com/devfile/devworkspace-operator/controllers/controller/workspacerouting/solvers"
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+const (
+	namespace = "devworkspace_che"
+
+	SolverErrorRoutingNotReady = "RoutingNotReady"
+	SolverErrorRoutingInvalid  = "RoutingInvalid"
+	SolverErrorOther           = "Other"
+
+	SyncerOperationCreate = "create"
+	SyncerOperationUpdate = "update"
+	SyncerOperationDelete = "delete"
+)
+
+var (
+	solverErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "solver_errors_total",
+		Help:      "The number of errors the workspace routing solver returned, by the type of the error.",
+	}, []string{"type"})
+
+	syncerOperations = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "syncer_operations_total",
+		Help:      "The number of objects created, updated or deleted in the cluster, by the kind of the object.",
+	}, []string{"kind", "operation"})
+)
+
+func init() {
+	metrics.Registry.MustRegister(solverErrors, syncerOperations)
+}
+
+// RecordSolverError increments the solver error counter according to the type of the provided error. Nil errors
+// are ignored.
+func RecordSolverError(err error) {
+	if err == nil {
+		return
+	}
+
+	var notReady *solvers.RoutingNotReady
+	var invalid *solvers.RoutingInvalid
+
+	if errors.As(err, &notReady) {
+		solverErrors.WithLabelValues(SolverErrorRoutingNotReady).Inc()
+	} else if errors.As(err, &invalid) {
+		solverErrors.WithLabelValues(SolverErrorRoutingInvalid).Inc()
+	} else {
+		solverErrors.WithLabelValues(SolverErrorOther).Inc()
+	}
+}
+
+// RecordSyncerOperation increments the counter of the syncer operations performed on the objects of given kind.
+func RecordSyncerOperation(kind string, operation string) {
+	syncerOperations.WithLabelValues(kind, operation).Inc()
+}
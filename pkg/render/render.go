@@ -0,0 +1,330 @@
+//
+// Copyright (c) 2019-2021 Red Hat, Inc.
+// This program and the accompanying materials are made
+// available under the terms of the Eclipse Public License 2.0
+// which is available at https://www.eclipse.org/legal/epl-2.0/
+//
+// SPDX-License-Identifier: EPL-2.0
+//
+// Contributors:
+//   Red Hat, Inc. - initial API and implementation
+//
+
+// Package render renders the routing configuration of a workspace without any cluster. It runs the real solver
+// against a fake client, so the output is exactly what the operator would create in the cluster.
+package render
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+
+	"github.com/che-incubator/devworkspace-che-operator/apis/che-controller/v1alpha1"
+	"github.com/che-incubator/devworkspace-che-operator/pkg/defaults"
+	"github.com/che-incubator/devworkspace-che-operator/pkg/infrastructure"
+	"github.com/che-incubator/devworkspace-che-operator/pkg/manager"
+	operatorscheme "github.com/che-incubator/devworkspace-che-operator/pkg/scheme"
+	"github.com/che-incubator/devworkspace-che-operator/pkg/solver"
+	dw "github.com/devfile/api/v2/pkg/apis/workspaces/v1alpha2"
+	controllerv1alpha1 "github.com/devfile/devworkspace-operator/apis/controller/v1alpha1"
+	"github.com/devfile/devworkspace-operator/controllers/controller/workspacerouting/solvers"
+	"github.com/devfile/devworkspace-operator/pkg/config"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	// CommandName is the name of the operator subcommand that renders the configuration
+	CommandName = "render"
+
+	defaultManagerNamespace   = "che"
+	defaultWorkspaceNamespace = "workspace"
+	defaultWorkspaceID        = "workspaceid"
+
+	renderedManagerUID = "rendered-manager-uid"
+	renderedRoutingUID = "rendered-routing-uid"
+
+	// the number of the reconciliations of the che manager we're willing to do to get the gateway established
+	maxManagerReconciliations = 5
+)
+
+// Run renders the routing configuration of the workspace as specified by the command line arguments and writes it
+// to out.
+func Run(args []string, out io.Writer) error {
+	flags := flag.NewFlagSet(CommandName, flag.ContinueOnError)
+	managerFile := flags.String("manager", "", "The path to the YAML file with the CheManager.")
+	routingFile := flags.String("routing", "", "The path to the YAML file with the WorkspaceRouting or the flattened DevWorkspace.")
+	workspaceID := flags.String("workspace-id", "", "The ID of the workspace. Defaults to the ID in the routing or the status "+
+		"of the DevWorkspace or to '"+defaultWorkspaceID+"' if there is none.")
+	openShift := flags.Bool("openshift", false, "Render the configuration as if running on OpenShift.")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if *managerFile == "" || *routingFile == "" {
+		flags.Usage()
+		return fmt.Errorf("both the che manager and the routing need to be specified")
+	}
+
+	mgr, err := readManager(*managerFile)
+	if err != nil {
+		return err
+	}
+
+	routing, err := readRouting(*routingFile, *workspaceID)
+	if err != nil {
+		return err
+	}
+
+	kind := infrastructure.Kind{Type: infrastructure.Kubernetes}
+	if *openShift {
+		kind = infrastructure.Kind{Type: infrastructure.OpenShift, Generation: infrastructure.V4}
+	}
+
+	return render(mgr, routing, kind, out)
+}
+
+func render(mgr *v1alpha1.CheManager, routing *controllerv1alpha1.WorkspaceRouting, kind infrastructure.Kind, out io.Writer) error {
+	// we're not talking to any cluster, so the infrastructure is whatever we're told it is
+	infrastructure.Override(kind)
+
+	// make sure the routing is handled by our manager regardless of whatever other managers the solver knows of
+	if routing.Annotations == nil {
+		routing.Annotations = map[string]string{}
+	}
+	routing.Annotations[defaults.ConfigAnnotationCheManagerName] = mgr.Name
+	routing.Annotations[defaults.ConfigAnnotationCheManagerNamespace] = mgr.Namespace
+
+	// the objects coming from the cluster always have the UIDs and the owned objects are only pruned if they do
+	if mgr.UID == "" {
+		mgr.UID = renderedManagerUID
+	}
+	if routing.UID == "" {
+		routing.UID = renderedRoutingUID
+	}
+
+	scheme := runtime.NewScheme()
+	utilruntime.Must(operatorscheme.AddToScheme(scheme))
+	cl := fake.NewFakeClientWithScheme(scheme, mgr)
+
+	managerReconciler := manager.New(cl, scheme)
+	managerKey := client.ObjectKey{Name: mgr.Name, Namespace: mgr.Namespace}
+	reconcileManager := func() error {
+		_, err := managerReconciler.Reconcile(reconcile.Request{NamespacedName: managerKey})
+		return err
+	}
+
+	// the first reconciliation only sets the finalizer, the second one actually creates the gateway
+	for i := 0; i < 2; i++ {
+		if err := reconcileManager(); err != nil {
+			return err
+		}
+	}
+
+	routingSolver, err := solver.Getter(scheme, nil, nil).GetSolver(cl, routing.Spec.RoutingClass)
+	if err != nil {
+		return err
+	}
+
+	objs, err := routingSolver.GetSpecObjects(routing, solvers.WorkspaceMetadata{
+		WorkspaceId:   routing.Spec.WorkspaceId,
+		Namespace:     routing.Namespace,
+		PodSelector:   routing.Spec.PodSelector,
+		RoutingSuffix: routing.Spec.RoutingSuffix,
+	})
+	if err != nil {
+		return err
+	}
+
+	// the endpoints are only exposed once the gateway is established
+	for i := 0; i < maxManagerReconciliations; i++ {
+		if manager.GetCurrentManagers()[managerKey].Status.GatewayPhase == v1alpha1.GatewayPhaseEstablished {
+			break
+		}
+		if err = reconcileManager(); err != nil {
+			return err
+		}
+	}
+
+	exposed, ready, err := routingSolver.GetExposedEndpoints(routing.Spec.Endpoints, objs)
+	if err != nil {
+		return err
+	}
+
+	configMaps := &corev1.ConfigMapList{}
+	if err = cl.List(context.TODO(), configMaps, client.InNamespace(mgr.Namespace), client.MatchingLabels{config.WorkspaceIDLabel: routing.Spec.WorkspaceId}); err != nil {
+		return err
+	}
+
+	w := &writer{out: out}
+
+	for i := range objs.Services {
+		w.object("Service", &objs.Services[i])
+	}
+	for i := range objs.Ingresses {
+		w.object("Ingress", &objs.Ingresses[i])
+	}
+	for i := range objs.Routes {
+		w.object("Route", &objs.Routes[i])
+	}
+
+	for _, cm := range configMaps.Items {
+		files := make([]string, 0, len(cm.Data))
+		for f := range cm.Data {
+			files = append(files, f)
+		}
+		sort.Strings(files)
+		for _, f := range files {
+			w.document(fmt.Sprintf("Gateway configuration %s in the config map %s/%s", f, cm.Namespace, cm.Name), cm.Data[f])
+		}
+	}
+
+	if ready {
+		w.object("Exposed endpoints", exposed)
+	} else {
+		w.document("Exposed endpoints", "# The endpoints are not ready. Does the che manager specify the host?\n")
+	}
+
+	return w.err
+}
+
+// writer writes the YAML documents to the output, remembering the first error.
+type writer struct {
+	out io.Writer
+	err error
+}
+
+func (w *writer) object(title string, obj interface{}) {
+	if w.err != nil {
+		return
+	}
+
+	var data []byte
+	if data, w.err = yaml.Marshal(obj); w.err != nil {
+		return
+	}
+
+	w.document(title, string(data))
+}
+
+func (w *writer) document(title string, content string) {
+	if w.err != nil {
+		return
+	}
+
+	_, w.err = fmt.Fprintf(w.out, "---\n# %s\n%s", title, content)
+}
+
+func readManager(file string) (*v1alpha1.CheManager, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	mgr := &v1alpha1.CheManager{}
+	if err = yaml.Unmarshal(data, mgr); err != nil {
+		return nil, err
+	}
+
+	if mgr.Kind != "CheManager" {
+		return nil, fmt.Errorf("expected a CheManager in %s but found %s", file, mgr.Kind)
+	}
+
+	if mgr.Namespace == "" {
+		mgr.Namespace = defaultManagerNamespace
+	}
+
+	return mgr, nil
+}
+
+func readRouting(file string, workspaceID string) (*controllerv1alpha1.WorkspaceRouting, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	meta := &metav1.TypeMeta{}
+	if err = yaml.Unmarshal(data, meta); err != nil {
+		return nil, err
+	}
+
+	var routing *controllerv1alpha1.WorkspaceRouting
+
+	switch meta.Kind {
+	case "WorkspaceRouting":
+		routing = &controllerv1alpha1.WorkspaceRouting{}
+		if err = yaml.Unmarshal(data, routing); err != nil {
+			return nil, err
+		}
+		if workspaceID != "" {
+			routing.Spec.WorkspaceId = workspaceID
+		}
+	case "DevWorkspace":
+		workspace := &dw.DevWorkspace{}
+		if err = yaml.Unmarshal(data, workspace); err != nil {
+			return nil, err
+		}
+		if workspaceID != "" {
+			workspace.Status.WorkspaceId = workspaceID
+		}
+		routing = routingOfWorkspace(workspace)
+	default:
+		return nil, fmt.Errorf("expected a WorkspaceRouting or a DevWorkspace in %s but found %s", file, meta.Kind)
+	}
+
+	if routing.Spec.WorkspaceId == "" {
+		routing.Spec.WorkspaceId = defaultWorkspaceID
+	}
+
+	if routing.Namespace == "" {
+		routing.Namespace = defaultWorkspaceNamespace
+	}
+
+	return routing, nil
+}
+
+// routingOfWorkspace creates the routing of the workspace the same way the DevWorkspace operator does.
+func routingOfWorkspace(workspace *dw.DevWorkspace) *controllerv1alpha1.WorkspaceRouting {
+	endpoints := map[string]controllerv1alpha1.EndpointList{}
+	for _, component := range workspace.Spec.Template.Components {
+		if component.Container == nil || len(component.Container.Endpoints) == 0 {
+			continue
+		}
+		endpoints[component.Name] = append(endpoints[component.Name], component.Container.Endpoints...)
+	}
+
+	annotations := map[string]string{}
+	if val, ok := workspace.Annotations[config.WorkspaceRestrictedAccessAnnotation]; ok {
+		annotations[config.WorkspaceRestrictedAccessAnnotation] = val
+	}
+
+	workspaceID := workspace.Status.WorkspaceId
+	if workspaceID == "" {
+		workspaceID = defaultWorkspaceID
+	}
+
+	return &controllerv1alpha1.WorkspaceRouting{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            "routing-" + workspaceID,
+			Namespace:       workspace.Namespace,
+			Labels:          map[string]string{config.WorkspaceIDLabel: workspaceID},
+			Annotations:     annotations,
+			OwnerReferences: []metav1.OwnerReference{*metav1.NewControllerRef(workspace, dw.SchemeGroupVersion.WithKind("DevWorkspace"))},
+		},
+		Spec: controllerv1alpha1.WorkspaceRoutingSpec{
+			WorkspaceId:  workspaceID,
+			RoutingClass: controllerv1alpha1.WorkspaceRoutingClass(workspace.Spec.RoutingClass),
+			Endpoints:    endpoints,
+			PodSelector:  map[string]string{config.WorkspaceIDLabel: workspaceID},
+		},
+	}
+}
@@ -0,0 +1,116 @@
+package render
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/che-incubator/devworkspace-che-operator/pkg/infrastructure"
+	controllerv1alpha1 "github.com/devfile/devworkspace-operator/apis/controller/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/yaml"
+)
+
+func TestRendersFlattenedDevWorkspace(t *testing.T) {
+	defer infrastructure.InitializeForTesting(infrastructure.Kind{Type: infrastructure.Undetected})
+
+	out := &bytes.Buffer{}
+	err := Run([]string{
+		"--manager", "../../samples/che-manager-minikube.yaml",
+		"--routing", "../../samples/flattened_theia-nodejs.yaml",
+		"--workspace-id", "wsid",
+	}, out)
+	if err != nil {
+		t.Fatalf("Failed to render: %s", err)
+	}
+
+	docs := strings.Split(out.String(), "---\n")[1:]
+	if len(docs) != 3 {
+		t.Fatalf("Expected the service, the gateway configuration and the endpoints to be rendered but got %d documents:\n%s", len(docs), out.String())
+	}
+
+	service := &corev1.Service{}
+	if err = yaml.Unmarshal([]byte(docs[0]), service); err != nil {
+		t.Fatalf("Failed to parse the service: %s", err)
+	}
+	if service.Name != "wsid-service" || service.Namespace != defaultWorkspaceNamespace {
+		t.Errorf("Unexpected service %s/%s", service.Namespace, service.Name)
+	}
+
+	if !strings.Contains(docs[1], "# Gateway configuration wsid.yml in the config map che/wsid") {
+		t.Errorf("Unexpected gateway configuration:\n%s", docs[1])
+	}
+	if !strings.Contains(docs[1], "rule: PathPrefix(`/wsid/theia-ide/3100`)") {
+		t.Errorf("The gateway configuration doesn't route to the theia endpoint:\n%s", docs[1])
+	}
+
+	endpoints := map[string]controllerv1alpha1.ExposedEndpointList{}
+	if err = yaml.Unmarshal([]byte(docs[2]), &endpoints); err != nil {
+		t.Fatalf("Failed to parse the exposed endpoints: %s", err)
+	}
+
+	found := false
+	for _, e := range endpoints["theia-ide"] {
+		if e.Name == "theia" {
+			found = true
+			if e.Url != "https://che.${MINIKUBE_IP}.nip.io/wsid/theia-ide/3100/" {
+				t.Errorf("Unexpected URL of the theia endpoint: %s", e.Url)
+			}
+		}
+	}
+	if !found {
+		t.Errorf("The theia endpoint was not exposed:\n%s", docs[2])
+	}
+}
+
+func TestRendersWithIsolatedWorkspaces(t *testing.T) {
+	defer infrastructure.InitializeForTesting(infrastructure.Kind{Type: infrastructure.Undetected})
+
+	dir, err := ioutil.TempDir("", "render")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	managerFile := filepath.Join(dir, "manager.yaml")
+	manager := `kind: CheManager
+apiVersion: che.eclipse.org/v1alpha1
+metadata:
+  name: che
+spec:
+  host: che.example.com
+  routing: singlehost
+  gateway:
+    isolateWorkspaces: true
+`
+	if err = ioutil.WriteFile(managerFile, []byte(manager), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	out := &bytes.Buffer{}
+	err = Run([]string{
+		"--manager", managerFile,
+		"--routing", "../../samples/flattened_theia-nodejs.yaml",
+		"--workspace-id", "wsid",
+	}, out)
+	if err != nil {
+		t.Fatalf("Failed to render with the isolated workspaces: %s", err)
+	}
+
+	if !strings.Contains(out.String(), "https://che.example.com/wsid/theia-ide/3100/") {
+		t.Errorf("The theia endpoint was not exposed:\n%s", out.String())
+	}
+}
+
+func TestRejectsUnknownKinds(t *testing.T) {
+	err := Run([]string{
+		"--manager", "../../samples/flattened_theia-nodejs.yaml",
+		"--routing", "../../samples/flattened_theia-nodejs.yaml",
+	}, &bytes.Buffer{})
+	if err == nil || !strings.Contains(err.Error(), "expected a CheManager") {
+		t.Errorf("Expected an error about the kind of the che manager but got: %v", err)
+	}
+}
@@ -0,0 +1,47 @@
+//
+// Copyright (c) 2019-2021 Red Hat, Inc.
+// This program and the accompanying materials are made
+// available under the terms of the Eclipse Public License 2.0
+// which is available at https://www.eclipse.org/legal/epl-2.0/
+//
+// SPDX-License-Identifier: EPL-2.0
+//
+// Contributors:
+//   Red Hat, Inc. - initial API and implementation
+//
+
+// Package scheme registers all the types the operator works with, so that the operator and the subcommands that run
+// the controllers without a cluster use the very same scheme.
+package scheme
+
+import (
+	"github.com/che-incubator/devworkspace-che-operator/apis/che-controller/v1alpha1"
+	dw "github.com/devfile/api/v2/pkg/apis/workspaces/v1alpha2"
+	controllerv1alpha1 "github.com/devfile/devworkspace-operator/apis/controller/v1alpha1"
+	routev1 "github.com/openshift/api/route/v1"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	extensions "k8s.io/api/extensions/v1beta1"
+	networkingv1 "k8s.io/api/networking/v1"
+	policy "k8s.io/api/policy/v1beta1"
+	rbac "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+var (
+	builder = runtime.NewSchemeBuilder(
+		v1alpha1.AddToScheme,
+		controllerv1alpha1.AddToScheme,
+		extensions.AddToScheme,
+		corev1.AddToScheme,
+		appsv1.AddToScheme,
+		rbac.AddToScheme,
+		policy.AddToScheme,
+		networkingv1.AddToScheme,
+		routev1.AddToScheme,
+		dw.AddToScheme,
+	)
+
+	// AddToScheme adds all the types the operator works with to the given scheme.
+	AddToScheme = builder.AddToScheme
+)
@@ -0,0 +1,274 @@
+//
+// Copyright (c) 2019-2021 Red Hat, Inc.
+// This program and the accompanying materials are made
+// available under the terms of the Eclipse Public License 2.0
+// which is available at https://www.eclipse.org/legal/epl-2.0/
+//
+// SPDX-License-Identifier: EPL-2.0
+//
+// Contributors:
+//   Red Hat, Inc. - initial API and implementation
+//
+
+package solver
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"strings"
+
+	dwoche "github.com/che-incubator/devworkspace-che-operator/apis/che-controller/v1alpha1"
+	"github.com/che-incubator/devworkspace-che-operator/pkg/traefik"
+	dwo "github.com/devfile/devworkspace-operator/apis/controller/v1alpha1"
+	"github.com/devfile/devworkspace-operator/controllers/controller/workspacerouting/solvers"
+)
+
+const (
+	// IPAllowListAnnotation is the annotation of the workspace routing with the comma-separated IP ranges from which
+	// the endpoints of the workspace can be accessed. It can only narrow the IP allow list of the Che manager. When put
+	// on the DevWorkspace, it is copied to the workspace routing by the DevWorkspace operator.
+	IPAllowListAnnotation = "che.routing.controller.devfile.io/ip-allow-list"
+
+	// RateLimitAnnotation is the annotation of the workspace routing with the rate limit of the requests to
+	// the endpoints of the workspace, e.g. {"average": 100, "burst": 50}. It can only lower the rate limit of the Che
+	// manager. An average of 0 keeps the rate limit of the Che manager.
+	RateLimitAnnotation = "che.routing.controller.devfile.io/rate-limit"
+
+	// the ingress or route in front of the gateway appends the IP address of the client to the X-Forwarded-For header
+	clientIPDepth = 1
+)
+
+// accessPolicy specifies which clients can access an endpoint and how often. The nil fields are not restricted.
+type accessPolicy struct {
+	ipAllowList []string
+	rateLimit   *dwoche.RateLimit
+
+	// trustedProxies is true if the gateway only accepts the X-Forwarded-For header from the proxies in front of it.
+	// Otherwise anyone who can reach the gateway directly can forge the IP address of the client.
+	trustedProxies bool
+}
+
+// workspaceAccessPolicy returns the access policy of the Che manager narrowed by the annotations of the workspace
+// routing. The workspaces are controlled by their users, so they can only restrict the access further, never lift
+// the restrictions of the Che manager. The returned error is a solvers.RoutingInvalid describing the malformed
+// configuration.
+func workspaceAccessPolicy(cheManager *dwoche.CheManager, routing *dwo.WorkspaceRouting) (accessPolicy, error) {
+	ret := accessPolicy{
+		ipAllowList:    cheManager.Spec.Gateway.IPAllowList,
+		rateLimit:      cheManager.Spec.Gateway.RateLimit,
+		trustedProxies: len(cheManager.Spec.Gateway.ForwardedHeadersTrustedIPs) > 0,
+	}
+
+	if problem := validateAccessPolicy(ret); problem != "" {
+		return accessPolicy{}, &solvers.RoutingInvalid{
+			Reason: fmt.Sprintf("the access policy of the Che manager is invalid: %s", problem),
+		}
+	}
+
+	override := accessPolicy{}
+
+	if anno, ok := routing.Annotations[IPAllowListAnnotation]; ok {
+		override.ipAllowList = []string{}
+		for _, r := range strings.Split(anno, ",") {
+			if r = strings.TrimSpace(r); r != "" {
+				override.ipAllowList = append(override.ipAllowList, r)
+			}
+		}
+	}
+
+	if anno, ok := routing.Annotations[RateLimitAnnotation]; ok {
+		override.rateLimit = &dwoche.RateLimit{}
+		if err := json.Unmarshal([]byte(anno), override.rateLimit); err != nil {
+			return accessPolicy{}, &solvers.RoutingInvalid{
+				Reason: fmt.Sprintf("the annotation '%s' is not an object with the average and burst: %s", RateLimitAnnotation, err),
+			}
+		}
+	}
+
+	if problem := validateAccessPolicy(override); problem != "" {
+		return accessPolicy{}, &solvers.RoutingInvalid{
+			Reason: fmt.Sprintf("the access policy of the workspace is invalid: %s", problem),
+		}
+	}
+
+	ret, err := ret.narrow(override)
+	if err == nil {
+		err = ret.checkClientIP()
+	}
+	if err != nil {
+		return accessPolicy{}, &solvers.RoutingInvalid{
+			Reason: fmt.Sprintf("the access policy of the workspace is invalid: %s", err),
+		}
+	}
+
+	return ret, nil
+}
+
+// forEndpoint returns the policy narrowed by the attributes of the endpoint. The returned error is
+// a solvers.RoutingInvalid if the attributes would not let any client access the endpoint.
+func (p accessPolicy) forEndpoint(endpointName string, attrs endpointAttributes) (accessPolicy, error) {
+	ret, err := p.narrow(accessPolicy{ipAllowList: attrs.ipAllowList, rateLimit: attrs.rateLimit})
+	if err == nil {
+		err = ret.checkClientIP()
+	}
+	if err != nil {
+		return accessPolicy{}, &solvers.RoutingInvalid{
+			Reason: fmt.Sprintf("the access policy of the endpoint '%s' is invalid: %s", endpointName, err),
+		}
+	}
+	return ret, nil
+}
+
+// narrow returns the policy restricted by the other policy. The other policy can only restrict the access further:
+// only the IP addresses allowed by both the policies are allowed and the lower of the rate limits applies. An empty
+// IP allow list or a rate limit with a zero average of the other policy don't lift the restrictions of this policy
+// and only the bursts that are set are taken into account.
+func (p accessPolicy) narrow(other accessPolicy) (accessPolicy, error) {
+	if len(other.ipAllowList) > 0 {
+		if len(p.ipAllowList) == 0 {
+			p.ipAllowList = other.ipAllowList
+		} else {
+			allowed := intersectIPRanges(p.ipAllowList, other.ipAllowList)
+			if len(allowed) == 0 {
+				// an empty allow list would allow everyone
+				return accessPolicy{}, fmt.Errorf("the IP allow list %v doesn't overlap with the allowed IP ranges %v", other.ipAllowList, p.ipAllowList)
+			}
+			p.ipAllowList = allowed
+		}
+	}
+
+	if other.rateLimit != nil && other.rateLimit.Average > 0 {
+		if p.rateLimit == nil || p.rateLimit.Average == 0 {
+			p.rateLimit = other.rateLimit
+		} else {
+			// an omitted burst means the default of Traefik, so it doesn't lower the burst set by the other policy
+			burst := p.rateLimit.Burst
+			if other.rateLimit.Burst > 0 && (burst == 0 || other.rateLimit.Burst < burst) {
+				burst = other.rateLimit.Burst
+			}
+			p.rateLimit = &dwoche.RateLimit{
+				Average: min64(p.rateLimit.Average, other.rateLimit.Average),
+				Burst:   burst,
+			}
+		}
+	}
+
+	return p, nil
+}
+
+// checkClientIP returns an error if the policy has an IP allow list but the IP address of the client cannot be
+// trusted. The gateway takes it from the X-Forwarded-For header, which anyone calling the gateway directly could
+// forge unless the gateway only accepts it from the trusted proxies.
+func (p accessPolicy) checkClientIP() error {
+	if len(p.ipAllowList) > 0 && !p.trustedProxies {
+		return fmt.Errorf("the IP allow list %v requires spec.gateway.forwardedHeadersTrustedIPs of the Che manager to be set, "+
+			"otherwise the IP address of the client could be forged", p.ipAllowList)
+	}
+	return nil
+}
+
+// intersectIPRanges returns the IP ranges contained in both the lists of the valid IP addresses or ranges. Two
+// ranges either don't overlap or one contains the other, so the intersection consists of the narrower ranges of
+// the overlapping pairs.
+func intersectIPRanges(a []string, b []string) []string {
+	var ret []string
+	seen := map[string]bool{}
+	add := func(r string) {
+		if !seen[r] {
+			seen[r] = true
+			ret = append(ret, r)
+		}
+	}
+
+	for _, ra := range a {
+		na := parseIPRange(ra)
+		for _, rb := range b {
+			nb := parseIPRange(rb)
+			if na == nil || nb == nil {
+				continue
+			}
+
+			onesA, _ := na.Mask.Size()
+			onesB, _ := nb.Mask.Size()
+			if onesA >= onesB && nb.Contains(na.IP) {
+				add(ra)
+			} else if onesB > onesA && na.Contains(nb.IP) {
+				add(rb)
+			}
+		}
+	}
+
+	return ret
+}
+
+// parseIPRange parses the IP address or range into a network. Returns nil if the range is not valid.
+func parseIPRange(r string) *net.IPNet {
+	if _, n, err := net.ParseCIDR(r); err == nil {
+		return n
+	}
+
+	ip := net.ParseIP(r)
+	if ip == nil {
+		return nil
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		return &net.IPNet{IP: ip4, Mask: net.CIDRMask(32, 32)}
+	}
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)}
+}
+
+func min64(a int64, b int64) int64 {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// middlewares returns the middlewares enforcing the policy, named after the router of the endpoint.
+func (p accessPolicy) middlewares(name string) []namedMiddleware {
+	var ret []namedMiddleware
+
+	if len(p.ipAllowList) > 0 {
+		ret = append(ret, namedMiddleware{
+			name: name + "-ip-allow-list",
+			middleware: traefik.Middleware{
+				IPWhiteList: &traefik.IPWhiteList{
+					SourceRange: p.ipAllowList,
+					IPStrategy:  &traefik.IPStrategy{Depth: clientIPDepth},
+				},
+			},
+		})
+	}
+
+	if p.rateLimit != nil && p.rateLimit.Average > 0 {
+		ret = append(ret, namedMiddleware{
+			name: name + "-rate-limit",
+			middleware: traefik.Middleware{
+				RateLimit: &traefik.RateLimit{
+					Average: p.rateLimit.Average,
+					Burst:   p.rateLimit.Burst,
+					SourceCriterion: &traefik.SourceCriterion{
+						IPStrategy: &traefik.IPStrategy{Depth: clientIPDepth},
+					},
+				},
+			},
+		})
+	}
+
+	return ret
+}
+
+// validateAccessPolicy returns the description of the problem with the access policy or an empty string if the policy
+// is fine.
+func validateAccessPolicy(p accessPolicy) string {
+	for _, r := range p.ipAllowList {
+		if !traefik.IsValidIPRange(r) {
+			return fmt.Sprintf("'%s' is not a valid IP address or range", r)
+		}
+	}
+	if p.rateLimit != nil && (p.rateLimit.Average < 0 || p.rateLimit.Burst < 0) {
+		return "the rate limit cannot be negative"
+	}
+	return ""
+}
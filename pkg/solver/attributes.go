@@ -0,0 +1,343 @@
+//
+// Copyright (c) 2019-2021 Red Hat, Inc.
+// This program and the accompanying materials are made
+// available under the terms of the Eclipse Public License 2.0
+// which is available at https://www.eclipse.org/legal/epl-2.0/
+//
+// SPDX-License-Identifier: EPL-2.0
+//
+// Contributors:
+//   Red Hat, Inc. - initial API and implementation
+//
+
+package solver
+
+import (
+	"fmt"
+	"net/url"
+	"reflect"
+	"regexp"
+	"strings"
+	"time"
+
+	dwoche "github.com/che-incubator/devworkspace-che-operator/apis/che-controller/v1alpha1"
+	"github.com/che-incubator/devworkspace-che-operator/pkg/traefik"
+	dw "github.com/devfile/api/v2/pkg/apis/workspaces/v1alpha2"
+	"github.com/devfile/devworkspace-operator/controllers/controller/workspacerouting/solvers"
+)
+
+const (
+	// uniqueEndpointAttributeName makes the endpoint exposed on its own URL instead of sharing it with the other
+	// endpoints on the same port. The value is a boolean.
+	uniqueEndpointAttributeName = "unique"
+	// cookiePathRewriteEndpointAttributeName requests the paths of the cookies set by the endpoint to be rewritten
+	// to the public URL prefix of the endpoint. The value is a boolean. Traefik cannot modify the cookies, so
+	// the endpoint is forwarded through the cookie rewriting sidecar of the gateway, which needs to be enabled in
+	// the Che manager.
+	cookiePathRewriteEndpointAttributeName = "cookiePathRewrite"
+	// timeoutEndpointAttributeName specifies how long the gateway waits for the response of the endpoint. The value
+	// is either a duration like "90s" or a number of seconds. On Kubernetes, the ingress in front of the gateway
+	// doesn't wait longer than an hour regardless of this timeout.
+	timeoutEndpointAttributeName = "timeout"
+	// retriesEndpointAttributeName specifies how many times the gateway tries to forward a request to the endpoint
+	// if it cannot be reached. The value is a positive integer.
+	retriesEndpointAttributeName = "retries"
+	// circuitBreakerEndpointAttributeName specifies the condition under which the gateway stops forwarding
+	// the requests to the endpoint, e.g. "NetworkErrorRatio() > 0.5". The value is a Traefik circuit breaker expression.
+	circuitBreakerEndpointAttributeName = "circuitBreaker"
+	// authEndpointAttributeName specifies whether the users need to be authenticated to access the endpoint. The
+	// gateway cannot authenticate the users yet, so authModeNone is the only supported value.
+	authEndpointAttributeName = "auth"
+	// headersEndpointAttributeName specifies the headers that the gateway adds to the requests to the endpoint.
+	// The value is an object with the header names as keys and header values as values. An empty value removes
+	// the header from the request.
+	headersEndpointAttributeName = "headers"
+	// responseHeadersEndpointAttributeName specifies the headers that the gateway adds to the responses of
+	// the endpoint. The value has the same format as the value of the headers attribute.
+	responseHeadersEndpointAttributeName = "responseHeaders"
+	// forwardedPrefixEndpointAttributeName specifies whether the gateway tells the endpoint the public path prefix
+	// it strips from the requests using the X-Forwarded-Prefix header. The value is a boolean, true by default.
+	forwardedPrefixEndpointAttributeName = "forwardedPrefix"
+	// addPrefixEndpointAttributeName specifies the path prefix that the gateway adds to the requests after
+	// stripping the public path prefix. The value is an absolute path.
+	addPrefixEndpointAttributeName = "addPrefix"
+	// redirectsEndpointAttributeName specifies the redirects that the gateway responds with instead of forwarding
+	// the matching requests to the endpoint. The value is a list of objects with the "regex" matched against the full
+	// public URL of the request, the "replacement" URL and an optional boolean "permanent".
+	redirectsEndpointAttributeName = "redirects"
+
+	// corsEndpointAttributeName overrides the CORS policy of the Che manager for the endpoint. The value is an object
+	// with the same structure as the CORS policy in the Che manager. The cross-origin requests to the endpoint are not
+	// allowed if the object doesn't specify any allowed origins.
+	corsEndpointAttributeName = "cors"
+	// ipAllowListEndpointAttributeName narrows the IP allow list of the workspace for the endpoint. The value is
+	// a list of IP addresses or ranges. An empty list keeps the IP allow list of the workspace.
+	ipAllowListEndpointAttributeName = "ipAllowList"
+	// rateLimitEndpointAttributeName lowers the rate limit of the workspace for the endpoint. The value is an object
+	// with the average and burst. An average of 0 keeps the rate limit of the workspace.
+	rateLimitEndpointAttributeName = "rateLimit"
+
+	forwardedPrefixHeader = "X-Forwarded-Prefix"
+)
+
+type authMode string
+
+const (
+	// authModeNone means anyone who can reach the gateway can access the endpoint
+	authModeNone authMode = "none"
+	// authModeRequired means only authenticated users can access the endpoint. It is not supported yet.
+	authModeRequired authMode = "required"
+)
+
+// endpointAttributes are the attributes of an endpoint that influence how the endpoint is routed. Use
+// parseEndpointAttributes to read them from an endpoint.
+type endpointAttributes struct {
+	unique            bool
+	cookiePathRewrite bool
+	timeout           time.Duration
+	retries           int
+	circuitBreaker    string
+	headers           map[string]string
+	responseHeaders   map[string]string
+	forwardedPrefix   bool
+	addPrefix         string
+	redirects         []traefik.RedirectRegex
+	// cors is nil if the endpoint uses the CORS policy of the Che manager
+	cors *dwoche.CorsPolicy
+	// ipAllowList and rateLimit are nil if the endpoint doesn't narrow the access policy of the workspace
+	ipAllowList []string
+	rateLimit   *dwoche.RateLimit
+}
+
+// parseEndpointAttributes reads and validates the routing attributes of the endpoint. The returned error is
+// a solvers.RoutingInvalid describing the malformed attribute.
+func parseEndpointAttributes(endpoint dw.Endpoint) (endpointAttributes, error) {
+	ret := endpointAttributes{forwardedPrefix: true}
+	attrs := endpoint.Attributes
+
+	invalid := func(attr string, msg string, args ...interface{}) error {
+		return &solvers.RoutingInvalid{
+			Reason: fmt.Sprintf("invalid value of the attribute '%s' of the endpoint '%s': %s", attr, endpoint.Name, fmt.Sprintf(msg, args...)),
+		}
+	}
+
+	var err error
+	if attrs.Exists(uniqueEndpointAttributeName) {
+		if ret.unique = attrs.GetBoolean(uniqueEndpointAttributeName, &err); err != nil {
+			return endpointAttributes{}, invalid(uniqueEndpointAttributeName, "expected a boolean")
+		}
+	}
+
+	if attrs.Exists(cookiePathRewriteEndpointAttributeName) {
+		if ret.cookiePathRewrite = attrs.GetBoolean(cookiePathRewriteEndpointAttributeName, &err); err != nil {
+			return endpointAttributes{}, invalid(cookiePathRewriteEndpointAttributeName, "expected a boolean")
+		}
+	}
+
+	if attrs.Exists(timeoutEndpointAttributeName) {
+		if ret.timeout, err = parseTimeout(attrs.Get(timeoutEndpointAttributeName, nil)); err != nil {
+			return endpointAttributes{}, invalid(timeoutEndpointAttributeName, "%s", err)
+		}
+	}
+
+	if attrs.Exists(retriesEndpointAttributeName) {
+		retries := attrs.GetNumber(retriesEndpointAttributeName, &err)
+		if err != nil || retries < 1 || retries != float64(int(retries)) {
+			return endpointAttributes{}, invalid(retriesEndpointAttributeName, "expected a positive integer")
+		}
+		ret.retries = int(retries)
+	}
+
+	if attrs.Exists(circuitBreakerEndpointAttributeName) {
+		ret.circuitBreaker = strings.TrimSpace(attrs.GetString(circuitBreakerEndpointAttributeName, &err))
+		if err != nil || !traefik.IsValidCircuitBreakerExpression(ret.circuitBreaker) {
+			return endpointAttributes{}, invalid(circuitBreakerEndpointAttributeName, "expected a circuit breaker expression like 'NetworkErrorRatio() > 0.5'")
+		}
+	}
+
+	if attrs.Exists(authEndpointAttributeName) {
+		mode := authMode(attrs.GetString(authEndpointAttributeName, &err))
+		if err == nil && mode == authModeRequired {
+			return endpointAttributes{}, invalid(authEndpointAttributeName, "'%s' is not supported, the gateway cannot authenticate the users yet", authModeRequired)
+		}
+		if err != nil || mode != authModeNone {
+			return endpointAttributes{}, invalid(authEndpointAttributeName, "expected '%s'", authModeNone)
+		}
+	}
+
+	for _, attr := range []string{headersEndpointAttributeName, responseHeadersEndpointAttributeName} {
+		if !attrs.Exists(attr) {
+			continue
+		}
+		headers := map[string]string{}
+		if err = attrs.GetInto(attr, &headers); err != nil {
+			return endpointAttributes{}, invalid(attr, "expected an object with string values")
+		}
+		for name, value := range headers {
+			if !isValidHeaderName(name) {
+				return endpointAttributes{}, invalid(attr, "'%s' is not a valid header name", name)
+			}
+			if strings.ContainsAny(value, "\r\n") {
+				return endpointAttributes{}, invalid(attr, "the value of the header '%s' contains a line break", name)
+			}
+		}
+		if attr == headersEndpointAttributeName {
+			ret.headers = headers
+		} else {
+			ret.responseHeaders = headers
+		}
+	}
+
+	if attrs.Exists(forwardedPrefixEndpointAttributeName) {
+		if ret.forwardedPrefix = attrs.GetBoolean(forwardedPrefixEndpointAttributeName, &err); err != nil {
+			return endpointAttributes{}, invalid(forwardedPrefixEndpointAttributeName, "expected a boolean")
+		}
+		if !ret.forwardedPrefix {
+			for name := range ret.headers {
+				if strings.EqualFold(name, forwardedPrefixHeader) {
+					return endpointAttributes{}, invalid(forwardedPrefixEndpointAttributeName, "the %s header cannot be both removed and set using the '%s' attribute", forwardedPrefixHeader, headersEndpointAttributeName)
+				}
+			}
+		}
+	}
+
+	if attrs.Exists(addPrefixEndpointAttributeName) {
+		ret.addPrefix = attrs.GetString(addPrefixEndpointAttributeName, &err)
+		if err != nil || !strings.HasPrefix(ret.addPrefix, "/") || strings.HasSuffix(ret.addPrefix, "/") {
+			return endpointAttributes{}, invalid(addPrefixEndpointAttributeName, "expected an absolute path without the trailing slash")
+		}
+	}
+
+	if attrs.Exists(redirectsEndpointAttributeName) {
+		if err = attrs.GetInto(redirectsEndpointAttributeName, &ret.redirects); err != nil {
+			return endpointAttributes{}, invalid(redirectsEndpointAttributeName, "expected a list of objects with the regex and the replacement")
+		}
+		for i, r := range ret.redirects {
+			if _, err = regexp.Compile(r.Regex); err != nil || r.Regex == "" {
+				return endpointAttributes{}, invalid(redirectsEndpointAttributeName, "the regex of the redirect %d is not a valid regular expression", i)
+			}
+			if r.Replacement == "" {
+				return endpointAttributes{}, invalid(redirectsEndpointAttributeName, "the redirect %d has no replacement", i)
+			}
+		}
+	}
+
+	if attrs.Exists(corsEndpointAttributeName) {
+		ret.cors = &dwoche.CorsPolicy{}
+		if err = attrs.GetInto(corsEndpointAttributeName, ret.cors); err != nil {
+			return endpointAttributes{}, invalid(corsEndpointAttributeName, "expected an object with the allowedOrigins, allowedMethods, allowedHeaders, allowCredentials and maxAge")
+		}
+		if problem := validateCorsPolicy(ret.cors); problem != "" {
+			return endpointAttributes{}, invalid(corsEndpointAttributeName, "%s", problem)
+		}
+	}
+
+	if attrs.Exists(ipAllowListEndpointAttributeName) {
+		ret.ipAllowList = []string{}
+		if err = attrs.GetInto(ipAllowListEndpointAttributeName, &ret.ipAllowList); err != nil {
+			return endpointAttributes{}, invalid(ipAllowListEndpointAttributeName, "expected a list of IP addresses or ranges")
+		}
+		if problem := validateAccessPolicy(accessPolicy{ipAllowList: ret.ipAllowList}); problem != "" {
+			return endpointAttributes{}, invalid(ipAllowListEndpointAttributeName, "%s", problem)
+		}
+	}
+
+	if attrs.Exists(rateLimitEndpointAttributeName) {
+		ret.rateLimit = &dwoche.RateLimit{}
+		if err = attrs.GetInto(rateLimitEndpointAttributeName, ret.rateLimit); err != nil {
+			return endpointAttributes{}, invalid(rateLimitEndpointAttributeName, "expected an object with the average and burst")
+		}
+		if problem := validateAccessPolicy(accessPolicy{rateLimit: ret.rateLimit}); problem != "" {
+			return endpointAttributes{}, invalid(rateLimitEndpointAttributeName, "%s", problem)
+		}
+	}
+
+	return ret, nil
+}
+
+// validateCorsPolicy returns the description of the problem with the CORS policy or an empty string if the policy
+// is fine.
+func validateCorsPolicy(policy *dwoche.CorsPolicy) string {
+	for _, o := range policy.AllowedOrigins {
+		if o == "*" {
+			if policy.AllowCredentials {
+				return "the credentials cannot be allowed in the requests from any origin"
+			}
+			continue
+		}
+		if u, err := url.Parse(o); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || (u.Path != "" && u.Path != "/") || u.RawQuery != "" {
+			return fmt.Sprintf("'%s' is not a valid origin, expected a URL like 'https://che.example.com'", o)
+		}
+	}
+	for _, m := range policy.AllowedMethods {
+		if !isValidHeaderName(m) {
+			return fmt.Sprintf("'%s' is not a valid HTTP method", m)
+		}
+	}
+	for _, h := range policy.AllowedHeaders {
+		if !isValidHeaderName(h) {
+			return fmt.Sprintf("'%s' is not a valid header name", h)
+		}
+	}
+	if policy.MaxAge < 0 {
+		return "the max age cannot be negative"
+	}
+	return ""
+}
+
+// routingEquals returns true if the attributes require the same routing in the gateway. The endpoints that share
+// the same URL must be routed the same way.
+func (a endpointAttributes) routingEquals(other endpointAttributes) bool {
+	// the uniqueness determines the URL itself, not how it is routed
+	a.unique = false
+	other.unique = false
+	return reflect.DeepEqual(a, other)
+}
+
+// requestHeaders returns the headers the gateway needs to set on the requests to the endpoint.
+func (a endpointAttributes) requestHeaders() map[string]string {
+	if a.forwardedPrefix {
+		return a.headers
+	}
+
+	// the empty value makes the gateway remove the header set by the middleware stripping the prefix
+	ret := map[string]string{forwardedPrefixHeader: ""}
+	for k, v := range a.headers {
+		ret[k] = v
+	}
+	return ret
+}
+
+func parseTimeout(value interface{}) (time.Duration, error) {
+	var timeout time.Duration
+	switch v := value.(type) {
+	case float64:
+		timeout = time.Duration(v * float64(time.Second))
+	case string:
+		var err error
+		if timeout, err = time.ParseDuration(v); err != nil {
+			return 0, fmt.Errorf("expected a duration like '90s' or a number of seconds")
+		}
+	default:
+		return 0, fmt.Errorf("expected a duration like '90s' or a number of seconds")
+	}
+
+	if timeout <= 0 {
+		return 0, fmt.Errorf("the timeout must be positive")
+	}
+
+	return timeout, nil
+}
+
+// isValidHeaderName checks that the name is a token as defined by RFC 7230.
+func isValidHeaderName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, c := range name {
+		if c > 127 || !(('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z') || ('0' <= c && c <= '9') || strings.ContainsRune("!#$%&'*+-.^_`|~", c)) {
+			return false
+		}
+	}
+	return true
+}
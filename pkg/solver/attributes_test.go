@@ -0,0 +1,174 @@
+package solver
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/che-incubator/devworkspace-che-operator/apis/che-controller/v1alpha1"
+	dw "github.com/devfile/api/v2/pkg/apis/workspaces/v1alpha2"
+	"github.com/devfile/api/v2/pkg/attributes"
+	"github.com/devfile/devworkspace-operator/controllers/controller/workspacerouting/solvers"
+)
+
+func TestParsesEndpointAttributes(t *testing.T) {
+	var err error
+	attrs := attributes.Attributes{}.
+		PutString(uniqueEndpointAttributeName, "true").
+		PutBoolean(cookiePathRewriteEndpointAttributeName, true).
+		PutString(timeoutEndpointAttributeName, "90s").
+		PutInteger(retriesEndpointAttributeName, 3).
+		PutString(circuitBreakerEndpointAttributeName, "NetworkErrorRatio() > 0.5").
+		PutString(authEndpointAttributeName, string(authModeNone)).
+		Put(headersEndpointAttributeName, map[string]string{"X-Custom": "value"}, &err).
+		Put(responseHeadersEndpointAttributeName, map[string]string{"X-Frame-Options": "SAMEORIGIN"}, &err).
+		PutBoolean(forwardedPrefixEndpointAttributeName, false).
+		PutString(addPrefixEndpointAttributeName, "/app").
+		Put(redirectsEndpointAttributeName, []map[string]interface{}{{"regex": "^(.*)/old/(.*)$", "replacement": "${1}/new/${2}", "permanent": true}}, &err)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parsed, err := parseEndpointAttributes(dw.Endpoint{Name: "e", Attributes: attrs})
+	if err != nil {
+		t.Fatalf("Failed to parse the attributes: %s", err)
+	}
+
+	if !parsed.unique {
+		t.Errorf("The endpoint should have been unique")
+	}
+	if !parsed.cookiePathRewrite {
+		t.Errorf("The cookie path rewrite should have been enabled")
+	}
+	if parsed.timeout != 90*time.Second {
+		t.Errorf("Unexpected timeout %s", parsed.timeout)
+	}
+	if parsed.retries != 3 {
+		t.Errorf("Unexpected retries %d", parsed.retries)
+	}
+	if parsed.circuitBreaker != "NetworkErrorRatio() > 0.5" {
+		t.Errorf("Unexpected circuit breaker %s", parsed.circuitBreaker)
+	}
+	if parsed.headers["X-Custom"] != "value" {
+		t.Errorf("Unexpected headers %v", parsed.headers)
+	}
+	if parsed.responseHeaders["X-Frame-Options"] != "SAMEORIGIN" {
+		t.Errorf("Unexpected response headers %v", parsed.responseHeaders)
+	}
+	if parsed.forwardedPrefix {
+		t.Errorf("The forwarded prefix should have been disabled")
+	}
+	if h, ok := parsed.requestHeaders()[forwardedPrefixHeader]; !ok || h != "" {
+		t.Errorf("The forwarded prefix header should have been removed from the requests but the headers are %v", parsed.requestHeaders())
+	}
+	if parsed.addPrefix != "/app" {
+		t.Errorf("Unexpected prefix to add %s", parsed.addPrefix)
+	}
+	if len(parsed.redirects) != 1 || parsed.redirects[0].Replacement != "${1}/new/${2}" || !parsed.redirects[0].Permanent {
+		t.Errorf("Unexpected redirects %+v", parsed.redirects)
+	}
+
+	// the timeout can also be specified in seconds
+	parsed, err = parseEndpointAttributes(dw.Endpoint{Name: "e", Attributes: attributes.Attributes{}.PutInteger(timeoutEndpointAttributeName, 30)})
+	if err != nil {
+		t.Fatalf("Failed to parse the attributes: %s", err)
+	}
+	if parsed.timeout != 30*time.Second {
+		t.Errorf("Unexpected timeout %s", parsed.timeout)
+	}
+}
+
+func TestDefaultEndpointAttributes(t *testing.T) {
+	parsed, err := parseEndpointAttributes(dw.Endpoint{Name: "e"})
+	if err != nil {
+		t.Fatalf("Failed to parse the attributes: %s", err)
+	}
+
+	if parsed.unique || parsed.cookiePathRewrite || parsed.timeout != 0 || parsed.retries != 0 ||
+		parsed.circuitBreaker != "" || len(parsed.headers) != 0 || !parsed.forwardedPrefix ||
+		len(parsed.requestHeaders()) != 0 || parsed.addPrefix != "" || len(parsed.redirects) != 0 {
+		t.Errorf("Unexpected default attributes: %+v", parsed)
+	}
+}
+
+func TestRejectsMalformedEndpointAttributes(t *testing.T) {
+	var err error
+	tests := map[string]attributes.Attributes{
+		uniqueEndpointAttributeName:            attributes.Attributes{}.PutString(uniqueEndpointAttributeName, "yes"),
+		cookiePathRewriteEndpointAttributeName: attributes.Attributes{}.PutInteger(cookiePathRewriteEndpointAttributeName, 2),
+		timeoutEndpointAttributeName:           attributes.Attributes{}.PutString(timeoutEndpointAttributeName, "forever"),
+		retriesEndpointAttributeName:           attributes.Attributes{}.PutFloat(retriesEndpointAttributeName, 1.5),
+		circuitBreakerEndpointAttributeName:    attributes.Attributes{}.PutBoolean(circuitBreakerEndpointAttributeName, true),
+		authEndpointAttributeName:              attributes.Attributes{}.PutString(authEndpointAttributeName, "maybe"),
+		headersEndpointAttributeName:           attributes.Attributes{}.Put(headersEndpointAttributeName, map[string]string{"X Bad": "v"}, &err),
+		responseHeadersEndpointAttributeName:   attributes.Attributes{}.PutString(responseHeadersEndpointAttributeName, "X-A: b"),
+		forwardedPrefixEndpointAttributeName: attributes.Attributes{}.
+			PutBoolean(forwardedPrefixEndpointAttributeName, false).
+			Put(headersEndpointAttributeName, map[string]string{"x-forwarded-prefix": "/"}, &err),
+		addPrefixEndpointAttributeName:   attributes.Attributes{}.PutString(addPrefixEndpointAttributeName, "app/"),
+		redirectsEndpointAttributeName:   attributes.Attributes{}.Put(redirectsEndpointAttributeName, []map[string]string{{"regex": "(", "replacement": "/"}}, &err),
+		corsEndpointAttributeName:        attributes.Attributes{}.Put(corsEndpointAttributeName, map[string]interface{}{"allowedOrigins": []string{"*"}, "allowCredentials": true}, &err),
+		ipAllowListEndpointAttributeName: attributes.Attributes{}.Put(ipAllowListEndpointAttributeName, []string{"10.0.0.0/8", "somewhere"}, &err),
+		rateLimitEndpointAttributeName:   attributes.Attributes{}.Put(rateLimitEndpointAttributeName, map[string]int{"average": -1}, &err),
+	}
+
+	for attr, attrs := range tests {
+		t.Run(attr, func(t *testing.T) {
+			_, err := parseEndpointAttributes(dw.Endpoint{Name: "e", Attributes: attrs})
+
+			var invalid *solvers.RoutingInvalid
+			if !errors.As(err, &invalid) {
+				t.Fatalf("Expected the attributes to be invalid but got: %v", err)
+			}
+			if !strings.Contains(invalid.Reason, "'"+attr+"' of the endpoint 'e'") {
+				t.Errorf("The reason should mention the attribute and the endpoint but was: %s", invalid.Reason)
+			}
+		})
+	}
+
+	for _, timeout := range []attributes.Attributes{
+		attributes.Attributes{}.PutInteger(timeoutEndpointAttributeName, 0),
+		attributes.Attributes{}.PutString(timeoutEndpointAttributeName, "-1s"),
+		attributes.Attributes{}.PutBoolean(timeoutEndpointAttributeName, true),
+	} {
+		if _, err := parseEndpointAttributes(dw.Endpoint{Name: "e", Attributes: timeout}); err == nil {
+			t.Errorf("The timeout %s should have been rejected", timeout[timeoutEndpointAttributeName].Raw)
+		}
+	}
+
+	headers := attributes.Attributes{}.Put(headersEndpointAttributeName, map[string]string{"X-A": "a\r\nX-B: b"}, &err)
+	if _, err := parseEndpointAttributes(dw.Endpoint{Name: "e", Attributes: headers}); err == nil {
+		t.Errorf("The header value with a line break should have been rejected")
+	}
+}
+
+func TestValidatesCorsPolicy(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy v1alpha1.CorsPolicy
+		valid  bool
+	}{
+		{"origins", v1alpha1.CorsPolicy{AllowedOrigins: []string{"https://che.example.com", "http://localhost:3000/"}, AllowedMethods: []string{"GET"}, AllowCredentials: true}, true},
+		{"anyOrigin", v1alpha1.CorsPolicy{AllowedOrigins: []string{"*"}, AllowedHeaders: []string{"Authorization"}}, true},
+		{"noOrigins", v1alpha1.CorsPolicy{}, true},
+		{"credentialsFromAnyOrigin", v1alpha1.CorsPolicy{AllowedOrigins: []string{"*"}, AllowCredentials: true}, false},
+		{"originWithPath", v1alpha1.CorsPolicy{AllowedOrigins: []string{"https://che.example.com/dashboard"}}, false},
+		{"originWithoutScheme", v1alpha1.CorsPolicy{AllowedOrigins: []string{"che.example.com"}}, false},
+		{"invalidMethod", v1alpha1.CorsPolicy{AllowedOrigins: []string{"*"}, AllowedMethods: []string{"GET POST"}}, false},
+		{"invalidHeader", v1alpha1.CorsPolicy{AllowedOrigins: []string{"*"}, AllowedHeaders: []string{"X:Y"}}, false},
+		{"negativeMaxAge", v1alpha1.CorsPolicy{AllowedOrigins: []string{"*"}, MaxAge: -1}, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			problem := validateCorsPolicy(&test.policy)
+			if test.valid && problem != "" {
+				t.Errorf("The policy should have been valid but: %s", problem)
+			}
+			if !test.valid && problem == "" {
+				t.Errorf("The policy should have been invalid")
+			}
+		})
+	}
+}
@@ -0,0 +1,132 @@
+//
+// Copyright (c) 2019-2021 Red Hat, Inc.
+// This program and the accompanying materials are made
+// available under the terms of the Eclipse Public License 2.0
+// which is available at https://www.eclipse.org/legal/epl-2.0/
+//
+// SPDX-License-Identifier: EPL-2.0
+//
+// Contributors:
+//   Red Hat, Inc. - initial API and implementation
+//
+
+package solver
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"strings"
+
+	dwoche "github.com/che-incubator/devworkspace-che-operator/apis/che-controller/v1alpha1"
+	"github.com/che-incubator/devworkspace-che-operator/pkg/defaults"
+	"github.com/che-incubator/devworkspace-che-operator/pkg/sync"
+	dwo "github.com/devfile/devworkspace-operator/apis/controller/v1alpha1"
+	"github.com/devfile/devworkspace-operator/controllers/controller/workspacerouting/solvers"
+	"github.com/devfile/devworkspace-operator/pkg/config"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// ClaimAnnotation is the annotation of the claim config maps with the claimed path or host
+	ClaimAnnotation = "che.routing.controller.devfile.io/claim"
+
+	claimComponent = "workspace-claim"
+
+	pathClaimKind = "path"
+	hostClaimKind = "host"
+)
+
+// workspaceClaim is a path or a host that only a single workspace can be exposed on
+type workspaceClaim struct {
+	// key identifies the claimed path or host
+	key string
+	// description describes the claimed path or host in the error messages
+	description string
+}
+
+// claimsOf returns the claims the workspace needs to make before it is exposed on the gateway. The paths of
+// the default path scheme are derived from the unique workspace ID, so only the paths of the workspace-name scheme
+// need to be claimed. All of them share the base path of the workspace, so claiming it is enough. The custom hosts
+// are always claimed, because only a single workspace can be exposed on a host.
+func claimsOf(paths workspacePaths, hosts customHosts) []workspaceClaim {
+	var claims []workspaceClaim
+
+	if paths.scheme == dwoche.WorkspaceNamePathScheme {
+		claims = append(claims, workspaceClaim{key: pathClaimKind + ":" + paths.base(), description: "path " + paths.base()})
+	}
+
+	for _, h := range hosts.hosts {
+		claims = append(claims, workspaceClaim{key: hostClaimKind + ":" + h, description: "custom host " + h})
+	}
+
+	return claims
+}
+
+// claim atomically claims the paths and hosts of the workspace. Each claim is a config map with the name derived
+// from the claimed value in the namespace of the Che manager. The config map is only ever created and never updated,
+// so the first workspace that creates it owns the claim, even if several workspaces are reconciled concurrently.
+// The claims are owned by the routing, so they are pruned when no longer needed, deleted on finalization and swept
+// when the routing is deleted.
+func (c *CheRoutingSolver) claim(syncer *sync.Syncer, cheManager *dwoche.CheManager, routing *dwo.WorkspaceRouting, claims []workspaceClaim) error {
+	for _, claim := range claims {
+		labels := defaults.GetLabelsForComponent(cheManager, claimComponent)
+		labels[config.WorkspaceIDLabel] = routing.Spec.WorkspaceId
+
+		cm := &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      getClaimConfigMapName(cheManager, claim.key),
+				Namespace: cheManager.Namespace,
+				Labels:    labels,
+				Annotations: map[string]string{
+					ClaimAnnotation: claim.key,
+					defaults.ConfigAnnotationWorkspaceRoutingName:      routing.Name,
+					defaults.ConfigAnnotationWorkspaceRoutingNamespace: routing.Namespace,
+				},
+			},
+		}
+
+		_, obj, err := syncer.Create(context.TODO(), routing, cm)
+		if err != nil {
+			return err
+		}
+
+		owner := obj.(metav1.Object).GetLabels()[config.WorkspaceIDLabel]
+		if owner != routing.Spec.WorkspaceId {
+			return &solvers.RoutingInvalid{
+				Reason: fmt.Sprintf("the %s is already used by the workspace %s", claim.description, owner),
+			}
+		}
+	}
+
+	return nil
+}
+
+// hasHostClaims returns true if the workspace has claimed any custom hosts. The claims are config maps, which are
+// watched by the solver anyway, so reading them doesn't cache anything new.
+func (c *CheRoutingSolver) hasHostClaims(ctx context.Context, cheManager *dwoche.CheManager, routing *dwo.WorkspaceRouting) (bool, error) {
+	labels := defaults.GetLabelsForComponent(cheManager, claimComponent)
+	labels[config.WorkspaceIDLabel] = routing.Spec.WorkspaceId
+
+	claims := &corev1.ConfigMapList{}
+	if err := c.client.List(ctx, claims, client.InNamespace(cheManager.Namespace), client.MatchingLabels(labels)); err != nil {
+		return false, err
+	}
+
+	for _, cm := range claims.Items {
+		if strings.HasPrefix(cm.Annotations[ClaimAnnotation], hostClaimKind+":") {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// getClaimConfigMapName returns the name of the config map of the claim. The claimed paths and hosts are hashed,
+// because they can contain characters not allowed in the names.
+func getClaimConfigMapName(cheManager *dwoche.CheManager, key string) string {
+	hash := sha256.Sum256([]byte(key))
+	return fmt.Sprintf("%s-claim-%x", cheManager.Name, hash[:16])
+}
@@ -0,0 +1,50 @@
+//
+// Copyright (c) 2019-2021 Red Hat, Inc.
+// This program and the accompanying materials are made
+// available under the terms of the Eclipse Public License 2.0
+// which is available at https://www.eclipse.org/legal/epl-2.0/
+//
+// SPDX-License-Identifier: EPL-2.0
+//
+// Contributors:
+//   Red Hat, Inc. - initial API and implementation
+//
+
+package solver
+
+import (
+	"fmt"
+
+	"github.com/che-incubator/devworkspace-che-operator/pkg/cookierewrite"
+	"github.com/che-incubator/devworkspace-che-operator/pkg/gateway"
+	"github.com/che-incubator/devworkspace-che-operator/pkg/traefik"
+)
+
+// routeThroughCookieRewriter makes the service of the endpoint forward the requests to the cookie rewriting sidecar
+// of the gateway instead of the endpoint itself. The returned middleware tells the sidecar the original URL of
+// the endpoint and the prefixes to rewrite the paths of the cookies with. It needs to be used by the routers of
+// the endpoint.
+func routeThroughCookieRewriter(name string, prefix string, attrs endpointAttributes, service *traefik.Service) namedMiddleware {
+	headers := map[string]string{
+		cookierewrite.BackendHeader:      service.LoadBalancer.Servers[0].URL,
+		cookierewrite.PublicPrefixHeader: prefix,
+	}
+	if attrs.addPrefix != "" {
+		headers[cookierewrite.BackendPrefixHeader] = attrs.addPrefix
+	}
+
+	service.LoadBalancer.Servers = []traefik.Server{
+		{
+			URL: fmt.Sprintf("http://127.0.0.1:%d", gateway.GatewayCookieRewritePort),
+		},
+	}
+
+	return namedMiddleware{
+		name: name + "-cookie-rewrite",
+		middleware: traefik.Middleware{
+			Headers: &traefik.Headers{
+				CustomRequestHeaders: headers,
+			},
+		},
+	}
+}
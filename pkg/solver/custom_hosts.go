@@ -0,0 +1,341 @@
+//
+// Copyright (c) 2019-2021 Red Hat, Inc.
+// This program and the accompanying materials are made
+// available under the terms of the Eclipse Public License 2.0
+// which is available at https://www.eclipse.org/legal/epl-2.0/
+//
+// SPDX-License-Identifier: EPL-2.0
+//
+// Contributors:
+//   Red Hat, Inc. - initial API and implementation
+//
+
+package solver
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	dwoche "github.com/che-incubator/devworkspace-che-operator/apis/che-controller/v1alpha1"
+	"github.com/che-incubator/devworkspace-che-operator/pkg/defaults"
+	"github.com/che-incubator/devworkspace-che-operator/pkg/gateway"
+	"github.com/che-incubator/devworkspace-che-operator/pkg/infrastructure"
+	"github.com/che-incubator/devworkspace-che-operator/pkg/sync"
+	dwo "github.com/devfile/devworkspace-operator/apis/controller/v1alpha1"
+	"github.com/devfile/devworkspace-operator/controllers/controller/workspacerouting/solvers"
+	"github.com/devfile/devworkspace-operator/pkg/config"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	routev1 "github.com/openshift/api/route/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/api/extensions/v1beta1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// CustomHostsAnnotation is the annotation of the workspace routing with the comma-separated list of additional
+	// hosts on which the endpoints of the workspace are exposed. When put on the DevWorkspace, it is copied to
+	// the workspace routing by the DevWorkspace operator.
+	CustomHostsAnnotation = "che.routing.controller.devfile.io/custom-hosts"
+
+	// CustomHostsTLSSecretAnnotation is the annotation of the workspace routing with the name of the TLS secret with
+	// the certificate of the custom hosts. The secret is read from the namespace of the workspace, unless the Che
+	// manager shares a secret with the name in its own namespace.
+	CustomHostsTLSSecretAnnotation = "che.routing.controller.devfile.io/custom-hosts-tls-secret"
+
+	// CustomHostURLsAttribute is the attribute of the exposed endpoints with the list of the URLs of the endpoint on
+	// the custom hosts.
+	CustomHostURLsAttribute = "customHostURLs"
+
+	customHostsComponent = "workspace-custom-hosts"
+)
+
+var (
+	customHostsIngressDiffOpts = cmp.Options{
+		cmpopts.IgnoreFields(v1beta1.Ingress{}, "TypeMeta", "ObjectMeta", "Status"),
+	}
+
+	customHostsTLSSecretDiffOpts = cmp.Options{
+		cmpopts.IgnoreFields(corev1.Secret{}, "TypeMeta", "ObjectMeta"),
+	}
+
+	customHostsRouteDiffOpts = cmp.Options{
+		cmpopts.IgnoreFields(routev1.Route{}, "TypeMeta", "ObjectMeta", "Status"),
+		cmpopts.IgnoreFields(routev1.RouteSpec{}, "WildcardPolicy"),
+		cmpopts.IgnoreFields(routev1.RouteTargetReference{}, "Weight"),
+	}
+)
+
+// customHosts are the additional hosts on which the workspace endpoints are exposed.
+type customHosts struct {
+	hosts []string
+	// tlsSecret is the key of the TLS secret with the certificate of the hosts, if any
+	tlsSecret client.ObjectKey
+}
+
+// customHostsOf reads the custom hosts from the annotations of an object in the provided namespace of
+// the workspace. The returned error is a solvers.RoutingInvalid describing the malformed hosts or the hosts not
+// allowed by the Che manager.
+func customHostsOf(cheManager *dwoche.CheManager, namespace string, annotations map[string]string) (customHosts, error) {
+	ret := customHosts{}
+
+	seen := map[string]bool{}
+	for _, h := range strings.Split(annotations[CustomHostsAnnotation], ",") {
+		h = strings.ToLower(strings.TrimSpace(h))
+		if h == "" || seen[h] {
+			continue
+		}
+
+		if errs := validation.IsDNS1123Subdomain(h); len(errs) > 0 {
+			return customHosts{}, &solvers.RoutingInvalid{
+				Reason: fmt.Sprintf("the custom host '%s' is not a valid host name: %s", h, strings.Join(errs, ", ")),
+			}
+		}
+
+		if problem := checkCustomHostAllowed(cheManager, h); problem != "" {
+			return customHosts{}, &solvers.RoutingInvalid{
+				Reason: fmt.Sprintf("the custom host '%s' is not allowed: %s", h, problem),
+			}
+		}
+
+		seen[h] = true
+		ret.hosts = append(ret.hosts, h)
+	}
+
+	sort.Strings(ret.hosts)
+
+	if name := annotations[CustomHostsTLSSecretAnnotation]; name != "" {
+		// the workspaces can only use their own secrets or the secrets the admin shares with them
+		ret.tlsSecret = client.ObjectKey{Name: name, Namespace: namespace}
+		for _, shared := range cheManager.Spec.CustomHosts.SharedTLSSecrets {
+			if shared == name {
+				ret.tlsSecret.Namespace = cheManager.Namespace
+				break
+			}
+		}
+	}
+
+	return ret, nil
+}
+
+// checkCustomHostAllowed checks that the Che manager allows the custom host. Returns the description of the problem
+// or an empty string if the host is allowed.
+func checkCustomHostAllowed(cheManager *dwoche.CheManager, host string) string {
+	if host == strings.ToLower(cheManager.Spec.Host) || host == strings.ToLower(cheManager.Status.GatewayHost) {
+		return "it is the host of the Che gateway"
+	}
+
+	for _, d := range cheManager.Spec.CustomHosts.AllowedDomains {
+		d = strings.TrimPrefix(strings.ToLower(strings.TrimSpace(d)), ".")
+		if d != "" && (host == d || strings.HasSuffix(host, "."+d)) {
+			return ""
+		}
+	}
+
+	if len(cheManager.Spec.CustomHosts.AllowedDomains) == 0 {
+		return "the Che manager doesn't allow any custom hosts"
+	}
+
+	return fmt.Sprintf("it is not in any of the domains allowed by the Che manager (%s)", strings.Join(cheManager.Spec.CustomHosts.AllowedDomains, ", "))
+}
+
+// sharedTLSSecret returns true if the TLS secret of the hosts is shared by the Che manager rather than owned by
+// the workspace.
+func (h customHosts) sharedTLSSecret(cheManager *dwoche.CheManager) bool {
+	return h.tlsSecret.Namespace == cheManager.Namespace
+}
+
+// scheme returns the URL scheme on which the custom hosts are accessible. The routes always terminate TLS while
+// the ingress only does if it has the TLS secret.
+func (h customHosts) scheme() string {
+	if h.tlsSecret.Name != "" || infrastructure.Current.Type == infrastructure.OpenShift {
+		return "https"
+	}
+	return "http"
+}
+
+// rule returns the traefik rule matching the requests to the custom hosts with the path prefix.
+func (h customHosts) rule(prefix string) string {
+	quoted := make([]string, len(h.hosts))
+	for i, host := range h.hosts {
+		quoted[i] = "`" + host + "`"
+	}
+	return fmt.Sprintf("Host(%s) && PathPrefix(`%s`)", strings.Join(quoted, ","), prefix)
+}
+
+// syncCustomHostsAccess makes the gateway accessible on the custom hosts of the workspace. Like the gateway
+// config maps, the ingress or routes are created in the namespace of the Che manager, because they need to point
+// to the gateway service. The objects that are no longer needed are only looked for if the workspace has or had
+// some custom hosts, so that the workspaces without them don't need to list the secrets, ingresses or routes.
+func (c *CheRoutingSolver) syncCustomHostsAccess(syncer sync.Syncer, cheManager *dwoche.CheManager, routing *dwo.WorkspaceRouting, hosts customHosts, hadCustomHosts bool) error {
+	ctx := context.TODO()
+
+	if len(hosts.hosts) == 0 && !hadCustomHosts {
+		return nil
+	}
+
+	if infrastructure.Current.Type == infrastructure.OpenShift {
+		routes, err := c.getCustomHostsRoutes(ctx, cheManager, routing, hosts)
+		if err != nil {
+			return err
+		}
+		for _, r := range routes {
+			if _, _, err := syncer.Sync(ctx, routing, r, customHostsRouteDiffOpts); err != nil {
+				return err
+			}
+		}
+		return syncer.PruneInNamespace(ctx, routing, cheManager.Namespace, &routev1.RouteList{})
+	}
+
+	if len(hosts.hosts) > 0 {
+		// the ingress can only reference the secrets in its own namespace, so the secret of the workspace is copied
+		// next to it
+		tlsSecretName := hosts.tlsSecret.Name
+		if tlsSecretName != "" && !hosts.sharedTLSSecret(cheManager) {
+			secret, err := c.getCustomHostsTLSSecret(ctx, hosts)
+			if err != nil {
+				return err
+			}
+			tlsCopy := getCustomHostsTLSSecretCopy(cheManager, routing, secret)
+			if _, _, err := syncer.Sync(ctx, routing, tlsCopy, customHostsTLSSecretDiffOpts); err != nil {
+				return err
+			}
+			tlsSecretName = tlsCopy.Name
+		}
+
+		if _, _, err := syncer.Sync(ctx, routing, getCustomHostsIngress(cheManager, routing, hosts, tlsSecretName), customHostsIngressDiffOpts); err != nil {
+			return err
+		}
+	}
+	return syncer.PruneInNamespace(ctx, routing, cheManager.Namespace, &v1beta1.IngressList{}, &corev1.SecretList{})
+}
+
+// getCustomHostsTLSSecret reads the TLS secret of the custom hosts. The routing is not ready until the secret exists.
+func (c *CheRoutingSolver) getCustomHostsTLSSecret(ctx context.Context, hosts customHosts) (*corev1.Secret, error) {
+	secret := &corev1.Secret{}
+	if err := c.reader.Get(ctx, hosts.tlsSecret, secret); err != nil {
+		if errors.IsNotFound(err) {
+			logger.Info("The TLS secret of the custom hosts doesn't exist yet", "secret", hosts.tlsSecret.Name, "namespace", hosts.tlsSecret.Namespace)
+			return nil, &solvers.RoutingNotReady{Retry: 10 * time.Second}
+		}
+		return nil, err
+	}
+	return secret, nil
+}
+
+// getCustomHostsTLSSecretCopy returns the copy of the TLS secret of the workspace in the namespace of the Che manager.
+// Only the certificate and the key are copied.
+func getCustomHostsTLSSecretCopy(cheManager *dwoche.CheManager, routing *dwo.WorkspaceRouting, secret *corev1.Secret) *corev1.Secret {
+	return &corev1.Secret{
+		ObjectMeta: customHostsObjectMeta(cheManager, routing, routing.Spec.WorkspaceId+"-custom-hosts-tls"),
+		Type:       corev1.SecretTypeTLS,
+		Data: map[string][]byte{
+			corev1.TLSCertKey:       secret.Data[corev1.TLSCertKey],
+			corev1.TLSPrivateKeyKey: secret.Data[corev1.TLSPrivateKeyKey],
+		},
+	}
+}
+
+func customHostsObjectMeta(cheManager *dwoche.CheManager, routing *dwo.WorkspaceRouting, name string) metav1.ObjectMeta {
+	labels := defaults.GetLabelsForComponent(cheManager, customHostsComponent)
+	labels[config.WorkspaceIDLabel] = routing.Spec.WorkspaceId
+
+	return metav1.ObjectMeta{
+		Name:      name,
+		Namespace: cheManager.Namespace,
+		Labels:    labels,
+		Annotations: map[string]string{
+			defaults.ConfigAnnotationWorkspaceRoutingName:      routing.Name,
+			defaults.ConfigAnnotationWorkspaceRoutingNamespace: routing.Namespace,
+		},
+	}
+}
+
+func getCustomHostsIngress(cheManager *dwoche.CheManager, routing *dwo.WorkspaceRouting, hosts customHosts, tlsSecretName string) *v1beta1.Ingress {
+	pathType := v1beta1.PathTypeImplementationSpecific
+
+	ingress := &v1beta1.Ingress{
+		ObjectMeta: customHostsObjectMeta(cheManager, routing, routing.Spec.WorkspaceId+"-custom-hosts"),
+	}
+	ingress.Annotations["kubernetes.io/ingress.class"] = "nginx"
+	// the same timeouts as on the main ingress of the gateway, so that the endpoint timeouts apply the same way
+	ingress.Annotations["nginx.ingress.kubernetes.io/proxy-read-timeout"] = "3600"
+	ingress.Annotations["nginx.ingress.kubernetes.io/proxy-connect-timeout"] = "3600"
+
+	for _, h := range hosts.hosts {
+		ingress.Spec.Rules = append(ingress.Spec.Rules, v1beta1.IngressRule{
+			Host: h,
+			IngressRuleValue: v1beta1.IngressRuleValue{
+				HTTP: &v1beta1.HTTPIngressRuleValue{
+					Paths: []v1beta1.HTTPIngressPath{
+						{
+							Path:     "/",
+							PathType: &pathType,
+							Backend: v1beta1.IngressBackend{
+								ServiceName: gateway.GetGatewayServiceName(cheManager),
+								ServicePort: intstr.FromInt(gateway.GatewayPort),
+							},
+						},
+					},
+				},
+			},
+		})
+	}
+
+	if tlsSecretName != "" {
+		ingress.Spec.TLS = []v1beta1.IngressTLS{
+			{
+				Hosts:      hosts.hosts,
+				SecretName: tlsSecretName,
+			},
+		}
+	}
+
+	return ingress
+}
+
+// getCustomHostsRoutes returns the routes of the custom hosts. The routes cannot reference the TLS secret, so its
+// certificate and key are copied to the routes. This exposes the key to anyone who can read the routes in the
+// namespace of the Che manager, which is documented in the README.
+func (c *CheRoutingSolver) getCustomHostsRoutes(ctx context.Context, cheManager *dwoche.CheManager, routing *dwo.WorkspaceRouting, hosts customHosts) ([]*routev1.Route, error) {
+	tls := &routev1.TLSConfig{
+		InsecureEdgeTerminationPolicy: routev1.InsecureEdgeTerminationPolicyRedirect,
+		Termination:                   routev1.TLSTerminationEdge,
+	}
+
+	if hosts.tlsSecret.Name != "" && len(hosts.hosts) > 0 {
+		secret, err := c.getCustomHostsTLSSecret(ctx, hosts)
+		if err != nil {
+			return nil, err
+		}
+		tls.Certificate = string(secret.Data[corev1.TLSCertKey])
+		tls.Key = string(secret.Data[corev1.TLSPrivateKeyKey])
+	}
+
+	var routes []*routev1.Route
+	for i, h := range hosts.hosts {
+		routes = append(routes, &routev1.Route{
+			ObjectMeta: customHostsObjectMeta(cheManager, routing, fmt.Sprintf("%s-custom-host-%d", routing.Spec.WorkspaceId, i)),
+			Spec: routev1.RouteSpec{
+				Host: h,
+				To: routev1.RouteTargetReference{
+					Kind: "Service",
+					Name: gateway.GetGatewayServiceName(cheManager),
+				},
+				Port: &routev1.RoutePort{
+					TargetPort: intstr.FromInt(gateway.GatewayPort),
+				},
+				TLS: tls,
+			},
+		})
+	}
+
+	return routes, nil
+}
@@ -0,0 +1,106 @@
+//
+// Copyright (c) 2019-2021 Red Hat, Inc.
+// This program and the accompanying materials are made
+// available under the terms of the Eclipse Public License 2.0
+// which is available at https://www.eclipse.org/legal/epl-2.0/
+//
+// SPDX-License-Identifier: EPL-2.0
+//
+// Contributors:
+//   Red Hat, Inc. - initial API and implementation
+//
+
+package solver
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/che-incubator/devworkspace-che-operator/pkg/gateway"
+	"github.com/che-incubator/devworkspace-che-operator/pkg/traefik"
+)
+
+const (
+	// errorPagesPriority is the priority of the router that shows the error page for the paths of the workspace that
+	// don't belong to any of its endpoints. It is lower than the priority of the endpoint routers, but higher than
+	// the default priorities of the catch-all routers like PathPrefix(`/`) that other components may configure.
+	errorPagesPriority = 50
+)
+
+var (
+	// errorPagesStatus are the statuses of the endpoint responses that are replaced by the error pages. The gateway
+	// responds with these itself when the workspace pod cannot be reached, e.g. because it's not running.
+	errorPagesStatus = []string{"502", "503", "504"}
+)
+
+// errorsMiddlewareName returns the name of the middleware that shows the error pages instead of the failed responses
+// of the workspace endpoints.
+func errorsMiddlewareName(workspaceID string) string {
+	return workspaceID + "-errors"
+}
+
+// addErrorPages configures the gateway to show the status of the workspace, as described by the error pages sidecar
+// of the gateway, when the endpoints of the workspace cannot be reached or when there's no endpoint on the path.
+// The access policy of the workspace applies to the error pages too. The endpoint routers need to use the middleware
+// named by errorsMiddlewareName.
+func addErrorPages(gw *gatewayConfig, workspaceID string, paths workspacePaths, access accessPolicy) error {
+	const owner = "error pages of the workspace"
+
+	name := workspaceID + "-error-pages"
+	notFoundName := workspaceID + "-not-found"
+
+	service := traefik.Service{
+		LoadBalancer: traefik.LoadBalancer{
+			Servers: []traefik.Server{
+				{
+					URL: fmt.Sprintf("http://127.0.0.1:%d", gateway.GatewayErrorPagesPort),
+				},
+			},
+		},
+	}
+
+	// the error pages sidecar finds the workspace by the path of the request
+	pagePrefix := fmt.Sprintf("/%s/%s/", paths.namespace, paths.workspaceName)
+
+	routerMiddlewares := append(access.middlewares(name), namedMiddleware{
+		name: notFoundName,
+		middleware: traefik.Middleware{
+			ReplacePath: &traefik.ReplacePath{Path: fmt.Sprintf("%s%d", pagePrefix, http.StatusNotFound)},
+		},
+	})
+
+	var middlewareNames []string
+	for _, m := range routerMiddlewares {
+		middlewareNames = append(middlewareNames, m.name)
+	}
+
+	if err := gw.addRouter(name, owner, traefik.Router{
+		Rule:        fmt.Sprintf("PathPrefix(`%s`)", paths.base()),
+		Service:     name,
+		Middlewares: middlewareNames,
+		Priority:    errorPagesPriority,
+	}, service); err != nil {
+		return err
+	}
+
+	// the errors middleware is used by the endpoint routers and replaces their failed responses with the pages
+	// from the same service
+	errors := namedMiddleware{
+		name: errorsMiddlewareName(workspaceID),
+		middleware: traefik.Middleware{
+			Errors: &traefik.Errors{
+				Status:  errorPagesStatus,
+				Service: name,
+				Query:   pagePrefix + "{status}",
+			},
+		},
+	}
+
+	for _, m := range append(routerMiddlewares, errors) {
+		if err := gw.addMiddleware(m.name, owner, m.middleware); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,95 @@
+//
+// Copyright (c) 2019-2021 Red Hat, Inc.
+// This program and the accompanying materials are made
+// available under the terms of the Eclipse Public License 2.0
+// which is available at https://www.eclipse.org/legal/epl-2.0/
+//
+// SPDX-License-Identifier: EPL-2.0
+//
+// Contributors:
+//   Red Hat, Inc. - initial API and implementation
+//
+
+package solver
+
+import (
+	"fmt"
+
+	"github.com/che-incubator/devworkspace-che-operator/pkg/traefik"
+	"github.com/devfile/devworkspace-operator/controllers/controller/workspacerouting/solvers"
+)
+
+// gatewayConfig collects the gateway configuration of a workspace and makes sure that the configuration of the
+// individual endpoints doesn't collide. Every router, middleware, servers transport and public path is recorded together with
+// the description of the endpoint it is configured for, so that the collisions can be reported precisely.
+type gatewayConfig struct {
+	config traefik.Config
+
+	routerOwners     map[string]string
+	middlewareOwners map[string]string
+	transportOwners  map[string]string
+	pathOwners       map[string]string
+}
+
+func newGatewayConfig() *gatewayConfig {
+	return &gatewayConfig{
+		config: traefik.Config{
+			HTTP: traefik.HTTP{
+				Routers:           map[string]traefik.Router{},
+				Services:          map[string]traefik.Service{},
+				Middlewares:       map[string]traefik.Middleware{},
+				ServersTransports: map[string]traefik.ServersTransport{},
+			},
+		},
+		routerOwners:     map[string]string{},
+		middlewareOwners: map[string]string{},
+		transportOwners:  map[string]string{},
+		pathOwners:       map[string]string{},
+	}
+}
+
+// claimPath records that the endpoint is exposed on the path.
+func (g *gatewayConfig) claimPath(path string, owner string) error {
+	if other, ok := g.pathOwners[path]; ok {
+		return &solvers.RoutingInvalid{
+			Reason: fmt.Sprintf("the %s and the %s would both be exposed on the path %s", other, owner, path),
+		}
+	}
+	g.pathOwners[path] = owner
+	return nil
+}
+
+// addRouter adds the router together with the service of the same name.
+func (g *gatewayConfig) addRouter(name string, owner string, router traefik.Router, service traefik.Service) error {
+	if other, ok := g.routerOwners[name]; ok {
+		return &solvers.RoutingInvalid{
+			Reason: fmt.Sprintf("the %s and the %s would both be configured in the gateway as '%s'", other, owner, name),
+		}
+	}
+	g.routerOwners[name] = owner
+	g.config.HTTP.Routers[name] = router
+	g.config.HTTP.Services[name] = service
+	return nil
+}
+
+func (g *gatewayConfig) addMiddleware(name string, owner string, middleware traefik.Middleware) error {
+	if other, ok := g.middlewareOwners[name]; ok {
+		return &solvers.RoutingInvalid{
+			Reason: fmt.Sprintf("the middleware '%s' would be configured in the gateway for both the %s and the %s", name, other, owner),
+		}
+	}
+	g.middlewareOwners[name] = owner
+	g.config.HTTP.Middlewares[name] = middleware
+	return nil
+}
+
+func (g *gatewayConfig) addServersTransport(name string, owner string, transport traefik.ServersTransport) error {
+	if other, ok := g.transportOwners[name]; ok {
+		return &solvers.RoutingInvalid{
+			Reason: fmt.Sprintf("the servers transport '%s' would be configured in the gateway for both the %s and the %s", name, other, owner),
+		}
+	}
+	g.transportOwners[name] = owner
+	g.config.HTTP.ServersTransports[name] = transport
+	return nil
+}
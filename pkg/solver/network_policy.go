@@ -0,0 +1,130 @@
+//
+// Copyright (c) 2019-2021 Red Hat, Inc.
+// This program and the accompanying materials are made
+// available under the terms of the Eclipse Public License 2.0
+// which is available at https://www.eclipse.org/legal/epl-2.0/
+//
+// SPDX-License-Identifier: EPL-2.0
+//
+// Contributors:
+//   Red Hat, Inc. - initial API and implementation
+//
+
+package solver
+
+import (
+	"context"
+	"sort"
+
+	dwoche "github.com/che-incubator/devworkspace-che-operator/apis/che-controller/v1alpha1"
+	"github.com/che-incubator/devworkspace-che-operator/pkg/defaults"
+	"github.com/che-incubator/devworkspace-che-operator/pkg/gateway"
+	"github.com/che-incubator/devworkspace-che-operator/pkg/sync"
+	dw "github.com/devfile/api/v2/pkg/apis/workspaces/v1alpha2"
+	dwo "github.com/devfile/devworkspace-operator/apis/controller/v1alpha1"
+	"github.com/devfile/devworkspace-operator/pkg/config"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+const (
+	networkPolicyComponent = "network-policy"
+)
+
+var (
+	networkPolicyDiffOpts = cmpopts.IgnoreFields(networkingv1.NetworkPolicy{}, "TypeMeta", "ObjectMeta")
+)
+
+// syncWorkspaceNetworkPolicy makes sure that the public endpoints of the workspace can only be reached through
+// the gateway, if the manager isolates the workspaces. Unlike the gateway config maps, the network policy needs to
+// live in the namespace of the workspace, so it is owned by the routing the usual way. The policy is pruned even if
+// the workspaces are not isolated, because they might have been before. The syncer of the solver reads through
+// the API reader, so this doesn't cache all the network policies in the cluster.
+func (c *CheRoutingSolver) syncWorkspaceNetworkPolicy(syncer sync.Syncer, cheManager *dwoche.CheManager, routing *dwo.WorkspaceRouting) error {
+	ctx := context.TODO()
+
+	if cheManager.Spec.Gateway.IsolateWorkspaces {
+		if networkPolicy := getWorkspaceNetworkPolicy(cheManager, routing); networkPolicy != nil {
+			if _, _, err := syncer.Sync(ctx, routing, networkPolicy, networkPolicyDiffOpts); err != nil {
+				return err
+			}
+		}
+	}
+
+	return syncer.Prune(ctx, routing, &networkingv1.NetworkPolicyList{})
+}
+
+// getWorkspaceNetworkPolicy returns the network policy that only lets the gateway reach the public endpoints of
+// the workspace pods. The internal endpoints are meant to be reached directly from within the cluster, so they're
+// left open, unless they share the port with a public endpoint, which would make the public endpoint reachable
+// without the gateway. The policy lists no other ports, so the ports of the endpoints with no exposure are only
+// reachable from within the pods, as intended. Nil is returned if the workspace has no public endpoints, because
+// there's nothing to protect.
+func getWorkspaceNetworkPolicy(cheManager *dwoche.CheManager, routing *dwo.WorkspaceRouting) *networkingv1.NetworkPolicy {
+	publicPorts := endpointPortsWithExposure(routing.Spec.Endpoints, dw.PublicEndpointExposure, nil)
+	if len(publicPorts) == 0 {
+		return nil
+	}
+
+	rules := []networkingv1.NetworkPolicyIngressRule{
+		{
+			From:  []networkingv1.NetworkPolicyPeer{gateway.GetGatewayNetworkPeer(cheManager)},
+			Ports: publicPorts,
+		},
+	}
+
+	if internalPorts := endpointPortsWithExposure(routing.Spec.Endpoints, dw.InternalEndpointExposure, publicPorts); len(internalPorts) > 0 {
+		rules = append(rules, networkingv1.NetworkPolicyIngressRule{
+			Ports: internalPorts,
+		})
+	}
+
+	labels := defaults.GetLabelsForComponent(cheManager, networkPolicyComponent)
+	labels[config.WorkspaceIDLabel] = routing.Spec.WorkspaceId
+
+	return &networkingv1.NetworkPolicy{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      routing.Spec.WorkspaceId + "-gateway-access",
+			Namespace: routing.Namespace,
+			Labels:    labels,
+		},
+		Spec: networkingv1.NetworkPolicySpec{
+			PodSelector: metav1.LabelSelector{
+				MatchLabels: routing.Spec.PodSelector,
+			},
+			PolicyTypes: []networkingv1.PolicyType{networkingv1.PolicyTypeIngress},
+			Ingress:     rules,
+		},
+	}
+}
+
+// endpointPortsWithExposure returns the sorted distinct target ports of the endpoints with given exposure, except
+// for the excluded ports.
+func endpointPortsWithExposure(endpoints map[string]dwo.EndpointList, exposure dw.EndpointExposure, excluded []networkingv1.NetworkPolicyPort) []networkingv1.NetworkPolicyPort {
+	seen := map[int]bool{}
+	for _, p := range excluded {
+		seen[p.Port.IntValue()] = true
+	}
+	var targetPorts []int
+	for _, machineName := range sortedMachineNames(endpoints) {
+		for _, e := range endpoints[machineName] {
+			if e.Exposure != exposure || seen[e.TargetPort] {
+				continue
+			}
+			seen[e.TargetPort] = true
+			targetPorts = append(targetPorts, e.TargetPort)
+		}
+	}
+	sort.Ints(targetPorts)
+
+	tcp := corev1.ProtocolTCP
+	var ports []networkingv1.NetworkPolicyPort
+	for _, p := range targetPorts {
+		port := intstr.FromInt(p)
+		ports = append(ports, networkingv1.NetworkPolicyPort{Protocol: &tcp, Port: &port})
+	}
+	return ports
+}
@@ -16,18 +16,25 @@ import (
 	"context"
 	"fmt"
 	"path"
+	"regexp"
+	"sort"
 	"strings"
 
 	dwoche "github.com/che-incubator/devworkspace-che-operator/apis/che-controller/v1alpha1"
 	"github.com/che-incubator/devworkspace-che-operator/pkg/defaults"
+	"github.com/che-incubator/devworkspace-che-operator/pkg/infrastructure"
 	"github.com/che-incubator/devworkspace-che-operator/pkg/sync"
+	"github.com/che-incubator/devworkspace-che-operator/pkg/traefik"
 	dw "github.com/devfile/api/v2/pkg/apis/workspaces/v1alpha2"
+	"github.com/devfile/api/v2/pkg/attributes"
 	dwo "github.com/devfile/devworkspace-operator/apis/controller/v1alpha1"
 	"github.com/devfile/devworkspace-operator/controllers/controller/workspacerouting/solvers"
 	"github.com/devfile/devworkspace-operator/pkg/common"
 	"github.com/devfile/devworkspace-operator/pkg/config"
 	"github.com/google/go-cmp/cmp/cmpopts"
+	routev1 "github.com/openshift/api/route/v1"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/api/extensions/v1beta1"
 	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/labels"
 	"sigs.k8s.io/controller-runtime/pkg/client"
@@ -35,12 +42,36 @@ import (
 )
 
 const (
-	uniqueEndpointAttributeName = "unique"
-	endpointURLPrefixPattern    = "/%s/%s/%d"
+	endpointURLPrefixPattern = "/%s/%s/%d"
 	// note - che-theia DEPENDS on this format - we should not change this unless crosschecked with the che-theia impl
 	uniqueEndpointURLPrefixPattern = "/%s/%s/%s"
+	// the URL prefix of the endpoints in the workspace-name path scheme
+	namedEndpointURLPrefixPattern = "/%s/%s/%s/%s"
 )
 
+// workspacePaths computes the public paths of the workspace endpoints according to the path scheme of the Che manager
+type workspacePaths struct {
+	scheme        dwoche.WorkspacePathScheme
+	workspaceID   string
+	namespace     string
+	workspaceName string
+}
+
+// routedEndpoint is the endpoint that determines the routing of its URL
+type routedEndpoint struct {
+	name  string
+	attrs endpointAttributes
+}
+
+// defaultCorsAllowedMethods are the methods of the cross-origin requests allowed if the CORS policy doesn't specify them
+var defaultCorsAllowedMethods = []string{"GET", "HEAD", "POST", "PUT", "PATCH", "DELETE"}
+
+// namedMiddleware is a middleware together with the name it is configured under in the gateway
+type namedMiddleware struct {
+	name       string
+	middleware traefik.Middleware
+}
+
 var (
 	configMapDiffOpts = cmpopts.IgnoreFields(corev1.ConfigMap{}, "TypeMeta", "ObjectMeta")
 )
@@ -58,6 +89,19 @@ func (c *CheRoutingSolver) singlehostSpecObjects(cheManager *dwoche.CheManager,
 	annos := map[string]string{}
 	annos[defaults.ConfigAnnotationCheManagerName] = cheManager.Name
 	annos[defaults.ConfigAnnotationCheManagerNamespace] = cheManager.Namespace
+	annos[defaults.ConfigAnnotationWorkspaceName] = workspacePathsOf(cheManager, routing).workspaceName
+
+	// the custom hosts are recorded on the services so that we know them when reporting the exposed endpoints
+	hosts, err := customHostsOf(cheManager, routing.Namespace, routing.Annotations)
+	if err != nil {
+		return solvers.RoutingObjects{}, err
+	}
+	if len(hosts.hosts) > 0 {
+		annos[CustomHostsAnnotation] = strings.Join(hosts.hosts, ",")
+		if hosts.tlsSecret.Name != "" {
+			annos[CustomHostsTLSSecretAnnotation] = hosts.tlsSecret.Name
+		}
+	}
 
 	additionalLabels := defaults.GetLabelsForComponent(cheManager, "exposure")
 
@@ -94,15 +138,46 @@ func (c *CheRoutingSolver) singlehostSpecObjects(cheManager *dwoche.CheManager,
 		return solvers.RoutingObjects{}, err
 	}
 
-	syncer := sync.New(c.client, c.scheme)
+	// the syncer reads the objects directly, because the cached client would cache all the objects of their kinds,
+	// e.g. all the secrets and ingresses in the cluster
+	syncer := sync.New(c.client, c.scheme, append(c.syncOpts, sync.WithReader(c.reader))...)
+
+	// the paths and hosts are claimed before the gateway is configured to route them
+	if err = c.claim(&syncer, cheManager, routing, claimsOf(workspacePathsOf(cheManager, routing), hosts)); err != nil {
+		return solvers.RoutingObjects{}, err
+	}
 
 	for _, cm := range configMaps {
-		_, _, err := syncer.Sync(context.TODO(), nil, &cm, configMapDiffOpts)
+		// the config maps live in the namespace of the che manager, so the routing is recorded as their owner
+		// using labels and annotations rather than an owner reference. This makes sure the config maps are
+		// swept even if the routing is deleted without being finalized.
+		_, _, err := syncer.Sync(context.TODO(), routing, &cm, configMapDiffOpts)
 		if err != nil {
 			return solvers.RoutingObjects{}, err
 		}
 
 	}
+
+	// the host claims are only released once the access on the hosts is pruned, so they tell whether the workspace
+	// used any custom hosts before
+	hadCustomHosts, err := c.hasHostClaims(context.TODO(), cheManager, routing)
+	if err != nil {
+		return solvers.RoutingObjects{}, err
+	}
+
+	if err = c.syncCustomHostsAccess(syncer, cheManager, routing, hosts, hadCustomHosts); err != nil {
+		return solvers.RoutingObjects{}, err
+	}
+
+	// release the claims that are no longer needed
+	if err = syncer.PruneInNamespace(context.TODO(), routing, cheManager.Namespace, &corev1.ConfigMapList{}); err != nil {
+		return solvers.RoutingObjects{}, err
+	}
+
+	if err = c.syncWorkspaceNetworkPolicy(syncer, cheManager, routing); err != nil {
+		return solvers.RoutingObjects{}, err
+	}
+
 	return objs, nil
 }
 
@@ -116,6 +191,16 @@ func (c *CheRoutingSolver) singlehostExposedEndpoints(manager *dwoche.CheManager
 		return nil, false, nil
 	}
 
+	paths := workspacePaths{scheme: manager.Spec.WorkspacePathScheme, workspaceID: workspaceID}
+	var hosts customHosts
+	if len(routingObj.Services) > 0 {
+		paths.namespace = routingObj.Services[0].Namespace
+		paths.workspaceName = routingObj.Services[0].Annotations[defaults.ConfigAnnotationWorkspaceName]
+		if hosts, err = customHostsOf(manager, routingObj.Services[0].Namespace, routingObj.Services[0].Annotations); err != nil {
+			return nil, false, err
+		}
+	}
+
 	exposed := map[string]dwo.ExposedEndpointList{}
 
 	for machineName, endpoints := range endpoints {
@@ -144,19 +229,33 @@ func (c *CheRoutingSolver) singlehostExposedEndpoints(manager *dwoche.CheManager
 				// in the future
 			}
 
-			publicURLPrefix := getPublicURLPrefixForEndpoint(workspaceID, machineName, endpoint)
+			attrs, err := parseEndpointAttributes(endpoint)
+			if err != nil {
+				return nil, false, err
+			}
 
-			publicURL := scheme + "://" + path.Join(host, publicURLPrefix, endpoint.Path)
+			publicURLPrefix := paths.prefix(machineName, int32(endpoint.TargetPort), paths.routeKey(endpoint.Name, attrs))
 
-			// path.Join() removes the trailing slashes, so make sure to reintroduce that if required.
-			if endpoint.Path == "" || strings.HasSuffix(endpoint.Path, "/") {
-				publicURL = publicURL + "/"
+			exposedAttrs := endpoint.Attributes
+			if len(hosts.hosts) > 0 {
+				// the endpoint is also accessible on the custom hosts. We report these URLs in the attributes, so we
+				// need to copy them not to modify the endpoint.
+				exposedAttrs = attributes.Attributes{}
+				for k, v := range endpoint.Attributes {
+					exposedAttrs[k] = v
+				}
+
+				var urls []string
+				for _, h := range hosts.hosts {
+					urls = append(urls, getPublicURL(hosts.scheme(), h, publicURLPrefix, endpoint.Path))
+				}
+				exposedAttrs.Put(CustomHostURLsAttribute, urls, nil)
 			}
 
 			exposedEndpoints = append(exposedEndpoints, dwo.ExposedEndpoint{
 				Name:       endpoint.Name,
-				Url:        publicURL,
-				Attributes: endpoint.Attributes,
+				Url:        getPublicURL(scheme, host, publicURLPrefix, endpoint.Path),
+				Attributes: exposedAttrs,
 			})
 		}
 		exposed[machineName] = exposedEndpoints
@@ -187,77 +286,223 @@ func (c *CheRoutingSolver) getGatewayConfigMaps(cheManager *dwoche.CheManager, w
 		Data: map[string]string{},
 	}
 
-	rtrs := map[string]traefikConfigRouter{}
-	srvcs := map[string]traefikConfigService{}
-	mdls := map[string]traefikConfigMiddleware{}
+	hosts, err := customHostsOf(cheManager, routing.Namespace, routing.Annotations)
+	if err != nil {
+		return []corev1.ConfigMap{}, err
+	}
+
+	if cors := cheManager.Spec.Gateway.Cors; cors != nil {
+		if problem := validateCorsPolicy(cors); problem != "" {
+			return []corev1.ConfigMap{}, &solvers.RoutingInvalid{
+				Reason: fmt.Sprintf("the CORS policy of the Che manager is invalid: %s", problem),
+			}
+		}
+	}
+
+	access, err := workspaceAccessPolicy(cheManager, routing)
+	if err != nil {
+		return []corev1.ConfigMap{}, err
+	}
+
+	paths := workspacePathsOf(cheManager, routing)
+	gw := newGatewayConfig()
+
+	for _, machineName := range sortedMachineNames(routing.Spec.Endpoints) {
+		endpoints := routing.Spec.Endpoints[machineName]
 
-	for machineName, endpoints := range routing.Spec.Endpoints {
 		// we need to support unique endpoints - so 1 port can actually be accessible
 		// multiple times, each time using a different resulting external URL.
 		// non-unique endpoints are all represented using a single external URL
-		ports := map[int32]map[string]bool{}
+		ports := map[int32]map[string]routedEndpoint{}
 		for _, e := range endpoints {
 			i := int32(e.TargetPort)
 
-			name := ""
-			if e.Attributes.GetBoolean(uniqueEndpointAttributeName, nil) {
-				name = e.Name
+			attrs, err := parseEndpointAttributes(e)
+			if err != nil {
+				return []corev1.ConfigMap{}, err
+			}
+
+			if attrs.cookiePathRewrite && !cheManager.Spec.Gateway.CookiePathRewriting {
+				return []corev1.ConfigMap{}, &solvers.RoutingInvalid{
+					Reason: fmt.Sprintf("the endpoint '%s' requires the paths of its cookies to be rewritten but the cookie rewriting is not enabled "+
+						"in spec.gateway.cookiePathRewriting of the Che manager. The endpoint can also use the %s header to set the correct paths "+
+						"of its cookies itself", e.Name, forwardedPrefixHeader),
+				}
 			}
 
+			name := paths.routeKey(e.Name, attrs)
+
 			if ports[i] == nil {
-				ports[i] = map[string]bool{}
+				ports[i] = map[string]routedEndpoint{}
+			}
+
+			if existing, ok := ports[i][name]; ok {
+				// the first endpoint determines the routing of the shared URL, like it always did
+				if !existing.attrs.routingEquals(attrs) && c.recorder != nil {
+					c.recorder.Eventf(routing, corev1.EventTypeWarning, EventReasonConflictingEndpoints,
+						"The endpoints '%s' and '%s' share the same URL but their attributes require different routing. "+
+							"The URL is routed according to the attributes of '%s'", existing.name, e.Name, existing.name)
+				}
+				continue
 			}
 
-			ports[i][name] = true
+			ports[i][name] = routedEndpoint{name: e.Name, attrs: attrs}
 		}
 
-		for port, names := range ports {
-			for endpointName := range names {
-				var name string
-				var prefix string
-				var serviceURL string
+		for _, port := range sortedPorts(ports) {
+			for _, endpointName := range sortedNames(ports[port]) {
+				attrs := ports[port][endpointName].attrs
 
+				var name string
 				if endpointName == "" {
 					name = fmt.Sprintf("%s-%s-%d", workspaceID, machineName, port)
 				} else {
 					name = fmt.Sprintf("%s-%s-%d-%s", workspaceID, machineName, port, endpointName)
 				}
-				prefix = getPublicURLPrefix(workspaceID, machineName, port, endpointName)
-				serviceURL = getServiceURL(port, workspaceID, routing.Namespace)
+				prefix := paths.prefix(machineName, port, endpointName)
+				service := traefik.Service{
+					LoadBalancer: traefik.LoadBalancer{
+						Servers: []traefik.Server{
+							{
+								URL: getServiceURL(port, workspaceID, routing.Namespace),
+							},
+						},
+					},
+				}
+
+				desc := describeExposure(machineName, port, endpointName)
+				if err := gw.claimPath(prefix, desc); err != nil {
+					return []corev1.ConfigMap{}, err
+				}
+
+				if attrs.timeout > 0 {
+					transportName := name + "-transport"
+					if err := gw.addServersTransport(transportName, desc, traefik.ServersTransport{
+						ForwardingTimeouts: &traefik.ForwardingTimeouts{
+							ResponseHeaderTimeout: attrs.timeout.String(),
+						},
+					}); err != nil {
+						return []corev1.ConfigMap{}, err
+					}
+					service.LoadBalancer.ServersTransport = transportName
+				}
+
+				cors := cheManager.Spec.Gateway.Cors
+				if attrs.cors != nil {
+					cors = attrs.cors
+				}
+
+				// the access policy is enforced before anything else and on all the routers of the endpoint
+				endpointAccess, err := access.forEndpoint(ports[port][endpointName].name, attrs)
+				if err != nil {
+					return []corev1.ConfigMap{}, err
+				}
+				accessMiddlewares := endpointAccess.middlewares(name)
+				var accessMiddlewareNames []string
+				for _, m := range accessMiddlewares {
+					accessMiddlewareNames = append(accessMiddlewareNames, m.name)
+				}
+
+				// the CORS middleware answers the preflight requests itself, so it goes right after the access policy.
+				// It is followed by the error pages, so that the CORS headers apply to them too.
+				frontMiddlewares := append(accessMiddlewares, corsMiddlewares(name, cors)...)
+				middlewares := append(frontMiddlewares, endpointMiddlewares(name, prefix, attrs)...)
+				if attrs.cookiePathRewrite {
+					// Traefik cannot modify the cookies, so the sidecar of the gateway needs to do it
+					middlewares = append(middlewares, routeThroughCookieRewriter(name, prefix, attrs, &service))
+				}
+				var middlewareNames []string
+				for i, m := range middlewares {
+					if cheManager.Spec.Gateway.ErrorPages && i == len(frontMiddlewares) {
+						middlewareNames = append(middlewareNames, errorsMiddlewareName(workspaceID))
+					}
+					middlewareNames = append(middlewareNames, m.name)
+				}
 
-				rtrs[name] = traefikConfigRouter{
+				if err := gw.addRouter(name, desc, traefik.Router{
 					Rule:        fmt.Sprintf("PathPrefix(`%s`)", prefix),
 					Service:     name,
-					Middlewares: []string{name},
+					Middlewares: middlewareNames,
 					Priority:    100,
+				}, service); err != nil {
+					return []corev1.ConfigMap{}, err
 				}
 
-				srvcs[name] = traefikConfigService{
-					LoadBalancer: traefikConfigLoadbalancer{
-						Servers: []traefikConfigLoadbalancerServer{
-							{
-								URL: serviceURL,
-							},
-						},
-					},
+				for _, m := range middlewares {
+					if err := gw.addMiddleware(m.name, desc, m.middleware); err != nil {
+						return []corev1.ConfigMap{}, err
+					}
+				}
+
+				if len(hosts.hosts) > 0 {
+					// the requests to the custom hosts are matched by the host so that they take precedence over
+					// the routers of the other workspaces in case the paths are shared
+					customHostsName := name + "-custom-hosts"
+					if err := gw.addRouter(customHostsName, desc, traefik.Router{
+						Rule:        hosts.rule(prefix),
+						Service:     customHostsName,
+						Middlewares: middlewareNames,
+						Priority:    200,
+					}, service); err != nil {
+						return []corev1.ConfigMap{}, err
+					}
+				}
+
+				if paths.scheme != dwoche.WorkspaceNamePathScheme {
+					continue
+				}
+
+				// redirect the paths of the default scheme to the new ones, so that the clients that compute the
+				// URLs of the endpoints themselves, like che-theia, keep working. All the non-unique endpoints on
+				// a port share the same path in the default scheme, so we redirect it to the first of them.
+				legacyName := ""
+				if attrs.unique {
+					legacyName = endpointName
+				}
+				legacyPrefix := getPublicURLPrefix(workspaceID, machineName, port, legacyName)
+				if _, ok := gw.pathOwners[legacyPrefix]; ok {
+					continue
 				}
 
-				mdls[name] = traefikConfigMiddleware{
-					StripPrefix: traefikConfigStripPrefix{
-						Prefixes: []string{prefix},
+				if err := gw.claimPath(legacyPrefix, desc); err != nil {
+					return []corev1.ConfigMap{}, err
+				}
+
+				redirectName := name + "-redirect"
+				if err := gw.addMiddleware(redirectName, desc, traefik.Middleware{
+					RedirectRegex: &traefik.RedirectRegex{
+						Regex:       "^([^:]+://[^/]+)" + regexp.QuoteMeta(legacyPrefix) + "([/?].*)?$",
+						Replacement: "${1}" + prefix + "${2}",
 					},
+				}); err != nil {
+					return []corev1.ConfigMap{}, err
 				}
 
+				if err := gw.addRouter(redirectName, desc, traefik.Router{
+					Rule:        fmt.Sprintf("PathPrefix(`%s`)", legacyPrefix),
+					Service:     redirectName,
+					Middlewares: append(accessMiddlewareNames, redirectName),
+					Priority:    100,
+				}, service); err != nil {
+					return []corev1.ConfigMap{}, err
+				}
 			}
 		}
 	}
 
-	config := traefikConfig{
-		HTTP: traefikConfigHTTP{
-			Routers:     rtrs,
-			Services:    srvcs,
-			Middlewares: mdls,
-		},
+	if cheManager.Spec.Gateway.ErrorPages && len(gw.config.HTTP.Routers) > 0 {
+		if err := addErrorPages(gw, workspaceID, paths, access); err != nil {
+			return []corev1.ConfigMap{}, err
+		}
+	}
+
+	config := gw.config
+
+	// an invalid configuration would break the gateway for all the workspaces, so it must never be published
+	if err := config.Validate(); err != nil {
+		return []corev1.ConfigMap{}, &solvers.RoutingInvalid{
+			Reason: fmt.Sprintf("the gateway configuration of the workspace would be invalid: %s", err),
+		}
 	}
 
 	contents, err := yaml.Marshal(config)
@@ -278,12 +523,13 @@ func (c *CheRoutingSolver) singlehostFinalize(cheManager *dwoche.CheManager, rou
 		return err
 	}
 
+	// the objects are listed directly, because a cached list would cache all the secrets, ingresses, etc. in the cluster
 	listOpts := &client.ListOptions{
 		Namespace:     cheManager.Namespace,
 		LabelSelector: selector,
 	}
 
-	err = c.client.List(context.TODO(), configs, listOpts)
+	err = c.reader.List(context.TODO(), configs, listOpts)
 	if err != nil {
 		return err
 	}
@@ -295,22 +541,223 @@ func (c *CheRoutingSolver) singlehostFinalize(cheManager *dwoche.CheManager, rou
 		}
 	}
 
+	if infrastructure.Current.Type == infrastructure.OpenShift {
+		routes := &routev1.RouteList{}
+		if err = c.reader.List(context.TODO(), routes, listOpts); err != nil {
+			return err
+		}
+		for _, r := range routes.Items {
+			if err = c.client.Delete(context.TODO(), &r); err != nil {
+				return err
+			}
+		}
+	} else {
+		ingresses := &v1beta1.IngressList{}
+		if err = c.reader.List(context.TODO(), ingresses, listOpts); err != nil {
+			return err
+		}
+		for _, ing := range ingresses.Items {
+			if err = c.client.Delete(context.TODO(), &ing); err != nil {
+				return err
+			}
+		}
+		secrets := &corev1.SecretList{}
+		if err = c.reader.List(context.TODO(), secrets, listOpts); err != nil {
+			return err
+		}
+		for _, s := range secrets.Items {
+			if err = c.client.Delete(context.TODO(), &s); err != nil {
+				return err
+			}
+		}
+	}
+
 	return nil
 }
 
+// corsMiddlewares returns the CORS middleware of the endpoint with the given router name, if the CORS policy allows
+// any cross-origin requests.
+func corsMiddlewares(name string, cors *dwoche.CorsPolicy) []namedMiddleware {
+	var ret []namedMiddleware
+
+	if cors != nil && len(cors.AllowedOrigins) > 0 {
+		methods := cors.AllowedMethods
+		if len(methods) == 0 {
+			methods = defaultCorsAllowedMethods
+		}
+		ret = append(ret, namedMiddleware{
+			name: name + "-cors",
+			middleware: traefik.Middleware{
+				Headers: &traefik.Headers{
+					AccessControlAllowOriginList:  cors.AllowedOrigins,
+					AccessControlAllowMethods:     methods,
+					AccessControlAllowHeaders:     cors.AllowedHeaders,
+					AccessControlAllowCredentials: cors.AllowCredentials,
+					AccessControlMaxAge:           cors.MaxAge,
+					// the responses differ by the origin, so the caches need to take it into account
+					AddVaryHeader: true,
+				},
+			},
+		})
+	}
+
+	return ret
+}
+
+// endpointMiddlewares returns the middlewares of the endpoint with the given router name in the order in which they
+// are applied to the requests. The redirects go first, because they are matched against the public URL, while
+// the retries go last, so that a request is retried only after it was fully prepared for the backend.
+func endpointMiddlewares(name string, prefix string, attrs endpointAttributes) []namedMiddleware {
+	var ret []namedMiddleware
+
+	for i := range attrs.redirects {
+		ret = append(ret, namedMiddleware{
+			name:       fmt.Sprintf("%s-redirects-%d", name, i),
+			middleware: traefik.Middleware{RedirectRegex: &attrs.redirects[i]},
+		})
+	}
+
+	ret = append(ret, namedMiddleware{
+		name: name,
+		middleware: traefik.Middleware{
+			StripPrefix: &traefik.StripPrefix{
+				Prefixes: []string{prefix},
+			},
+		},
+	})
+
+	if attrs.addPrefix != "" {
+		ret = append(ret, namedMiddleware{
+			name: name + "-add-prefix",
+			middleware: traefik.Middleware{
+				AddPrefix: &traefik.AddPrefix{Prefix: attrs.addPrefix},
+			},
+		})
+	}
+
+	if requestHeaders := attrs.requestHeaders(); len(requestHeaders) > 0 || len(attrs.responseHeaders) > 0 {
+		ret = append(ret, namedMiddleware{
+			name: name + "-headers",
+			middleware: traefik.Middleware{
+				Headers: &traefik.Headers{
+					CustomRequestHeaders:  requestHeaders,
+					CustomResponseHeaders: attrs.responseHeaders,
+				},
+			},
+		})
+	}
+
+	if attrs.circuitBreaker != "" {
+		ret = append(ret, namedMiddleware{
+			name: name + "-circuit-breaker",
+			middleware: traefik.Middleware{
+				CircuitBreaker: &traefik.CircuitBreaker{Expression: attrs.circuitBreaker},
+			},
+		})
+	}
+
+	if attrs.retries > 0 {
+		ret = append(ret, namedMiddleware{
+			name: name + "-retry",
+			middleware: traefik.Middleware{
+				Retry: &traefik.Retry{Attempts: attrs.retries},
+			},
+		})
+	}
+
+	return ret
+}
+
+func describeExposure(machineName string, port int32, routeKey string) string {
+	if routeKey == "" {
+		return fmt.Sprintf("port %d of the component %s", port, machineName)
+	}
+	return fmt.Sprintf("endpoint %s on the port %d of the component %s", routeKey, port, machineName)
+}
+
+func sortedMachineNames(endpoints map[string]dwo.EndpointList) []string {
+	names := make([]string, 0, len(endpoints))
+	for n := range endpoints {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func sortedPorts(ports map[int32]map[string]routedEndpoint) []int32 {
+	ret := make([]int32, 0, len(ports))
+	for p := range ports {
+		ret = append(ret, p)
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i] < ret[j] })
+	return ret
+}
+
+func sortedNames(names map[string]routedEndpoint) []string {
+	ret := make([]string, 0, len(names))
+	for n := range names {
+		ret = append(ret, n)
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+func getPublicURL(scheme string, host string, publicURLPrefix string, endpointPath string) string {
+	publicURL := scheme + "://" + path.Join(host, publicURLPrefix, endpointPath)
+
+	// path.Join() removes the trailing slashes, so make sure to reintroduce that if required.
+	if endpointPath == "" || strings.HasSuffix(endpointPath, "/") {
+		publicURL = publicURL + "/"
+	}
+
+	return publicURL
+}
+
 func getServiceURL(port int32, workspaceID string, workspaceNamespace string) string {
 	// the default .cluster.local suffix of the internal domain names seems to be configurable, so let's just
 	// not use it so we don't have to know about it...
 	return fmt.Sprintf("http://%s.%s.svc:%d", common.ServiceName(workspaceID), workspaceNamespace, port)
 }
 
-func getPublicURLPrefixForEndpoint(workspaceID string, machineName string, endpoint dw.Endpoint) string {
-	endpointName := ""
-	if endpoint.Attributes.GetString(uniqueEndpointAttributeName, nil) == "true" {
-		endpointName = endpoint.Name
+// workspacePathsOf returns the paths of the workspace of the routing. The name of the workspace is the name of
+// the DevWorkspace that owns the routing, or the workspace ID if the routing is not owned by any.
+func workspacePathsOf(cheManager *dwoche.CheManager, routing *dwo.WorkspaceRouting) workspacePaths {
+	name := routing.Spec.WorkspaceId
+	if owner := v1.GetControllerOf(routing); owner != nil && owner.Kind == "DevWorkspace" {
+		name = owner.Name
 	}
 
-	return getPublicURLPrefix(workspaceID, machineName, int32(endpoint.TargetPort), endpointName)
+	return workspacePaths{
+		scheme:        cheManager.Spec.WorkspacePathScheme,
+		workspaceID:   routing.Spec.WorkspaceId,
+		namespace:     routing.Namespace,
+		workspaceName: name,
+	}
+}
+
+// base returns the path prefix shared by all the endpoints of the workspace.
+func (p workspacePaths) base() string {
+	if p.scheme == dwoche.WorkspaceNamePathScheme {
+		return fmt.Sprintf("/%s/%s/", p.namespace, p.workspaceName)
+	}
+	return "/" + p.workspaceID + "/"
+}
+
+// routeKey returns the name that identifies the URL of the endpoint among the URLs of the other endpoints on the same
+// port. Endpoints with the same key share the same URL.
+func (p workspacePaths) routeKey(endpointName string, attrs endpointAttributes) string {
+	if p.scheme == dwoche.WorkspaceNamePathScheme || attrs.unique {
+		return endpointName
+	}
+	return ""
+}
+
+// prefix returns the public URL prefix of the endpoints on the port with the route key.
+func (p workspacePaths) prefix(machineName string, port int32, routeKey string) string {
+	if p.scheme == dwoche.WorkspaceNamePathScheme {
+		return fmt.Sprintf(namedEndpointURLPrefixPattern, p.namespace, p.workspaceName, machineName, routeKey)
+	}
+	return getPublicURLPrefix(p.workspaceID, machineName, port, routeKey)
 }
 
 func getPublicURLPrefix(workspaceID string, machineName string, port int32, uniqueEndpointName string) string {
@@ -2,13 +2,20 @@ package solver
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"os"
+	"reflect"
+	"strings"
 	"testing"
 
 	"github.com/che-incubator/devworkspace-che-operator/apis/che-controller/v1alpha1"
 	"github.com/che-incubator/devworkspace-che-operator/pkg/defaults"
 	"github.com/che-incubator/devworkspace-che-operator/pkg/manager"
+	"github.com/che-incubator/devworkspace-che-operator/pkg/sync"
+	"github.com/che-incubator/devworkspace-che-operator/pkg/traefik"
 	dw "github.com/devfile/api/v2/pkg/apis/workspaces/v1alpha2"
+	"github.com/devfile/api/v2/pkg/attributes"
 	dwo "github.com/devfile/devworkspace-operator/apis/controller/v1alpha1"
 	"github.com/devfile/devworkspace-operator/controllers/controller/workspacerouting/solvers"
 	"github.com/devfile/devworkspace-operator/pkg/config"
@@ -16,11 +23,15 @@ import (
 	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
 	extensions "k8s.io/api/extensions/v1beta1"
+	networkingv1 "k8s.io/api/networking/v1"
+	policy "k8s.io/api/policy/v1beta1"
 	rbac "k8s.io/api/rbac/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/client-go/tools/record"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/client/fake"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
@@ -34,6 +45,8 @@ func createTestScheme() *runtime.Scheme {
 	utilruntime.Must(corev1.AddToScheme(scheme))
 	utilruntime.Must(appsv1.AddToScheme(scheme))
 	utilruntime.Must(rbac.AddToScheme(scheme))
+	utilruntime.Must(policy.AddToScheme(scheme))
+	utilruntime.Must(networkingv1.AddToScheme(scheme))
 	utilruntime.Must(dw.AddToScheme(scheme))
 	utilruntime.Must(dwo.AddToScheme(scheme))
 	utilruntime.Must(routev1.AddToScheme(scheme))
@@ -42,6 +55,17 @@ func createTestScheme() *runtime.Scheme {
 }
 
 func getSpecObjects(t *testing.T, routing *dwo.WorkspaceRouting) (client.Client, solvers.RoutingSolver, solvers.RoutingObjects) {
+	cl, solver, objs, err := getSpecObjectsWithManager(t, routing, v1alpha1.CheManagerSpec{
+		Host: "over.the.rainbow",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return cl, solver, objs
+}
+
+func getSpecObjectsWithManager(t *testing.T, routing *dwo.WorkspaceRouting, spec v1alpha1.CheManagerSpec, initObjs ...runtime.Object) (client.Client, solvers.RoutingSolver, solvers.RoutingObjects, error) {
 	scheme := createTestScheme()
 	cheManager := &v1alpha1.CheManager{
 		ObjectMeta: metav1.ObjectMeta{
@@ -49,14 +73,12 @@ func getSpecObjects(t *testing.T, routing *dwo.WorkspaceRouting) (client.Client,
 			Namespace:  "ns",
 			Finalizers: []string{manager.FinalizerName},
 		},
-		Spec: v1alpha1.CheManagerSpec{
-			Host: "over.the.rainbow",
-		},
+		Spec: spec,
 	}
 
-	cl := fake.NewFakeClientWithScheme(scheme, cheManager)
+	cl := fake.NewFakeClientWithScheme(scheme, append(initObjs, cheManager)...)
 
-	solver, err := Getter(scheme).GetSolver(cl, "che")
+	solver, err := Getter(scheme, nil, nil).GetSolver(cl, "che")
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -77,13 +99,13 @@ func getSpecObjects(t *testing.T, routing *dwo.WorkspaceRouting) (client.Client,
 
 	objs, err := solver.GetSpecObjects(routing, meta)
 	if err != nil {
-		t.Fatal(err)
+		return cl, solver, objs, err
 	}
 
 	// now we need a second round of che manager reconciliation so that it proclaims the che gateway as established
 	cheRecon.Reconcile(reconcile.Request{NamespacedName: types.NamespacedName{Name: "che", Namespace: "ns"}})
 
-	return cl, solver, objs
+	return cl, solver, objs, nil
 }
 
 func simpleWorkspaceRouting() *dwo.WorkspaceRouting {
@@ -190,13 +212,17 @@ func TestCreateObjects(t *testing.T) {
 			t.Fatalf("traefik configuration for the workspace not found")
 		}
 
+		if workspaceCfg.Annotations[sync.ForeignOwnerNameAnnotation] != "routing" || workspaceCfg.Annotations[sync.ForeignOwnerNamespaceAnnotation] != "ws" {
+			t.Errorf("The workspace routing should have been recorded as the owner of the workspace configuration")
+		}
+
 		traefikWorkspaceConfig := workspaceCfg.Data["wsid.yml"]
 
 		if len(traefikWorkspaceConfig) == 0 {
 			t.Fatal("No traefik config file found in the workspace config configmap")
 		}
 
-		workspaceConfig := traefikConfig{}
+		workspaceConfig := traefik.Config{}
 		if err := yaml.Unmarshal([]byte(traefikWorkspaceConfig), &workspaceConfig); err != nil {
 			t.Fatal(err)
 		}
@@ -286,3 +312,1421 @@ func TestFinalize(t *testing.T) {
 		t.Fatal("The only configmap left should be the main traefik config, but the configmap has unexpected name")
 	}
 }
+
+func TestRecordsEventsAboutInvalidRouting(t *testing.T) {
+	scheme := createTestScheme()
+	managerNames := []string{"che1", "che2"}
+
+	cl := fake.NewFakeClientWithScheme(scheme)
+	cheRecon := manager.New(cl, scheme)
+
+	for _, name := range managerNames {
+		if err := cl.Create(context.TODO(), &v1alpha1.CheManager{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:       name,
+				Namespace:  "ns",
+				Finalizers: []string{manager.FinalizerName},
+			},
+			Spec: v1alpha1.CheManagerSpec{
+				Host: "over.the.rainbow",
+			},
+		}); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := cheRecon.Reconcile(reconcile.Request{NamespacedName: types.NamespacedName{Name: name, Namespace: "ns"}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// make sure the managers don't leak into the other tests
+	defer func() {
+		for _, name := range managerNames {
+			cl.Delete(context.TODO(), &v1alpha1.CheManager{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "ns"}})
+			cheRecon.Reconcile(reconcile.Request{NamespacedName: types.NamespacedName{Name: name, Namespace: "ns"}})
+		}
+	}()
+
+	recorder := record.NewFakeRecorder(10)
+	solver, err := Getter(scheme, nil, recorder).GetSolver(cl, "che")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	routing := simpleWorkspaceRouting()
+	// the objects are pruned by the UID of the routing
+	routing.UID = "routing-uid"
+	meta := solvers.WorkspaceMetadata{WorkspaceId: routing.Spec.WorkspaceId, Namespace: routing.Namespace}
+
+	// there are multiple managers and the routing doesn't say which one to use
+	if _, err = solver.GetSpecObjects(routing, meta); err == nil {
+		t.Fatal("The routing should have been invalid")
+	}
+	if len(recorder.Events) != 1 {
+		t.Fatalf("Expected a single event but there were %d", len(recorder.Events))
+	}
+	if e := <-recorder.Events; !strings.HasPrefix(e, corev1.EventTypeWarning+" "+EventReasonRoutingInvalid) {
+		t.Errorf("Unexpected event recorded: %s", e)
+	}
+
+	// the routing requires a non-existent manager
+	routing.Annotations = map[string]string{
+		defaults.ConfigAnnotationCheManagerName:      "nonexistent",
+		defaults.ConfigAnnotationCheManagerNamespace: "ns",
+	}
+	if _, err = solver.GetSpecObjects(routing, meta); err == nil {
+		t.Fatal("The routing should not have been ready")
+	}
+	if len(recorder.Events) != 1 {
+		t.Fatalf("Expected a single event but there were %d", len(recorder.Events))
+	}
+	if e := <-recorder.Events; !strings.HasPrefix(e, corev1.EventTypeWarning+" "+EventReasonCheManagerNotFound) {
+		t.Errorf("Unexpected event recorded: %s", e)
+	}
+}
+
+func TestExposedEndpointsAreRoutedToWorkspace(t *testing.T) {
+	routing := simpleWorkspaceRouting()
+	cl, solver, objs := getSpecObjects(t, routing)
+
+	exposed, _, err := solver.GetExposedEndpoints(routing.Spec.Endpoints, objs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cms := &corev1.ConfigMapList{}
+	if err = cl.List(context.TODO(), cms, client.InNamespace("ns")); err != nil {
+		t.Fatal(err)
+	}
+
+	gateway, err := traefik.GatewayFromConfigMaps(cms.Items...)
+	if err != nil {
+		t.Fatalf("The gateway configuration is invalid: %s", err)
+	}
+
+	for _, e := range exposed["m1"] {
+		ex, err := gateway.ExplainURL(e.Url)
+		if err != nil {
+			t.Errorf("Failed to route the endpoint %s: %s", e.Name, err)
+			continue
+		}
+
+		if !strings.HasPrefix(ex.Backend, "http://wsid-service.ws.svc:9999/") {
+			t.Errorf("The endpoint %s should have been routed to the workspace service but was routed to %s", e.Name, ex.Backend)
+		}
+	}
+
+	ex, err := gateway.ExplainURL("https://over.the.rainbow/wsid/m1/9999/1/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ex.Backend != "http://wsid-service.ws.svc:9999/1/" {
+		t.Errorf("The workspace prefix should have been stripped from the path but the backend is %s", ex.Backend)
+	}
+}
+
+func TestRejectsCollidingEndpoints(t *testing.T) {
+	uniqueAttrs := func() attributes.Attributes {
+		return attributes.Attributes{}.PutBoolean(uniqueEndpointAttributeName, true)
+	}
+
+	tests := map[string]struct {
+		endpoints map[string]dwo.EndpointList
+		reason    string
+	}{
+		// the name of the unique endpoint makes the router name the same as the one of the port of the other component
+		"sameRouterName": {
+			endpoints: map[string]dwo.EndpointList{
+				"m":   {{Name: "80", TargetPort: 1, Exposure: dw.PublicEndpointExposure, Attributes: uniqueAttrs()}},
+				"m-1": {{Name: "e", TargetPort: 80, Exposure: dw.PublicEndpointExposure}},
+			},
+			reason: "would both be configured in the gateway as 'wsid-m-1-80'",
+		},
+		// unique endpoints are exposed on a path that doesn't contain the port
+		"samePath": {
+			endpoints: map[string]dwo.EndpointList{
+				"m": {
+					{Name: "e", TargetPort: 1, Exposure: dw.PublicEndpointExposure, Attributes: uniqueAttrs()},
+					{Name: "e", TargetPort: 2, Exposure: dw.PublicEndpointExposure, Attributes: uniqueAttrs()},
+				},
+			},
+			reason: "would both be exposed on the path /wsid/m/e",
+		},
+		"authRequired": {
+			endpoints: map[string]dwo.EndpointList{
+				"m": {{Name: "e", TargetPort: 1, Exposure: dw.PublicEndpointExposure, Attributes: attributes.Attributes{}.PutString(authEndpointAttributeName, "required")}},
+			},
+			reason: "'required' is not supported, the gateway cannot authenticate the users yet",
+		},
+		"invalidRule": {
+			endpoints: map[string]dwo.EndpointList{
+				"m": {{Name: "e`)", TargetPort: 1, Exposure: dw.PublicEndpointExposure, Attributes: uniqueAttrs()}},
+			},
+			reason: "has an invalid rule",
+		},
+	}
+
+	manager := &v1alpha1.CheManager{ObjectMeta: metav1.ObjectMeta{Name: "che", Namespace: "ns"}}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			routing := simpleWorkspaceRouting()
+			routing.Spec.Endpoints = test.endpoints
+
+			_, err := (&CheRoutingSolver{}).getGatewayConfigMaps(manager, "wsid", routing)
+
+			var invalid *solvers.RoutingInvalid
+			if !errors.As(err, &invalid) {
+				t.Fatalf("Expected the routing to be invalid but got: %v", err)
+			}
+			if !strings.Contains(invalid.Reason, test.reason) {
+				t.Errorf("Expected the reason to contain \"%s\" but it was: %s", test.reason, invalid.Reason)
+			}
+		})
+	}
+}
+
+func TestFirstEndpointDeterminesRoutingOfSharedURL(t *testing.T) {
+	routing := simpleWorkspaceRouting()
+	routing.Spec.Endpoints = map[string]dwo.EndpointList{
+		"m": {
+			{Name: "e1", TargetPort: 1, Exposure: dw.PublicEndpointExposure},
+			{Name: "e2", TargetPort: 1, Exposure: dw.PublicEndpointExposure, Attributes: attributes.Attributes{}.PutString(timeoutEndpointAttributeName, "1m")},
+		},
+	}
+
+	recorder := record.NewFakeRecorder(10)
+	manager := &v1alpha1.CheManager{ObjectMeta: metav1.ObjectMeta{Name: "che", Namespace: "ns"}}
+
+	cms, err := (&CheRoutingSolver{recorder: recorder}).getGatewayConfigMaps(manager, "wsid", routing)
+	if err != nil {
+		t.Fatalf("The endpoints sharing the same URL should have been exposed but got: %s", err)
+	}
+
+	workspaceConfig := traefik.Config{}
+	if err := yaml.Unmarshal([]byte(cms[0].Data["wsid.yml"]), &workspaceConfig); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := workspaceConfig.HTTP.Services["wsid-m-1"]; !ok {
+		t.Errorf("The shared URL should have been routed but got the services %v", workspaceConfig.HTTP.Services)
+	}
+	if len(workspaceConfig.HTTP.ServersTransports) != 0 {
+		t.Errorf("The URL should have been routed according to the attributes of the first endpoint but got the servers transports %v", workspaceConfig.HTTP.ServersTransports)
+	}
+
+	if len(recorder.Events) != 1 {
+		t.Fatalf("Expected a single event but there were %d", len(recorder.Events))
+	}
+	if e := <-recorder.Events; !strings.HasPrefix(e, corev1.EventTypeWarning+" "+EventReasonConflictingEndpoints) {
+		t.Errorf("Unexpected event: %s", e)
+	}
+}
+
+func TestEndpointAttributesAreAppliedConsistently(t *testing.T) {
+	var err error
+	routing := simpleWorkspaceRouting()
+	routing.Spec.Endpoints["m1"] = append(routing.Spec.Endpoints["m1"], dw.Endpoint{
+		Name:       "e4",
+		TargetPort: 8888,
+		Exposure:   dw.PublicEndpointExposure,
+		// values like "True" of the unique attribute used to be interpreted differently by the URL reporting and the
+		// gateway configuration
+		Attributes: attributes.Attributes{}.
+			PutString(uniqueEndpointAttributeName, "True").
+			Put(headersEndpointAttributeName, map[string]string{"X-Custom": "value"}, &err),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cl, solver, objs := getSpecObjects(t, routing)
+
+	exposed, _, err := solver.GetExposedEndpoints(routing.Spec.Endpoints, objs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var url string
+	for _, e := range exposed["m1"] {
+		if e.Name == "e4" {
+			url = e.Url
+		}
+	}
+	if url != "http://over.the.rainbow/wsid/m1/e4/" {
+		t.Fatalf("Unexpected URL of the unique endpoint: %s", url)
+	}
+
+	cms := &corev1.ConfigMapList{}
+	if err = cl.List(context.TODO(), cms, client.InNamespace("ns")); err != nil {
+		t.Fatal(err)
+	}
+
+	gateway, err := traefik.GatewayFromConfigMaps(cms.Items...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ex, err := gateway.ExplainURL(url)
+	if err != nil {
+		t.Fatalf("The URL of the unique endpoint is not routed: %s", err)
+	}
+
+	if ex.RouterName != "wsid-m1-8888-e4" || ex.Backend != "http://wsid-service.ws.svc:8888/" {
+		t.Errorf("Unexpected routing of the unique endpoint to %s using %s", ex.Backend, ex.RouterName)
+	}
+
+	if len(ex.Middlewares) != 2 || ex.Middlewares[1].Middleware.Headers == nil || ex.Middlewares[1].Middleware.Headers.CustomRequestHeaders["X-Custom"] != "value" {
+		t.Errorf("The custom header should have been set on the requests: %+v", ex.Middlewares)
+	}
+}
+
+func TestWorkspaceNamePathScheme(t *testing.T) {
+	routing := simpleWorkspaceRouting()
+	routing.OwnerReferences = []metav1.OwnerReference{*metav1.NewControllerRef(&dw.DevWorkspace{ObjectMeta: metav1.ObjectMeta{Name: "my-workspace"}}, dw.SchemeGroupVersion.WithKind("DevWorkspace"))}
+
+	cl, solver, objs, err := getSpecObjectsWithManager(t, routing, v1alpha1.CheManagerSpec{
+		Host:                "over.the.rainbow",
+		WorkspacePathScheme: v1alpha1.WorkspaceNamePathScheme,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	exposed, _, err := solver.GetExposedEndpoints(routing.Spec.Endpoints, objs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expectedURLs := map[string]string{
+		"e1": "https://over.the.rainbow/ws/my-workspace/m1/e1/1/",
+		"e2": "https://over.the.rainbow/ws/my-workspace/m1/e2/2.js",
+		"e3": "http://over.the.rainbow/ws/my-workspace/m1/e3/",
+	}
+	for _, e := range exposed["m1"] {
+		if e.Url != expectedURLs[e.Name] {
+			t.Errorf("The endpoint %s should have the URL %s but has %s", e.Name, expectedURLs[e.Name], e.Url)
+		}
+	}
+
+	cms := &corev1.ConfigMapList{}
+	if err = cl.List(context.TODO(), cms, client.InNamespace("ns")); err != nil {
+		t.Fatal(err)
+	}
+
+	gateway, err := traefik.GatewayFromConfigMaps(cms.Items...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, url := range expectedURLs {
+		ex, err := gateway.ExplainURL(url)
+		if err != nil {
+			t.Errorf("The URL of the endpoint %s is not routed: %s", name, err)
+			continue
+		}
+		if !strings.HasPrefix(ex.Backend, "http://wsid-service.ws.svc:9999/") {
+			t.Errorf("The endpoint %s should have been routed to the workspace service but was routed to '%s'", name, ex.Backend)
+		}
+	}
+
+	// the paths of the default scheme are redirected, so that che-theia keeps working
+	ex, err := gateway.ExplainURL("https://over.the.rainbow/wsid/m1/9999/1/?a=b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ex.Redirect != "https://over.the.rainbow/ws/my-workspace/m1/e1/1/?a=b" {
+		t.Errorf("Unexpected redirect of the path of the default scheme: '%s'", ex.Redirect)
+	}
+}
+
+func TestWorkspaceNamePathsDontCollideWithOtherWorkspaces(t *testing.T) {
+	owner := []metav1.OwnerReference{*metav1.NewControllerRef(&dw.DevWorkspace{ObjectMeta: metav1.ObjectMeta{Name: "my-workspace"}}, dw.SchemeGroupVersion.WithKind("DevWorkspace"))}
+
+	routing := simpleWorkspaceRouting()
+	routing.OwnerReferences = owner
+
+	_, solver, _, err := getSpecObjectsWithManager(t, routing, v1alpha1.CheManagerSpec{
+		Host:                "over.the.rainbow",
+		WorkspacePathScheme: v1alpha1.WorkspaceNamePathScheme,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// e.g. a new workspace with the same name while the routing of the deleted one still exists
+	other := simpleWorkspaceRouting()
+	other.Name = "other-routing"
+	other.Spec.WorkspaceId = "otherws"
+	other.OwnerReferences = owner
+
+	_, err = solver.GetSpecObjects(other, solvers.WorkspaceMetadata{WorkspaceId: "otherws", Namespace: "ws"})
+
+	var invalid *solvers.RoutingInvalid
+	if !errors.As(err, &invalid) {
+		t.Fatalf("Expected the routing to be invalid but got: %v", err)
+	}
+	if !strings.Contains(invalid.Reason, "the path /ws/my-workspace/ is already used by the workspace wsid") {
+		t.Errorf("Unexpected reason: %s", invalid.Reason)
+	}
+}
+
+func TestCustomHostsAreClaimedBySingleWorkspace(t *testing.T) {
+	routing := simpleWorkspaceRouting()
+	routing.UID = "routing-uid"
+	routing.Annotations = map[string]string{
+		CustomHostsAnnotation: "myproject.dev.example.com",
+	}
+
+	cl, solver, _, err := getSpecObjectsWithManager(t, routing, v1alpha1.CheManagerSpec{
+		Host:        "over.the.rainbow",
+		CustomHosts: v1alpha1.CustomHostsSpec{AllowedDomains: []string{"example.com"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	other := simpleWorkspaceRouting()
+	other.Name = "other-routing"
+	other.UID = "other-routing-uid"
+	other.Spec.WorkspaceId = "otherws"
+	other.Annotations = map[string]string{
+		CustomHostsAnnotation: "myproject.dev.example.com",
+	}
+	otherMeta := solvers.WorkspaceMetadata{WorkspaceId: "otherws", Namespace: "ws"}
+
+	_, err = solver.GetSpecObjects(other, otherMeta)
+
+	var invalid *solvers.RoutingInvalid
+	if !errors.As(err, &invalid) {
+		t.Fatalf("Expected the routing to be invalid but got: %v", err)
+	}
+	if !strings.Contains(invalid.Reason, "the custom host myproject.dev.example.com is already used by the workspace wsid") {
+		t.Errorf("Unexpected reason: %s", invalid.Reason)
+	}
+
+	// the claim is released once the first workspace no longer uses the host
+	routing.Annotations = nil
+	if _, err = solver.GetSpecObjects(routing, solvers.WorkspaceMetadata{WorkspaceId: "wsid", Namespace: "ws"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = solver.GetSpecObjects(other, otherMeta); err != nil {
+		t.Fatalf("The custom host should have been claimed by the other workspace: %s", err)
+	}
+
+	claim := &corev1.ConfigMap{}
+	key := client.ObjectKey{Name: getClaimConfigMapName(&v1alpha1.CheManager{ObjectMeta: metav1.ObjectMeta{Name: "che"}}, "host:myproject.dev.example.com"), Namespace: "ns"}
+	if err = cl.Get(context.TODO(), key, claim); err != nil {
+		t.Fatal(err)
+	}
+	if claim.Labels[config.WorkspaceIDLabel] != "otherws" {
+		t.Errorf("The custom host should have been claimed by the other workspace but was claimed by %s", claim.Labels[config.WorkspaceIDLabel])
+	}
+}
+
+func TestCustomHosts(t *testing.T) {
+	routing := simpleWorkspaceRouting()
+	routing.Annotations = map[string]string{
+		CustomHostsAnnotation:          "myproject.dev.example.com, Other.Example.com",
+		CustomHostsTLSSecretAnnotation: "custom-tls",
+	}
+
+	tlsSecret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "custom-tls",
+			Namespace: "ws",
+		},
+		Type: corev1.SecretTypeTLS,
+		Data: map[string][]byte{
+			corev1.TLSCertKey:       []byte("cert"),
+			corev1.TLSPrivateKeyKey: []byte("key"),
+		},
+	}
+
+	cl, solver, objs, err := getSpecObjectsWithManager(t, routing, v1alpha1.CheManagerSpec{
+		Host:        "over.the.rainbow",
+		CustomHosts: v1alpha1.CustomHostsSpec{AllowedDomains: []string{"dev.example.com", "other.example.com"}},
+	}, tlsSecret)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ingress := &extensions.Ingress{}
+	if err := cl.Get(context.TODO(), client.ObjectKey{Name: "wsid-custom-hosts", Namespace: "ns"}, ingress); err != nil {
+		t.Fatalf("The ingress of the custom hosts should have been created: %s", err)
+	}
+
+	if len(ingress.Spec.Rules) != 2 || ingress.Spec.Rules[0].Host != "myproject.dev.example.com" || ingress.Spec.Rules[1].Host != "other.example.com" {
+		t.Errorf("Unexpected rules of the custom hosts ingress: %v", ingress.Spec.Rules)
+	}
+
+	// the TLS secret of the workspace is copied next to the ingress
+	if len(ingress.Spec.TLS) != 1 || ingress.Spec.TLS[0].SecretName != "wsid-custom-hosts-tls" {
+		t.Errorf("The ingress should have used the copy of the TLS secret of the custom hosts: %v", ingress.Spec.TLS)
+	}
+	tlsCopy := &corev1.Secret{}
+	if err = cl.Get(context.TODO(), client.ObjectKey{Name: "wsid-custom-hosts-tls", Namespace: "ns"}, tlsCopy); err != nil {
+		t.Fatalf("The TLS secret of the workspace should have been copied: %s", err)
+	}
+	if string(tlsCopy.Data[corev1.TLSCertKey]) != "cert" || string(tlsCopy.Data[corev1.TLSPrivateKeyKey]) != "key" {
+		t.Errorf("Unexpected data of the copy of the TLS secret: %v", tlsCopy.Data)
+	}
+	if backend := ingress.Spec.Rules[0].HTTP.Paths[0].Backend; backend.ServiceName != "che" {
+		t.Errorf("The custom hosts should have been routed to the gateway but were routed to %s", backend.ServiceName)
+	}
+
+	exposed, _, err := solver.GetExposedEndpoints(routing.Spec.Endpoints, objs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e1 := exposed["m1"][0]
+	if e1.Url != "https://over.the.rainbow/wsid/m1/9999/1/" {
+		t.Errorf("The custom hosts should not have changed the main URL of the endpoint but it is %s", e1.Url)
+	}
+
+	var urls []string
+	if err = e1.Attributes.GetInto(CustomHostURLsAttribute, &urls); err != nil {
+		t.Fatal(err)
+	}
+	expectedURLs := []string{"https://myproject.dev.example.com/wsid/m1/9999/1/", "https://other.example.com/wsid/m1/9999/1/"}
+	if len(urls) != 2 || urls[0] != expectedURLs[0] || urls[1] != expectedURLs[1] {
+		t.Errorf("Expected the custom host URLs %v but got %v", expectedURLs, urls)
+	}
+
+	cms := &corev1.ConfigMapList{}
+	if err = cl.List(context.TODO(), cms, client.InNamespace("ns")); err != nil {
+		t.Fatal(err)
+	}
+
+	gateway, err := traefik.GatewayFromConfigMaps(cms.Items...)
+	if err != nil {
+		t.Fatalf("The gateway configuration is invalid: %s", err)
+	}
+
+	ex, err := gateway.ExplainURL(urls[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ex.RouterName != "wsid-m1-9999-custom-hosts" {
+		t.Errorf("The custom host should have been routed by its own router but was routed by %s", ex.RouterName)
+	}
+	if ex.Backend != "http://wsid-service.ws.svc:9999/1/" {
+		t.Errorf("Unexpected backend of the custom host URL: %s", ex.Backend)
+	}
+
+	if err = solver.Finalize(routing); err != nil {
+		t.Fatal(err)
+	}
+
+	ingresses := &extensions.IngressList{}
+	if err = cl.List(context.TODO(), ingresses, client.InNamespace("ns")); err != nil {
+		t.Fatal(err)
+	}
+	for _, ing := range ingresses.Items {
+		if ing.Name == "wsid-custom-hosts" {
+			t.Error("The ingress of the custom hosts should have been deleted during the finalization")
+		}
+	}
+	if err = cl.Get(context.TODO(), client.ObjectKey{Name: "wsid-custom-hosts-tls", Namespace: "ns"}, &corev1.Secret{}); !k8serrors.IsNotFound(err) {
+		t.Error("The copy of the TLS secret should have been deleted during the finalization")
+	}
+}
+
+func TestCustomHostsWithSharedTLSSecret(t *testing.T) {
+	routing := simpleWorkspaceRouting()
+	routing.Annotations = map[string]string{
+		CustomHostsAnnotation:          "myproject.dev.example.com",
+		CustomHostsTLSSecretAnnotation: "wildcard-tls",
+	}
+
+	cl, _, _, err := getSpecObjectsWithManager(t, routing, v1alpha1.CheManagerSpec{
+		Host: "over.the.rainbow",
+		CustomHosts: v1alpha1.CustomHostsSpec{
+			AllowedDomains:   []string{"dev.example.com"},
+			SharedTLSSecrets: []string{"wildcard-tls"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ingress := &extensions.Ingress{}
+	if err := cl.Get(context.TODO(), client.ObjectKey{Name: "wsid-custom-hosts", Namespace: "ns"}, ingress); err != nil {
+		t.Fatalf("The ingress of the custom hosts should have been created: %s", err)
+	}
+	if len(ingress.Spec.TLS) != 1 || ingress.Spec.TLS[0].SecretName != "wildcard-tls" {
+		t.Errorf("The ingress should have used the shared TLS secret: %v", ingress.Spec.TLS)
+	}
+	if err = cl.Get(context.TODO(), client.ObjectKey{Name: "wsid-custom-hosts-tls", Namespace: "ns"}, &corev1.Secret{}); !k8serrors.IsNotFound(err) {
+		t.Error("The shared TLS secret should not have been copied")
+	}
+}
+
+// listRecordingReader records the kinds of the lists read through it
+type listRecordingReader struct {
+	client.Reader
+	lists []string
+}
+
+func (r *listRecordingReader) List(ctx context.Context, list runtime.Object, opts ...client.ListOption) error {
+	r.lists = append(r.lists, reflect.TypeOf(list).Elem().Name())
+	return r.Reader.List(ctx, list, opts...)
+}
+
+func TestCustomHostsAccessIsOnlyPrunedForWorkspacesWithCustomHosts(t *testing.T) {
+	scheme := createTestScheme()
+	cheManager := &v1alpha1.CheManager{
+		ObjectMeta: metav1.ObjectMeta{Name: "che", Namespace: "ns", Finalizers: []string{manager.FinalizerName}},
+		Spec: v1alpha1.CheManagerSpec{
+			Host:        "over.the.rainbow",
+			CustomHosts: v1alpha1.CustomHostsSpec{AllowedDomains: []string{"dev.example.com"}},
+		},
+	}
+	cl := fake.NewFakeClientWithScheme(scheme, cheManager)
+	reader := &listRecordingReader{Reader: cl}
+
+	solver, err := Getter(scheme, reader, nil).GetSolver(cl, "che")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cheRecon := manager.New(cl, scheme)
+	if _, err = cheRecon.Reconcile(reconcile.Request{NamespacedName: types.NamespacedName{Name: "che", Namespace: "ns"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	routing := simpleWorkspaceRouting()
+	// the objects are pruned by the UID of the routing
+	routing.UID = "routing-uid"
+	meta := solvers.WorkspaceMetadata{WorkspaceId: routing.Spec.WorkspaceId, Namespace: routing.GetNamespace(), PodSelector: routing.Spec.PodSelector}
+
+	if _, err = solver.GetSpecObjects(routing, meta); err != nil {
+		t.Fatal(err)
+	}
+	for _, l := range reader.lists {
+		if l == "IngressList" || l == "SecretList" {
+			t.Errorf("The %s should not have been read for a workspace without custom hosts", l)
+		}
+	}
+
+	routing.Annotations = map[string]string{CustomHostsAnnotation: "myproject.dev.example.com"}
+	if _, err = solver.GetSpecObjects(routing, meta); err != nil {
+		t.Fatal(err)
+	}
+	if err = cl.Get(context.TODO(), client.ObjectKey{Name: "wsid-custom-hosts", Namespace: "ns"}, &extensions.Ingress{}); err != nil {
+		t.Fatalf("The ingress of the custom hosts should have been created: %s", err)
+	}
+
+	// the workspace had custom hosts before, so their ingress needs to be pruned
+	routing.Annotations = nil
+	if _, err = solver.GetSpecObjects(routing, meta); err != nil {
+		t.Fatal(err)
+	}
+	if err = cl.Get(context.TODO(), client.ObjectKey{Name: "wsid-custom-hosts", Namespace: "ns"}, &extensions.Ingress{}); !k8serrors.IsNotFound(err) {
+		t.Error("The ingress of the custom hosts should have been pruned once the workspace stopped using them")
+	}
+
+	reader.lists = nil
+	if _, err = solver.GetSpecObjects(routing, meta); err != nil {
+		t.Fatal(err)
+	}
+	for _, l := range reader.lists {
+		if l == "IngressList" || l == "SecretList" {
+			t.Errorf("The %s should not have been read once the custom hosts were pruned", l)
+		}
+	}
+}
+
+func TestRejectsInvalidCustomHosts(t *testing.T) {
+	tests := map[string]struct {
+		hosts          string
+		allowedDomains []string
+		reason         string
+	}{
+		"malformed": {
+			hosts:          "myproject.dev.example.com,not_a_host",
+			allowedDomains: []string{"example.com"},
+			reason:         "'not_a_host' is not a valid host name",
+		},
+		"noAllowedDomains": {
+			hosts:  "myproject.dev.example.com",
+			reason: "the Che manager doesn't allow any custom hosts",
+		},
+		"outsideAllowedDomains": {
+			hosts:          "myproject.dev.example.com,team.example.org",
+			allowedDomains: []string{"example.com"},
+			reason:         "'team.example.org' is not allowed: it is not in any of the domains",
+		},
+		// the domain is only matched as a whole
+		"suffixOfAllowedDomain": {
+			hosts:          "evilexample.com",
+			allowedDomains: []string{"example.com"},
+			reason:         "'evilexample.com' is not allowed",
+		},
+		"gatewayHost": {
+			hosts:          "Over.The.Rainbow",
+			allowedDomains: []string{"rainbow"},
+			reason:         "'over.the.rainbow' is not allowed: it is the host of the Che gateway",
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			routing := simpleWorkspaceRouting()
+			routing.Annotations = map[string]string{
+				CustomHostsAnnotation: test.hosts,
+			}
+
+			_, _, _, err := getSpecObjectsWithManager(t, routing, v1alpha1.CheManagerSpec{
+				Host:        "over.the.rainbow",
+				CustomHosts: v1alpha1.CustomHostsSpec{AllowedDomains: test.allowedDomains},
+			})
+
+			var invalid *solvers.RoutingInvalid
+			if !errors.As(err, &invalid) {
+				t.Fatalf("Expected the routing to be invalid but got: %v", err)
+			}
+			if !strings.Contains(invalid.Reason, test.reason) {
+				t.Errorf("Expected the reason to contain \"%s\" but it was: %s", test.reason, invalid.Reason)
+			}
+		})
+	}
+}
+
+func TestEndpointResilienceAttributes(t *testing.T) {
+	routing := simpleWorkspaceRouting()
+	routing.Spec.Endpoints["m1"] = append(routing.Spec.Endpoints["m1"], dw.Endpoint{
+		Name:       "debug",
+		TargetPort: 8888,
+		Exposure:   dw.PublicEndpointExposure,
+		Attributes: attributes.Attributes{}.
+			PutString(timeoutEndpointAttributeName, "2h").
+			PutInteger(retriesEndpointAttributeName, 4).
+			PutString(circuitBreakerEndpointAttributeName, "NetworkErrorRatio() > 0.3"),
+	})
+
+	cl, _, _ := getSpecObjects(t, routing)
+
+	cms := &corev1.ConfigMapList{}
+	if err := cl.List(context.TODO(), cms, client.InNamespace("ns")); err != nil {
+		t.Fatal(err)
+	}
+
+	gateway, err := traefik.GatewayFromConfigMaps(cms.Items...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ex, err := gateway.ExplainURL("http://over.the.rainbow/wsid/m1/8888/")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if ex.TransportName != "wsid-m1-8888-transport" || ex.Transport.ForwardingTimeouts == nil || ex.Transport.ForwardingTimeouts.ResponseHeaderTimeout != "2h0m0s" {
+		t.Errorf("The timeout of the endpoint should have been applied using a servers transport but was: %s %+v", ex.TransportName, ex.Transport)
+	}
+
+	var names []string
+	for _, m := range ex.Middlewares {
+		names = append(names, m.Name)
+	}
+	expected := []string{"wsid-m1-8888", "wsid-m1-8888-circuit-breaker", "wsid-m1-8888-retry"}
+	if strings.Join(names, ",") != strings.Join(expected, ",") {
+		t.Fatalf("Expected the middlewares %v but got %v", expected, names)
+	}
+	if ex.Middlewares[1].Middleware.CircuitBreaker.Expression != "NetworkErrorRatio() > 0.3" {
+		t.Errorf("Unexpected circuit breaker: %+v", ex.Middlewares[1].Middleware.CircuitBreaker)
+	}
+	if ex.Middlewares[2].Middleware.Retry.Attempts != 4 {
+		t.Errorf("Unexpected retry: %+v", ex.Middlewares[2].Middleware.Retry)
+	}
+
+	// the endpoints without the attributes are not affected
+	ex, err = gateway.ExplainURL("http://over.the.rainbow/wsid/m1/9999/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ex.TransportName != "" || len(ex.Middlewares) != 1 {
+		t.Errorf("The endpoint without the attributes should have been routed with the defaults: %+v", ex)
+	}
+}
+
+func TestEndpointRewritingAttributes(t *testing.T) {
+	var err error
+	routing := simpleWorkspaceRouting()
+	routing.Spec.Endpoints["m1"] = append(routing.Spec.Endpoints["m1"], dw.Endpoint{
+		Name:       "app",
+		TargetPort: 8888,
+		Exposure:   dw.PublicEndpointExposure,
+		Attributes: attributes.Attributes{}.
+			PutString(addPrefixEndpointAttributeName, "/context").
+			PutBoolean(forwardedPrefixEndpointAttributeName, false).
+			Put(responseHeadersEndpointAttributeName, map[string]string{"X-Frame-Options": "SAMEORIGIN"}, &err).
+			Put(redirectsEndpointAttributeName, []map[string]string{
+				{"regex": "^(https?://[^/]+/wsid/m1/8888)/?$", "replacement": "${1}/index.html"},
+			}, &err),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cl, _, _ := getSpecObjects(t, routing)
+
+	cms := &corev1.ConfigMapList{}
+	if err = cl.List(context.TODO(), cms, client.InNamespace("ns")); err != nil {
+		t.Fatal(err)
+	}
+
+	gateway, err := traefik.GatewayFromConfigMaps(cms.Items...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ex, err := gateway.ExplainURL("http://over.the.rainbow/wsid/m1/8888/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ex.Redirect != "http://over.the.rainbow/wsid/m1/8888/index.html" {
+		t.Errorf("The request should have been redirected but the explanation is %+v", ex)
+	}
+
+	ex, err = gateway.ExplainURL("http://over.the.rainbow/wsid/m1/8888/index.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ex.Backend != "http://wsid-service.ws.svc:8888/context/index.html" {
+		t.Errorf("The prefix should have been added to the path of the request but the backend is %s", ex.Backend)
+	}
+
+	var names []string
+	for _, m := range ex.Middlewares {
+		names = append(names, m.Name)
+	}
+	expected := []string{"wsid-m1-8888-redirects-0", "wsid-m1-8888", "wsid-m1-8888-add-prefix", "wsid-m1-8888-headers"}
+	if strings.Join(names, ",") != strings.Join(expected, ",") {
+		t.Fatalf("Expected the middlewares %v but got %v", expected, names)
+	}
+
+	headers := ex.Middlewares[3].Middleware.Headers
+	if v, ok := headers.CustomRequestHeaders["X-Forwarded-Prefix"]; !ok || v != "" {
+		t.Errorf("The X-Forwarded-Prefix header should have been removed from the requests: %+v", headers)
+	}
+	if headers.CustomResponseHeaders["X-Frame-Options"] != "SAMEORIGIN" {
+		t.Errorf("The response header should have been set: %+v", headers)
+	}
+}
+
+func TestRejectsCookiePathRewritingWithoutSidecar(t *testing.T) {
+	routing := simpleWorkspaceRouting()
+	routing.Spec.Endpoints["m1"][0].Attributes = attributes.Attributes{}.PutBoolean(cookiePathRewriteEndpointAttributeName, true)
+
+	_, _, _, err := getSpecObjectsWithManager(t, routing, v1alpha1.CheManagerSpec{Host: "over.the.rainbow"})
+
+	var invalid *solvers.RoutingInvalid
+	if !errors.As(err, &invalid) {
+		t.Fatalf("Expected the routing to be invalid but got: %v", err)
+	}
+	if !strings.Contains(invalid.Reason, "spec.gateway.cookiePathRewriting") {
+		t.Errorf("The reason should have told how to enable the cookie rewriting: %s", invalid.Reason)
+	}
+}
+
+func TestCookiePathRewriting(t *testing.T) {
+	os.Setenv("RELATED_IMAGE_gateway_cookie_rewriter", "quay.io/che-incubator/devworkspace-che-operator:test")
+	defer os.Unsetenv("RELATED_IMAGE_gateway_cookie_rewriter")
+
+	routing := simpleWorkspaceRouting()
+	routing.Spec.Endpoints["m1"][2].Attributes = attributes.Attributes{}.
+		PutBoolean(uniqueEndpointAttributeName, true).
+		PutBoolean(cookiePathRewriteEndpointAttributeName, true).
+		PutString(addPrefixEndpointAttributeName, "/app")
+
+	cl, _, _, err := getSpecObjectsWithManager(t, routing, v1alpha1.CheManagerSpec{
+		Host: "over.the.rainbow",
+		Gateway: v1alpha1.GatewaySpec{
+			CookiePathRewriting: true,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cms := &corev1.ConfigMapList{}
+	if err := cl.List(context.TODO(), cms, client.InNamespace("ns")); err != nil {
+		t.Fatal(err)
+	}
+
+	gateway, err := traefik.GatewayFromConfigMaps(cms.Items...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ex, err := gateway.ExplainURL("http://over.the.rainbow/wsid/m1/e3/login")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ex.Backend != "http://127.0.0.1:8092/app/login" {
+		t.Errorf("The request should have been forwarded to the cookie rewriting sidecar but the backend is %s", ex.Backend)
+	}
+
+	var headers map[string]string
+	for _, m := range ex.Middlewares {
+		if m.Name == "wsid-m1-9999-e3-cookie-rewrite" {
+			headers = m.Middleware.Headers.CustomRequestHeaders
+		}
+	}
+	expected := map[string]string{
+		"X-Che-Cookie-Rewrite-Backend":        "http://wsid-service.ws.svc:9999",
+		"X-Che-Cookie-Rewrite-Public-Prefix":  "/wsid/m1/e3",
+		"X-Che-Cookie-Rewrite-Backend-Prefix": "/app",
+	}
+	if !reflect.DeepEqual(headers, expected) {
+		t.Errorf("Unexpected headers telling the sidecar how to rewrite the cookies: %v", headers)
+	}
+
+	// the other endpoints are forwarded directly
+	ex, err = gateway.ExplainURL("http://over.the.rainbow/wsid/m1/9999/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(ex.Backend, "http://wsid-service.ws.svc:9999") {
+		t.Errorf("The endpoint without the cookie path rewriting should have been forwarded directly but the backend is %s", ex.Backend)
+	}
+}
+
+func TestCorsPolicy(t *testing.T) {
+	var err error
+	routing := simpleWorkspaceRouting()
+	routing.Spec.Endpoints["m1"] = append(routing.Spec.Endpoints["m1"], dw.Endpoint{
+		Name:       "private",
+		TargetPort: 8888,
+		Exposure:   dw.PublicEndpointExposure,
+		Attributes: attributes.Attributes{}.Put(corsEndpointAttributeName, map[string]interface{}{}, &err),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cl, _, _, err := getSpecObjectsWithManager(t, routing, v1alpha1.CheManagerSpec{
+		Host: "over.the.rainbow",
+		Gateway: v1alpha1.GatewaySpec{
+			Cors: &v1alpha1.CorsPolicy{
+				AllowedOrigins:   []string{"https://dashboard.example.com"},
+				AllowCredentials: true,
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cms := &corev1.ConfigMapList{}
+	if err = cl.List(context.TODO(), cms, client.InNamespace("ns")); err != nil {
+		t.Fatal(err)
+	}
+
+	gateway, err := traefik.GatewayFromConfigMaps(cms.Items...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ex, err := gateway.ExplainURL("http://over.the.rainbow/wsid/m1/9999/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ex.Middlewares) == 0 || ex.Middlewares[0].Name != "wsid-m1-9999-cors" {
+		t.Fatalf("The CORS middleware should have been applied first but the middlewares are %+v", ex.Middlewares)
+	}
+	headers := ex.Middlewares[0].Middleware.Headers
+	if len(headers.AccessControlAllowOriginList) != 1 || headers.AccessControlAllowOriginList[0] != "https://dashboard.example.com" ||
+		!headers.AccessControlAllowCredentials || len(headers.AccessControlAllowMethods) == 0 {
+		t.Errorf("The CORS policy of the Che manager should have been applied: %+v", headers)
+	}
+
+	// the endpoint overriding the policy without any origins doesn't allow any cross-origin requests
+	ex, err = gateway.ExplainURL("http://over.the.rainbow/wsid/m1/8888/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, m := range ex.Middlewares {
+		if m.Middleware.Headers != nil && len(m.Middleware.Headers.AccessControlAllowOriginList) > 0 {
+			t.Errorf("The endpoint should not have allowed any cross-origin requests: %+v", m)
+		}
+	}
+}
+
+func TestRejectsInvalidCorsPolicyOfManager(t *testing.T) {
+	_, _, _, err := getSpecObjectsWithManager(t, simpleWorkspaceRouting(), v1alpha1.CheManagerSpec{
+		Host: "over.the.rainbow",
+		Gateway: v1alpha1.GatewaySpec{
+			Cors: &v1alpha1.CorsPolicy{
+				AllowedOrigins:   []string{"*"},
+				AllowCredentials: true,
+			},
+		},
+	})
+
+	var invalid *solvers.RoutingInvalid
+	if !errors.As(err, &invalid) {
+		t.Fatalf("Expected the routing to be invalid but got: %v", err)
+	}
+	if !strings.Contains(invalid.Reason, "CORS policy of the Che manager") {
+		t.Errorf("Unexpected reason: %s", invalid.Reason)
+	}
+}
+
+func TestAccessPolicy(t *testing.T) {
+	var err error
+	routing := simpleWorkspaceRouting()
+	routing.Annotations = map[string]string{
+		IPAllowListAnnotation: "10.1.0.0/16, 192.168.1.7",
+		RateLimitAnnotation:   `{"average": 0}`,
+	}
+	routing.Spec.Endpoints["m1"] = append(routing.Spec.Endpoints["m1"],
+		dw.Endpoint{
+			Name:       "public",
+			TargetPort: 8888,
+			Exposure:   dw.PublicEndpointExposure,
+			Attributes: attributes.Attributes{}.
+				Put(ipAllowListEndpointAttributeName, []string{}, &err).
+				Put(rateLimitEndpointAttributeName, map[string]int{"average": 5, "burst": 10}, &err),
+		},
+		dw.Endpoint{
+			Name:       "wider",
+			TargetPort: 7777,
+			Exposure:   dw.PublicEndpointExposure,
+			Attributes: attributes.Attributes{}.
+				Put(ipAllowListEndpointAttributeName, []string{"0.0.0.0/0"}, &err).
+				Put(rateLimitEndpointAttributeName, map[string]int{"average": 1000, "burst": 1000}, &err),
+		},
+		dw.Endpoint{
+			Name:       "averageOnly",
+			TargetPort: 6666,
+			Exposure:   dw.PublicEndpointExposure,
+			Attributes: attributes.Attributes{}.
+				Put(rateLimitEndpointAttributeName, map[string]int{"average": 10}, &err),
+		})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cl, _, _, err := getSpecObjectsWithManager(t, routing, v1alpha1.CheManagerSpec{
+		Host: "over.the.rainbow",
+		Gateway: v1alpha1.GatewaySpec{
+			IPAllowList:                []string{"10.0.0.0/8", "172.16.0.0/12"},
+			RateLimit:                  &v1alpha1.RateLimit{Average: 100, Burst: 50},
+			ForwardedHeadersTrustedIPs: []string{"10.128.0.0/14"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cms := &corev1.ConfigMapList{}
+	if err = cl.List(context.TODO(), cms, client.InNamespace("ns")); err != nil {
+		t.Fatal(err)
+	}
+
+	gateway, err := traefik.GatewayFromConfigMaps(cms.Items...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string]struct {
+		url         string
+		sourceRange string
+		average     int64
+		burst       int64
+	}{
+		// the workspace can only narrow the IP ranges of the manager and cannot switch off its rate limit
+		"workspace": {url: "http://over.the.rainbow/wsid/m1/9999/", sourceRange: "10.1.0.0/16", average: 100, burst: 50},
+		// an empty IP allow list of the endpoint keeps the one of the workspace, the lower rate limit applies
+		"endpoint": {url: "http://over.the.rainbow/wsid/m1/8888/", sourceRange: "10.1.0.0/16", average: 5, burst: 10},
+		// the endpoint cannot widen the access
+		"widerEndpoint": {url: "http://over.the.rainbow/wsid/m1/7777/", sourceRange: "10.1.0.0/16", average: 100, burst: 50},
+		// the endpoint that doesn't set the burst keeps the burst of the manager
+		"averageOnlyEndpoint": {url: "http://over.the.rainbow/wsid/m1/6666/", sourceRange: "10.1.0.0/16", average: 10, burst: 50},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			ex, err := gateway.ExplainURL(test.url)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(ex.Middlewares) < 2 {
+				t.Fatalf("The access policy should have been enforced: %+v", ex.Middlewares)
+			}
+			ips := ex.Middlewares[0].Middleware.IPWhiteList
+			if ips == nil || strings.Join(ips.SourceRange, ",") != test.sourceRange || ips.IPStrategy == nil || ips.IPStrategy.Depth != 1 {
+				t.Errorf("The IP allow list %s should have been applied first: %+v", test.sourceRange, ex.Middlewares[0])
+			}
+			if rate := ex.Middlewares[1].Middleware.RateLimit; rate == nil || rate.Average != test.average || rate.Burst != test.burst {
+				t.Errorf("The rate limit %d/%d should have been applied: %+v", test.average, test.burst, ex.Middlewares[1])
+			}
+		})
+	}
+}
+
+func TestRejectsAccessPolicyNotOverlappingWithManager(t *testing.T) {
+	routing := simpleWorkspaceRouting()
+	routing.Annotations = map[string]string{
+		IPAllowListAnnotation: "192.168.0.0/16",
+	}
+
+	_, _, _, err := getSpecObjectsWithManager(t, routing, v1alpha1.CheManagerSpec{
+		Host: "over.the.rainbow",
+		Gateway: v1alpha1.GatewaySpec{
+			IPAllowList: []string{"10.0.0.0/8"},
+		},
+	})
+
+	var invalid *solvers.RoutingInvalid
+	if !errors.As(err, &invalid) {
+		t.Fatalf("Expected the routing to be invalid but got: %v", err)
+	}
+	if !strings.Contains(invalid.Reason, "doesn't overlap") {
+		t.Errorf("Unexpected reason: %s", invalid.Reason)
+	}
+}
+
+func TestIPAllowListRequiresTrustedProxies(t *testing.T) {
+	// without the trusted proxies, the gateway accepts the X-Forwarded-For header from anyone, so a pod calling
+	// the gateway service directly could forge the IP address it is checked against
+	var err error
+	endpointRouting := simpleWorkspaceRouting()
+	endpointRouting.Spec.Endpoints["m1"][0].Attributes = attributes.Attributes{}.
+		Put(ipAllowListEndpointAttributeName, []string{"10.0.0.0/8"}, &err)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	annotatedRouting := simpleWorkspaceRouting()
+	annotatedRouting.Annotations = map[string]string{
+		IPAllowListAnnotation: "10.0.0.0/8",
+	}
+
+	tests := map[string]struct {
+		routing *dwo.WorkspaceRouting
+		gateway v1alpha1.GatewaySpec
+	}{
+		"manager":   {routing: simpleWorkspaceRouting(), gateway: v1alpha1.GatewaySpec{IPAllowList: []string{"10.0.0.0/8"}}},
+		"workspace": {routing: annotatedRouting},
+		"endpoint":  {routing: endpointRouting},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, _, _, err := getSpecObjectsWithManager(t, test.routing, v1alpha1.CheManagerSpec{
+				Host:    "over.the.rainbow",
+				Gateway: test.gateway,
+			})
+
+			var invalid *solvers.RoutingInvalid
+			if !errors.As(err, &invalid) {
+				t.Fatalf("Expected the routing to be invalid but got: %v", err)
+			}
+			if !strings.Contains(invalid.Reason, "forwardedHeadersTrustedIPs") {
+				t.Errorf("Unexpected reason: %s", invalid.Reason)
+			}
+
+			test.gateway.ForwardedHeadersTrustedIPs = []string{"10.128.0.0/14"}
+			if _, _, _, err = getSpecObjectsWithManager(t, test.routing, v1alpha1.CheManagerSpec{
+				Host:    "over.the.rainbow",
+				Gateway: test.gateway,
+			}); err != nil {
+				t.Errorf("The IP allow list should have been accepted with the trusted proxies: %s", err)
+			}
+		})
+	}
+}
+
+func TestRejectsInvalidAccessPolicy(t *testing.T) {
+	routing := simpleWorkspaceRouting()
+	routing.Annotations = map[string]string{
+		RateLimitAnnotation: "fast",
+	}
+
+	_, _, _, err := getSpecObjectsWithManager(t, routing, v1alpha1.CheManagerSpec{Host: "over.the.rainbow"})
+
+	var invalid *solvers.RoutingInvalid
+	if !errors.As(err, &invalid) {
+		t.Fatalf("Expected the routing to be invalid but got: %v", err)
+	}
+	if !strings.Contains(invalid.Reason, RateLimitAnnotation) {
+		t.Errorf("Unexpected reason: %s", invalid.Reason)
+	}
+
+	routing.Annotations = map[string]string{
+		IPAllowListAnnotation: "10.0.0.0/8,everywhere",
+	}
+
+	_, _, _, err = getSpecObjectsWithManager(t, routing, v1alpha1.CheManagerSpec{Host: "over.the.rainbow"})
+	if !errors.As(err, &invalid) {
+		t.Fatalf("Expected the routing to be invalid but got: %v", err)
+	}
+	if !strings.Contains(invalid.Reason, "'everywhere'") {
+		t.Errorf("Unexpected reason: %s", invalid.Reason)
+	}
+}
+
+func TestNetworkPolicyOfIsolatedWorkspace(t *testing.T) {
+	routing := simpleWorkspaceRouting()
+	routing.UID = "routing-uid"
+	routing.Spec.PodSelector = map[string]string{"controller.devfile.io/workspace_id": "wsid"}
+	routing.Spec.Endpoints["m1"] = append(routing.Spec.Endpoints["m1"],
+		dw.Endpoint{
+			Name:       "internal",
+			TargetPort: 7777,
+			Exposure:   dw.InternalEndpointExposure,
+		},
+		dw.Endpoint{
+			Name:       "none",
+			TargetPort: 6666,
+			Exposure:   dw.NoneEndpointExposure,
+		},
+		dw.Endpoint{
+			Name:       "internal-on-public-port",
+			TargetPort: 9999,
+			Exposure:   dw.InternalEndpointExposure,
+		})
+
+	cl, solver, _, err := getSpecObjectsWithManager(t, routing, v1alpha1.CheManagerSpec{
+		Host: "over.the.rainbow",
+		Gateway: v1alpha1.GatewaySpec{
+			IsolateWorkspaces: true,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.TODO()
+
+	networkPolicy := &networkingv1.NetworkPolicy{}
+	if err = cl.Get(ctx, client.ObjectKey{Name: "wsid-gateway-access", Namespace: "ws"}, networkPolicy); err != nil {
+		t.Fatalf("The network policy should have been created in the namespace of the workspace: %s", err)
+	}
+
+	if !reflect.DeepEqual(networkPolicy.Spec.PodSelector.MatchLabels, routing.Spec.PodSelector) {
+		t.Errorf("The network policy should select the workspace pods but it selects %v", networkPolicy.Spec.PodSelector.MatchLabels)
+	}
+
+	portsOf := func(rule networkingv1.NetworkPolicyIngressRule) []int {
+		var ports []int
+		for _, p := range rule.Ports {
+			ports = append(ports, p.Port.IntValue())
+		}
+		return ports
+	}
+
+	rules := networkPolicy.Spec.Ingress
+	if len(rules) != 2 {
+		t.Fatalf("The network policy should have 2 ingress rules but has %d", len(rules))
+	}
+
+	if len(rules[0].From) != 1 || rules[0].From[0].NamespaceSelector.MatchLabels["kubernetes.io/metadata.name"] != "ns" ||
+		len(rules[0].From[0].PodSelector.MatchLabels) == 0 {
+		t.Errorf("The public endpoints should only be reachable from the gateway pods: %v", rules[0].From)
+	}
+	if ports := portsOf(rules[0]); !reflect.DeepEqual(ports, []int{9999}) {
+		t.Errorf("The gateway should be able to reach the public endpoints only but the ports are %v", ports)
+	}
+
+	if len(rules[1].From) != 0 {
+		t.Errorf("The internal endpoints should be reachable from anywhere: %v", rules[1].From)
+	}
+	// the port shared with a public endpoint must not be opened to anyone, otherwise the gateway could be bypassed
+	if ports := portsOf(rules[1]); !reflect.DeepEqual(ports, []int{7777}) {
+		t.Errorf("Only the internal endpoints not sharing the port with a public endpoint should be reachable from anywhere but the ports are %v", ports)
+	}
+
+	// the endpoints with no exposure are only reachable from within the pod, which no network policy restricts
+	for _, rule := range rules {
+		for _, port := range portsOf(rule) {
+			if port == 6666 {
+				t.Errorf("The endpoint with no exposure should not be reachable from outside of the pod: %v", rule)
+			}
+		}
+	}
+
+	// once the manager no longer isolates the workspaces, the network policy is pruned
+	cheManager := &v1alpha1.CheManager{}
+	if err = cl.Get(ctx, client.ObjectKey{Name: "che", Namespace: "ns"}, cheManager); err != nil {
+		t.Fatal(err)
+	}
+	cheManager.Spec.Gateway.IsolateWorkspaces = false
+	if err = cl.Update(ctx, cheManager); err != nil {
+		t.Fatal(err)
+	}
+	cheRecon := manager.New(cl, createTestScheme())
+	if _, err = cheRecon.Reconcile(reconcile.Request{NamespacedName: types.NamespacedName{Name: "che", Namespace: "ns"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	meta := solvers.WorkspaceMetadata{
+		WorkspaceId: routing.Spec.WorkspaceId,
+		Namespace:   routing.Namespace,
+		PodSelector: routing.Spec.PodSelector,
+	}
+	if _, err = solver.GetSpecObjects(routing, meta); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = cl.Get(ctx, client.ObjectKey{Name: "wsid-gateway-access", Namespace: "ws"}, &networkingv1.NetworkPolicy{}); !k8serrors.IsNotFound(err) {
+		t.Errorf("The network policy should have been pruned: %v", err)
+	}
+}
+
+// listRecordingClient records the kinds of the lists read through it
+type listRecordingClient struct {
+	client.Client
+	lists []string
+}
+
+func (c *listRecordingClient) List(ctx context.Context, list runtime.Object, opts ...client.ListOption) error {
+	c.lists = append(c.lists, reflect.TypeOf(list).Elem().Name())
+	return c.Client.List(ctx, list, opts...)
+}
+
+func TestSolverOnlyListsConfigMapsThroughCachedClient(t *testing.T) {
+	scheme := createTestScheme()
+	cheManager := &v1alpha1.CheManager{
+		ObjectMeta: metav1.ObjectMeta{Name: "che", Namespace: "ns", Finalizers: []string{manager.FinalizerName}},
+		Spec:       v1alpha1.CheManagerSpec{Host: "over.the.rainbow"},
+	}
+	cl := fake.NewFakeClientWithScheme(scheme, cheManager)
+	cachedClient := &listRecordingClient{Client: cl}
+
+	// the network policies are pruned even if the workspaces are not isolated, because they might have been before,
+	// so they need to be read without caching them all
+	solver, err := Getter(scheme, cl, nil).GetSolver(cachedClient, "che")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cheRecon := manager.New(cl, scheme)
+	if _, err = cheRecon.Reconcile(reconcile.Request{NamespacedName: types.NamespacedName{Name: "che", Namespace: "ns"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	routing := simpleWorkspaceRouting()
+	routing.UID = "routing-uid"
+	meta := solvers.WorkspaceMetadata{WorkspaceId: routing.Spec.WorkspaceId, Namespace: routing.GetNamespace(), PodSelector: routing.Spec.PodSelector}
+	if _, err = solver.GetSpecObjects(routing, meta); err != nil {
+		t.Fatal(err)
+	}
+	if err = solver.Finalize(routing); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, l := range cachedClient.lists {
+		if l != "ConfigMapList" {
+			t.Errorf("The %s should not have been listed through the cached client", l)
+		}
+	}
+}
+
+func TestErrorPages(t *testing.T) {
+	os.Setenv("RELATED_IMAGE_gateway_error_pages", "quay.io/che-incubator/devworkspace-che-operator:test")
+	defer os.Unsetenv("RELATED_IMAGE_gateway_error_pages")
+
+	cl, _, _, err := getSpecObjectsWithManager(t, simpleWorkspaceRouting(), v1alpha1.CheManagerSpec{
+		Host: "over.the.rainbow",
+		Gateway: v1alpha1.GatewaySpec{
+			ErrorPages: true,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cms := &corev1.ConfigMapList{}
+	if err := cl.List(context.TODO(), cms, client.InNamespace("ns")); err != nil {
+		t.Fatal(err)
+	}
+
+	gateway, err := traefik.GatewayFromConfigMaps(cms.Items...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ex, err := gateway.ExplainURL("http://over.the.rainbow/wsid/m1/9999/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ex.Middlewares) == 0 || ex.Middlewares[0].Name != "wsid-errors" {
+		t.Fatalf("The failed responses of the endpoint should have been replaced by the error pages: %+v", ex.Middlewares)
+	}
+
+	errors := ex.Middlewares[0].Middleware.Errors
+	if errors.Service != "wsid-error-pages" || errors.Query != "/ws/wsid/{status}" ||
+		!reflect.DeepEqual(errors.Status, []string{"502", "503", "504"}) {
+		t.Errorf("Unexpected configuration of the error pages: %+v", errors)
+	}
+
+	ex, err = gateway.ExplainURL("http://over.the.rainbow/wsid/unknown/path")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ex.RouterName != "wsid-error-pages" {
+		t.Fatalf("The paths of the workspace without an endpoint should have been routed to the error pages: %+v", ex)
+	}
+	if ex.Backend != "http://127.0.0.1:8091/ws/wsid/404" {
+		t.Errorf("The error pages should have been asked for the not found page but the backend is %s", ex.Backend)
+	}
+
+	// the paths of other workspaces are not affected
+	ex, err = gateway.ExplainURL("http://over.the.rainbow/other/unknown/path")
+	if err == nil && ex.RouterName == "wsid-error-pages" {
+		t.Errorf("The paths of other workspaces should not have been routed to the error pages")
+	}
+}
+
+func TestCustomHostsRoutesUseTLSSecretOfWorkspace(t *testing.T) {
+	tlsSecret := func(namespace string, cert string) *corev1.Secret {
+		return &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "custom-tls",
+				Namespace: namespace,
+			},
+			Type: corev1.SecretTypeTLS,
+			Data: map[string][]byte{
+				corev1.TLSCertKey:       []byte(cert),
+				corev1.TLSPrivateKeyKey: []byte("key"),
+			},
+		}
+	}
+
+	cl := fake.NewFakeClientWithScheme(createTestScheme(), tlsSecret("ns", "manager-cert"), tlsSecret("ws", "workspace-cert"))
+	solver := &CheRoutingSolver{client: cl, reader: cl}
+
+	routing := simpleWorkspaceRouting()
+	routing.Annotations = map[string]string{
+		CustomHostsAnnotation:          "myproject.dev.example.com",
+		CustomHostsTLSSecretAnnotation: "custom-tls",
+	}
+
+	for sharedSecrets, expectedCert := range map[string]string{"": "workspace-cert", "custom-tls": "manager-cert"} {
+		manager := &v1alpha1.CheManager{
+			ObjectMeta: metav1.ObjectMeta{Name: "che", Namespace: "ns"},
+			Spec: v1alpha1.CheManagerSpec{
+				Host: "over.the.rainbow",
+				CustomHosts: v1alpha1.CustomHostsSpec{
+					AllowedDomains:   []string{"example.com"},
+					SharedTLSSecrets: []string{sharedSecrets},
+				},
+			},
+		}
+
+		hosts, err := customHostsOf(manager, routing.Namespace, routing.Annotations)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		routes, err := solver.getCustomHostsRoutes(context.TODO(), manager, routing, hosts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(routes) != 1 || routes[0].Spec.TLS.Certificate != expectedCert {
+			t.Errorf("Expected the route to use the certificate '%s' with the shared TLS secrets '%s' but got %v", expectedCert, sharedSecrets, routes)
+		}
+	}
+}
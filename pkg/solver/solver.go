@@ -13,12 +13,15 @@
 package solver
 
 import (
+	"errors"
 	"fmt"
 	"time"
 
 	"github.com/che-incubator/devworkspace-che-operator/apis/che-controller/v1alpha1"
 	"github.com/che-incubator/devworkspace-che-operator/pkg/defaults"
 	"github.com/che-incubator/devworkspace-che-operator/pkg/manager"
+	"github.com/che-incubator/devworkspace-che-operator/pkg/metrics"
+	"github.com/che-incubator/devworkspace-che-operator/pkg/sync"
 	"github.com/che-incubator/devworkspace-che-operator/pkg/util"
 	controllerv1alpha1 "github.com/devfile/devworkspace-operator/apis/controller/v1alpha1"
 	dwo "github.com/devfile/devworkspace-operator/apis/controller/v1alpha1"
@@ -28,6 +31,7 @@ import (
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/client"
@@ -40,10 +44,24 @@ var (
 	logger = ctrl.Log.WithName("solver")
 )
 
+const (
+	// EventReasonCheManagerNotFound is the reason of the event recorded on the workspace routing when the Che manager
+	// it requires doesn't exist.
+	EventReasonCheManagerNotFound = "CheManagerNotFound"
+	// EventReasonRoutingInvalid is the reason of the event recorded on the workspace routing when it cannot be solved.
+	EventReasonRoutingInvalid = "RoutingInvalid"
+	// EventReasonConflictingEndpoints is the reason of the event recorded on the workspace routing when several
+	// endpoints share the same URL but require different routing. Only the first of them is used to route the URL.
+	EventReasonConflictingEndpoints = "ConflictingEndpoints"
+)
+
 // CheRoutingSolver is a struct representing the routing solver for Che specific routing of workspaces
 type CheRoutingSolver struct {
-	client client.Client
-	scheme *runtime.Scheme
+	client   client.Client
+	reader   client.Reader
+	scheme   *runtime.Scheme
+	recorder record.EventRecorder
+	syncOpts []sync.Option
 }
 
 // Magic to ensure we get compile time error right here if our struct doesn't support the interface.
@@ -52,13 +70,23 @@ var _ solvers.RoutingSolver = (*CheRoutingSolver)(nil)
 
 // CheRouterGetter negotiates the solver with the calling code
 type CheRouterGetter struct {
-	scheme *runtime.Scheme
+	scheme   *runtime.Scheme
+	reader   client.Reader
+	recorder record.EventRecorder
+	syncOpts []sync.Option
 }
 
-// Getter creates a new CheRouterGetter
-func Getter(scheme *runtime.Scheme) *CheRouterGetter {
+// Getter creates a new CheRouterGetter. The reader is used to list the objects of the workspaces in the namespace
+// of the manager without caching all the objects of their kinds in the cluster (e.g. the API reader of the manager).
+// It can be nil, in which case the client of the solver is used. The recorder is used to record the events on
+// the workspace routings and can be nil if no events should be recorded. The sync options configure how the gateway
+// configuration of the workspaces is synced to the cluster.
+func Getter(scheme *runtime.Scheme, reader client.Reader, recorder record.EventRecorder, syncOpts ...sync.Option) *CheRouterGetter {
 	return &CheRouterGetter{
-		scheme: scheme,
+		scheme:   scheme,
+		reader:   reader,
+		recorder: recorder,
+		syncOpts: syncOpts,
 	}
 }
 
@@ -70,7 +98,11 @@ func (g *CheRouterGetter) GetSolver(client client.Client, routingClass controlle
 	if !isSupported(routingClass) {
 		return nil, solvers.RoutingNotSupported
 	}
-	return &CheRoutingSolver{client: client, scheme: g.scheme}, nil
+	reader := g.reader
+	if reader == nil {
+		reader = client
+	}
+	return &CheRoutingSolver{client: client, reader: reader, scheme: g.scheme, recorder: g.recorder, syncOpts: g.syncOpts}, nil
 }
 
 func (g *CheRouterGetter) SetupControllerManager(mgr *builder.Builder) error {
@@ -122,8 +154,13 @@ func (c *CheRoutingSolver) FinalizerRequired(routing *controllerv1alpha1.Workspa
 	return true
 }
 
-func (c *CheRoutingSolver) Finalize(routing *controllerv1alpha1.WorkspaceRouting) error {
-	cheManager, err := cheManagerOfRouting(routing)
+func (c *CheRoutingSolver) Finalize(routing *controllerv1alpha1.WorkspaceRouting) (err error) {
+	defer func() {
+		metrics.RecordSolverError(err)
+		c.recordRoutingError(routing, err)
+	}()
+
+	cheManager, err := c.cheManagerOfRouting(routing)
 	if err != nil {
 		return err
 	}
@@ -136,8 +173,13 @@ func (c *CheRoutingSolver) Finalize(routing *controllerv1alpha1.WorkspaceRouting
 }
 
 // GetSpecObjects constructs cluster routing objects which should be applied on the cluster
-func (c *CheRoutingSolver) GetSpecObjects(routing *controllerv1alpha1.WorkspaceRouting, workspaceMeta solvers.WorkspaceMetadata) (solvers.RoutingObjects, error) {
-	cheManager, err := cheManagerOfRouting(routing)
+func (c *CheRoutingSolver) GetSpecObjects(routing *controllerv1alpha1.WorkspaceRouting, workspaceMeta solvers.WorkspaceMetadata) (objs solvers.RoutingObjects, err error) {
+	defer func() {
+		metrics.RecordSolverError(err)
+		c.recordRoutingError(routing, err)
+	}()
+
+	cheManager, err := c.cheManagerOfRouting(routing)
 	if err != nil {
 		return solvers.RoutingObjects{}, err
 	}
@@ -154,6 +196,8 @@ func (c *CheRoutingSolver) GetSpecObjects(routing *controllerv1alpha1.WorkspaceR
 // Return value "ready" specifies if all endpoints are resolved on the cluster; if false it is necessary to retry, as
 // URLs will be undefined.
 func (c *CheRoutingSolver) GetExposedEndpoints(endpoints map[string]controllerv1alpha1.EndpointList, routingObj solvers.RoutingObjects) (exposedEndpoints map[string]controllerv1alpha1.ExposedEndpointList, ready bool, err error) {
+	defer func() { metrics.RecordSolverError(err) }()
+
 	if len(routingObj.Services) == 0 {
 		return map[string]dwo.ExposedEndpointList{}, true, nil
 	}
@@ -178,11 +222,29 @@ func isSupported(routingClass controllerv1alpha1.WorkspaceRoutingClass) bool {
 	return routingClass == "che"
 }
 
-func cheManagerOfRouting(routing *controllerv1alpha1.WorkspaceRouting) (*v1alpha1.CheManager, error) {
+func (c *CheRoutingSolver) cheManagerOfRouting(routing *controllerv1alpha1.WorkspaceRouting) (*v1alpha1.CheManager, error) {
 	cheName := routing.Annotations[defaults.ConfigAnnotationCheManagerName]
 	cheNamespace := routing.Annotations[defaults.ConfigAnnotationCheManagerNamespace]
 
-	return findCheManager(client.ObjectKey{Name: cheName, Namespace: cheNamespace})
+	m, err := findCheManager(client.ObjectKey{Name: cheName, Namespace: cheNamespace})
+
+	// if there are no managers at all, they most probably have not been reconciled yet, so we only report
+	// the situation where the managers are known but the one required by the routing is not among them.
+	var notReady *solvers.RoutingNotReady
+	if c.recorder != nil && errors.As(err, &notReady) && len(manager.GetCurrentManagers()) > 0 {
+		c.recorder.Eventf(routing, corev1.EventTypeWarning, EventReasonCheManagerNotFound,
+			"The Che manager %s/%s required by the routing was not found", cheNamespace, cheName)
+	}
+
+	return m, err
+}
+
+// recordRoutingError records an event on the routing if the error means that the routing is invalid.
+func (c *CheRoutingSolver) recordRoutingError(routing *controllerv1alpha1.WorkspaceRouting, err error) {
+	var invalid *solvers.RoutingInvalid
+	if c.recorder != nil && errors.As(err, &invalid) {
+		c.recorder.Event(routing, corev1.EventTypeWarning, EventReasonRoutingInvalid, invalid.Reason)
+	}
 }
 
 func findCheManager(cheManagerKey client.ObjectKey) (*v1alpha1.CheManager, error) {
@@ -0,0 +1,285 @@
+//
+// Copyright (c) 2019-2021 Red Hat, Inc.
+// This program and the accompanying materials are made
+// available under the terms of the Eclipse Public License 2.0
+// which is available at https://www.eclipse.org/legal/epl-2.0/
+//
+// SPDX-License-Identifier: EPL-2.0
+//
+// Contributors:
+//   Red Hat, Inc. - initial API and implementation
+//
+
+package sync
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	gosync "sync"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/yaml"
+)
+
+// The operations that the syncer plans in the dry-run mode
+const (
+	PlannedCreate   = "create"
+	PlannedUpdate   = "update"
+	PlannedRecreate = "recreate"
+	PlannedDelete   = "delete"
+)
+
+const (
+	// the config maps are limited to 1 MiB, so the report leaves some room for the keys and the metadata
+	maxDryRunReportSize = 900 * 1024
+	// a single large object, e.g. the gateway configuration, must not take up the whole report
+	maxDryRunReportEntrySize = 16 * 1024
+	// the key of the report under which the planned changes that don't fit the report are counted
+	dryRunReportTruncatedKey = "truncated"
+)
+
+// WithDryRun makes the syncer only compute and log the changes it would do to the cluster without actually doing
+// them. The planned changes are also written to the report, if one is provided.
+func WithDryRun(report *DryRunReport) Option {
+	return func(s *Syncer) {
+		s.dryRun = true
+		s.report = report
+	}
+}
+
+// IsDryRun returns true if the syncer doesn't write anything to the cluster. The users of the syncer should
+// refrain from writing to the cluster themselves, too, in this case.
+func (s *Syncer) IsDryRun() bool {
+	return s.dryRun
+}
+
+// DryRunReport collects the changes planned by the syncers in the dry-run mode and writes them into a config map
+// with one entry per object. The config map is limited in size, so the long changes are truncated and the changes
+// that don't fit at all are only counted in the "truncated" entry. The full changes are always logged.
+type DryRunReport struct {
+	client  client.Client
+	scheme  *runtime.Scheme
+	key     client.ObjectKey
+	lock    gosync.Mutex
+	changes map[string]string
+}
+
+// NewDryRunReport creates a new report that is written to the config map with the provided key.
+func NewDryRunReport(client client.Client, scheme *runtime.Scheme, key client.ObjectKey) *DryRunReport {
+	return &DryRunReport{
+		client:  client,
+		scheme:  scheme,
+		key:     key,
+		changes: map[string]string{},
+	}
+}
+
+// record records the planned change of the object and writes the report if it changed.
+func (r *DryRunReport) record(ctx context.Context, operation string, kind string, key client.ObjectKey, diff string) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	entry := fmt.Sprintf("%s.%s.%s", kind, key.Namespace, key.Name)
+	change := fmt.Sprintf("operation: %s\n%s", operation, diff)
+	if len(change) > maxDryRunReportEntrySize {
+		change = fmt.Sprintf("%s\n... truncated %d bytes, see the log of the operator for the full change\n",
+			change[:maxDryRunReportEntrySize], len(change)-maxDryRunReportEntrySize)
+	}
+	if r.changes[entry] == change {
+		return nil
+	}
+	r.changes[entry] = change
+
+	cm := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      r.key.Name,
+			Namespace: r.key.Namespace,
+		},
+		Data: r.data(),
+	}
+
+	// the report is the only thing that is actually written to the cluster in the dry-run mode
+	syncer := New(r.client, r.scheme)
+	_, _, err := syncer.Sync(ctx, nil, cm, cmpopts.IgnoreFields(corev1.ConfigMap{}, "TypeMeta", "ObjectMeta"))
+	return err
+}
+
+// data returns the entries of the report that fit into a config map. The entries are added in the order of their
+// keys, so that the same changes always end up in the report.
+func (r *DryRunReport) data() map[string]string {
+	entries := make([]string, 0, len(r.changes))
+	for k := range r.changes {
+		entries = append(entries, k)
+	}
+	sort.Strings(entries)
+
+	data := map[string]string{}
+	size := 0
+	truncated := 0
+	for _, k := range entries {
+		entrySize := len(k) + len(r.changes[k])
+		if size+entrySize > maxDryRunReportSize {
+			truncated++
+			continue
+		}
+		data[k] = r.changes[k]
+		size += entrySize
+	}
+
+	if truncated > 0 {
+		data[dryRunReportTruncatedKey] = fmt.Sprintf("%d planned changes didn't fit into the report, see the log of the operator for them\n", truncated)
+	}
+
+	return data
+}
+
+// plan figures out what Sync would do with the blueprint without changing anything in the cluster.
+func (s *Syncer) plan(ctx context.Context, key client.ObjectKey, blueprintObject runtime.Object, diffOpts cmp.Option) (bool, runtime.Object, error) {
+	blueprint := blueprintObject.(metav1.Object)
+	kind := s.kindOf(blueprintObject)
+
+	actual := newEmptyObject(blueprintObject)
+	if err := s.reader.Get(ctx, key, actual); err != nil {
+		if !errors.IsNotFound(err) {
+			return false, nil, err
+		}
+
+		spec, err := yaml.Marshal(blueprintObject)
+		if err != nil {
+			return false, nil, err
+		}
+
+		return true, blueprintObject, s.recordPlan(ctx, PlannedCreate, kind, key, string(spec))
+	}
+
+	diff := cmp.Diff(actual, blueprint, diffOpts)
+	if actual.(metav1.Object).GetLabels()[OwnerUIDLabel] != blueprint.GetLabels()[OwnerUIDLabel] {
+		diff += fmt.Sprintf("label %s: %q -> %q\n", OwnerUIDLabel, actual.(metav1.Object).GetLabels()[OwnerUIDLabel], blueprint.GetLabels()[OwnerUIDLabel])
+	}
+
+	if len(diff) == 0 {
+		return false, actual, nil
+	}
+
+	operation := PlannedUpdate
+	if requiresRecreate(actual, blueprint) {
+		operation = PlannedRecreate
+	}
+
+	return true, actual, s.recordPlan(ctx, operation, kind, key, diff)
+}
+
+// recordPlan logs the planned change and records it in the report, if any.
+func (s *Syncer) recordPlan(ctx context.Context, operation string, kind string, key client.ObjectKey, diff string) error {
+	log.Info("Dry run: planned a change of an object", "operation", operation, "kind", kind, "name", key.Name, "namespace", key.Namespace, "diff", diff)
+
+	if s.report == nil {
+		return nil
+	}
+
+	return s.report.record(ctx, operation, kind, key, diff)
+}
+
+// dryRunDiffOpts ignore the metadata managed by the cluster when planning the writes of the dry-run client
+var dryRunDiffOpts = cmp.Options{
+	cmpopts.IgnoreFields(metav1.TypeMeta{}, "APIVersion", "Kind"),
+	cmpopts.IgnoreFields(metav1.ObjectMeta{}, "ResourceVersion", "Generation", "CreationTimestamp", "UID", "SelfLink", "ManagedFields"),
+}
+
+// dryRunClient reads from the cluster but only plans the writes, like the syncer in the dry-run mode. It is given to
+// the controllers that write to the cluster on their own, so that their changes show up in the dry-run report, too.
+// The status updates are dropped, because the status only reflects the objects that were not actually changed.
+type dryRunClient struct {
+	client.Client
+	syncer Syncer
+}
+
+var _ client.Client = (*dryRunClient)(nil)
+
+// NewDryRunClient creates a client that reads from the cluster using the provided client, but only logs the writes
+// and records them in the report, if one is provided, instead of doing them.
+func NewDryRunClient(cl client.Client, scheme *runtime.Scheme, report *DryRunReport) client.Client {
+	return &dryRunClient{
+		Client: cl,
+		syncer: New(cl, scheme, WithDryRun(report)),
+	}
+}
+
+func (c *dryRunClient) Create(ctx context.Context, obj runtime.Object, opts ...client.CreateOption) error {
+	return c.planWrite(ctx, obj)
+}
+
+func (c *dryRunClient) Update(ctx context.Context, obj runtime.Object, opts ...client.UpdateOption) error {
+	return c.planWrite(ctx, obj)
+}
+
+func (c *dryRunClient) Patch(ctx context.Context, obj runtime.Object, patch client.Patch, opts ...client.PatchOption) error {
+	key, err := client.ObjectKeyFromObject(obj)
+	if err != nil {
+		return err
+	}
+
+	// the result of the patch is computed by the cluster, so only the patch itself is recorded
+	data, err := patch.Data(obj)
+	if err != nil {
+		return err
+	}
+
+	return c.syncer.recordPlan(ctx, PlannedUpdate, c.syncer.kindOf(obj), key, string(data))
+}
+
+func (c *dryRunClient) Delete(ctx context.Context, obj runtime.Object, opts ...client.DeleteOption) error {
+	key, err := client.ObjectKeyFromObject(obj)
+	if err != nil {
+		return err
+	}
+
+	return c.syncer.recordPlan(ctx, PlannedDelete, c.syncer.kindOf(obj), key, "")
+}
+
+func (c *dryRunClient) DeleteAllOf(ctx context.Context, obj runtime.Object, opts ...client.DeleteAllOfOption) error {
+	deleteOpts := &client.DeleteAllOfOptions{}
+	deleteOpts.ApplyOptions(opts)
+
+	selector := ""
+	if deleteOpts.LabelSelector != nil {
+		selector = deleteOpts.LabelSelector.String()
+	}
+
+	key := client.ObjectKey{Name: "*", Namespace: deleteOpts.Namespace}
+	return c.syncer.recordPlan(ctx, PlannedDelete, c.syncer.kindOf(obj), key, fmt.Sprintf("selector: %s\n", selector))
+}
+
+func (c *dryRunClient) Status() client.StatusWriter {
+	return dryRunStatusWriter{}
+}
+
+// planWrite plans the creation or the update of the object.
+func (c *dryRunClient) planWrite(ctx context.Context, obj runtime.Object) error {
+	objMeta, ok := obj.(metav1.Object)
+	if !ok {
+		return fmt.Errorf("object %T is not a metav1.Object", obj)
+	}
+
+	key := client.ObjectKey{Name: objMeta.GetName(), Namespace: objMeta.GetNamespace()}
+	_, _, err := c.syncer.plan(ctx, key, obj, dryRunDiffOpts)
+	return err
+}
+
+// dryRunStatusWriter drops all the status updates
+type dryRunStatusWriter struct{}
+
+func (dryRunStatusWriter) Update(ctx context.Context, obj runtime.Object, opts ...client.UpdateOption) error {
+	return nil
+}
+
+func (dryRunStatusWriter) Patch(ctx context.Context, obj runtime.Object, patch client.Patch, opts ...client.PatchOption) error {
+	return nil
+}
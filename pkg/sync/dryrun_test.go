@@ -0,0 +1,210 @@
+package sync
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp/cmpopts"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestDryRun(t *testing.T) {
+	preexisting := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "preexisting",
+			Namespace: "default",
+		},
+		Data: map[string]string{"a": "b"},
+	}
+
+	obsolete := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "obsolete",
+			Namespace: "default",
+		},
+	}
+
+	cl := fake.NewFakeClientWithScheme(scheme, preexisting, obsolete)
+	reportKey := client.ObjectKey{Name: "report", Namespace: "default"}
+
+	syncer := New(cl, scheme, WithDryRun(NewDryRunReport(cl, scheme, reportKey)))
+
+	if !syncer.IsDryRun() {
+		t.Fatal("The syncer should be in the dry-run mode")
+	}
+
+	diffOpts := cmpopts.IgnoreFields(corev1.ConfigMap{}, "TypeMeta", "ObjectMeta")
+
+	update := preexisting.DeepCopy()
+	update.Data["a"] = "c"
+	changed, _, err := syncer.Sync(context.TODO(), nil, update, diffOpts)
+	if err != nil {
+		t.Fatalf("Failed to sync: %s", err)
+	}
+	if !changed {
+		t.Error("The update should have been planned")
+	}
+
+	changed, _, err = syncer.Sync(context.TODO(), nil, &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "new",
+			Namespace: "default",
+		},
+	}, diffOpts)
+	if err != nil {
+		t.Fatalf("Failed to sync: %s", err)
+	}
+	if !changed {
+		t.Error("The creation should have been planned")
+	}
+
+	if err = syncer.Delete(context.TODO(), obsolete.DeepCopy()); err != nil {
+		t.Fatalf("Failed to delete: %s", err)
+	}
+
+	cm := &corev1.ConfigMap{}
+	if err = cl.Get(context.TODO(), client.ObjectKey{Name: "preexisting", Namespace: "default"}, cm); err != nil {
+		t.Fatalf("Failed to get the config map: %s", err)
+	}
+	if cm.Data["a"] != "b" {
+		t.Error("The config map should not have been updated in the dry-run mode")
+	}
+	if err = cl.Get(context.TODO(), client.ObjectKey{Name: "new", Namespace: "default"}, &corev1.ConfigMap{}); !errors.IsNotFound(err) {
+		t.Error("The config map should not have been created in the dry-run mode")
+	}
+	if err = cl.Get(context.TODO(), client.ObjectKey{Name: "obsolete", Namespace: "default"}, &corev1.ConfigMap{}); err != nil {
+		t.Errorf("The config map should not have been deleted in the dry-run mode: %s", err)
+	}
+
+	report := &corev1.ConfigMap{}
+	if err = cl.Get(context.TODO(), reportKey, report); err != nil {
+		t.Fatalf("Failed to get the report: %s", err)
+	}
+
+	expected := map[string]string{
+		"ConfigMap.default.preexisting": "operation: update\n",
+		"ConfigMap.default.new":         "operation: create\n",
+		"ConfigMap.default.obsolete":    "operation: delete\n",
+	}
+	if len(report.Data) != len(expected) {
+		t.Errorf("Unexpected entries in the report: %v", report.Data)
+	}
+	for k, prefix := range expected {
+		if !strings.HasPrefix(report.Data[k], prefix) {
+			t.Errorf("Expected the report entry %s to start with '%s' but was '%s'", k, prefix, report.Data[k])
+		}
+	}
+}
+
+func TestDryRunReportIsLimitedInSize(t *testing.T) {
+	cl := fake.NewFakeClientWithScheme(scheme)
+	reportKey := client.ObjectKey{Name: "report", Namespace: "default"}
+	report := NewDryRunReport(cl, scheme, reportKey)
+
+	// each of the changes is larger than an entry of the report and all of them don't fit into a config map
+	diff := strings.Repeat("x", 2*maxDryRunReportEntrySize)
+	for i := 0; i < 100; i++ {
+		key := client.ObjectKey{Name: fmt.Sprintf("cm-%03d", i), Namespace: "default"}
+		if err := report.record(context.TODO(), PlannedCreate, "ConfigMap", key, diff); err != nil {
+			t.Fatalf("Failed to record the change: %s", err)
+		}
+	}
+
+	cm := &corev1.ConfigMap{}
+	if err := cl.Get(context.TODO(), reportKey, cm); err != nil {
+		t.Fatalf("Failed to get the report: %s", err)
+	}
+
+	size := 0
+	for k, v := range cm.Data {
+		size += len(k) + len(v)
+		if k != dryRunReportTruncatedKey && !strings.Contains(v, "truncated") {
+			t.Errorf("The long change of %s should have been truncated", k)
+		}
+	}
+	if size > 1024*1024 {
+		t.Errorf("The report should fit into a config map but has %d bytes", size)
+	}
+	if !strings.HasPrefix(cm.Data[dryRunReportTruncatedKey], fmt.Sprintf("%d planned changes", 100-len(cm.Data)+1)) {
+		t.Errorf("The report should count the changes that didn't fit into it but says: '%s'", cm.Data[dryRunReportTruncatedKey])
+	}
+	if _, ok := cm.Data["ConfigMap.default.cm-000"]; !ok {
+		t.Error("The first changes should have been kept in the report")
+	}
+}
+
+func TestDryRunClient(t *testing.T) {
+	preexisting := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "preexisting",
+			Namespace: "default",
+		},
+		Data: map[string]string{"a": "b"},
+	}
+
+	cl := fake.NewFakeClientWithScheme(scheme, preexisting)
+	reportKey := client.ObjectKey{Name: "report", Namespace: "default"}
+
+	dryRunClient := NewDryRunClient(cl, scheme, NewDryRunReport(cl, scheme, reportKey))
+
+	update := &corev1.ConfigMap{}
+	if err := dryRunClient.Get(context.TODO(), client.ObjectKey{Name: "preexisting", Namespace: "default"}, update); err != nil {
+		t.Fatalf("Failed to get the config map using the dry-run client: %s", err)
+	}
+	update.Data["a"] = "c"
+	if err := dryRunClient.Update(context.TODO(), update); err != nil {
+		t.Fatalf("Failed to update: %s", err)
+	}
+	if err := dryRunClient.Status().Update(context.TODO(), update); err != nil {
+		t.Fatalf("Failed to update the status: %s", err)
+	}
+
+	if err := dryRunClient.Create(context.TODO(), &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "new",
+			Namespace: "default",
+		},
+	}); err != nil {
+		t.Fatalf("Failed to create: %s", err)
+	}
+
+	if err := dryRunClient.Delete(context.TODO(), preexisting.DeepCopy()); err != nil {
+		t.Fatalf("Failed to delete: %s", err)
+	}
+
+	cm := &corev1.ConfigMap{}
+	if err := cl.Get(context.TODO(), client.ObjectKey{Name: "preexisting", Namespace: "default"}, cm); err != nil {
+		t.Fatalf("The config map should not have been deleted by the dry-run client: %s", err)
+	}
+	if cm.Data["a"] != "b" {
+		t.Error("The config map should not have been updated by the dry-run client")
+	}
+	if err := cl.Get(context.TODO(), client.ObjectKey{Name: "new", Namespace: "default"}, &corev1.ConfigMap{}); !errors.IsNotFound(err) {
+		t.Error("The config map should not have been created by the dry-run client")
+	}
+
+	report := &corev1.ConfigMap{}
+	if err := cl.Get(context.TODO(), reportKey, report); err != nil {
+		t.Fatalf("Failed to get the report: %s", err)
+	}
+
+	// the delete of the preexisting config map replaces its planned update
+	expected := map[string]string{
+		"ConfigMap.default.preexisting": "operation: delete\n",
+		"ConfigMap.default.new":         "operation: create\n",
+	}
+	if len(report.Data) != len(expected) {
+		t.Errorf("Unexpected entries in the report: %v", report.Data)
+	}
+	for k, prefix := range expected {
+		if !strings.HasPrefix(report.Data[k], prefix) {
+			t.Errorf("Expected the report entry %s to start with '%s' but was '%s'", k, prefix, report.Data[k])
+		}
+	}
+}
@@ -0,0 +1,223 @@
+//
+// Copyright (c) 2019-2021 Red Hat, Inc.
+// This program and the accompanying materials are made
+// available under the terms of the Eclipse Public License 2.0
+// which is available at https://www.eclipse.org/legal/epl-2.0/
+//
+// SPDX-License-Identifier: EPL-2.0
+//
+// Contributors:
+//   Red Hat, Inc. - initial API and implementation
+//
+
+package sync
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/che-incubator/devworkspace-che-operator/pkg/metrics"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/selection"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+)
+
+// Kubernetes doesn't allow owner references across namespaces. If the owner of a synced object lives in a different
+// namespace than the object, the syncer records the owner in the below annotations (together with the OwnerUIDLabel)
+// instead. The Sweeper then deletes the objects whose owners no longer exist.
+const (
+	foreignOwnerAnnotationPrefix = "che.routing.controller.devfile.io/owner-"
+	// ForeignOwnerAPIVersionAnnotation is the API version of the owner in another namespace
+	ForeignOwnerAPIVersionAnnotation = foreignOwnerAnnotationPrefix + "api-version"
+	// ForeignOwnerKindAnnotation is the kind of the owner in another namespace
+	ForeignOwnerKindAnnotation = foreignOwnerAnnotationPrefix + "kind"
+	// ForeignOwnerNameAnnotation is the name of the owner in another namespace
+	ForeignOwnerNameAnnotation = foreignOwnerAnnotationPrefix + "name"
+	// ForeignOwnerNamespaceAnnotation is the namespace of the owner in another namespace
+	ForeignOwnerNamespaceAnnotation = foreignOwnerAnnotationPrefix + "namespace"
+)
+
+// Sweeper periodically deletes the objects synced for owners in other namespaces that no longer exist. These
+// objects cannot be garbage collected by Kubernetes, because they can't have owner references.
+type Sweeper struct {
+	client   client.Client
+	reader   client.Reader
+	scheme   *runtime.Scheme
+	interval time.Duration
+	lists    []runtime.Object
+}
+
+var _ manager.Runnable = (*Sweeper)(nil)
+var _ manager.LeaderElectionRunnable = (*Sweeper)(nil)
+
+// NewSweeper creates a new sweeper that looks for the orphaned objects every interval using the provided lists,
+// one for each kind of the objects to sweep, e.g. &corev1.ConfigMapList{}. The objects and their owners are read
+// using the reader, which should not be cached (e.g. the API reader of the manager) so that listing the swept objects
+// doesn't cache all the objects of their kinds in the cluster. The client is only used to delete the orphans.
+func NewSweeper(client client.Client, reader client.Reader, scheme *runtime.Scheme, interval time.Duration, lists ...runtime.Object) *Sweeper {
+	return &Sweeper{
+		client:   client,
+		reader:   reader,
+		scheme:   scheme,
+		interval: interval,
+		lists:    lists,
+	}
+}
+
+// Start runs the sweeps until the stop channel is closed.
+func (s *Sweeper) Start(stop <-chan struct{}) error {
+	wait.Until(func() {
+		if err := s.Sweep(context.TODO()); err != nil {
+			log.Error(err, "Failed to sweep the objects with no owners")
+		}
+	}, s.interval, stop)
+	return nil
+}
+
+// NeedLeaderElection makes sure that only the leader sweeps the objects.
+func (s *Sweeper) NeedLeaderElection() bool {
+	return true
+}
+
+// Sweep deletes the objects whose owners in other namespaces no longer exist. The objects that cannot be swept are
+// skipped and the errors are returned together once all the other objects were swept.
+func (s *Sweeper) Sweep(ctx context.Context) error {
+	selector, err := ownedObjectsSelector()
+	if err != nil {
+		return err
+	}
+
+	// a single object that cannot be swept must not stop the sweeping of all the others
+	var errs []error
+
+	for _, prototype := range s.lists {
+		list := prototype.DeepCopyObject()
+		if err := s.reader.List(ctx, list, client.MatchingLabelsSelector{Selector: selector}); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+
+		objs, err := meta.ExtractList(list)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+
+		for _, obj := range objs {
+			objMeta, ok := obj.(metav1.Object)
+			if !ok || objMeta.GetAnnotations()[ForeignOwnerNameAnnotation] == "" {
+				continue
+			}
+
+			kind := ""
+			if gvk, err := apiutil.GVKForObject(obj, s.scheme); err == nil {
+				kind = gvk.Kind
+			}
+
+			exists, err := s.ownerExists(ctx, objMeta)
+			if err != nil {
+				log.Error(err, "Failed to check the owner of the object, skipping it", "kind", kind, "name", objMeta.GetName(), "namespace", objMeta.GetNamespace())
+				errs = append(errs, fmt.Errorf("failed to check the owner of the %s %s/%s: %s", kind, objMeta.GetNamespace(), objMeta.GetName(), err))
+				continue
+			}
+			if exists {
+				continue
+			}
+
+			log.Info("Deleting object whose owner no longer exists", "kind", kind, "name", objMeta.GetName(), "namespace", objMeta.GetNamespace(),
+				"owner-kind", objMeta.GetAnnotations()[ForeignOwnerKindAnnotation], "owner-name", objMeta.GetAnnotations()[ForeignOwnerNameAnnotation],
+				"owner-namespace", objMeta.GetAnnotations()[ForeignOwnerNamespaceAnnotation])
+			if err = s.client.Delete(ctx, obj); err != nil {
+				if !errors.IsNotFound(err) {
+					log.Error(err, "Failed to delete the object whose owner no longer exists", "kind", kind, "name", objMeta.GetName(), "namespace", objMeta.GetNamespace())
+					errs = append(errs, fmt.Errorf("failed to delete the %s %s/%s: %s", kind, objMeta.GetNamespace(), objMeta.GetName(), err))
+				}
+				continue
+			}
+			metrics.RecordSyncerOperation(kind, metrics.SyncerOperationDelete)
+		}
+	}
+
+	return utilerrors.NewAggregate(errs)
+}
+
+// ownerExists checks whether the foreign owner of the object still exists. An owner with the same name but
+// a different UID is a different object and therefore doesn't count.
+func (s *Sweeper) ownerExists(ctx context.Context, obj metav1.Object) (bool, error) {
+	annos := obj.GetAnnotations()
+	gv, err := schema.ParseGroupVersion(annos[ForeignOwnerAPIVersionAnnotation])
+	if err != nil {
+		return false, err
+	}
+
+	owner := &unstructured.Unstructured{}
+	owner.SetGroupVersionKind(gv.WithKind(annos[ForeignOwnerKindAnnotation]))
+
+	key := client.ObjectKey{Name: annos[ForeignOwnerNameAnnotation], Namespace: annos[ForeignOwnerNamespaceAnnotation]}
+	if err = s.reader.Get(ctx, key, owner); err != nil {
+		if errors.IsNotFound(err) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	return string(owner.GetUID()) == obj.GetLabels()[OwnerUIDLabel], nil
+}
+
+func ownedObjectsSelector() (labels.Selector, error) {
+	req, err := labels.NewRequirement(OwnerUIDLabel, selection.Exists, nil)
+	if err != nil {
+		return nil, err
+	}
+	return labels.NewSelector().Add(*req), nil
+}
+
+// isForeignOwner returns true if the owner lives in a different namespace than the object and therefore
+// cannot be referenced by the object using an owner reference.
+func isForeignOwner(owner metav1.Object, obj metav1.Object) bool {
+	return owner.GetNamespace() != "" && owner.GetNamespace() != obj.GetNamespace()
+}
+
+// setForeignOwner records the owner in the annotations of the object.
+func (s *Syncer) setForeignOwner(owner metav1.Object, obj metav1.Object) error {
+	ownerObj, ok := owner.(runtime.Object)
+	if !ok {
+		return fmt.Errorf("owner %T is not a runtime.Object", owner)
+	}
+
+	gvk, err := apiutil.GVKForObject(ownerObj, s.scheme)
+	if err != nil {
+		return err
+	}
+
+	annos := map[string]string{}
+	for k, v := range obj.GetAnnotations() {
+		annos[k] = v
+	}
+	annos[ForeignOwnerAPIVersionAnnotation] = gvk.GroupVersion().String()
+	annos[ForeignOwnerKindAnnotation] = gvk.Kind
+	annos[ForeignOwnerNameAnnotation] = owner.GetName()
+	annos[ForeignOwnerNamespaceAnnotation] = owner.GetNamespace()
+	obj.SetAnnotations(annos)
+
+	return nil
+}
+
+// isForeignOwnedBy returns true if the object records the owner as its owner in another namespace.
+func isForeignOwnedBy(obj metav1.Object, owner metav1.Object) bool {
+	annos := obj.GetAnnotations()
+	return annos[ForeignOwnerNameAnnotation] == owner.GetName() &&
+		annos[ForeignOwnerNamespaceAnnotation] == owner.GetNamespace() &&
+		obj.GetLabels()[OwnerUIDLabel] == string(owner.GetUID())
+}
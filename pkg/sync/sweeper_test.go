@@ -0,0 +1,206 @@
+package sync
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp/cmpopts"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestSyncRecordsOwnerInOtherNamespace(t *testing.T) {
+	owner := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "owner",
+			Namespace: "user",
+			UID:       "owner-uid",
+		},
+	}
+
+	cm := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "cm",
+			Namespace: "infra",
+		},
+	}
+
+	cl := fake.NewFakeClientWithScheme(scheme, owner)
+	syncer := New(cl, scheme)
+
+	if _, _, err := syncer.Sync(context.TODO(), owner, cm, cmpopts.IgnoreFields(corev1.ConfigMap{}, "TypeMeta", "ObjectMeta")); err != nil {
+		t.Fatalf("Failed to sync: %s", err)
+	}
+
+	synced := &corev1.ConfigMap{}
+	if err := cl.Get(context.TODO(), client.ObjectKey{Name: "cm", Namespace: "infra"}, synced); err != nil {
+		t.Fatalf("Failed to get the synced config map: %s", err)
+	}
+
+	if len(synced.OwnerReferences) != 0 {
+		t.Errorf("The config map should not have an owner reference across namespaces")
+	}
+
+	expectedAnnos := map[string]string{
+		ForeignOwnerAPIVersionAnnotation: "v1",
+		ForeignOwnerKindAnnotation:       "Pod",
+		ForeignOwnerNameAnnotation:       "owner",
+		ForeignOwnerNamespaceAnnotation:  "user",
+	}
+	for k, v := range expectedAnnos {
+		if synced.Annotations[k] != v {
+			t.Errorf("Expected annotation %s to be '%s' but was '%s'", k, v, synced.Annotations[k])
+		}
+	}
+	if synced.Labels[OwnerUIDLabel] != "owner-uid" {
+		t.Errorf("The config map should have been labeled with the UID of the owner")
+	}
+}
+
+func TestSweeperDeletesObjectsWithNoOwner(t *testing.T) {
+	owner := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "owner",
+			Namespace: "user",
+			UID:       "owner-uid",
+		},
+	}
+
+	owned := func(name string, ownerName string, ownerUID string) *corev1.ConfigMap {
+		return &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: "infra",
+				Labels:    map[string]string{OwnerUIDLabel: ownerUID},
+				Annotations: map[string]string{
+					ForeignOwnerAPIVersionAnnotation: "v1",
+					ForeignOwnerKindAnnotation:       "Pod",
+					ForeignOwnerNameAnnotation:       ownerName,
+					ForeignOwnerNamespaceAnnotation:  "user",
+				},
+			},
+		}
+	}
+
+	unrelated := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "unrelated",
+			Namespace: "infra",
+		},
+	}
+
+	cl := fake.NewFakeClientWithScheme(scheme,
+		owner,
+		owned("live", "owner", "owner-uid"),
+		owned("orphaned", "deleted-owner", "deleted-owner-uid"),
+		// an owner with the same name but different UID is a different object
+		owned("recreated", "owner", "previous-owner-uid"),
+		unrelated)
+
+	sweeper := NewSweeper(cl, cl, scheme, 0, &corev1.ConfigMapList{})
+
+	if err := sweeper.Sweep(context.TODO()); err != nil {
+		t.Fatalf("Failed to sweep: %s", err)
+	}
+
+	for _, name := range []string{"live", "unrelated"} {
+		if err := cl.Get(context.TODO(), client.ObjectKey{Name: name, Namespace: "infra"}, &corev1.ConfigMap{}); err != nil {
+			t.Errorf("The config map %s should have been kept: %s", name, err)
+		}
+	}
+
+	for _, name := range []string{"orphaned", "recreated"} {
+		if err := cl.Get(context.TODO(), client.ObjectKey{Name: name, Namespace: "infra"}, &corev1.ConfigMap{}); !errors.IsNotFound(err) {
+			t.Errorf("The config map %s should have been swept", name)
+		}
+	}
+}
+
+func TestSweeperDeletesClusterScopedObjectsWithNoOwner(t *testing.T) {
+	owner := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "owner",
+			Namespace: "user",
+			UID:       "owner-uid",
+		},
+	}
+
+	ownedMeta := func(name string, ownerName string, ownerUID string) metav1.ObjectMeta {
+		return metav1.ObjectMeta{
+			Name:   name,
+			Labels: map[string]string{OwnerUIDLabel: ownerUID},
+			Annotations: map[string]string{
+				ForeignOwnerAPIVersionAnnotation: "v1",
+				ForeignOwnerKindAnnotation:       "Pod",
+				ForeignOwnerNameAnnotation:       ownerName,
+				ForeignOwnerNamespaceAnnotation:  "user",
+			},
+		}
+	}
+
+	cl := fake.NewFakeClientWithScheme(scheme,
+		owner,
+		&rbacv1.ClusterRole{ObjectMeta: ownedMeta("live", "owner", "owner-uid")},
+		&rbacv1.ClusterRole{ObjectMeta: ownedMeta("orphaned", "deleted-owner", "deleted-owner-uid")},
+		&rbacv1.ClusterRoleBinding{ObjectMeta: ownedMeta("live", "owner", "owner-uid")},
+		&rbacv1.ClusterRoleBinding{ObjectMeta: ownedMeta("orphaned", "deleted-owner", "deleted-owner-uid")})
+
+	sweeper := NewSweeper(cl, cl, scheme, 0, &rbacv1.ClusterRoleList{}, &rbacv1.ClusterRoleBindingList{})
+
+	if err := sweeper.Sweep(context.TODO()); err != nil {
+		t.Fatalf("Failed to sweep: %s", err)
+	}
+
+	if err := cl.Get(context.TODO(), client.ObjectKey{Name: "live"}, &rbacv1.ClusterRole{}); err != nil {
+		t.Errorf("The cluster role with an owner should have been kept: %s", err)
+	}
+	if err := cl.Get(context.TODO(), client.ObjectKey{Name: "live"}, &rbacv1.ClusterRoleBinding{}); err != nil {
+		t.Errorf("The cluster role binding with an owner should have been kept: %s", err)
+	}
+	if err := cl.Get(context.TODO(), client.ObjectKey{Name: "orphaned"}, &rbacv1.ClusterRole{}); !errors.IsNotFound(err) {
+		t.Error("The cluster role with no owner should have been swept")
+	}
+	if err := cl.Get(context.TODO(), client.ObjectKey{Name: "orphaned"}, &rbacv1.ClusterRoleBinding{}); !errors.IsNotFound(err) {
+		t.Error("The cluster role binding with no owner should have been swept")
+	}
+}
+
+func TestSweeperSkipsObjectsThatCannotBeSwept(t *testing.T) {
+	owned := func(name string, apiVersion string) *corev1.ConfigMap {
+		return &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: "infra",
+				Labels:    map[string]string{OwnerUIDLabel: "deleted-owner-uid"},
+				Annotations: map[string]string{
+					ForeignOwnerAPIVersionAnnotation: apiVersion,
+					ForeignOwnerKindAnnotation:       "Pod",
+					ForeignOwnerNameAnnotation:       "deleted-owner",
+					ForeignOwnerNamespaceAnnotation:  "user",
+				},
+			},
+		}
+	}
+
+	// the config maps are listed by their names, so the malformed one is processed first
+	cl := fake.NewFakeClientWithScheme(scheme, owned("a-malformed", "a/b/c"), owned("b-orphaned", "v1"))
+
+	sweeper := NewSweeper(cl, cl, scheme, 0, &corev1.ConfigMapList{})
+
+	err := sweeper.Sweep(context.TODO())
+	if err == nil || !strings.Contains(err.Error(), "infra/a-malformed") {
+		t.Errorf("The sweep should have reported the object that could not be swept but the error was: %v", err)
+	}
+
+	if err = cl.Get(context.TODO(), client.ObjectKey{Name: "b-orphaned", Namespace: "infra"}, &corev1.ConfigMap{}); !errors.IsNotFound(err) {
+		t.Errorf("The orphaned config map should have been swept despite the malformed one")
+	}
+	if err = cl.Get(context.TODO(), client.ObjectKey{Name: "a-malformed", Namespace: "infra"}, &corev1.ConfigMap{}); err != nil {
+		t.Errorf("The malformed config map should have been kept: %s", err)
+	}
+}
@@ -15,13 +15,25 @@ package sync
 import (
 	"context"
 	"fmt"
+	"reflect"
 
+	"github.com/che-incubator/devworkspace-che-operator/pkg/metrics"
 	"github.com/google/go-cmp/cmp"
+	routev1 "github.com/openshift/api/route/v1"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
 	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/retry"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
 )
 
@@ -29,41 +41,198 @@ var (
 	log = ctrl.Log.WithName("sync")
 )
 
+const (
+	// EventReasonCreated is the reason of the event recorded on the owner when an object is created.
+	EventReasonCreated = "Created"
+	// EventReasonUpdated is the reason of the event recorded on the owner when an object is updated.
+	EventReasonUpdated = "Updated"
+	// EventReasonRecreated is the reason of the event recorded on the owner when an object is deleted and created
+	// again because it cannot be updated in place.
+	EventReasonRecreated = "Recreated"
+	// EventReasonPruned is the reason of the event recorded on the owner when an object is deleted because it is
+	// no longer part of the objects synced for the owner.
+	EventReasonPruned = "Pruned"
+
+	// OwnerUIDLabel is the label put on all the objects synced for an owner. Its value is the UID of the owner.
+	// The label is used to find the objects to prune.
+	OwnerUIDLabel = "che.routing.controller.devfile.io/owner-uid"
+)
+
 // Syncer synchronized K8s objects with the cluster
 type Syncer struct {
-	client client.Client
-	scheme *runtime.Scheme
+	client       client.Client
+	reader       client.Reader
+	scheme       *runtime.Scheme
+	recorder     record.EventRecorder
+	fieldManager string
+	dryRun       bool
+	report       *DryRunReport
+	// the objects successfully synced by this syncer, used to figure out what to prune
+	synced map[syncedObject]bool
 }
 
-func New(client client.Client, scheme *runtime.Scheme) Syncer {
-	return Syncer{client: client, scheme: scheme}
+type syncedObject struct {
+	gvk schema.GroupVersionKind
+	key client.ObjectKey
+}
+
+// Option configures the optional behavior of the syncer
+type Option func(*Syncer)
+
+// WithEventRecorder makes the syncer record events about the created, updated and recreated objects on their
+// owners. No events are recorded for objects without an owner.
+func WithEventRecorder(recorder record.EventRecorder) Option {
+	return func(s *Syncer) {
+		s.recorder = recorder
+	}
+}
+
+// WithServerSideApply makes the syncer use the server-side apply with the provided field manager to sync
+// the objects to the cluster. Only the fields set in the blueprints are then managed by the syncer, which
+// leaves the rest of the objects to the defaulting in the cluster and to the other controllers. The diff options
+// passed to Sync are not used in this mode.
+func WithServerSideApply(fieldManager string) Option {
+	return func(s *Syncer) {
+		s.fieldManager = fieldManager
+	}
+}
+
+// WithReader makes the syncer read the objects from the cluster using the reader instead of its client. This is
+// useful with a reader that doesn't cache (e.g. the API reader of the manager), because the cached client would
+// cache all the objects of the read kinds in the cluster, even if only a few of them are ever read.
+func WithReader(reader client.Reader) Option {
+	return func(s *Syncer) {
+		s.reader = reader
+	}
+}
+
+func New(client client.Client, scheme *runtime.Scheme, opts ...Option) Syncer {
+	s := Syncer{client: client, reader: client, scheme: scheme, synced: map[syncedObject]bool{}}
+	for _, o := range opts {
+		o(&s)
+	}
+	return s
 }
 
 // Sync syncs the blueprint to the cluster in a generic (as much as Go allows) manner.
 // Returns true if the object was created or updated, false if there was no change detected.
+//
+// The synced object is labeled with the UID of the owner and remembered by the syncer so that the objects
+// of the owner that were not synced can be pruned afterwards (see Prune).
 func (s *Syncer) Sync(ctx context.Context, owner metav1.Object, blueprint metav1.Object, diffOpts cmp.Option) (bool, runtime.Object, error) {
 	blueprintObject, ok := blueprint.(runtime.Object)
 	if !ok {
 		return false, nil, fmt.Errorf("object %T is not a runtime.Object. Cannot sync it", blueprint)
 	}
 
+	setOwnerUIDLabel(owner, blueprint)
+
+	key := client.ObjectKey{Name: blueprint.GetName(), Namespace: blueprint.GetNamespace()}
+
+	var changed bool
+	var obj runtime.Object
+	var err error
+	if s.dryRun {
+		changed, obj, err = s.plan(ctx, key, blueprintObject, diffOpts)
+	} else {
+		changed, obj, err = s.sync(ctx, owner, key, blueprintObject, diffOpts)
+	}
+	if err == nil {
+		s.markSynced(blueprintObject, key)
+	}
+
+	return changed, obj, err
+}
+
+// Create creates the blueprint in the cluster unless an object with the same key already exists. Unlike Sync,
+// Create never changes an existing object and returns it instead, so it can be used to atomically claim the name
+// of the object - whoever creates it first, owns it. Returns true if the object was created.
+//
+// The created object is labeled with the UID of the owner and is remembered as synced, so it is not pruned (see
+// Prune) if it was created by the owner.
+func (s *Syncer) Create(ctx context.Context, owner metav1.Object, blueprint metav1.Object) (bool, runtime.Object, error) {
+	blueprintObject, ok := blueprint.(runtime.Object)
+	if !ok {
+		return false, nil, fmt.Errorf("object %T is not a runtime.Object. Cannot create it", blueprint)
+	}
+
+	setOwnerUIDLabel(owner, blueprint)
+
 	key := client.ObjectKey{Name: blueprint.GetName(), Namespace: blueprint.GetNamespace()}
 
-	actual := blueprintObject.DeepCopyObject()
+	var created bool
+	var obj runtime.Object
+	var err error
+	if s.dryRun {
+		obj = newEmptyObject(blueprintObject)
+		if err = s.reader.Get(ctx, key, obj); err != nil && errors.IsNotFound(err) {
+			created = true
+			obj = blueprintObject
+			err = s.recordPlan(ctx, PlannedCreate, s.kindOf(blueprintObject), key, "")
+		}
+	} else {
+		obj, created, err = s.create(ctx, owner, key, blueprint)
+		if err == nil && created {
+			s.recordEvent(owner, EventReasonCreated, obj)
+		}
+	}
+	if err == nil {
+		s.markSynced(blueprintObject, key)
+	}
+
+	return created, obj, err
+}
 
-	if getErr := s.client.Get(context.TODO(), key, actual); getErr != nil {
+// setOwnerUIDLabel labels the blueprint with the UID of the owner, if any.
+func setOwnerUIDLabel(owner metav1.Object, blueprint metav1.Object) {
+	if owner == nil || owner.GetUID() == "" {
+		return
+	}
+
+	labels := map[string]string{}
+	for k, v := range blueprint.GetLabels() {
+		labels[k] = v
+	}
+	labels[OwnerUIDLabel] = string(owner.GetUID())
+	blueprint.SetLabels(labels)
+}
+
+// markSynced remembers the object as synced so that it is not pruned.
+func (s *Syncer) markSynced(obj runtime.Object, key client.ObjectKey) {
+	if gvk, err := apiutil.GVKForObject(obj, s.scheme); err == nil {
+		if s.synced == nil {
+			s.synced = map[syncedObject]bool{}
+		}
+		s.synced[syncedObject{gvk: gvk, key: key}] = true
+	}
+}
+
+func (s *Syncer) sync(ctx context.Context, owner metav1.Object, key client.ObjectKey, blueprintObject runtime.Object, diffOpts cmp.Option) (bool, runtime.Object, error) {
+	blueprint := blueprintObject.(metav1.Object)
+
+	actual := newEmptyObject(blueprintObject)
+
+	if getErr := s.reader.Get(context.TODO(), key, actual); getErr != nil {
 		if statusErr, ok := getErr.(*errors.StatusError); !ok || statusErr.Status().Reason != metav1.StatusReasonNotFound {
 			return false, nil, getErr
 		}
 		actual = nil
 	}
 
+	if s.fieldManager != "" {
+		return s.apply(ctx, owner, actual, blueprint)
+	}
+
 	if actual == nil {
-		actual, err := s.create(ctx, owner, key, blueprint)
+		actual, created, err := s.create(ctx, owner, key, blueprint)
 		if err != nil {
 			return false, actual, err
 		}
 
+		if created {
+			s.recordEvent(owner, EventReasonCreated, actual)
+		}
+
 		return true, actual, nil
 	}
 
@@ -80,8 +249,13 @@ func (s *Syncer) Delete(ctx context.Context, object metav1.Object) error {
 		return fmt.Errorf("Could not use the supplied object as kubernetes runtime object. That's unexpected: %s", object)
 	}
 
-	if err = s.client.Get(ctx, key, ro); err == nil {
-		err = s.client.Delete(ctx, ro)
+	if err = s.reader.Get(ctx, key, ro); err == nil {
+		if s.dryRun {
+			return s.recordPlan(ctx, PlannedDelete, s.kindOf(ro), key, "")
+		}
+		if err = s.client.Delete(ctx, ro); err == nil {
+			metrics.RecordSyncerOperation(s.kindOf(ro), metrics.SyncerOperationDelete)
+		}
 	}
 
 	if err != nil && !errors.IsNotFound(err) {
@@ -91,44 +265,147 @@ func (s *Syncer) Delete(ctx context.Context, object metav1.Object) error {
 	return nil
 }
 
-func (s *Syncer) create(ctx context.Context, owner metav1.Object, key client.ObjectKey, blueprint metav1.Object) (runtime.Object, error) {
+// Prune deletes the objects of the owner that were not synced by this syncer. The objects of the owner are looked up
+// in the namespace of the owner by the owner UID label using the provided lists, one for each kind of the objects
+// to prune, e.g. &corev1.ServiceList{}. Only the objects that are controlled by the owner are deleted.
+//
+// Prune should only be called after all the objects of the owner were successfully synced, otherwise it would
+// delete the objects that failed to sync.
+func (s *Syncer) Prune(ctx context.Context, owner metav1.Object, lists ...runtime.Object) error {
+	return s.PruneInNamespace(ctx, owner, owner.GetNamespace(), lists...)
+}
+
+// PruneInNamespace is like Prune but looks up the objects of the owner in the provided namespace. This is used to
+// prune the objects that were synced to a different namespace than the one of their owner.
+func (s *Syncer) PruneInNamespace(ctx context.Context, owner metav1.Object, namespace string, lists ...runtime.Object) error {
+	if owner.GetUID() == "" {
+		// we wouldn't be able to find the objects of the owner
+		return nil
+	}
+
+	for _, list := range lists {
+		if err := s.reader.List(ctx, list, client.InNamespace(namespace), client.MatchingLabels{OwnerUIDLabel: string(owner.GetUID())}); err != nil {
+			return err
+		}
+
+		objs, err := meta.ExtractList(list)
+		if err != nil {
+			return err
+		}
+
+		for _, obj := range objs {
+			objMeta, ok := obj.(metav1.Object)
+			if !ok || !(metav1.IsControlledBy(objMeta, owner) || isForeignOwnedBy(objMeta, owner)) {
+				continue
+			}
+
+			gvk, err := apiutil.GVKForObject(obj, s.scheme)
+			if err != nil {
+				return err
+			}
+
+			if s.synced[syncedObject{gvk: gvk, key: client.ObjectKey{Name: objMeta.GetName(), Namespace: objMeta.GetNamespace()}}] {
+				continue
+			}
+
+			if s.dryRun {
+				if err = s.recordPlan(ctx, PlannedDelete, gvk.Kind, client.ObjectKey{Name: objMeta.GetName(), Namespace: objMeta.GetNamespace()}, ""); err != nil {
+					return err
+				}
+				continue
+			}
+
+			log.Info("Pruning object that is no longer needed", "kind", gvk.Kind, "name", objMeta.GetName(), "namespace", objMeta.GetNamespace())
+			if err = s.client.Delete(ctx, obj); err != nil {
+				if errors.IsNotFound(err) {
+					continue
+				}
+				return err
+			}
+			metrics.RecordSyncerOperation(gvk.Kind, metrics.SyncerOperationDelete)
+			s.recordEvent(owner, EventReasonPruned, obj)
+		}
+	}
+
+	return nil
+}
+
+// create creates the blueprint in the cluster. Returns the object in the cluster and true if the object was actually
+// created or false if it already existed.
+func (s *Syncer) create(ctx context.Context, owner metav1.Object, key client.ObjectKey, blueprint metav1.Object) (runtime.Object, bool, error) {
 	blueprintObject, ok := blueprint.(runtime.Object)
-	kind := blueprintObject.GetObjectKind().GroupVersionKind().Kind
 	if !ok {
-		return nil, fmt.Errorf("object %T is not a runtime.Object. Cannot sync it", blueprint)
+		return nil, false, fmt.Errorf("object %T is not a runtime.Object. Cannot sync it", blueprint)
 	}
+	kind := s.kindOf(blueprintObject)
 
-	actual := blueprintObject.DeepCopyObject()
+	actual := newEmptyObject(blueprintObject)
 
 	log.Info("Creating a new object", "kind", kind, "name", blueprint.GetName(), "namespace", blueprint.GetNamespace())
 	obj, err := s.setOwnerReferenceAndConvertToRuntime(owner, blueprint)
 	if err != nil {
-		return nil, err
+		return nil, false, err
 	}
 
 	err = s.client.Create(ctx, obj)
 	if err != nil {
 		if !errors.IsAlreadyExists(err) {
-			return nil, err
+			return nil, false, err
 		}
 
 		// ok, we got an already-exists error. So let's try to load the object into "actual".
 		// if we fail this retry for whatever reason, just give up rather than retrying this in a loop...
 		// the reconciliation loop will lead us here again in the next round.
-		if err = s.client.Get(ctx, key, actual); err != nil {
-			return nil, err
+		if err = s.reader.Get(ctx, key, actual); err != nil {
+			return nil, false, err
 		}
+
+		return actual, false, nil
 	}
 
-	return actual, nil
+	metrics.RecordSyncerOperation(kind, metrics.SyncerOperationCreate)
+
+	return obj, true, nil
 }
 
+// update updates the actual object in the cluster to match the blueprint. If the update conflicts with a change
+// done to the object by someone else, the object is fetched again and the update is retried with a bounded number
+// of attempts.
 func (s *Syncer) update(ctx context.Context, owner metav1.Object, actual runtime.Object, blueprint metav1.Object, diffOpts cmp.Option) (bool, runtime.Object, error) {
+	blueprintObject := blueprint.(runtime.Object)
+
+	var changed bool
+	var obj runtime.Object
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		var err error
+		// the blueprint is modified during the update, so we need to start from its pristine copy in each attempt
+		changed, obj, err = s.tryUpdate(ctx, owner, actual, blueprintObject.DeepCopyObject().(metav1.Object), diffOpts)
+		if !errors.IsConflict(err) {
+			return err
+		}
+
+		actualMeta := actual.(metav1.Object)
+		log.Info("The object was modified in the meantime. Will retry the update with its current state.", "kind", s.kindOf(actual), "name", actualMeta.GetName(), "namespace", actualMeta.GetNamespace())
+		key := client.ObjectKey{Name: actualMeta.GetName(), Namespace: actualMeta.GetNamespace()}
+		actual = newEmptyObject(actual)
+		if getErr := s.reader.Get(ctx, key, actual); getErr != nil {
+			return getErr
+		}
+
+		return err
+	})
+
+	return changed, obj, err
+}
+
+func (s *Syncer) tryUpdate(ctx context.Context, owner metav1.Object, actual runtime.Object, blueprint metav1.Object, diffOpts cmp.Option) (bool, runtime.Object, error) {
 	actualMeta := actual.(metav1.Object)
 
 	diff := cmp.Diff(actual, blueprint, diffOpts)
-	if len(diff) > 0 {
-		kind := actual.GetObjectKind().GroupVersionKind().Kind
+	// the objects created before we started labeling them with the owner need to be labeled, too
+	ownerLabelMissing := actualMeta.GetLabels()[OwnerUIDLabel] != blueprint.GetLabels()[OwnerUIDLabel]
+	if len(diff) > 0 || ownerLabelMissing {
+		kind := s.kindOf(actual)
 		log.Info("Updating existing object", "kind", kind, "name", actualMeta.GetName(), "namespace", actualMeta.GetNamespace())
 
 		// we need to handle labels and annotations specially in case the cluster admin has modified them.
@@ -154,39 +431,276 @@ func (s *Syncer) update(ctx context.Context, owner metav1.Object, actual runtime
 		blueprint.SetAnnotations(targetAnnos)
 		blueprint.SetLabels(targetLabels)
 
-		if isUpdateUsingDeleteCreate(actual.GetObjectKind().GroupVersionKind().Kind) {
-			err := s.client.Delete(ctx, actual)
-			if err != nil {
-				return false, actual, err
-			}
-
-			key := client.ObjectKey{Name: actualMeta.GetName(), Namespace: actualMeta.GetNamespace()}
-			obj, err := s.create(ctx, owner, key, blueprint)
+		if requiresRecreate(actual, blueprint) {
+			obj, err := s.recreate(ctx, owner, actual, blueprint)
 			return false, obj, err
-		} else {
-			obj, err := s.setOwnerReferenceAndConvertToRuntime(owner, blueprint)
-			if err != nil {
-				return false, actual, err
-			}
+		}
 
-			// to be able to update, we need to set the resource version of the object that we know of
-			obj.(metav1.Object).SetResourceVersion(actualMeta.GetResourceVersion())
+		obj, err := s.setOwnerReferenceAndConvertToRuntime(owner, blueprint)
+		if err != nil {
+			return false, actual, err
+		}
 
-			err = s.client.Update(ctx, obj)
-			if err != nil {
+		// to be able to update, we need to set the resource version of the object that we know of
+		obj.(metav1.Object).SetResourceVersion(actualMeta.GetResourceVersion())
+		preserveClusterPopulatedFields(actual, obj)
+
+		err = s.client.Update(ctx, obj)
+		if err != nil {
+			if !errors.IsInvalid(err) {
 				return false, obj, err
 			}
 
-			return true, obj, nil
+			// the update most probably tried to change some immutable field that we don't know about in
+			// requiresRecreate. The only way forward is to recreate the object.
+			log.Info("The update of the object was rejected as invalid. Will recreate the object.", "kind", kind, "name", actualMeta.GetName(), "namespace", actualMeta.GetNamespace(), "error", err.Error())
+			obj, err = s.recreate(ctx, owner, actual, blueprint)
+			return false, obj, err
 		}
+		metrics.RecordSyncerOperation(kind, metrics.SyncerOperationUpdate)
+		s.recordEvent(owner, EventReasonUpdated, obj)
+
+		return true, obj, nil
 	}
 	return false, actual, nil
 }
 
-func isUpdateUsingDeleteCreate(kind string) bool {
-	// Routes are not able to update the host, so we just need to re-create them...
-	// ingresses and services have been identified to needs this, too, for reasons that I don't know..
-	return "Service" == kind || "Ingress" == kind || "Route" == kind
+// recreate deletes the actual object from the cluster and creates it anew from the blueprint.
+func (s *Syncer) recreate(ctx context.Context, owner metav1.Object, actual runtime.Object, blueprint metav1.Object) (runtime.Object, error) {
+	actualMeta := actual.(metav1.Object)
+
+	err := s.client.Delete(ctx, actual)
+	if err != nil {
+		return actual, err
+	}
+	metrics.RecordSyncerOperation(s.kindOf(actual), metrics.SyncerOperationDelete)
+
+	// the blueprint might have been modified during the failed update attempt
+	blueprint.SetResourceVersion("")
+
+	key := client.ObjectKey{Name: actualMeta.GetName(), Namespace: actualMeta.GetNamespace()}
+	obj, _, err := s.create(ctx, owner, key, blueprint)
+	if err == nil {
+		s.recordEvent(owner, EventReasonRecreated, obj)
+	}
+	return obj, err
+}
+
+// apply applies the blueprint to the cluster using the server-side apply. The object is only deleted and created
+// again if the blueprint changes a field that cannot be updated.
+func (s *Syncer) apply(ctx context.Context, owner metav1.Object, actual runtime.Object, blueprint metav1.Object) (bool, runtime.Object, error) {
+	reason := EventReasonUpdated
+	if actual == nil {
+		reason = EventReasonCreated
+	} else if requiresRecreate(actual, blueprint) {
+		kind := s.kindOf(actual)
+		log.Info("Recreating object because of a change in an immutable field", "kind", kind, "name", blueprint.GetName(), "namespace", blueprint.GetNamespace())
+		if err := s.client.Delete(ctx, actual); err != nil && !errors.IsNotFound(err) {
+			return false, actual, err
+		}
+		metrics.RecordSyncerOperation(kind, metrics.SyncerOperationDelete)
+		actual = nil
+		reason = EventReasonRecreated
+	}
+
+	obj, err := s.setOwnerReferenceAndConvertToRuntime(owner, blueprint)
+	if err != nil {
+		return false, actual, err
+	}
+
+	// the apply patch needs to contain the apiVersion and kind, which our blueprints don't always specify
+	gvk, err := apiutil.GVKForObject(obj, s.scheme)
+	if err != nil {
+		return false, actual, err
+	}
+	obj.GetObjectKind().SetGroupVersionKind(gvk)
+
+	// we want to apply our changes regardless of the changes done to the object by others in the meantime
+	objMeta := obj.(metav1.Object)
+	objMeta.SetResourceVersion("")
+
+	// only the fields set in the blueprint are applied, so the forced ownership only takes over the fields we
+	// actually care about
+	applied, err := toApplyConfiguration(obj)
+	if err != nil {
+		return false, actual, err
+	}
+
+	if err = s.client.Patch(ctx, applied, client.Apply, client.FieldOwner(s.fieldManager), client.ForceOwnership); err != nil {
+		return false, actual, err
+	}
+
+	if err = fromUnstructured(applied, obj); err != nil {
+		return false, actual, err
+	}
+
+	// the resource version only changes if the apply actually changed something
+	if actual != nil && actual.(metav1.Object).GetResourceVersion() == objMeta.GetResourceVersion() {
+		return false, obj, nil
+	}
+
+	if actual == nil {
+		log.Info("Created a new object", "kind", gvk.Kind, "name", objMeta.GetName(), "namespace", objMeta.GetNamespace())
+		metrics.RecordSyncerOperation(gvk.Kind, metrics.SyncerOperationCreate)
+	} else {
+		log.Info("Updated existing object", "kind", gvk.Kind, "name", objMeta.GetName(), "namespace", objMeta.GetNamespace())
+		metrics.RecordSyncerOperation(gvk.Kind, metrics.SyncerOperationUpdate)
+	}
+	s.recordEvent(owner, reason, obj)
+
+	return true, obj, nil
+}
+
+// toApplyConfiguration converts the object to the unstructured form containing only the fields that are set in it.
+// The typed objects serialize the fields without omitempty with their zero values (e.g. the creation timestamp,
+// the status or the empty structs), which would make the server-side apply claim and reset those fields even though
+// they're managed by the cluster or other controllers.
+func toApplyConfiguration(obj runtime.Object) (*unstructured.Unstructured, error) {
+	var content map[string]interface{}
+	if u, ok := obj.(*unstructured.Unstructured); ok {
+		content = u.DeepCopy().Object
+	} else {
+		var err error
+		if content, err = runtime.DefaultUnstructuredConverter.ToUnstructured(obj); err != nil {
+			return nil, err
+		}
+	}
+
+	delete(content, "status")
+	pruneEmptyFields(content)
+
+	ret := &unstructured.Unstructured{Object: content}
+	ret.SetGroupVersionKind(obj.GetObjectKind().GroupVersionKind())
+	return ret, nil
+}
+
+// pruneEmptyFields removes the null values and the empty maps and lists from the object, recursively. The scalar
+// values are kept even if they're zero, because they're either set explicitly or required.
+func pruneEmptyFields(content map[string]interface{}) {
+	for k, v := range content {
+		switch val := v.(type) {
+		case nil:
+			delete(content, k)
+		case map[string]interface{}:
+			pruneEmptyFields(val)
+			if len(val) == 0 {
+				delete(content, k)
+			}
+		case []interface{}:
+			for _, item := range val {
+				if m, ok := item.(map[string]interface{}); ok {
+					pruneEmptyFields(m)
+				}
+			}
+			if len(val) == 0 {
+				delete(content, k)
+			}
+		}
+	}
+}
+
+// fromUnstructured copies the unstructured object into the object, which can also be unstructured.
+func fromUnstructured(u *unstructured.Unstructured, obj runtime.Object) error {
+	if target, ok := obj.(*unstructured.Unstructured); ok {
+		target.Object = u.Object
+		return nil
+	}
+
+	gvk := obj.GetObjectKind().GroupVersionKind()
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(u.Object, obj); err != nil {
+		return err
+	}
+	obj.GetObjectKind().SetGroupVersionKind(gvk)
+	return nil
+}
+
+// requiresRecreate returns true if the blueprint changes a field of the object in the cluster that cannot be
+// updated in place.
+func requiresRecreate(actual runtime.Object, blueprint metav1.Object) bool {
+	switch a := actual.(type) {
+	case *routev1.Route:
+		// an empty host in the blueprint means that it is generated by OpenShift
+		b, ok := blueprint.(*routev1.Route)
+		return ok && b.Spec.Host != "" && b.Spec.Host != a.Spec.Host
+	case *appsv1.Deployment:
+		b, ok := blueprint.(*appsv1.Deployment)
+		return ok && !equality.Semantic.DeepEqual(a.Spec.Selector, b.Spec.Selector)
+	}
+	return false
+}
+
+// preserveClusterPopulatedFields copies the fields that are filled in by the cluster from the actual object to
+// the object that is going to be updated, if they're not set explicitly. Without this, the update would try to
+// reset the fields, which either fails or, worse, changes them.
+func preserveClusterPopulatedFields(actual runtime.Object, obj runtime.Object) {
+	a, ok := actual.(*corev1.Service)
+	if !ok {
+		return
+	}
+	b, ok := obj.(*corev1.Service)
+	if !ok {
+		return
+	}
+
+	if b.Spec.ClusterIP == "" && b.Spec.Type != corev1.ServiceTypeExternalName {
+		b.Spec.ClusterIP = a.Spec.ClusterIP
+	}
+
+	if b.Spec.Type == corev1.ServiceTypeLoadBalancer && b.Spec.HealthCheckNodePort == 0 {
+		b.Spec.HealthCheckNodePort = a.Spec.HealthCheckNodePort
+	}
+
+	if b.Spec.Type == corev1.ServiceTypeNodePort || b.Spec.Type == corev1.ServiceTypeLoadBalancer {
+		for i := range b.Spec.Ports {
+			bp := &b.Spec.Ports[i]
+			if bp.NodePort != 0 {
+				continue
+			}
+			for _, ap := range a.Spec.Ports {
+				if ap.Name == bp.Name && ap.Port == bp.Port && ap.Protocol == bp.Protocol {
+					bp.NodePort = ap.NodePort
+					break
+				}
+			}
+		}
+	}
+}
+
+// recordEvent records an event with given reason about the object on its owner, if the syncer has an event recorder.
+func (s *Syncer) recordEvent(owner metav1.Object, reason string, obj runtime.Object) {
+	if s.recorder == nil || owner == nil {
+		return
+	}
+
+	ownerObj, ok := owner.(runtime.Object)
+	if !ok {
+		return
+	}
+
+	s.recorder.Eventf(ownerObj, corev1.EventTypeNormal, reason, "%s %s %s", reason, s.kindOf(obj), obj.(metav1.Object).GetName())
+}
+
+// kindOf returns the kind of the object. The kind is looked up in the scheme because the objects that we
+// get, e.g. from the client, don't necessarily have their TypeMeta filled in.
+func (s *Syncer) kindOf(obj runtime.Object) string {
+	gvk, err := apiutil.GVKForObject(obj, s.scheme)
+	if err != nil {
+		return obj.GetObjectKind().GroupVersionKind().Kind
+	}
+	return gvk.Kind
+}
+
+// newEmptyObject returns a new empty object of the same type as the provided object to read the object from
+// the cluster into. Reading into a copy of the object is not enough, because decoding the object would merge
+// the maps in the copy with the maps read from the cluster.
+func newEmptyObject(obj runtime.Object) runtime.Object {
+	if u, ok := obj.(*unstructured.Unstructured); ok {
+		ret := &unstructured.Unstructured{}
+		ret.SetGroupVersionKind(u.GroupVersionKind())
+		return ret
+	}
+
+	return reflect.New(reflect.TypeOf(obj).Elem()).Interface().(runtime.Object)
 }
 
 func (s *Syncer) setOwnerReferenceAndConvertToRuntime(owner metav1.Object, obj metav1.Object) (runtime.Object, error) {
@@ -199,6 +713,10 @@ func (s *Syncer) setOwnerReferenceAndConvertToRuntime(owner metav1.Object, obj m
 		return robj, nil
 	}
 
+	if isForeignOwner(owner, obj) {
+		return robj, s.setForeignOwner(owner, obj)
+	}
+
 	err := controllerutil.SetControllerReference(owner, obj, s.scheme)
 	if err != nil {
 		return nil, err
@@ -2,13 +2,24 @@ package sync
 
 import (
 	"context"
+	"fmt"
 	"reflect"
+	"strings"
 	"testing"
 
 	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	routev1 "github.com/openshift/api/route/v1"
 	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/client/fake"
 )
@@ -19,6 +30,8 @@ var (
 
 func init() {
 	corev1.AddToScheme(scheme)
+	rbacv1.AddToScheme(scheme)
+	routev1.AddToScheme(scheme)
 }
 
 func TestSyncCreates(t *testing.T) {
@@ -39,7 +52,7 @@ func TestSyncCreates(t *testing.T) {
 
 	cl := fake.NewFakeClientWithScheme(scheme, preexisting)
 
-	syncer := Syncer{client: cl, scheme: scheme}
+	syncer := Syncer{client: cl, reader: cl, scheme: scheme}
 
 	syncer.Sync(context.TODO(), preexisting, new, cmp.Options{})
 
@@ -94,7 +107,7 @@ func TestSyncUpdates(t *testing.T) {
 
 	cl := fake.NewFakeClientWithScheme(scheme, preexisting)
 
-	syncer := Syncer{client: cl, scheme: scheme}
+	syncer := Syncer{client: cl, reader: cl, scheme: scheme}
 
 	syncer.Sync(context.TODO(), newOwner, update, cmp.Options{})
 
@@ -164,7 +177,7 @@ func TestSyncKeepsAdditionalAnnosAndLabels(t *testing.T) {
 
 	cl := fake.NewFakeClientWithScheme(scheme, preexisting)
 
-	syncer := Syncer{client: cl, scheme: scheme}
+	syncer := Syncer{client: cl, reader: cl, scheme: scheme}
 
 	syncer.Sync(context.TODO(), owner, update, cmp.Options{})
 
@@ -191,3 +204,563 @@ func TestSyncKeepsAdditionalAnnosAndLabels(t *testing.T) {
 		t.Fatal("Unexpected annotations on the synced object")
 	}
 }
+
+func TestSyncRecordsEvents(t *testing.T) {
+	owner := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "owner",
+			Namespace: "default",
+		},
+	}
+
+	cm := &corev1.ConfigMap{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: "v1",
+			Kind:       "ConfigMap",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "cm",
+			Namespace: "default",
+		},
+		Data: map[string]string{"a": "b"},
+	}
+
+	svc := &corev1.Service{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: "v1",
+			Kind:       "Service",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "svc",
+			Namespace: "default",
+		},
+		Spec: corev1.ServiceSpec{
+			Type: corev1.ServiceTypeClusterIP,
+		},
+	}
+
+	cl := fake.NewFakeClientWithScheme(scheme, owner)
+	recorder := record.NewFakeRecorder(10)
+
+	syncer := New(cl, scheme, WithEventRecorder(recorder))
+
+	expectEvent := func(expected string) {
+		select {
+		case e := <-recorder.Events:
+			if e != expected {
+				t.Errorf("Expected event '%s' but got '%s'", expected, e)
+			}
+		default:
+			t.Errorf("Expected event '%s' but there was none", expected)
+		}
+	}
+
+	if _, _, err := syncer.Sync(context.TODO(), owner, cm.DeepCopy(), cmp.Options{}); err != nil {
+		t.Fatalf("Failed to sync: %s", err)
+	}
+	expectEvent("Normal Created Created ConfigMap cm")
+
+	cm.Data["a"] = "c"
+	if _, _, err := syncer.Sync(context.TODO(), owner, cm.DeepCopy(), cmpopts.IgnoreFields(corev1.ConfigMap{}, "TypeMeta", "ObjectMeta")); err != nil {
+		t.Fatalf("Failed to sync: %s", err)
+	}
+	expectEvent("Normal Updated Updated ConfigMap cm")
+
+	if _, _, err := syncer.Sync(context.TODO(), owner, svc.DeepCopy(), cmp.Options{}); err != nil {
+		t.Fatalf("Failed to sync: %s", err)
+	}
+	expectEvent("Normal Created Created Service svc")
+
+	svc.Spec.Type = corev1.ServiceTypeNodePort
+	if _, _, err := syncer.Sync(context.TODO(), owner, svc.DeepCopy(), cmpopts.IgnoreFields(corev1.Service{}, "TypeMeta", "ObjectMeta")); err != nil {
+		t.Fatalf("Failed to sync: %s", err)
+	}
+	expectEvent("Normal Updated Updated Service svc")
+
+	route := &routev1.Route{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "route",
+			Namespace: "default",
+		},
+		Spec: routev1.RouteSpec{
+			Host: "a.host",
+		},
+	}
+	if _, _, err := syncer.Sync(context.TODO(), owner, route.DeepCopy(), cmp.Options{}); err != nil {
+		t.Fatalf("Failed to sync: %s", err)
+	}
+	expectEvent("Normal Created Created Route route")
+
+	route.Spec.Host = "b.host"
+	if _, _, err := syncer.Sync(context.TODO(), owner, route.DeepCopy(), cmpopts.IgnoreFields(routev1.Route{}, "TypeMeta", "ObjectMeta")); err != nil {
+		t.Fatalf("Failed to sync: %s", err)
+	}
+	expectEvent("Normal Recreated Recreated Route route")
+
+	// no events for objects without an owner
+	if _, _, err := syncer.Sync(context.TODO(), nil, &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "unowned", Namespace: "default"}}, cmp.Options{}); err != nil {
+		t.Fatalf("Failed to sync: %s", err)
+	}
+	if len(recorder.Events) != 0 {
+		t.Errorf("There should have been no event recorded for an object without an owner but got: %s", <-recorder.Events)
+	}
+}
+
+// applyClient emulates the server-side apply on top of the fake client, which doesn't support it. The last applied
+// object is remembered so that the tests can check what was sent.
+type applyClient struct {
+	client.Client
+	applied *unstructured.Unstructured
+}
+
+func (c *applyClient) Patch(ctx context.Context, obj runtime.Object, patch client.Patch, opts ...client.PatchOption) error {
+	if patch.Type() != types.ApplyPatchType {
+		return c.Client.Patch(ctx, obj, patch, opts...)
+	}
+
+	u, ok := obj.(*unstructured.Unstructured)
+	if !ok {
+		return fmt.Errorf("the applied object should be unstructured but is %T", obj)
+	}
+	c.applied = u.DeepCopy()
+
+	typed, err := scheme.New(u.GroupVersionKind())
+	if err != nil {
+		return err
+	}
+	if err = runtime.DefaultUnstructuredConverter.FromUnstructured(u.Object, typed); err != nil {
+		return err
+	}
+
+	key, err := client.ObjectKeyFromObject(typed)
+	if err != nil {
+		return err
+	}
+
+	existing := typed.DeepCopyObject()
+	if err = c.Client.Get(ctx, key, existing); err != nil {
+		if !errors.IsNotFound(err) {
+			return err
+		}
+		if err = c.Client.Create(ctx, typed); err != nil {
+			return err
+		}
+	} else {
+		// like the API server, don't change the resource version if the apply doesn't change anything
+		typed.(metav1.Object).SetResourceVersion(existing.(metav1.Object).GetResourceVersion())
+		existing.GetObjectKind().SetGroupVersionKind(typed.GetObjectKind().GroupVersionKind())
+		if !equality.Semantic.DeepEqual(existing, typed) {
+			if err = c.Client.Update(ctx, typed); err != nil {
+				return err
+			}
+		}
+	}
+
+	content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(typed)
+	if err != nil {
+		return err
+	}
+	u.Object = content
+	return nil
+}
+
+func TestServerSideApply(t *testing.T) {
+	owner := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "owner",
+			Namespace: "default",
+		},
+	}
+
+	svc := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "svc",
+			Namespace: "default",
+		},
+		Spec: corev1.ServiceSpec{
+			Ports: []corev1.ServicePort{{Name: "http", Port: 8080}},
+		},
+	}
+
+	cl := &applyClient{Client: fake.NewFakeClientWithScheme(scheme, owner)}
+	recorder := record.NewFakeRecorder(10)
+
+	syncer := New(cl, scheme, WithEventRecorder(recorder), WithServerSideApply("test"))
+
+	expectSync := func(blueprint metav1.Object, expectedChange bool, expectedEvent string) {
+		changed, _, err := syncer.Sync(context.TODO(), owner, blueprint, cmp.Options{})
+		if err != nil {
+			t.Fatalf("Failed to sync: %s", err)
+		}
+		if changed != expectedChange {
+			t.Errorf("Expected the sync to report change=%v but got %v", expectedChange, changed)
+		}
+
+		if expectedEvent == "" {
+			if len(recorder.Events) != 0 {
+				t.Errorf("Expected no event but got '%s'", <-recorder.Events)
+			}
+			return
+		}
+
+		if len(recorder.Events) != 1 {
+			t.Fatalf("Expected a single event but there were %d", len(recorder.Events))
+		}
+		if e := <-recorder.Events; e != expectedEvent {
+			t.Errorf("Expected event '%s' but got '%s'", expectedEvent, e)
+		}
+	}
+
+	expectSync(svc.DeepCopy(), true, "Normal Created Created Service svc")
+	expectSync(svc.DeepCopy(), false, "")
+
+	// services are updated in place when applying
+	svc.Spec.Ports[0].Port = 8081
+	expectSync(svc.DeepCopy(), true, "Normal Updated Updated Service svc")
+
+	synced := &corev1.Service{}
+	if err := cl.Get(context.TODO(), client.ObjectKey{Name: "svc", Namespace: "default"}, synced); err != nil {
+		t.Fatalf("Failed to get the synced service: %s", err)
+	}
+	if synced.Spec.Ports[0].Port != 8081 {
+		t.Errorf("The service should have been updated")
+	}
+	if len(synced.OwnerReferences) == 0 || synced.OwnerReferences[0].Name != "owner" {
+		t.Errorf("The service should have been owned by the owner")
+	}
+
+	// only the fields set in the blueprint are applied, so that we don't take over the rest
+	for _, path := range [][]string{{"status"}, {"metadata", "creationTimestamp"}, {"spec", "sessionAffinity"}} {
+		if _, found, _ := unstructured.NestedFieldNoCopy(cl.applied.Object, path...); found {
+			t.Errorf("The field %s should not have been applied: %v", strings.Join(path, "."), cl.applied.Object)
+		}
+	}
+	if port, _, _ := unstructured.NestedSlice(cl.applied.Object, "spec", "ports"); len(port) != 1 {
+		t.Errorf("The ports of the service should have been applied: %v", cl.applied.Object)
+	}
+
+	// the route host cannot be changed, so the route needs to be recreated
+	route := &routev1.Route{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "route",
+			Namespace: "default",
+		},
+		Spec: routev1.RouteSpec{
+			Host: "a.host",
+		},
+	}
+	expectSync(route.DeepCopy(), true, "Normal Created Created Route route")
+
+	route.Spec.Host = "b.host"
+	expectSync(route.DeepCopy(), true, "Normal Recreated Recreated Route route")
+}
+
+// invalidUpdateClient rejects all updates as invalid, like the API server does when an immutable field is changed.
+type invalidUpdateClient struct {
+	client.Client
+}
+
+func (c invalidUpdateClient) Update(ctx context.Context, obj runtime.Object, opts ...client.UpdateOption) error {
+	return errors.NewInvalid(obj.GetObjectKind().GroupVersionKind().GroupKind(), obj.(metav1.Object).GetName(), nil)
+}
+
+func TestSyncUpdatesServicesInPlace(t *testing.T) {
+	preexisting := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "svc",
+			Namespace: "default",
+		},
+		Spec: corev1.ServiceSpec{
+			Type:      corev1.ServiceTypeNodePort,
+			ClusterIP: "10.0.0.42",
+			Ports:     []corev1.ServicePort{{Name: "http", Port: 8080, NodePort: 30000}},
+		},
+	}
+
+	update := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "svc",
+			Namespace: "default",
+			Labels:    map[string]string{"a": "b"},
+		},
+		Spec: corev1.ServiceSpec{
+			Type:  corev1.ServiceTypeNodePort,
+			Ports: []corev1.ServicePort{{Name: "http", Port: 8080}, {Name: "https", Port: 8443}},
+		},
+	}
+
+	cl := fake.NewFakeClientWithScheme(scheme, preexisting)
+
+	syncer := New(cl, scheme)
+
+	changed, _, err := syncer.Sync(context.TODO(), nil, update, cmpopts.IgnoreFields(corev1.Service{}, "TypeMeta", "ObjectMeta"))
+	if err != nil {
+		t.Fatalf("Failed to sync: %s", err)
+	}
+	if !changed {
+		t.Error("The service should have been updated")
+	}
+
+	synced := &corev1.Service{}
+	if err = cl.Get(context.TODO(), client.ObjectKey{Name: "svc", Namespace: "default"}, synced); err != nil {
+		t.Fatalf("Failed to get the synced service: %s", err)
+	}
+
+	if synced.Spec.ClusterIP != "10.0.0.42" {
+		t.Errorf("The cluster IP of the service should have been preserved but was '%s'", synced.Spec.ClusterIP)
+	}
+	if len(synced.Spec.Ports) != 2 {
+		t.Fatalf("The service should have been updated with the new port")
+	}
+	if synced.Spec.Ports[0].NodePort != 30000 {
+		t.Errorf("The node port of the service should have been preserved but was %d", synced.Spec.Ports[0].NodePort)
+	}
+}
+
+func TestSyncRecreatesWhenUpdateIsInvalid(t *testing.T) {
+	preexisting := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "svc",
+			Namespace: "default",
+		},
+		Spec: corev1.ServiceSpec{
+			Ports: []corev1.ServicePort{{Name: "http", Port: 8080}},
+		},
+	}
+
+	update := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "svc",
+			Namespace: "default",
+		},
+		Spec: corev1.ServiceSpec{
+			Ports: []corev1.ServicePort{{Name: "http", Port: 8081}},
+		},
+	}
+
+	cl := invalidUpdateClient{fake.NewFakeClientWithScheme(scheme, preexisting)}
+
+	syncer := New(cl, scheme)
+
+	if _, _, err := syncer.Sync(context.TODO(), nil, update, cmpopts.IgnoreFields(corev1.Service{}, "TypeMeta", "ObjectMeta")); err != nil {
+		t.Fatalf("Failed to sync: %s", err)
+	}
+
+	synced := &corev1.Service{}
+	if err := cl.Get(context.TODO(), client.ObjectKey{Name: "svc", Namespace: "default"}, synced); err != nil {
+		t.Fatalf("Failed to get the synced service: %s", err)
+	}
+
+	if synced.Spec.Ports[0].Port != 8081 {
+		t.Errorf("The service should have been recreated with the new port")
+	}
+}
+
+// conflictingClient fails the configured number of updates with a conflict, like the API server does when
+// the object was modified by someone else since we read it.
+type conflictingClient struct {
+	client.Client
+	conflicts *int
+}
+
+func (c conflictingClient) Update(ctx context.Context, obj runtime.Object, opts ...client.UpdateOption) error {
+	if *c.conflicts > 0 {
+		*c.conflicts--
+		return errors.NewConflict(schema.GroupResource{Resource: "services"}, obj.(metav1.Object).GetName(), fmt.Errorf("the object has been modified"))
+	}
+	return c.Client.Update(ctx, obj, opts...)
+}
+
+func TestSyncRetriesUpdateOnConflict(t *testing.T) {
+	preexisting := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "svc",
+			Namespace: "default",
+		},
+		Spec: corev1.ServiceSpec{
+			Ports: []corev1.ServicePort{{Name: "http", Port: 8080}},
+		},
+	}
+
+	update := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "svc",
+			Namespace: "default",
+		},
+		Spec: corev1.ServiceSpec{
+			Ports: []corev1.ServicePort{{Name: "http", Port: 8081}},
+		},
+	}
+
+	conflicts := 2
+	cl := conflictingClient{Client: fake.NewFakeClientWithScheme(scheme, preexisting), conflicts: &conflicts}
+
+	syncer := New(cl, scheme)
+
+	changed, _, err := syncer.Sync(context.TODO(), nil, update, cmpopts.IgnoreFields(corev1.Service{}, "TypeMeta", "ObjectMeta"))
+	if err != nil {
+		t.Fatalf("The sync should have succeeded after retrying the conflicting updates: %s", err)
+	}
+	if !changed {
+		t.Error("The service should have been updated")
+	}
+
+	synced := &corev1.Service{}
+	if err := cl.Get(context.TODO(), client.ObjectKey{Name: "svc", Namespace: "default"}, synced); err != nil {
+		t.Fatalf("Failed to get the synced service: %s", err)
+	}
+
+	if synced.Spec.Ports[0].Port != 8081 {
+		t.Errorf("The service should have been updated with the new port")
+	}
+
+	conflicts = 100
+	update.Spec.Ports[0].Port = 8082
+	if _, _, err = syncer.Sync(context.TODO(), nil, update, cmpopts.IgnoreFields(corev1.Service{}, "TypeMeta", "ObjectMeta")); !errors.IsConflict(err) {
+		t.Errorf("The sync should have given up on the constantly conflicting updates but returned: %v", err)
+	}
+}
+
+func TestCreateDoesntChangeExistingObjects(t *testing.T) {
+	owner := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "owner",
+			Namespace: "default",
+			UID:       "owner-uid",
+		},
+	}
+
+	preexisting := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "claim",
+			Namespace: "default",
+		},
+		Data: map[string]string{"owner": "other"},
+	}
+
+	cl := fake.NewFakeClientWithScheme(scheme, owner, preexisting)
+
+	syncer := New(cl, scheme)
+
+	created, obj, err := syncer.Create(context.TODO(), owner, &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "claim",
+			Namespace: "default",
+		},
+		Data: map[string]string{"owner": "owner"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if created {
+		t.Error("The existing object should not have been created")
+	}
+	if obj.(*corev1.ConfigMap).Data["owner"] != "other" {
+		t.Errorf("The existing object should have been returned but got %v", obj)
+	}
+
+	cm := &corev1.ConfigMap{}
+	if err = cl.Get(context.TODO(), client.ObjectKey{Name: "claim", Namespace: "default"}, cm); err != nil {
+		t.Fatal(err)
+	}
+	if cm.Data["owner"] != "other" || cm.Labels[OwnerUIDLabel] != "" {
+		t.Errorf("The existing object should not have been changed but is %v", cm)
+	}
+
+	created, obj, err = syncer.Create(context.TODO(), owner, &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "new",
+			Namespace: "default",
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !created {
+		t.Error("The new object should have been created")
+	}
+	if obj.(*corev1.ConfigMap).Labels[OwnerUIDLabel] != "owner-uid" {
+		t.Errorf("The created object should have been labeled with the owner UID but got %v", obj)
+	}
+}
+
+func TestPrune(t *testing.T) {
+	owner := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "owner",
+			Namespace: "default",
+			UID:       "owner-uid",
+		},
+	}
+
+	stale := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "stale",
+			Namespace: "default",
+			Labels:    map[string]string{OwnerUIDLabel: "owner-uid"},
+			OwnerReferences: []metav1.OwnerReference{{
+				APIVersion: "v1",
+				Kind:       "Pod",
+				Name:       "owner",
+				UID:        "owner-uid",
+				Controller: func() *bool { b := true; return &b }(),
+			}},
+		},
+	}
+
+	// not controlled by the owner, even though it has the label
+	foreign := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "foreign",
+			Namespace: "default",
+			Labels:    map[string]string{OwnerUIDLabel: "owner-uid"},
+		},
+	}
+
+	cl := fake.NewFakeClientWithScheme(scheme, owner, stale, foreign)
+	recorder := record.NewFakeRecorder(10)
+
+	syncer := New(cl, scheme, WithEventRecorder(recorder))
+
+	desired := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "desired",
+			Namespace: "default",
+		},
+		Data: map[string]string{"a": "b"},
+	}
+
+	if _, _, err := syncer.Sync(context.TODO(), owner, desired, cmpopts.IgnoreFields(corev1.ConfigMap{}, "TypeMeta", "ObjectMeta")); err != nil {
+		t.Fatalf("Failed to sync: %s", err)
+	}
+
+	synced := &corev1.ConfigMap{}
+	if err := cl.Get(context.TODO(), client.ObjectKey{Name: "desired", Namespace: "default"}, synced); err != nil {
+		t.Fatalf("Failed to get the synced config map: %s", err)
+	}
+	if synced.Labels[OwnerUIDLabel] != "owner-uid" {
+		t.Errorf("The synced object should have been labeled with the owner UID")
+	}
+
+	if err := syncer.Prune(context.TODO(), owner, &corev1.ConfigMapList{}); err != nil {
+		t.Fatalf("Failed to prune: %s", err)
+	}
+
+	if err := cl.Get(context.TODO(), client.ObjectKey{Name: "desired", Namespace: "default"}, &corev1.ConfigMap{}); err != nil {
+		t.Errorf("The synced object should not have been pruned: %s", err)
+	}
+	if err := cl.Get(context.TODO(), client.ObjectKey{Name: "stale", Namespace: "default"}, &corev1.ConfigMap{}); !errors.IsNotFound(err) {
+		t.Errorf("The stale object should have been pruned")
+	}
+	if err := cl.Get(context.TODO(), client.ObjectKey{Name: "foreign", Namespace: "default"}, &corev1.ConfigMap{}); err != nil {
+		t.Errorf("The object not controlled by the owner should not have been pruned: %s", err)
+	}
+
+	events := []string{}
+	for len(recorder.Events) > 0 {
+		events = append(events, <-recorder.Events)
+	}
+	if !reflect.DeepEqual(events, []string{"Normal Created Created ConfigMap desired", "Normal Pruned Pruned ConfigMap stale"}) {
+		t.Errorf("Unexpected events: %v", events)
+	}
+}
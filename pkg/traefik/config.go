@@ -0,0 +1,160 @@
+//
+// Copyright (c) 2019-2021 Red Hat, Inc.
+// This program and the accompanying materials are made
+// available under the terms of the Eclipse Public License 2.0
+// which is available at https://www.eclipse.org/legal/epl-2.0/
+//
+// SPDX-License-Identifier: EPL-2.0
+//
+// Contributors:
+//   Red Hat, Inc. - initial API and implementation
+//
+
+// Package traefik models the dynamic configuration of the Traefik gateway and the way Traefik routes the requests
+// according to it.
+package traefik
+
+// Config is a representation of the dynamic Traefik config as we need it. This is in no way complete but can be
+// used for the purposes we need it for.
+type Config struct {
+	HTTP HTTP `json:"http"`
+}
+
+type HTTP struct {
+	Routers           map[string]Router           `json:"routers"`
+	Services          map[string]Service          `json:"services"`
+	Middlewares       map[string]Middleware       `json:"middlewares"`
+	ServersTransports map[string]ServersTransport `json:"serversTransports,omitempty"`
+}
+
+type Router struct {
+	Rule        string   `json:"rule"`
+	Service     string   `json:"service"`
+	Middlewares []string `json:"middlewares"`
+	Priority    int      `json:"priority"`
+}
+
+type Service struct {
+	LoadBalancer LoadBalancer `json:"loadBalancer"`
+}
+
+type Middleware struct {
+	StripPrefix    *StripPrefix    `json:"stripPrefix,omitempty"`
+	AddPrefix      *AddPrefix      `json:"addPrefix,omitempty"`
+	Headers        *Headers        `json:"headers,omitempty"`
+	RedirectRegex  *RedirectRegex  `json:"redirectRegex,omitempty"`
+	Retry          *Retry          `json:"retry,omitempty"`
+	CircuitBreaker *CircuitBreaker `json:"circuitBreaker,omitempty"`
+	IPWhiteList    *IPWhiteList    `json:"ipWhiteList,omitempty"`
+	RateLimit      *RateLimit      `json:"rateLimit,omitempty"`
+	Errors         *Errors         `json:"errors,omitempty"`
+	ReplacePath    *ReplacePath    `json:"replacePath,omitempty"`
+}
+
+type LoadBalancer struct {
+	Servers []Server `json:"servers"`
+	// ServersTransport is the name of the servers transport used to connect to the servers
+	ServersTransport string `json:"serversTransport,omitempty"`
+}
+
+type Server struct {
+	URL string `json:"url"`
+}
+
+type StripPrefix struct {
+	Prefixes []string `json:"prefixes"`
+}
+
+type AddPrefix struct {
+	Prefix string `json:"prefix"`
+}
+
+// Headers sets the headers of the requests and responses. The headers with empty values are removed. If any of
+// the AccessControl fields is set, Traefik responds to the CORS preflight requests itself.
+type Headers struct {
+	CustomRequestHeaders  map[string]string `json:"customRequestHeaders,omitempty"`
+	CustomResponseHeaders map[string]string `json:"customResponseHeaders,omitempty"`
+
+	AccessControlAllowOriginList  []string `json:"accessControlAllowOriginList,omitempty"`
+	AccessControlAllowMethods     []string `json:"accessControlAllowMethods,omitempty"`
+	AccessControlAllowHeaders     []string `json:"accessControlAllowHeaders,omitempty"`
+	AccessControlAllowCredentials bool     `json:"accessControlAllowCredentials,omitempty"`
+	AccessControlMaxAge           int64    `json:"accessControlMaxAge,omitempty"`
+	AddVaryHeader                 bool     `json:"addVaryHeader,omitempty"`
+}
+
+type RedirectRegex struct {
+	Regex       string `json:"regex"`
+	Replacement string `json:"replacement"`
+	Permanent   bool   `json:"permanent,omitempty"`
+}
+
+type Retry struct {
+	Attempts        int    `json:"attempts"`
+	InitialInterval string `json:"initialInterval,omitempty"`
+}
+
+// CircuitBreaker stops forwarding the requests to the service when the expression evaluates to true,
+// e.g. "NetworkErrorRatio() > 0.5".
+type CircuitBreaker struct {
+	Expression string `json:"expression"`
+}
+
+// IPWhiteList only lets through the requests from the clients with the IP addresses in the source ranges.
+type IPWhiteList struct {
+	SourceRange []string    `json:"sourceRange"`
+	IPStrategy  *IPStrategy `json:"ipStrategy,omitempty"`
+}
+
+// RateLimit limits the number of requests per second of each client.
+type RateLimit struct {
+	Average         int64            `json:"average"`
+	Burst           int64            `json:"burst,omitempty"`
+	SourceCriterion *SourceCriterion `json:"sourceCriterion,omitempty"`
+}
+
+type SourceCriterion struct {
+	IPStrategy *IPStrategy `json:"ipStrategy,omitempty"`
+}
+
+// IPStrategy specifies how the IP address of the client is determined. If Depth is set, the IP address is taken from
+// the X-Forwarded-For header, Depth entries from the right. Otherwise, the address of the connection is used.
+type IPStrategy struct {
+	Depth int `json:"depth,omitempty"`
+}
+
+// Errors replaces the body of the responses with the status codes in the Status ranges, e.g. "502" or "500-599",
+// with the page the Service returns for the Query. The "{status}" placeholder in the query is replaced with
+// the status code of the response.
+type Errors struct {
+	Status  []string `json:"status"`
+	Service string   `json:"service"`
+	Query   string   `json:"query,omitempty"`
+}
+
+// ReplacePath replaces the path of the request with the Path.
+type ReplacePath struct {
+	Path string `json:"path"`
+}
+
+// ServersTransport configures how the gateway connects to the servers of the services that use it. The servers
+// transports require at least Traefik 2.4.
+type ServersTransport struct {
+	ForwardingTimeouts *ForwardingTimeouts `json:"forwardingTimeouts,omitempty"`
+}
+
+// ForwardingTimeouts are the durations in the format understood by time.ParseDuration, e.g. "90s".
+type ForwardingTimeouts struct {
+	DialTimeout           string `json:"dialTimeout,omitempty"`
+	ResponseHeaderTimeout string `json:"responseHeaderTimeout,omitempty"`
+	IdleConnTimeout       string `json:"idleConnTimeout,omitempty"`
+}
+
+// EffectivePriority returns the priority with which Traefik matches the router. If the router doesn't specify
+// the priority explicitly, it is the length of its rule.
+func (r Router) EffectivePriority() int {
+	if r.Priority > 0 {
+		return r.Priority
+	}
+	return len(r.Rule)
+}
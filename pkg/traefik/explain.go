@@ -0,0 +1,345 @@
+//
+// Copyright (c) 2019-2021 Red Hat, Inc.
+// This program and the accompanying materials are made
+// available under the terms of the Eclipse Public License 2.0
+// which is available at https://www.eclipse.org/legal/epl-2.0/
+//
+// SPDX-License-Identifier: EPL-2.0
+//
+// Contributors:
+//   Red Hat, Inc. - initial API and implementation
+//
+
+package traefik
+
+import (
+	"fmt"
+	"net/http"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/yaml"
+)
+
+// Gateway is the dynamic configuration of the gateway merged from all the configuration files, the same way
+// Traefik merges them.
+type Gateway struct {
+	routers     map[string]Router
+	services    map[string]Service
+	middlewares map[string]Middleware
+	transports  map[string]ServersTransport
+
+	// the routers in the order Traefik tries to match them
+	ordered []parsedRouter
+}
+
+type parsedRouter struct {
+	name string
+	rule Rule
+}
+
+// Explanation describes how the gateway handles a request.
+type Explanation struct {
+	// RouterName is the name of the router that matches the request
+	RouterName string
+	Router     Router
+	// Middlewares are the middlewares applied to the request, in the order they're applied
+	Middlewares []AppliedMiddleware
+	ServiceName string
+	Service     Service
+	// Path is the path of the request as it is forwarded to the backend
+	Path string
+	// Backend is the URL to which the request is forwarded
+	Backend string
+	// TransportName is the name of the servers transport used to forward the request, if the service specifies it
+	TransportName string
+	Transport     ServersTransport
+	// Redirect is the URL to which the client is redirected, if the request is redirected instead of forwarded
+	Redirect string
+}
+
+// AppliedMiddleware describes what a middleware did to a request
+type AppliedMiddleware struct {
+	Name       string
+	Middleware Middleware
+	// Effect is a human readable description of what the middleware did
+	Effect string
+}
+
+// NewGateway merges the configurations and checks that the routers can be used. The configurations cannot contain
+// different definitions of the same router, service or middleware, because it'd be undefined which one Traefik
+// would use.
+func NewGateway(configs ...Config) (*Gateway, error) {
+	g := &Gateway{
+		routers:     map[string]Router{},
+		services:    map[string]Service{},
+		middlewares: map[string]Middleware{},
+		transports:  map[string]ServersTransport{},
+	}
+
+	for _, c := range configs {
+		for name, r := range c.HTTP.Routers {
+			if existing, ok := g.routers[name]; ok && !reflect.DeepEqual(existing, r) {
+				return nil, fmt.Errorf("router '%s' is defined multiple times with different configuration", name)
+			}
+			g.routers[name] = r
+		}
+		for name, s := range c.HTTP.Services {
+			if existing, ok := g.services[name]; ok && !reflect.DeepEqual(existing, s) {
+				return nil, fmt.Errorf("service '%s' is defined multiple times with different configuration", name)
+			}
+			g.services[name] = s
+		}
+		for name, m := range c.HTTP.Middlewares {
+			if existing, ok := g.middlewares[name]; ok && !reflect.DeepEqual(existing, m) {
+				return nil, fmt.Errorf("middleware '%s' is defined multiple times with different configuration", name)
+			}
+			g.middlewares[name] = m
+		}
+		for name, t := range c.HTTP.ServersTransports {
+			if existing, ok := g.transports[name]; ok && !reflect.DeepEqual(existing, t) {
+				return nil, fmt.Errorf("servers transport '%s' is defined multiple times with different configuration", name)
+			}
+			g.transports[name] = t
+		}
+	}
+
+	for name, r := range g.routers {
+		rule, err := ParseRule(r.Rule)
+		if err != nil {
+			return nil, fmt.Errorf("router '%s': %s", name, err)
+		}
+		g.ordered = append(g.ordered, parsedRouter{name: name, rule: rule})
+	}
+
+	// Traefik tries the routers with higher priority first. We order the routers with the same priority by name
+	// so that the explanation is at least stable.
+	sort.Slice(g.ordered, func(i, j int) bool {
+		pi := g.routers[g.ordered[i].name].EffectivePriority()
+		pj := g.routers[g.ordered[j].name].EffectivePriority()
+		if pi != pj {
+			return pi > pj
+		}
+		return g.ordered[i].name < g.ordered[j].name
+	})
+
+	return g, nil
+}
+
+// ExplainURL explains how the gateway handles a GET request to the URL.
+func (g *Gateway) ExplainURL(url string) (*Explanation, error) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	return g.Explain(req)
+}
+
+// Explain finds the router that matches the request and figures out where the request is forwarded to.
+func (g *Gateway) Explain(req *http.Request) (*Explanation, error) {
+	var routerName string
+	for _, r := range g.ordered {
+		if r.rule.Matches(req) {
+			routerName = r.name
+			break
+		}
+	}
+
+	if routerName == "" {
+		return nil, fmt.Errorf("no router matches the request to %s", req.URL)
+	}
+
+	router := g.routers[routerName]
+	ex := &Explanation{
+		RouterName:  routerName,
+		Router:      router,
+		ServiceName: router.Service,
+		Path:        req.URL.Path,
+	}
+
+	for _, name := range router.Middlewares {
+		m, ok := g.middlewares[name]
+		if !ok {
+			return nil, fmt.Errorf("router '%s' uses the middleware '%s' that doesn't exist", routerName, name)
+		}
+
+		applied := AppliedMiddleware{Name: name, Middleware: m}
+		if m.RedirectRegex != nil {
+			redirect, err := redirectURL(m.RedirectRegex, req)
+			if err != nil {
+				return nil, fmt.Errorf("middleware '%s': %s", name, err)
+			}
+			if redirect != "" {
+				applied.Effect = fmt.Sprintf("redirected the request to %s", redirect)
+				ex.Middlewares = append(ex.Middlewares, applied)
+				ex.Redirect = redirect
+				return ex, nil
+			}
+			applied.Effect = "the regex didn't match the request"
+		} else {
+			ex.Path, applied.Effect = apply(m, ex.Path)
+		}
+		ex.Middlewares = append(ex.Middlewares, applied)
+	}
+
+	service, ok := g.services[router.Service]
+	if !ok {
+		return nil, fmt.Errorf("router '%s' uses the service '%s' that doesn't exist", routerName, router.Service)
+	}
+	ex.Service = service
+
+	if len(service.LoadBalancer.Servers) == 0 {
+		return nil, fmt.Errorf("service '%s' has no servers", router.Service)
+	}
+
+	if name := service.LoadBalancer.ServersTransport; name != "" {
+		transport, ok := g.transports[name]
+		if !ok {
+			return nil, fmt.Errorf("service '%s' uses the servers transport '%s' that doesn't exist", router.Service, name)
+		}
+		ex.TransportName = name
+		ex.Transport = transport
+	}
+
+	ex.Backend = strings.TrimSuffix(service.LoadBalancer.Servers[0].URL, "/") + ex.Path
+	if req.URL.RawQuery != "" {
+		ex.Backend += "?" + req.URL.RawQuery
+	}
+
+	return ex, nil
+}
+
+// apply applies the middleware to the request path and returns the new path together with the description of
+// the effect.
+func apply(m Middleware, path string) (string, string) {
+	if m.StripPrefix != nil {
+		for _, prefix := range m.StripPrefix.Prefixes {
+			if strings.HasPrefix(path, prefix) {
+				stripped := strings.TrimPrefix(path, prefix)
+				if !strings.HasPrefix(stripped, "/") {
+					stripped = "/" + stripped
+				}
+				return stripped, fmt.Sprintf("stripped the prefix %s from the path and put it into the X-Forwarded-Prefix header", prefix)
+			}
+		}
+		return path, "no prefix matched the path"
+	}
+
+	if m.AddPrefix != nil {
+		return m.AddPrefix.Prefix + path, fmt.Sprintf("added the prefix %s to the path", m.AddPrefix.Prefix)
+	}
+
+	if m.Headers != nil {
+		var effects []string
+		set, removed := describeHeaders(m.Headers.CustomRequestHeaders)
+		if set != "" {
+			effects = append(effects, fmt.Sprintf("set the request headers %s", set))
+		}
+		if removed != "" {
+			effects = append(effects, fmt.Sprintf("removed the request headers %s", removed))
+		}
+		set, removed = describeHeaders(m.Headers.CustomResponseHeaders)
+		if set != "" {
+			effects = append(effects, fmt.Sprintf("will set the response headers %s", set))
+		}
+		if removed != "" {
+			effects = append(effects, fmt.Sprintf("will remove the response headers %s", removed))
+		}
+		if origins := m.Headers.AccessControlAllowOriginList; len(origins) > 0 {
+			effects = append(effects, fmt.Sprintf("will allow the cross-origin requests from %s", strings.Join(origins, ", ")))
+		}
+		return path, strings.Join(effects, ", ")
+	}
+
+	if m.IPWhiteList != nil {
+		return path, fmt.Sprintf("will only let through the requests from %s", strings.Join(m.IPWhiteList.SourceRange, ", "))
+	}
+
+	if m.RateLimit != nil {
+		burst := m.RateLimit.Burst
+		if burst == 0 {
+			// the default of Traefik
+			burst = 1
+		}
+		return path, fmt.Sprintf("will limit the requests of each client to %d per second on average with bursts of %d", m.RateLimit.Average, burst)
+	}
+
+	if m.ReplacePath != nil {
+		return m.ReplacePath.Path, fmt.Sprintf("replaced the path with %s", m.ReplacePath.Path)
+	}
+
+	if m.Errors != nil {
+		return path, fmt.Sprintf("will replace the responses with the status %s with the error page from the service %s",
+			strings.Join(m.Errors.Status, ", "), m.Errors.Service)
+	}
+
+	if m.Retry != nil {
+		return path, fmt.Sprintf("allowed up to %d attempts to forward the request to an unreachable backend", m.Retry.Attempts)
+	}
+
+	if m.CircuitBreaker != nil {
+		return path, fmt.Sprintf("let the request through unless %s", m.CircuitBreaker.Expression)
+	}
+
+	return path, "no effect on the routing"
+}
+
+// describeHeaders returns the descriptions of the headers that are set and of those that are removed.
+func describeHeaders(headers map[string]string) (string, string) {
+	var set, removed []string
+	for h, v := range headers {
+		if v == "" {
+			removed = append(removed, h)
+		} else {
+			set = append(set, fmt.Sprintf("%s: %s", h, v))
+		}
+	}
+	sort.Strings(set)
+	sort.Strings(removed)
+	return strings.Join(set, ", "), strings.Join(removed, ", ")
+}
+
+// redirectURL returns the URL the middleware redirects the request to or an empty string if the request isn't
+// redirected. The regex is matched against the full URL of the request, the same way Traefik does it.
+func redirectURL(r *RedirectRegex, req *http.Request) (string, error) {
+	re, err := regexp.Compile(r.Regex)
+	if err != nil {
+		return "", err
+	}
+
+	scheme := req.URL.Scheme
+	if scheme == "" {
+		scheme = "http"
+	}
+	host := req.Host
+	if host == "" {
+		host = req.URL.Host
+	}
+	url := scheme + "://" + host + req.URL.RequestURI()
+
+	if !re.MatchString(url) {
+		return "", nil
+	}
+
+	return re.ReplaceAllString(url, r.Replacement), nil
+}
+
+// GatewayFromConfigMaps creates the gateway from all the configuration files in the config maps, the same way
+// the gateway reads them.
+func GatewayFromConfigMaps(configMaps ...corev1.ConfigMap) (*Gateway, error) {
+	var configs []Config
+	for _, cm := range configMaps {
+		for file, content := range cm.Data {
+			config := Config{}
+			if err := yaml.Unmarshal([]byte(content), &config); err != nil {
+				return nil, fmt.Errorf("failed to parse %s in the config map %s/%s: %s", file, cm.Namespace, cm.Name, err)
+			}
+			configs = append(configs, config)
+		}
+	}
+
+	return NewGateway(configs...)
+}
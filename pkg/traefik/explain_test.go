@@ -0,0 +1,131 @@
+package traefik
+
+import (
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestExplainsRouting(t *testing.T) {
+	g, err := GatewayFromConfigMaps(corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "wsid", Namespace: "che"},
+		Data: map[string]string{
+			"wsid.yml": `
+http:
+  routers:
+    wsid-m1-9999:
+      rule: "PathPrefix(` + "`/wsid/m1/9999`" + `)"
+      service: wsid-m1-9999
+      middlewares: [wsid-m1-9999]
+    dashboard:
+      rule: "PathPrefix(` + "`/`" + `)"
+      service: dashboard
+  services:
+    wsid-m1-9999:
+      loadBalancer:
+        servers:
+        - url: "http://wsid-service.ws.svc:9999"
+    dashboard:
+      loadBalancer:
+        servers:
+        - url: "http://dashboard.che.svc:8080/"
+  middlewares:
+    wsid-m1-9999:
+      stripPrefix:
+        prefixes: ["/wsid/m1/9999"]
+`,
+		},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create the gateway: %s", err)
+	}
+
+	ex, err := g.ExplainURL("https://che.example.com/wsid/m1/9999/1/?a=b")
+	if err != nil {
+		t.Fatalf("Failed to explain the URL: %s", err)
+	}
+
+	if ex.RouterName != "wsid-m1-9999" {
+		t.Errorf("Unexpected router %s", ex.RouterName)
+	}
+	if len(ex.Middlewares) != 1 || !strings.Contains(ex.Middlewares[0].Effect, "stripped") {
+		t.Errorf("Expected the prefix to be stripped: %v", ex.Middlewares)
+	}
+	if ex.Backend != "http://wsid-service.ws.svc:9999/1/?a=b" {
+		t.Errorf("Unexpected backend %s", ex.Backend)
+	}
+
+	ex, err = g.ExplainURL("https://che.example.com/wsid/m1/9998/1/")
+	if err != nil {
+		t.Fatalf("Failed to explain the URL: %s", err)
+	}
+	if ex.RouterName != "dashboard" || ex.Backend != "http://dashboard.che.svc:8080/wsid/m1/9998/1/" {
+		t.Errorf("Expected the request to be routed to the dashboard but got router %s and backend %s", ex.RouterName, ex.Backend)
+	}
+}
+
+func TestRejectsConflictingDefinitions(t *testing.T) {
+	a := Config{HTTP: HTTP{Routers: map[string]Router{"r": {Rule: "PathPrefix(`/a`)", Service: "s"}}}}
+	b := Config{HTTP: HTTP{Routers: map[string]Router{"r": {Rule: "PathPrefix(`/b`)", Service: "s"}}}}
+
+	if _, err := NewGateway(a, a); err != nil {
+		t.Errorf("Identical definitions should be allowed: %s", err)
+	}
+
+	if _, err := NewGateway(a, b); err == nil {
+		t.Errorf("Expected the conflicting router definitions to be rejected")
+	}
+}
+
+func TestFailsOnMissingReferences(t *testing.T) {
+	g, err := NewGateway(Config{HTTP: HTTP{
+		Routers: map[string]Router{"r": {Rule: "PathPrefix(`/a`)", Service: "s", Middlewares: []string{"m"}}},
+	}})
+	if err != nil {
+		t.Fatalf("Failed to create the gateway: %s", err)
+	}
+
+	if _, err = g.ExplainURL("http://host/a"); err == nil {
+		t.Errorf("Expected the missing middleware to be reported")
+	}
+
+	if _, err = g.ExplainURL("http://host/b"); err == nil || !strings.Contains(err.Error(), "no router") {
+		t.Errorf("Expected no router to match, got: %v", err)
+	}
+}
+
+func TestExplainsRedirects(t *testing.T) {
+	g, err := NewGateway(Config{HTTP: HTTP{
+		Routers: map[string]Router{
+			"old": {Rule: "PathPrefix(`/old`)", Service: "s", Middlewares: []string{"redirect"}},
+		},
+		Services: map[string]Service{
+			"s": {LoadBalancer: LoadBalancer{Servers: []Server{{URL: "http://svc:8080"}}}},
+		},
+		Middlewares: map[string]Middleware{
+			"redirect": {RedirectRegex: &RedirectRegex{Regex: "^([^:]+://[^/]+)/old([/?].*)?$", Replacement: "${1}/new${2}"}},
+		},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ex, err := g.ExplainURL("https://host/old/path?q=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ex.Redirect != "https://host/new/path?q=1" || ex.Backend != "" {
+		t.Errorf("Unexpected redirect '%s' and backend '%s'", ex.Redirect, ex.Backend)
+	}
+
+	// the request is forwarded if the regex doesn't match
+	ex, err = g.ExplainURL("https://host/older")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ex.Redirect != "" || ex.Backend != "http://svc:8080/older" {
+		t.Errorf("Unexpected redirect '%s' and backend '%s'", ex.Redirect, ex.Backend)
+	}
+}
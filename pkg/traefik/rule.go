@@ -0,0 +1,252 @@
+//
+// Copyright (c) 2019-2021 Red Hat, Inc.
+// This program and the accompanying materials are made
+// available under the terms of the Eclipse Public License 2.0
+// which is available at https://www.eclipse.org/legal/epl-2.0/
+//
+// SPDX-License-Identifier: EPL-2.0
+//
+// Contributors:
+//   Red Hat, Inc. - initial API and implementation
+//
+
+package traefik
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"unicode"
+)
+
+// Rule is a parsed router rule that can be matched against requests.
+type Rule interface {
+	// Matches returns true if the request matches the rule.
+	Matches(req *http.Request) bool
+}
+
+// ParseRule parses the router rule. Only the matchers we use are supported: Host, Path, PathPrefix and Method,
+// combined using &&, || and ! with parentheses.
+func ParseRule(rule string) (Rule, error) {
+	p := &ruleParser{input: rule}
+	r, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+
+	p.skipSpaces()
+	if p.pos != len(p.input) {
+		return nil, p.errorf("unexpected input")
+	}
+
+	return r, nil
+}
+
+type andRule []Rule
+
+func (r andRule) Matches(req *http.Request) bool {
+	for _, sub := range r {
+		if !sub.Matches(req) {
+			return false
+		}
+	}
+	return true
+}
+
+type orRule []Rule
+
+func (r orRule) Matches(req *http.Request) bool {
+	for _, sub := range r {
+		if sub.Matches(req) {
+			return true
+		}
+	}
+	return false
+}
+
+type notRule struct {
+	rule Rule
+}
+
+func (r notRule) Matches(req *http.Request) bool {
+	return !r.rule.Matches(req)
+}
+
+type matcherRule struct {
+	match func(req *http.Request, arg string) bool
+	args  []string
+}
+
+func (r matcherRule) Matches(req *http.Request) bool {
+	for _, a := range r.args {
+		if r.match(req, a) {
+			return true
+		}
+	}
+	return false
+}
+
+var matchers = map[string]func(req *http.Request, arg string) bool{
+	"Host": func(req *http.Request, host string) bool {
+		reqHost := req.Host
+		if h, _, err := net.SplitHostPort(reqHost); err == nil {
+			reqHost = h
+		}
+		return strings.EqualFold(reqHost, host)
+	},
+	"Path": func(req *http.Request, path string) bool {
+		return req.URL.Path == path
+	},
+	"PathPrefix": func(req *http.Request, prefix string) bool {
+		return strings.HasPrefix(req.URL.Path, prefix)
+	},
+	"Method": func(req *http.Request, method string) bool {
+		return strings.EqualFold(req.Method, method)
+	},
+}
+
+type ruleParser struct {
+	input string
+	pos   int
+}
+
+func (p *ruleParser) parseOr() (Rule, error) {
+	var rules orRule
+	for {
+		r, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, r)
+
+		if !p.consume("||") {
+			break
+		}
+	}
+
+	if len(rules) == 1 {
+		return rules[0], nil
+	}
+	return rules, nil
+}
+
+func (p *ruleParser) parseAnd() (Rule, error) {
+	var rules andRule
+	for {
+		r, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, r)
+
+		if !p.consume("&&") {
+			break
+		}
+	}
+
+	if len(rules) == 1 {
+		return rules[0], nil
+	}
+	return rules, nil
+}
+
+func (p *ruleParser) parseUnary() (Rule, error) {
+	if p.consume("!") {
+		r, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return notRule{rule: r}, nil
+	}
+
+	if p.consume("(") {
+		r, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if !p.consume(")") {
+			return nil, p.errorf("expected ')'")
+		}
+		return r, nil
+	}
+
+	return p.parseMatcher()
+}
+
+func (p *ruleParser) parseMatcher() (Rule, error) {
+	p.skipSpaces()
+	start := p.pos
+	for p.pos < len(p.input) && (unicode.IsLetter(rune(p.input[p.pos])) || unicode.IsDigit(rune(p.input[p.pos]))) {
+		p.pos++
+	}
+	name := p.input[start:p.pos]
+	if name == "" {
+		return nil, p.errorf("expected a matcher")
+	}
+
+	match, ok := matchers[name]
+	if !ok {
+		return nil, fmt.Errorf("unsupported matcher '%s' in rule '%s'", name, p.input)
+	}
+
+	if !p.consume("(") {
+		return nil, p.errorf("expected '('")
+	}
+
+	var args []string
+	for {
+		arg, err := p.parseString()
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+
+		if !p.consume(",") {
+			break
+		}
+	}
+
+	if !p.consume(")") {
+		return nil, p.errorf("expected ')'")
+	}
+
+	return matcherRule{match: match, args: args}, nil
+}
+
+func (p *ruleParser) parseString() (string, error) {
+	p.skipSpaces()
+	if p.pos >= len(p.input) || (p.input[p.pos] != '`' && p.input[p.pos] != '"') {
+		return "", p.errorf("expected a quoted string")
+	}
+
+	quote := p.input[p.pos]
+	end := strings.IndexByte(p.input[p.pos+1:], quote)
+	if end < 0 {
+		return "", p.errorf("unterminated string")
+	}
+
+	ret := p.input[p.pos+1 : p.pos+1+end]
+	p.pos += end + 2
+	return ret, nil
+}
+
+// consume skips the spaces and the token, if it follows. Returns true if the token was found.
+func (p *ruleParser) consume(token string) bool {
+	p.skipSpaces()
+	if strings.HasPrefix(p.input[p.pos:], token) {
+		p.pos += len(token)
+		return true
+	}
+	return false
+}
+
+func (p *ruleParser) skipSpaces() {
+	for p.pos < len(p.input) && unicode.IsSpace(rune(p.input[p.pos])) {
+		p.pos++
+	}
+}
+
+func (p *ruleParser) errorf(msg string) error {
+	return fmt.Errorf("%s at position %d in rule '%s'", msg, p.pos, p.input)
+}
@@ -0,0 +1,55 @@
+package traefik
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestRuleMatching(t *testing.T) {
+	tests := []struct {
+		rule    string
+		url     string
+		matches bool
+	}{
+		{"PathPrefix(`/ws/m1`)", "http://host/ws/m1/a", true},
+		{"PathPrefix(`/ws/m1`)", "http://host/ws/m2/a", false},
+		{"Path(`/ws`)", "http://host/ws", true},
+		{"Path(`/ws`)", "http://host/ws/", false},
+		{"Host(`Che.Example.Com`)", "http://che.example.com:8443/", true},
+		{"Host(`a.com`, `b.com`)", "http://b.com/", true},
+		{"Host(`a.com`) && PathPrefix(`/x`)", "http://a.com/y", false},
+		{"Host(`a.com`) || PathPrefix(`/x`)", "http://b.com/x", true},
+		{"PathPrefix(`/x`) && !(Path(`/x/y`) || Path(`/x/z`))", "http://a.com/x/y", false},
+		{"PathPrefix(`/x`) && !(Path(`/x/y`) || Path(`/x/z`))", "http://a.com/x/w", true},
+		{"Method(\"GET\")", "http://a.com/", true},
+	}
+
+	for _, test := range tests {
+		r, err := ParseRule(test.rule)
+		if err != nil {
+			t.Errorf("Failed to parse the rule %s: %s", test.rule, err)
+			continue
+		}
+
+		req, _ := http.NewRequest(http.MethodGet, test.url, nil)
+		if r.Matches(req) != test.matches {
+			t.Errorf("Expected the rule %s to match %s: %t", test.rule, test.url, test.matches)
+		}
+	}
+}
+
+func TestInvalidRules(t *testing.T) {
+	for _, rule := range []string{
+		"",
+		"PathPrefix(`/x`",
+		"PathPrefix(/x)",
+		"PathPrefix(`/x)",
+		"Headers(`X-A`, `b`)",
+		"PathPrefix(`/x`) &&",
+		"PathPrefix(`/x`) Path(`/y`)",
+	} {
+		if _, err := ParseRule(rule); err == nil {
+			t.Errorf("Expected the rule '%s' to be rejected", rule)
+		}
+	}
+}
@@ -0,0 +1,338 @@
+//
+// Copyright (c) 2019-2021 Red Hat, Inc.
+// This program and the accompanying materials are made
+// available under the terms of the Eclipse Public License 2.0
+// which is available at https://www.eclipse.org/legal/epl-2.0/
+//
+// SPDX-License-Identifier: EPL-2.0
+//
+// Contributors:
+//   Red Hat, Inc. - initial API and implementation
+//
+
+package traefik
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// circuitBreakerCondition is a single condition of the circuit breaker expression, e.g. "NetworkErrorRatio() > 0.5".
+var circuitBreakerCondition = regexp.MustCompile(`^\s*(NetworkErrorRatio\(\)|ResponseCodeRatio\(\s*\d+\s*,\s*\d+\s*,\s*\d+\s*,\s*\d+\s*\)|LatencyAtQuantileMS\(\s*\d+(\.\d+)?\s*\))\s*(>=|<=|==|!=|>|<)\s*\d+(\.\d+)?\s*$`)
+
+// Validate checks that Traefik would accept the configuration and route the requests unambiguously. It checks that
+// the rules of the routers parse, that the services and middlewares the routers and middlewares reference exist, that
+// the services have servers to forward to and existing servers transports to use and that no two routers match
+// the same requests with the same priority.
+// All the problems found are reported in the returned error.
+func (c Config) Validate() error {
+	var problems []string
+
+	rules := map[string]string{}
+	for _, name := range sortedKeys(c.HTTP.Routers) {
+		r := c.HTTP.Routers[name]
+
+		if problem := validateName(name); problem != "" {
+			problems = append(problems, fmt.Sprintf("router '%s' %s", name, problem))
+		}
+
+		if _, err := ParseRule(r.Rule); err != nil {
+			problems = append(problems, fmt.Sprintf("router '%s' has an invalid rule: %s", name, err))
+		}
+
+		// Traefik picks one of the routers with the same rule and priority at random
+		ruleKey := fmt.Sprintf("%d:%s", r.EffectivePriority(), r.Rule)
+		if other, ok := rules[ruleKey]; ok {
+			problems = append(problems, fmt.Sprintf("routers '%s' and '%s' have the same rule %s and priority", other, name, r.Rule))
+		} else {
+			rules[ruleKey] = name
+		}
+
+		if r.Service == "" {
+			problems = append(problems, fmt.Sprintf("router '%s' doesn't specify a service", name))
+		} else if _, ok := c.HTTP.Services[r.Service]; !ok {
+			problems = append(problems, fmt.Sprintf("router '%s' references the service '%s' that doesn't exist", name, r.Service))
+		}
+
+		for _, m := range r.Middlewares {
+			if _, ok := c.HTTP.Middlewares[m]; !ok {
+				problems = append(problems, fmt.Sprintf("router '%s' references the middleware '%s' that doesn't exist", name, m))
+			}
+		}
+	}
+
+	for _, name := range sortedKeys(c.HTTP.Services) {
+		if problem := validateName(name); problem != "" {
+			problems = append(problems, fmt.Sprintf("service '%s' %s", name, problem))
+		}
+
+		lb := c.HTTP.Services[name].LoadBalancer
+		if len(lb.Servers) == 0 {
+			problems = append(problems, fmt.Sprintf("service '%s' has no servers", name))
+		}
+		for _, s := range lb.Servers {
+			if u, err := url.Parse(s.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				problems = append(problems, fmt.Sprintf("service '%s' has an invalid server URL '%s'", name, s.URL))
+			}
+		}
+		if lb.ServersTransport != "" {
+			if _, ok := c.HTTP.ServersTransports[lb.ServersTransport]; !ok {
+				problems = append(problems, fmt.Sprintf("service '%s' references the servers transport '%s' that doesn't exist", name, lb.ServersTransport))
+			}
+		}
+	}
+
+	for _, name := range sortedKeys(c.HTTP.ServersTransports) {
+		if problem := validateName(name); problem != "" {
+			problems = append(problems, fmt.Sprintf("servers transport '%s' %s", name, problem))
+		}
+
+		if timeouts := c.HTTP.ServersTransports[name].ForwardingTimeouts; timeouts != nil {
+			for _, d := range []string{timeouts.DialTimeout, timeouts.ResponseHeaderTimeout, timeouts.IdleConnTimeout} {
+				if problem := validateDuration(d); problem != "" {
+					problems = append(problems, fmt.Sprintf("servers transport '%s' %s", name, problem))
+				}
+			}
+		}
+	}
+
+	for _, name := range sortedKeys(c.HTTP.Middlewares) {
+		if problem := validateName(name); problem != "" {
+			problems = append(problems, fmt.Sprintf("middleware '%s' %s", name, problem))
+		}
+
+		for _, problem := range validateMiddleware(c.HTTP.Middlewares[name]) {
+			problems = append(problems, fmt.Sprintf("middleware '%s' %s", name, problem))
+		}
+
+		// the errors middleware is the only one that references a service
+		if errors := c.HTTP.Middlewares[name].Errors; errors != nil && errors.Service != "" {
+			if _, ok := c.HTTP.Services[errors.Service]; !ok {
+				problems = append(problems, fmt.Sprintf("middleware '%s' references the service '%s' that doesn't exist", name, errors.Service))
+			}
+		}
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("%s", strings.Join(problems, "; "))
+	}
+
+	return nil
+}
+
+// validateMiddleware checks that the middleware configures exactly one middleware type and that the configuration of
+// the type makes sense.
+func validateMiddleware(m Middleware) []string {
+	var problems []string
+	types := 0
+
+	if m.StripPrefix != nil {
+		types++
+		if len(m.StripPrefix.Prefixes) == 0 {
+			problems = append(problems, "has no prefixes to strip")
+		}
+	}
+
+	if m.AddPrefix != nil {
+		types++
+		if !strings.HasPrefix(m.AddPrefix.Prefix, "/") {
+			problems = append(problems, fmt.Sprintf("has a prefix to add '%s' that is not an absolute path", m.AddPrefix.Prefix))
+		}
+	}
+
+	if m.Headers != nil {
+		types++
+		if len(m.Headers.CustomRequestHeaders) == 0 && len(m.Headers.CustomResponseHeaders) == 0 &&
+			len(m.Headers.AccessControlAllowOriginList) == 0 {
+			problems = append(problems, "has no headers to set")
+		}
+	}
+
+	if m.Headers != nil && m.Headers.AccessControlAllowCredentials {
+		for _, o := range m.Headers.AccessControlAllowOriginList {
+			if o == "*" {
+				problems = append(problems, "allows the credentials in the requests from any origin")
+			}
+		}
+	}
+
+	if m.IPWhiteList != nil {
+		types++
+		if len(m.IPWhiteList.SourceRange) == 0 {
+			problems = append(problems, "has no IP ranges to allow")
+		}
+		for _, r := range m.IPWhiteList.SourceRange {
+			if !IsValidIPRange(r) {
+				problems = append(problems, fmt.Sprintf("has an invalid IP range '%s'", r))
+			}
+		}
+	}
+
+	if m.RateLimit != nil {
+		types++
+		if m.RateLimit.Average <= 0 {
+			problems = append(problems, "has no average rate limit")
+		}
+		if m.RateLimit.Burst < 0 {
+			problems = append(problems, "has a negative burst")
+		}
+	}
+
+	if m.RedirectRegex != nil {
+		types++
+		if _, err := regexp.Compile(m.RedirectRegex.Regex); err != nil {
+			problems = append(problems, fmt.Sprintf("has an invalid redirect regex: %s", err))
+		}
+		if m.RedirectRegex.Replacement == "" {
+			problems = append(problems, "has no redirect replacement")
+		}
+	}
+
+	if m.Retry != nil {
+		types++
+		if m.Retry.Attempts <= 0 {
+			problems = append(problems, "has no retry attempts")
+		}
+		if problem := validateDuration(m.Retry.InitialInterval); problem != "" {
+			problems = append(problems, problem)
+		}
+	}
+
+	if m.CircuitBreaker != nil {
+		types++
+		if !IsValidCircuitBreakerExpression(m.CircuitBreaker.Expression) {
+			problems = append(problems, fmt.Sprintf("has an invalid circuit breaker expression '%s'", m.CircuitBreaker.Expression))
+		}
+	}
+
+	if m.Errors != nil {
+		types++
+		if len(m.Errors.Status) == 0 {
+			problems = append(problems, "has no status codes to replace the responses of")
+		}
+		for _, st := range m.Errors.Status {
+			if !isValidStatusRange(st) {
+				problems = append(problems, fmt.Sprintf("has an invalid status code range '%s'", st))
+			}
+		}
+		if m.Errors.Service == "" {
+			problems = append(problems, "has no service to get the error pages from")
+		}
+		if m.Errors.Query != "" && !strings.HasPrefix(m.Errors.Query, "/") {
+			problems = append(problems, fmt.Sprintf("has an error page query '%s' that is not an absolute path", m.Errors.Query))
+		}
+	}
+
+	if m.ReplacePath != nil {
+		types++
+		if !strings.HasPrefix(m.ReplacePath.Path, "/") {
+			problems = append(problems, fmt.Sprintf("has a replacement path '%s' that is not an absolute path", m.ReplacePath.Path))
+		}
+	}
+
+	switch {
+	case types == 0:
+		problems = append(problems, "doesn't configure anything")
+	case types > 1:
+		problems = append(problems, "configures more than one middleware type")
+	}
+
+	return problems
+}
+
+// IsValidCircuitBreakerExpression checks that the expression is a combination of the conditions supported by Traefik
+// joined by && and ||. The parentheses for grouping the conditions are not supported.
+func IsValidCircuitBreakerExpression(expression string) bool {
+	if strings.TrimSpace(expression) == "" {
+		return false
+	}
+	for _, or := range strings.Split(expression, "||") {
+		for _, cond := range strings.Split(or, "&&") {
+			if !circuitBreakerCondition.MatchString(cond) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// IsValidIPRange checks that the string is either an IP address or an IP range in the CIDR notation, as accepted
+// by Traefik in the IP allow lists and the trusted IPs.
+func IsValidIPRange(ipRange string) bool {
+	if _, _, err := net.ParseCIDR(ipRange); err == nil {
+		return true
+	}
+	return net.ParseIP(ipRange) != nil
+}
+
+// isValidStatusRange checks that the string is either an HTTP status code or a range of them like "500-599".
+func isValidStatusRange(statusRange string) bool {
+	bounds := strings.Split(statusRange, "-")
+	if len(bounds) > 2 {
+		return false
+	}
+	var codes []int
+	for _, b := range bounds {
+		code, err := strconv.Atoi(b)
+		if err != nil || code < 100 || code > 599 {
+			return false
+		}
+		codes = append(codes, code)
+	}
+	return len(codes) == 1 || codes[0] <= codes[1]
+}
+
+// validateDuration returns the description of the problem with the optional duration or an empty string if
+// the duration is fine.
+func validateDuration(duration string) string {
+	if duration == "" {
+		return ""
+	}
+	if d, err := time.ParseDuration(duration); err != nil || d < 0 {
+		return fmt.Sprintf("has an invalid duration '%s'", duration)
+	}
+	return ""
+}
+
+// validateName returns the description of the problem with the name of a router, service or middleware or an empty
+// string if the name is fine.
+func validateName(name string) string {
+	if name == "" {
+		return "has an empty name"
+	}
+	// Traefik uses @ to separate the name of the object from the name of the provider that defines it
+	if strings.Contains(name, "@") {
+		return "has a name containing '@'"
+	}
+	return ""
+}
+
+func sortedKeys(m interface{}) []string {
+	var keys []string
+	switch m := m.(type) {
+	case map[string]Router:
+		for k := range m {
+			keys = append(keys, k)
+		}
+	case map[string]Service:
+		for k := range m {
+			keys = append(keys, k)
+		}
+	case map[string]Middleware:
+		for k := range m {
+			keys = append(keys, k)
+		}
+	case map[string]ServersTransport:
+		for k := range m {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
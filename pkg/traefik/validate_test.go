@@ -0,0 +1,113 @@
+package traefik
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidConfig(t *testing.T) {
+	c := Config{HTTP: HTTP{
+		Routers: map[string]Router{
+			"r1": {Rule: "PathPrefix(`/a`)", Service: "s", Middlewares: []string{"m"}, Priority: 100},
+			"r2": {Rule: "PathPrefix(`/b`)", Service: "s", Priority: 100},
+		},
+		Services: map[string]Service{
+			"s": {LoadBalancer: LoadBalancer{Servers: []Server{{URL: "http://svc.ns.svc:8080"}}, ServersTransport: "t"}},
+		},
+		Middlewares: map[string]Middleware{
+			"m":     {StripPrefix: &StripPrefix{Prefixes: []string{"/a"}}},
+			"retry": {Retry: &Retry{Attempts: 3, InitialInterval: "100ms"}},
+			"add":   {AddPrefix: &AddPrefix{Prefix: "/app"}},
+			"resp":  {Headers: &Headers{CustomResponseHeaders: map[string]string{"X-Frame-Options": "DENY"}}},
+			"ips":   {IPWhiteList: &IPWhiteList{SourceRange: []string{"10.0.0.0/8", "192.168.1.7"}, IPStrategy: &IPStrategy{Depth: 1}}},
+			"rate":  {RateLimit: &RateLimit{Average: 100, Burst: 50}},
+			"cors":  {Headers: &Headers{AccessControlAllowOriginList: []string{"https://che.example.com"}, AccessControlAllowCredentials: true}},
+			"cb":    {CircuitBreaker: &CircuitBreaker{Expression: "NetworkErrorRatio() > 0.5 || ResponseCodeRatio(500, 600, 0, 600) > 0.25 && LatencyAtQuantileMS(50.0) >= 100"}},
+			"pages": {Errors: &Errors{Status: []string{"404", "500-599"}, Service: "s", Query: "/ns/ws/{status}"}},
+			"path":  {ReplacePath: &ReplacePath{Path: "/ns/ws/404"}},
+		},
+		ServersTransports: map[string]ServersTransport{
+			"t": {ForwardingTimeouts: &ForwardingTimeouts{ResponseHeaderTimeout: "1m30s"}},
+		},
+	}}
+
+	if err := c.Validate(); err != nil {
+		t.Errorf("The config should have been valid: %s", err)
+	}
+}
+
+func TestInvalidConfig(t *testing.T) {
+	c := Config{HTTP: HTTP{
+		Routers: map[string]Router{
+			"r1":   {Rule: "PathPrefix(`/a`)", Service: "s", Middlewares: []string{"nonexistent"}, Priority: 100},
+			"r2":   {Rule: "PathPrefix(`/a`)", Service: "nonexistent", Priority: 100},
+			"r3":   {Rule: "PathPrefix(`/c`", Service: "s"},
+			"r@4":  {Rule: "PathPrefix(`/d`)", Service: "empty"},
+			"r5":   {Rule: "PathPrefix(`/e`)"},
+			"r6":   {Rule: "PathPrefix(`/f`)", Service: "bad-url", Middlewares: []string{"m"}},
+			"ok-r": {Rule: "PathPrefix(`/g`)", Service: "s"},
+		},
+		Services: map[string]Service{
+			"s":            {LoadBalancer: LoadBalancer{Servers: []Server{{URL: "http://svc.ns.svc:8080"}}}},
+			"empty":        {},
+			"bad-url":      {LoadBalancer: LoadBalancer{Servers: []Server{{URL: "svc:8080"}}}},
+			"no-transport": {LoadBalancer: LoadBalancer{Servers: []Server{{URL: "http://svc.ns.svc:8080"}}, ServersTransport: "nonexistent"}},
+		},
+		Middlewares: map[string]Middleware{
+			"m":     {},
+			"regex": {RedirectRegex: &RedirectRegex{Regex: "(", Replacement: "/"}},
+			"retry": {Retry: &Retry{}},
+			"add":   {AddPrefix: &AddPrefix{Prefix: "app"}},
+			"ips":   {IPWhiteList: &IPWhiteList{SourceRange: []string{"10.0.0.0/8", "10.0.0.300"}}},
+			"rate":  {RateLimit: &RateLimit{}},
+			"cors":  {Headers: &Headers{AccessControlAllowOriginList: []string{"*"}, AccessControlAllowCredentials: true}},
+			"cb":    {CircuitBreaker: &CircuitBreaker{Expression: "ErrorRatio() > 0.5"}},
+			"both":  {StripPrefix: &StripPrefix{Prefixes: []string{"/a"}}, Headers: &Headers{CustomRequestHeaders: map[string]string{"a": "b"}}},
+			"pages": {Errors: &Errors{Status: []string{"5xx", "599-500"}, Service: "nonexistent", Query: "{status}"}},
+			"path":  {ReplacePath: &ReplacePath{Path: "ws/404"}},
+		},
+		ServersTransports: map[string]ServersTransport{
+			"t": {ForwardingTimeouts: &ForwardingTimeouts{DialTimeout: "soon"}},
+		},
+	}}
+
+	err := c.Validate()
+	if err == nil {
+		t.Fatal("The config should have been invalid")
+	}
+
+	for _, expected := range []string{
+		"router 'r1' references the middleware 'nonexistent' that doesn't exist",
+		"routers 'r1' and 'r2' have the same rule",
+		"router 'r2' references the service 'nonexistent' that doesn't exist",
+		"router 'r3' has an invalid rule",
+		"router 'r@4' has a name containing '@'",
+		"router 'r5' doesn't specify a service",
+		"service 'empty' has no servers",
+		"service 'bad-url' has an invalid server URL 'svc:8080'",
+		"middleware 'm' doesn't configure anything",
+		"middleware 'regex' has an invalid redirect regex",
+		"service 'no-transport' references the servers transport 'nonexistent' that doesn't exist",
+		"servers transport 't' has an invalid duration 'soon'",
+		"middleware 'retry' has no retry attempts",
+		"middleware 'ips' has an invalid IP range '10.0.0.300'",
+		"middleware 'rate' has no average rate limit",
+		"middleware 'cors' allows the credentials in the requests from any origin",
+		"middleware 'add' has a prefix to add 'app' that is not an absolute path",
+		"middleware 'cb' has an invalid circuit breaker expression",
+		"middleware 'both' configures more than one middleware type",
+		"middleware 'pages' has an invalid status code range '5xx'",
+		"middleware 'pages' has an invalid status code range '599-500'",
+		"middleware 'pages' has an error page query '{status}' that is not an absolute path",
+		"middleware 'pages' references the service 'nonexistent' that doesn't exist",
+		"middleware 'path' has a replacement path 'ws/404' that is not an absolute path",
+	} {
+		if !strings.Contains(err.Error(), expected) {
+			t.Errorf("Expected the error to report \"%s\" but it was: %s", expected, err)
+		}
+	}
+
+	if strings.Contains(err.Error(), "ok-r") {
+		t.Errorf("The valid router should not have been reported: %s", err)
+	}
+}